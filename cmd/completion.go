@@ -0,0 +1,163 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+// bashCompletionFunctions is added to the generated bash completion so --cluster suggests the clusters in
+// the account and --service suggests the services in the cluster given on the command line. The global AWS
+// flags already typed are passed along, and any errors, such as missing credentials, just mean no suggestions.
+const bashCompletionFunctions = `
+__awsops_global_flags()
+{
+    local i
+    awsops_flags=()
+    for (( i=0; i < ${#words[@]}; i++ )); do
+        case "${words[i]}" in
+            -p|--profile|-r|--region|--assume-role-arn|--external-id|--endpoint-url|--web-identity-token-file|--config)
+                awsops_flags+=("${words[i]}" "${words[i+1]}")
+                ;;
+            --profile=*|--region=*|--assume-role-arn=*|--external-id=*|--endpoint-url=*|--web-identity-token-file=*|--config=*)
+                awsops_flags+=("${words[i]}")
+                ;;
+        esac
+    done
+}
+
+__awsops_clusters()
+{
+    local awsops_out awsops_flags
+    __awsops_global_flags
+    if awsops_out=$(awsops ecs clusterNames "${awsops_flags[@]}" 2>/dev/null); then
+        COMPREPLY=( $( compgen -W "${awsops_out[*]}" -- "$cur" ) )
+    fi
+}
+
+__awsops_services()
+{
+    local awsops_out awsops_cluster awsops_flags i
+    for (( i=0; i < ${#words[@]}; i++ )); do
+        case "${words[i]}" in
+            -c|--cluster)
+                awsops_cluster="${words[i+1]}"
+                ;;
+            --cluster=*)
+                awsops_cluster="${words[i]#--cluster=}"
+                ;;
+        esac
+    done
+    if [[ -z "$awsops_cluster" ]]; then
+        return
+    fi
+    __awsops_global_flags
+    if awsops_out=$(awsops ecs serviceNames --cluster "$awsops_cluster" "${awsops_flags[@]}" 2>/dev/null); then
+        COMPREPLY=( $( compgen -W "${awsops_out[*]}" -- "$cur" ) )
+    fi
+}
+`
+
+// completionCmd represents the completion command
+var completionCmd = &cobra.Command{
+	Use:   "completion [bash|zsh]",
+	Short: "Generate a shell completion script",
+	Long: `Prints a completion script for bash or zsh. For example, to load completions in bash:
+
+  source <(awsops completion bash)
+
+In bash, --cluster completes with the names of the ECS clusters in the account, and --service completes
+with the names of the services in the cluster given with --cluster. The zsh script only completes
+commands and flags, not cluster or service names, and fish and PowerShell are not supported.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			fmt.Println("Shell is required, either bash or zsh")
+			os.Exit(1)
+		}
+
+		var err error
+		switch args[0] {
+		case "bash":
+			err = rootCmd.GenBashCompletion(os.Stdout)
+		case "zsh":
+			err = rootCmd.GenZshCompletion(os.Stdout)
+		default:
+			fmt.Printf("Unsupported shell %q, must be bash or zsh\n", args[0])
+			os.Exit(1)
+		}
+		if err != nil {
+			exitWithError("Unable to generate completion script: ", err)
+		}
+	},
+}
+
+// clusterNamesCmd prints cluster names for shell completion. It is hidden since it is only meant to be
+// called by the completion script, and it prints nothing rather than an error if the clusters can't be listed.
+// The session is created like any other command's so --profile, --assume-role-arn and --endpoint-url apply,
+// and the completion script ignores its output when that fails.
+var clusterNamesCmd = &cobra.Command{
+	Use:    "clusterNames",
+	Short:  "List ECS cluster names for shell completion",
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		initAwsSess()
+
+		clusterArns, err := lib.ListEcsClusterArns(context.Background(), AwsSess)
+		if err != nil {
+			return
+		}
+
+		for _, arn := range clusterArns {
+			fmt.Println(lib.NormalizeClusterIdentifier(*arn))
+		}
+	},
+}
+
+// serviceNamesCmd prints the service names in --cluster for shell completion. Like clusterNames it is hidden
+// and prints nothing rather than an error if the services can't be listed.
+var serviceNamesCmd = &cobra.Command{
+	Use:    "serviceNames",
+	Short:  "List ECS service names in a cluster for shell completion",
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if cluster == "" {
+			return
+		}
+
+		initAwsSess()
+
+		names, err := lib.ListServiceNamesForEcsCluster(context.Background(), AwsSess, cluster)
+		if err != nil {
+			return
+		}
+
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+	ecsCmd.AddCommand(clusterNamesCmd)
+	ecsCmd.AddCommand(serviceNamesCmd)
+
+	rootCmd.BashCompletionFunction = bashCompletionFunctions
+}
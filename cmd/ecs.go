@@ -15,16 +15,35 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/silinternational/awsops/lib"
 	"github.com/spf13/cobra"
 )
 
 var cluster string
+var output string
+var waitStable bool
+var waitTimeout time.Duration
 
 // ecsCmd represents the ecs command
 var ecsCmd = &cobra.Command{
 	Use:   "ecs",
 	Short: "ECS related actions, run 'awsops ecs' to view list of subcommands",
 	Long: "",
+	// Allow either a cluster name or ARN to be given with --cluster
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		applyConfigDefaults(cmd)
+		if name := lib.NormalizeClusterIdentifier(cluster); name != cluster {
+			useClusterArnRegion(cluster)
+			cluster = name
+		}
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		cmd.Help()
 	},
@@ -38,9 +57,92 @@ func init() {
 	// Cobra supports Persistent Flags which will work for this command
 	// and all subcommands, e.g.:
 	// ecsCmd.PersistentFlags().String("foo", "", "A help for foo")
-	ecsCmd.PersistentFlags().StringVarP(&cluster, "cluster", "c", "", "ECS cluster name")
+	ecsCmd.PersistentFlags().StringVarP(&cluster, "cluster", "c", "", "ECS cluster name or ARN")
+	// Complete cluster names in bash using __awsops_clusters from completion.go
+	cobra.MarkFlagCustom(ecsCmd.PersistentFlags(), "cluster", "__awsops_clusters")
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// ecsCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
+
+// useClusterArnRegion sets Region to the region of the cluster ARN unless --region was given, in which case
+// they must match so a command never acts on a cluster in a different region than the one asked for
+func useClusterArnRegion(clusterArn string) {
+	arnRegion, err := lib.RegionFromArn(clusterArn)
+	if err != nil {
+		return
+	}
+
+	if rootCmd.PersistentFlags().Changed("region") && Region != arnRegion {
+		fmt.Printf("Cluster ARN is in region %s but --region is %s, use one or the other\n", arnRegion, Region)
+		os.Exit(1)
+	}
+
+	Region = arnRegion
+}
+
+// parseTag splits a key=value tag given with one of the tag flags
+func parseTag(flagName, tag string) (string, string, error) {
+	parts := strings.SplitN(tag, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("Invalid tag %q for %s, must be key=value", tag, flagName)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// parseTagFlag returns the key=value tags given with a repeatable tag flag as a map
+func parseTagFlag(flagName string, values []string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, tag := range values {
+		key, value, err := parseTag(flagName, tag)
+		if err != nil {
+			return nil, err
+		}
+		tags[key] = value
+	}
+
+	return tags, nil
+}
+
+// checkOutputFormat exits when --output is not one of the supported formats
+func checkOutputFormat() {
+	if output != "text" && output != "json" {
+		fmt.Printf("Invalid output format %q, must be text or json\n", output)
+		os.Exit(1)
+	}
+}
+
+// printJSON prints v as indented JSON for use with --output json
+func printJSON(v interface{}) {
+	encoded, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		exitWithError("Unable to encode output as JSON: ", err)
+	}
+	fmt.Println(string(encoded))
+}
+
+// addWaitFlags adds --wait and --wait-timeout to commands that change the cluster
+func addWaitFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&waitStable, "wait", false, "Wait for all services in the cluster to become stable when done")
+	cmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 10*time.Minute, "Maximum time to wait for services to become stable with --wait")
+}
+
+// waitForServicesStable waits for services in the cluster to become stable and exits if any do not
+func waitForServicesStable(ctx context.Context) {
+	fmt.Println("Waiting for services to become stable...")
+	stable, unstable, err := lib.WaitForServicesStable(ctx, AwsSess, cluster, waitTimeout)
+	if err != nil {
+		exitWithError("Unable to wait for services to become stable: ", err)
+	}
+
+	if len(stable) > 0 {
+		fmt.Println("Stable services: ", strings.Join(stable, ", "))
+	}
+
+	if len(unstable) > 0 {
+		fmt.Printf("Services not stable after %s: %s\n", waitTimeout, strings.Join(unstable, ", "))
+		os.Exit(exitTimeout)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+var deployedTaskDefinition string
+
+// deploymentStatusCmd represents the ecsDeploymentStatus command
+var deploymentStatusCmd = &cobra.Command{
+	Use:   "deploymentStatus",
+	Short: "Check whether the latest deployment of an ECS service has finished",
+	Long: `Prints the rollout state and task counts of the primary deployment of an ECS service. Exits 0 when the
+deployment is complete, 6 while it is still in progress and 7 when it failed, so CI can gate a release on it.
+A deployment rolled back by the circuit breaker counts as failed. Pass --task-definition with the revision that
+was deployed so a finished rollback, which leaves the previous revision COMPLETED, fails too:
+
+  until awsops ecs deploymentStatus -c cluster -s service; do [ $? -eq 6 ] || exit 1; sleep 15; done`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if service == "" {
+			fmt.Println("Service is required, use --service")
+			os.Exit(1)
+		}
+		checkOutputFormat()
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		status, err := lib.GetServiceDeploymentStatus(ctx, AwsSess, cluster, service, deployedTaskDefinition)
+		if err != nil {
+			exitWithError("Unable to get deployment status: ", err)
+		}
+
+		if output == "json" {
+			printJSON(status)
+		} else {
+			printDeploymentStatus(status)
+		}
+
+		if status.Failed {
+			os.Exit(exitDeploymentFailed)
+		}
+		if !status.Complete {
+			os.Exit(exitInProgress)
+		}
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(deploymentStatusCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// deploymentStatusCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	deploymentStatusCmd.Flags().StringVarP(&service, "service", "s", "", "ECS service name or ARN")
+	// Complete service names in bash using __awsops_services from completion.go
+	cobra.MarkFlagCustom(deploymentStatusCmd.Flags(), "service", "__awsops_services")
+	deploymentStatusCmd.Flags().StringVar(&deployedTaskDefinition, "task-definition", "", "Task definition ARN or family:revision that was deployed, the deployment fails if the primary deployment runs another")
+	deploymentStatusCmd.Flags().StringVarP(&output, "output", "o", "text", "Output format, either text or json")
+}
+
+func printDeploymentStatus(status lib.DeploymentStatus) {
+	state := status.RolloutState
+	if state == "" {
+		state = "no rollout state reported"
+	}
+
+	fmt.Printf("Service %s deployment of %s: %s\n", status.ServiceName, status.TaskDefinition, state)
+	if status.RolloutStateReason != "" {
+		fmt.Println("  ", status.RolloutStateReason)
+	}
+	fmt.Printf("   Running: %v/%v, pending: %v, deployments: %v\n", status.RunningCount, status.DesiredCount,
+		status.PendingCount, status.Deployments)
+
+	switch {
+	case status.Failed:
+		fmt.Println("Deployment failed")
+	case status.Complete:
+		fmt.Println("Deployment complete")
+	default:
+		fmt.Println("Deployment in progress")
+	}
+}
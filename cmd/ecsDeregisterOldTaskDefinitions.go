@@ -0,0 +1,115 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+var keepRevisions int
+
+// deregisterOldTaskDefinitionsCmd represents the ecsDeregisterOldTaskDefinitions command
+var deregisterOldTaskDefinitionsCmd = &cobra.Command{
+	Use:   "deregisterOldTaskDefinitions",
+	Short: "Deregister all but the newest task definition revisions in each family",
+	Long: "Command deregisters all but the newest --keep ACTIVE revisions of each task definition family matching --family-prefix. " +
+		"Revisions referenced by a service in any cluster in the region are never deregistered.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if familyPrefix == "" {
+			fmt.Println("A --family-prefix must be provided")
+			os.Exit(1)
+		}
+		if keepRevisions < 1 {
+			fmt.Println("--keep must be at least 1")
+			os.Exit(1)
+		}
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		families, err := lib.ListTaskDefinitionFamilies(ctx, AwsSess, familyPrefix, ecs.TaskDefinitionStatusActive)
+		if err != nil {
+			exitWithError("Unable to list task definitions: ", err)
+		}
+
+		clusters, err := lib.ListEcsClusterArns(ctx, AwsSess)
+		if err != nil {
+			exitWithError("Unable to list clusters to check for task definitions in use: ", err)
+		}
+		inUse, err := lib.GetTaskDefinitionsInUse(ctx, AwsSess, clusters)
+		if err != nil {
+			exitWithError("Unable to check for task definitions in use: ", err)
+		}
+
+		old := lib.GetOldTaskDefinitionRevisions(families, keepRevisions, inUse)
+		if len(old) == 0 {
+			fmt.Println("No task definition revisions to deregister")
+			return
+		}
+
+		if dryRun {
+			fmt.Printf("Task definition revisions that would be deregistered (%v):\n", len(old))
+			for _, revision := range old {
+				fmt.Println("  ", revision.Arn)
+			}
+			fmt.Println("DRY RUN — no changes made")
+			return
+		}
+
+		failed := 0
+		for _, revision := range old {
+			// Checked again here so a revision is refused even if the selection above changes
+			if service, ok := inUse[revision.Arn]; ok {
+				fmt.Printf("Refusing to deregister %s, it is used by service %s\n", revision.Arn, service)
+				continue
+			}
+
+			fmt.Println("Deregistering task definition: ", revision.Arn)
+			err := lib.DeregisterTaskDefinition(ctx, AwsSess, revision.Arn)
+			if err != nil {
+				fmt.Println("Unable to deregister task definition: ", err)
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			fmt.Printf("Unable to deregister %v of %v task definition revisions\n", failed, len(old))
+			os.Exit(1)
+		}
+		fmt.Printf("Deregistered %v task definition revisions\n", len(old))
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(deregisterOldTaskDefinitionsCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// deregisterOldTaskDefinitionsCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	deregisterOldTaskDefinitionsCmd.Flags().StringVar(&familyPrefix, "family-prefix", "", "Only deregister revisions of task definition families starting with this prefix (required)")
+	deregisterOldTaskDefinitionsCmd.Flags().IntVar(&keepRevisions, "keep", 5, "Number of newest revisions to keep in each family")
+	deregisterOldTaskDefinitionsCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the revisions that would be deregistered without making any changes")
+}
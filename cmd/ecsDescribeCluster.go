@@ -0,0 +1,71 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+// describeClusterCmd represents the ecsDescribeCluster command
+var describeClusterCmd = &cobra.Command{
+	Use:   "describeCluster",
+	Short: "Describe instances and services for ECS cluster",
+	Long:  "Command prints the ASG name, instance IDs and IPs, and running/pending task counts per service for an ECS cluster",
+	Run: func(cmd *cobra.Command, args []string) {
+		checkOutputFormat()
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		report, err := lib.GetClusterReport(ctx, AwsSess, cluster)
+		if err != nil {
+			exitWithError("Unable to describe cluster: ", err)
+		}
+
+		if output == "json" {
+			printJSON(report)
+			return
+		}
+
+		fmt.Println("Cluster: ", report.ClusterName)
+		fmt.Println("ASG: ", report.AsgName)
+		fmt.Printf("Instances (%v):\n", len(report.Instances))
+		for _, instance := range report.Instances {
+			fmt.Printf("  %s  %s  %s\n", instance.InstanceID, instance.PrivateIP, instance.AvailabilityZone)
+		}
+		fmt.Printf("Services (%v):\n", len(report.Services))
+		for _, service := range report.Services {
+			fmt.Printf("  %s  running: %v  pending: %v\n", service.ServiceName, service.RunningCount, service.PendingCount)
+		}
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(describeClusterCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// describeClusterCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	describeClusterCmd.Flags().StringVarP(&output, "output", "o", "text", "Output format, either text or json")
+}
@@ -0,0 +1,135 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+var instanceID string
+var drainInstanceIDs []string
+var noWait bool
+
+// drainInstanceCmd represents the ecsDrainInstance command
+var drainInstanceCmd = &cobra.Command{
+	Use:     "drainInstance",
+	Aliases: []string{"cordon"},
+	Short:   "Drain container instances in an ECS cluster",
+	Long: `Sets the container instances for the given EC2 instances to DRAINING so
+ECS moves their tasks elsewhere, then waits until no tasks are running on them.
+
+Called as cordon it returns straight away like --no-wait, leaving the instances running so they
+can be investigated, and undrainInstance (or uncordon) sets them back to ACTIVE. ECS has no state
+that only stops new placements, so service tasks on a cordoned instance are still replaced elsewhere
+while standalone tasks keep running. Unlike replaceInstances the instances are not detached from the
+ASG or terminated.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(drainInstanceIDs) == 0 {
+			fmt.Println("At least one instance ID is required, use --instance-id")
+			os.Exit(1)
+		}
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		instances := getContainerInstancesToDrain(ctx)
+		for _, instance := range instances {
+			if aws.StringValue(instance.Status) == ecs.ContainerInstanceStatusDraining {
+				fmt.Printf("Warning: instance %s is already DRAINING\n", *instance.Ec2InstanceId)
+				continue
+			}
+
+			fmt.Printf("Draining instance %s (%s)...", *instance.Ec2InstanceId, *instance.ContainerInstanceArn)
+			err := lib.UpdateContainerInstanceState(ctx, AwsSess, cluster, *instance.ContainerInstanceArn, ecs.ContainerInstanceStatusDraining)
+			if err != nil {
+				fmt.Println()
+				exitWithError("Unable to drain instance: ", err)
+			}
+			fmt.Printf("done\n")
+		}
+
+		if noWait || cmd.CalledAs() == "cordon" {
+			return
+		}
+
+		for _, instance := range instances {
+			err := waitForZeroRunningTasks(ctx, *instance.ContainerInstanceArn)
+			if err != nil {
+				exitWithError("Stopped waiting for tasks to drain: ", err)
+			}
+			fmt.Printf("Instance %s drained\n", *instance.Ec2InstanceId)
+		}
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(drainInstanceCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// drainInstanceCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	drainInstanceCmd.Flags().StringArrayVarP(&drainInstanceIDs, "instance-id", "i", []string{}, "EC2 instance ID of the container instance, may be repeated")
+	drainInstanceCmd.Flags().BoolVar(&noWait, "no-wait", false, "Return immediately after setting the instances to DRAINING, the default when called as cordon")
+}
+
+// getContainerInstancesToDrain looks up the container instance for each --instance-id, exiting before
+// any instance is changed if one of them is not registered with the cluster
+func getContainerInstancesToDrain(ctx context.Context) []*ecs.ContainerInstance {
+	var instances []*ecs.ContainerInstance
+	for _, id := range drainInstanceIDs {
+		instance, err := lib.GetContainerInstanceForEc2Instance(ctx, AwsSess, cluster, id)
+		if err != nil {
+			exitWithError("Unable to find container instance: ", err)
+		}
+		instances = append(instances, instance)
+	}
+
+	return instances
+}
+
+func waitForZeroRunningTasks(ctx context.Context, containerInstanceArn string) error {
+	for {
+		instance, err := lib.DescribeContainerInstance(ctx, AwsSess, cluster, containerInstanceArn)
+		if err != nil {
+			fmt.Println()
+			return err
+		}
+
+		fmt.Printf("\rRunning tasks: %v ", *instance.RunningTasksCount)
+		if *instance.RunningTasksCount == 0 {
+			fmt.Println()
+			return nil
+		}
+
+		if err := aws.SleepWithContext(ctx, 15*time.Second); err != nil {
+			fmt.Println()
+			return err
+		}
+	}
+}
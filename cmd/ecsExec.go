@@ -0,0 +1,158 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+// sessionManagerPlugin is the AWS binary that connects to an SSM session, which ECS Exec sessions are
+const sessionManagerPlugin = "session-manager-plugin"
+
+var execTask string
+var execContainer string
+var execCommand string
+var execPrintSession bool
+
+// execCmd represents the ecsExec command
+var execCmd = &cobra.Command{
+	Use:   "exec",
+	Short: "Run a command in a container of an ECS task with ECS Exec",
+	Long: `Starts an ECS Exec session running --command in a container of a task, then connects to it with the
+session manager plugin so the command is interactive:
+
+  awsops ecs exec -c cluster -t 0123456789abcdef --container app --command /bin/sh
+
+The task must have been started with ECS Exec enabled. The session manager plugin must be installed for
+an interactive session, see https://docs.aws.amazon.com/systems-manager/latest/userguide/session-manager-working-with-install-plugin.html.
+Without it, or with --print-session, the session is started and its ID printed instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if execTask == "" {
+			fmt.Println("Task is required, use --task")
+			os.Exit(1)
+		}
+		if execCommand == "" {
+			fmt.Println("Command is required, use --command")
+			os.Exit(1)
+		}
+		checkOutputFormat()
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		execSession, err := lib.StartExecSession(ctx, AwsSess, cluster, execTask, execContainer, execCommand)
+		if err != nil {
+			exitWithError("Unable to start ECS Exec session: ", err)
+		}
+
+		plugin, lookErr := exec.LookPath(sessionManagerPlugin)
+		if execPrintSession || lookErr != nil {
+			if output == "json" {
+				printJSON(execSession)
+				return
+			}
+
+			fmt.Printf("Started session %s in container %s of task %s\n", execSession.SessionID,
+				execSession.ContainerName, execSession.TaskArn)
+			if !execPrintSession {
+				fmt.Printf("Install %s to attach to the session interactively\n", sessionManagerPlugin)
+			}
+			return
+		}
+
+		// The session outlives the command context so --timeout doesn't end it, and Ctrl-C is left for
+		// the plugin to pass on to the command like the AWS CLI does
+		cancel()
+		signal.Ignore(os.Interrupt)
+		if err := runSessionManagerPlugin(plugin, execSession); err != nil {
+			exitWithError("Session ended with an error: ", err)
+		}
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(execCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// execCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	execCmd.Flags().StringVarP(&execTask, "task", "t", "", "ECS task ID or ARN")
+	execCmd.Flags().StringVar(&execContainer, "container", "", "Container to run the command in, optional when the task has one container")
+	execCmd.Flags().StringVar(&execCommand, "command", "/bin/sh", "Command to run in the container")
+	execCmd.Flags().BoolVar(&execPrintSession, "print-session", false, "Start the session and print its ID instead of attaching to it")
+	execCmd.Flags().StringVarP(&output, "output", "o", "text", "Output format of --print-session, either text or json")
+}
+
+// runSessionManagerPlugin attaches the terminal to the session through the session manager plugin, with the
+// same arguments the AWS CLI passes it
+func runSessionManagerPlugin(plugin string, execSession *lib.ExecSession) error {
+	sessionJSON, err := json.Marshal(map[string]string{
+		"SessionId":  execSession.SessionID,
+		"StreamUrl":  execSession.StreamURL,
+		"TokenValue": execSession.TokenValue,
+	})
+	if err != nil {
+		return err
+	}
+
+	targetJSON, err := json.Marshal(map[string]string{"Target": execSession.Target})
+	if err != nil {
+		return err
+	}
+
+	region := aws.StringValue(AwsSess.Config.Region)
+	ssmEndpoint, err := sessionManagerEndpoint(region)
+	if err != nil {
+		return err
+	}
+
+	pluginCmd := exec.Command(plugin, string(sessionJSON), region, "StartSession", Profile, string(targetJSON), ssmEndpoint)
+	pluginCmd.Stdin = os.Stdin
+	pluginCmd.Stdout = os.Stdout
+	pluginCmd.Stderr = os.Stderr
+
+	return pluginCmd.Run()
+}
+
+// sessionManagerEndpoint returns the SSM endpoint the plugin connects through, which is --endpoint-url when
+// set, otherwise the SDK's endpoint for the region so partitions such as China and GovCloud are covered
+func sessionManagerEndpoint(region string) (string, error) {
+	if EndpointURL != "" {
+		return EndpointURL, nil
+	}
+
+	endpoint, err := endpoints.DefaultResolver().EndpointFor(ssm.EndpointsID, region)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve the SSM endpoint for region %s: %w", region, err)
+	}
+
+	return endpoint.URL, nil
+}
@@ -0,0 +1,115 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+var serviceName string
+var nameRegex bool
+
+// findServiceCmd represents the ecsFindService command
+var findServiceCmd = &cobra.Command{
+	Use:   "findService",
+	Short: "Find which ECS clusters run a service",
+	Long:  "Command searches the services in every ECS cluster in the account and region and prints the clusters with a service matching --name, exiting with code 2 when there is no match",
+	Run: func(cmd *cobra.Command, args []string) {
+		checkOutputFormat()
+		if serviceName == "" {
+			fmt.Println("A service --name must be provided")
+			os.Exit(1)
+		}
+
+		match := func(name string) bool {
+			return name == serviceName
+		}
+		if nameRegex {
+			re, err := regexp.Compile(serviceName)
+			if err != nil {
+				exitWithError("Invalid --name regular expression: ", err)
+			}
+			match = re.MatchString
+		}
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		// Services found in the clusters that could be searched are still shown when others fail
+		found, err := lib.FindService(ctx, AwsSess, match)
+		if err != nil {
+			if output != "json" && len(found) > 0 {
+				printServiceLocationTable(found)
+			}
+			exitWithError("Unable to search clusters for service: ", err)
+		}
+
+		if output == "json" {
+			printJSON(found)
+		} else if len(found) > 0 {
+			printServiceLocationTable(found)
+		}
+
+		if len(found) == 0 {
+			if output != "json" {
+				fmt.Printf("No service matching %q found in any cluster\n", serviceName)
+			}
+			os.Exit(exitNotFound)
+		}
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(findServiceCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// findServiceCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	findServiceCmd.Flags().StringVar(&serviceName, "name", "", "Service name to search for")
+	findServiceCmd.Flags().BoolVar(&nameRegex, "regex", false, "Treat --name as a regular expression matched against service names")
+	findServiceCmd.Flags().StringVarP(&output, "output", "o", "text", "Output format, either text or json")
+}
+
+func printServiceLocationTable(found []lib.ServiceLocation) {
+	clusterWidth, serviceWidth, statusWidth := len("CLUSTER"), len("SERVICE"), len("STATUS")
+	for _, f := range found {
+		if len(f.ClusterName) > clusterWidth {
+			clusterWidth = len(f.ClusterName)
+		}
+		if len(f.ServiceName) > serviceWidth {
+			serviceWidth = len(f.ServiceName)
+		}
+		if len(f.Status) > statusWidth {
+			statusWidth = len(f.Status)
+		}
+	}
+
+	format := fmt.Sprintf("%%-%vs  %%-%vs  %%-%vs  %%7v  %%7v\n", clusterWidth, serviceWidth, statusWidth)
+	fmt.Printf(format, "CLUSTER", "SERVICE", "STATUS", "DESIRED", "RUNNING")
+	for _, f := range found {
+		fmt.Printf(format, f.ClusterName, f.ServiceName, f.Status, f.DesiredCount, f.RunningCount)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+var maxMemoryPercent float64
+
+// healthCheckCmd represents the ecsHealthCheck command
+var healthCheckCmd = &cobra.Command{
+	Use:   "healthCheck",
+	Short: "Check that an ECS cluster is operationally sound",
+	Long: `Runs read-only checks against an ECS cluster and reports whether each passed: every active service has
+its desired count of tasks running, no deployment has failed, the ECS agent on every container instance is
+connected and no container instance has more than --max-memory-percent of its memory reserved. Exits 1 when
+any check fails, or with the code for the error when the services or container instances can't be described.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if maxMemoryPercent <= 0 || maxMemoryPercent > 100 {
+			fmt.Println("--max-memory-percent must be greater than 0 and at most 100")
+			os.Exit(1)
+		}
+		checkOutputFormat()
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		checks, err := lib.CheckClusterHealth(ctx, AwsSess, cluster, maxMemoryPercent)
+
+		if output == "json" {
+			printJSON(checks)
+		} else {
+			printHealthChecks(checks)
+		}
+
+		// The checks that could not run already show the error, so only the exit code is left
+		if err != nil {
+			os.Exit(exitCodeForError(err))
+		}
+
+		for _, check := range checks {
+			if !check.Passed {
+				os.Exit(exitGeneric)
+			}
+		}
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(healthCheckCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// healthCheckCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	healthCheckCmd.Flags().Float64Var(&maxMemoryPercent, "max-memory-percent", 90, "Fail when a container instance has more than this percent of its memory reserved")
+	healthCheckCmd.Flags().StringVarP(&output, "output", "o", "text", "Output format, either text or json")
+}
+
+func printHealthChecks(checks []lib.HealthCheck) {
+	for _, check := range checks {
+		result := "PASS"
+		if !check.Passed {
+			result = "FAIL"
+		}
+
+		fmt.Printf("%s  %s\n", result, check.Name)
+		for _, failure := range check.Failures {
+			fmt.Println("       ", failure)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+var minHealthyPercentage int64
+var instanceWarmup time.Duration
+var refreshTimeout time.Duration
+var completeLifecycleHooks bool
+
+// instanceRefreshCmd represents the ecsInstanceRefresh command
+var instanceRefreshCmd = &cobra.Command{
+	Use:   "instanceRefresh",
+	Short: "Replace EC2 instances for given ECS cluster with an ASG instance refresh",
+	Long: `Start an Auto Scaling instance refresh on the ASG for the cluster and
+wait for it to finish. AWS replaces instances in batches while keeping
+--min-healthy-percentage of the desired capacity InService.
+
+Unlike replaceInstances, instances are not drained in ECS before they are
+terminated unless the ASG has a termination lifecycle hook that drains them.
+With --complete-lifecycle-hooks, instances held by termination lifecycle
+hooks are drained and their hooks completed once they have no running tasks.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if minHealthyPercentage < 0 || minHealthyPercentage > 100 {
+			fmt.Println("--min-healthy-percentage must be between 0 and 100")
+			os.Exit(1)
+		}
+		if instanceWarmup < 0 {
+			fmt.Println("--instance-warmup must not be negative")
+			os.Exit(1)
+		}
+		if completeLifecycleHooks && noWait {
+			fmt.Println("--complete-lifecycle-hooks completes hooks while waiting for the refresh, it can't be used with --no-wait")
+			os.Exit(1)
+		}
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		asgName, err := lib.GetAsgNameForEcsCluster(ctx, AwsSess, cluster)
+		if err != nil {
+			exitWithError("Unable to find ASG for cluster: ", err)
+		}
+
+		hooks, err := lib.GetTerminatingLifecycleHooks(ctx, AwsSess, asgName)
+		if err != nil {
+			exitWithError("Unable to check lifecycle hooks: ", err)
+		}
+		if len(hooks) > 0 && !completeLifecycleHooks {
+			fmt.Printf("Warning: ASG %s has termination lifecycle hooks (%s), which may delay termination until they are "+
+				"completed or time out. Use --complete-lifecycle-hooks to drain instances and complete them.\n",
+				asgName, strings.Join(hooks, ", "))
+		}
+
+		refreshID, err := lib.StartInstanceRefresh(ctx, AwsSess, asgName, minHealthyPercentage, instanceWarmup)
+		if err != nil {
+			exitWithError("Unable to start instance refresh: ", err)
+		}
+		fmt.Printf("Started instance refresh %s for ASG %s\n", refreshID, asgName)
+
+		if noWait {
+			return
+		}
+
+		var whileWaiting func(ctx context.Context) error
+		if completeLifecycleHooks && len(hooks) > 0 {
+			whileWaiting = func(ctx context.Context) error {
+				completed, err := lib.CompleteTerminatingLifecycleHooks(ctx, AwsSess, cluster, asgName, hooks)
+				if len(completed) > 0 {
+					fmt.Printf("\nDrained and completed lifecycle hooks for %s\n", strings.Join(completed, ", "))
+				}
+				return err
+			}
+		}
+
+		if err := lib.WaitForInstanceRefresh(ctx, AwsSess, asgName, refreshID, refreshTimeout, whileWaiting); err != nil {
+			exitWithError("Instance refresh did not complete: ", err)
+		}
+		fmt.Println("Instance refresh complete")
+
+		if waitStable {
+			waitForServicesStable(ctx)
+		}
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(instanceRefreshCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// instanceRefreshCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	instanceRefreshCmd.Flags().Int64Var(&minHealthyPercentage, "min-healthy-percentage", 90, "Percentage of the ASG desired capacity that must stay InService during the refresh")
+	instanceRefreshCmd.Flags().DurationVar(&instanceWarmup, "instance-warmup", 5*time.Minute, "Time after a new instance is InService before it counts as healthy, long enough for the ECS agent to register and tasks to start, 0 uses the ASG health check grace period")
+	instanceRefreshCmd.Flags().DurationVar(&refreshTimeout, "refresh-timeout", time.Hour, "Maximum time to wait for the instance refresh to finish")
+	instanceRefreshCmd.Flags().BoolVar(&completeLifecycleHooks, "complete-lifecycle-hooks", false, "Drain instances held by termination lifecycle hooks and complete the hooks once they have no running tasks")
+	instanceRefreshCmd.Flags().BoolVar(&noWait, "no-wait", false, "Return immediately after starting the instance refresh")
+	addWaitFlags(instanceRefreshCmd)
+}
@@ -0,0 +1,99 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+// instanceTasksCmd represents the ecsInstanceTasks command
+var instanceTasksCmd = &cobra.Command{
+	Use:   "instanceTasks",
+	Short: "List tasks running on a container instance in an ECS cluster",
+	Long:  "Command prints the group, last status, start time, task definition and ARN of each task running on the container instance for the given EC2 instance",
+	Run: func(cmd *cobra.Command, args []string) {
+		checkOutputFormat()
+		if instanceID == "" {
+			fmt.Println("Instance ID is required, use --instance-id")
+			os.Exit(1)
+		}
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		instance, err := lib.GetContainerInstanceForEc2Instance(ctx, AwsSess, cluster, instanceID)
+		if err != nil {
+			exitWithError("Unable to find container instance: ", err)
+		}
+
+		tasks, err := lib.GetRunningTasksForInstance(ctx, AwsSess, cluster, *instance.ContainerInstanceArn)
+		if err != nil {
+			exitWithError("Unable to list tasks: ", err)
+		}
+
+		if output == "json" {
+			printJSON(tasks)
+			return
+		}
+
+		printTaskTable(tasks)
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(instanceTasksCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// instanceTasksCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	instanceTasksCmd.Flags().StringVarP(&instanceID, "instance-id", "i", "", "EC2 instance ID of the container instance")
+	instanceTasksCmd.Flags().StringVarP(&output, "output", "o", "text", "Output format, either text or json")
+}
+
+func printTaskTable(tasks []lib.InstanceTask) {
+	groupWidth, statusWidth, taskDefWidth := len("GROUP"), len("STATUS"), len("TASK DEFINITION")
+	for _, t := range tasks {
+		if len(t.Group) > groupWidth {
+			groupWidth = len(t.Group)
+		}
+		if len(t.LastStatus) > statusWidth {
+			statusWidth = len(t.LastStatus)
+		}
+		if len(t.TaskDefinition) > taskDefWidth {
+			taskDefWidth = len(t.TaskDefinition)
+		}
+	}
+
+	format := fmt.Sprintf("%%-%vs  %%-%vs  %%-20s  %%-%vs  %%s\n", groupWidth, statusWidth, taskDefWidth)
+	fmt.Printf(format, "GROUP", "STATUS", "STARTED", "TASK DEFINITION", "TASK")
+	for _, t := range tasks {
+		started := "-"
+		if t.StartedAt != nil {
+			started = t.StartedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Printf(format, t.Group, t.LastStatus, started, t.TaskDefinition, t.TaskArn)
+	}
+}
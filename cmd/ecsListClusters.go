@@ -0,0 +1,80 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+// listClustersCmd represents the ecsListClusters command
+var listClustersCmd = &cobra.Command{
+	Use:   "listClusters",
+	Short: "List ECS clusters with instance, service and task counts",
+	Long:  "Command prints a table of every ECS cluster in the account and region with status, registered container instances, active services and running/pending tasks",
+	Run: func(cmd *cobra.Command, args []string) {
+		checkOutputFormat()
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		summaries, err := lib.GetClusterSummaries(ctx, AwsSess)
+		if err != nil {
+			exitWithError("Unable to list clusters: ", err)
+		}
+
+		if output == "json" {
+			printJSON(summaries)
+			return
+		}
+
+		printClusterTable(summaries)
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(listClustersCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// listClustersCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	listClustersCmd.Flags().StringVarP(&output, "output", "o", "text", "Output format, either text or json")
+}
+
+func printClusterTable(summaries []lib.ClusterSummary) {
+	nameWidth, statusWidth := len("CLUSTER"), len("STATUS")
+	for _, s := range summaries {
+		if len(s.ClusterName) > nameWidth {
+			nameWidth = len(s.ClusterName)
+		}
+		if len(s.Status) > statusWidth {
+			statusWidth = len(s.Status)
+		}
+	}
+
+	format := fmt.Sprintf("%%-%vs  %%-%vs  %%9v  %%8v  %%7v  %%7v\n", nameWidth, statusWidth)
+	fmt.Printf(format, "CLUSTER", "STATUS", "INSTANCES", "SERVICES", "RUNNING", "PENDING")
+	for _, s := range summaries {
+		fmt.Printf(format, s.ClusterName, s.Status, s.RegisteredContainerInstances, s.ActiveServices, s.RunningTasks, s.PendingTasks)
+	}
+}
@@ -21,6 +21,8 @@ import (
 	"strings"
 )
 
+var publicIPs bool
+
 // ecsListInstanceIPsCmd represents the ecsListInstanceIPs command
 var listInstanceIPsCmd = &cobra.Command{
 	Use:   "listInstanceIPs",
@@ -28,8 +30,15 @@ var listInstanceIPsCmd = &cobra.Command{
 	Long:  "Command returns a space separated list of IP addresses for instances in an ECS cluster",
 	Run: func(cmd *cobra.Command, args []string) {
 		initAwsSess()
-
-		instanceIPs := lib.GetInstanceIPsForEcsCluster(AwsSess, cluster)
+		ctx, cancel := initContext()
+		defer cancel()
+
+		var instanceIPs []string
+		if publicIPs {
+			instanceIPs = lib.GetInstancePublicIPsForEcsCluster(ctx, AwsSess, cluster)
+		} else {
+			instanceIPs = lib.GetInstanceIPsForEcsCluster(ctx, AwsSess, cluster)
+		}
 		fmt.Println(strings.Join(instanceIPs, " "))
 	},
 }
@@ -47,4 +56,5 @@ func init() {
 	// is called directly, e.g.:
 	// ecsListInstanceIPsCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	//ecsReplaceInstancesCmd.Flags().StringVarP(&cluster, "cluster", "c", "", "ECS cluster name")
+	listInstanceIPsCmd.Flags().BoolVar(&publicIPs, "public", false, "List public IPs instead of private IPs, instances without a public IP are skipped")
 }
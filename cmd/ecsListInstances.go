@@ -0,0 +1,88 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+// listInstancesCmd represents the ecsListInstances command
+var listInstancesCmd = &cobra.Command{
+	Use:   "listInstances",
+	Short: "List container instances for ECS cluster with resource utilization",
+	Long:  "Command prints a table of container instances in an ECS cluster with IP, AMI, Availability Zone, task counts and registered vs remaining CPU and memory, most loaded first",
+	Run: func(cmd *cobra.Command, args []string) {
+		checkOutputFormat()
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		utilization, err := lib.GetInstanceUtilizationForEcsCluster(ctx, AwsSess, cluster)
+		if err != nil {
+			exitWithError("Unable to list instances: ", err)
+		}
+
+		if output == "json" {
+			printJSON(utilization)
+			return
+		}
+
+		printInstanceTable(utilization)
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(listInstancesCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// listInstancesCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	listInstancesCmd.Flags().StringVarP(&output, "output", "o", "text", "Output format, either text or json")
+}
+
+func printInstanceTable(utilization []lib.InstanceUtilization) {
+	idWidth, ipWidth, amiWidth, azWidth := len("INSTANCE"), len("IP"), len("AMI"), len("AZ")
+	for _, u := range utilization {
+		if len(u.InstanceID) > idWidth {
+			idWidth = len(u.InstanceID)
+		}
+		if len(u.PrivateIP) > ipWidth {
+			ipWidth = len(u.PrivateIP)
+		}
+		if len(u.AmiID) > amiWidth {
+			amiWidth = len(u.AmiID)
+		}
+		if len(u.AvailabilityZone) > azWidth {
+			azWidth = len(u.AvailabilityZone)
+		}
+	}
+
+	format := fmt.Sprintf("%%-%vs  %%-%vs  %%-%vs  %%-%vs  %%7v  %%7v  %%11v  %%13v\n", idWidth, ipWidth, amiWidth, azWidth)
+	fmt.Printf(format, "INSTANCE", "IP", "AMI", "AZ", "RUNNING", "PENDING", "CPU", "MEMORY")
+	for _, u := range utilization {
+		fmt.Printf(format, u.InstanceID, u.PrivateIP, u.AmiID, u.AvailabilityZone, u.RunningTasks, u.PendingTasks,
+			fmt.Sprintf("%v/%v", u.RemainingCPU, u.RegisteredCPU),
+			fmt.Sprintf("%v/%v", u.RemainingMemory, u.RegisteredMemory))
+	}
+}
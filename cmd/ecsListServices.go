@@ -0,0 +1,139 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+type serviceSummary struct {
+	ServiceName  string            `json:"serviceName"`
+	Status       string            `json:"status"`
+	DesiredCount int64             `json:"desiredCount"`
+	RunningCount int64             `json:"runningCount"`
+	PendingCount int64             `json:"pendingCount"`
+	Revision     string            `json:"taskDefinitionRevision"`
+	Tags         map[string]string `json:"tags"`
+}
+
+var serviceFilterTags []string
+
+// listServicesCmd represents the ecsListServices command
+var listServicesCmd = &cobra.Command{
+	Use:   "listServices",
+	Short: "List services for ECS cluster with task counts",
+	Long:  "Command prints a table of services in an ECS cluster with status, desired/running/pending counts, task definition revision and tags",
+	Run: func(cmd *cobra.Command, args []string) {
+		checkOutputFormat()
+		tags, err := parseTagFlag("--filter-tag", serviceFilterTags)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		services := lib.FilterServicesByTags(lib.ListServicesForEcsCluster(ctx, AwsSess, cluster, ecs.ServiceFieldTags), tags)
+
+		summaries := []serviceSummary{}
+		for _, service := range services {
+			serviceTags := map[string]string{}
+			for _, tag := range service.Tags {
+				serviceTags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+
+			summaries = append(summaries, serviceSummary{
+				ServiceName:  aws.StringValue(service.ServiceName),
+				Status:       aws.StringValue(service.Status),
+				DesiredCount: aws.Int64Value(service.DesiredCount),
+				RunningCount: aws.Int64Value(service.RunningCount),
+				PendingCount: aws.Int64Value(service.PendingCount),
+				Revision:     taskDefinitionRevision(aws.StringValue(service.TaskDefinition)),
+				Tags:         serviceTags,
+			})
+		}
+
+		sort.Slice(summaries, func(i, j int) bool {
+			return summaries[i].ServiceName < summaries[j].ServiceName
+		})
+
+		if output == "json" {
+			printJSON(summaries)
+			return
+		}
+
+		printServiceTable(summaries)
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(listServicesCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// listServicesCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	listServicesCmd.Flags().StringVarP(&output, "output", "o", "text", "Output format, either text or json")
+	listServicesCmd.Flags().StringArrayVar(&serviceFilterTags, "filter-tag", []string{}, "Only list services with this key=value tag, may be repeated to require several tags")
+}
+
+// taskDefinitionRevision returns the revision number from a task definition ARN
+// such as arn:aws:ecs:us-east-1:123456789012:task-definition/family:12
+func taskDefinitionRevision(taskDefinitionArn string) string {
+	i := strings.LastIndex(taskDefinitionArn, ":")
+	if i == -1 {
+		return ""
+	}
+
+	return taskDefinitionArn[i+1:]
+}
+
+func printServiceTable(summaries []serviceSummary) {
+	nameWidth, statusWidth := len("SERVICE"), len("STATUS")
+	for _, s := range summaries {
+		if len(s.ServiceName) > nameWidth {
+			nameWidth = len(s.ServiceName)
+		}
+		if len(s.Status) > statusWidth {
+			statusWidth = len(s.Status)
+		}
+	}
+
+	format := fmt.Sprintf("%%-%vs  %%-%vs  %%7v  %%7v  %%7v  %%8v  %%s\n", nameWidth, statusWidth)
+	fmt.Printf(format, "SERVICE", "STATUS", "DESIRED", "RUNNING", "PENDING", "REVISION", "TAGS")
+	for _, s := range summaries {
+		var tags []string
+		for key, value := range s.Tags {
+			tags = append(tags, key+"="+value)
+		}
+		sort.Strings(tags)
+
+		fmt.Printf(format, s.ServiceName, s.Status, s.DesiredCount, s.RunningCount, s.PendingCount, s.Revision, strings.Join(tags, ","))
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+var familyPrefix string
+var taskDefinitionStatus string
+var latestOnly bool
+
+// listTaskDefinitionsCmd represents the ecsListTaskDefinitions command
+var listTaskDefinitionsCmd = &cobra.Command{
+	Use:   "listTaskDefinitions",
+	Short: "List task definition revisions grouped by family",
+	Long:  "Command prints the task definition ARNs in the account and region grouped by family with their revision numbers, oldest first",
+	Run: func(cmd *cobra.Command, args []string) {
+		checkOutputFormat()
+		if taskDefinitionStatus != ecs.TaskDefinitionStatusActive && taskDefinitionStatus != ecs.TaskDefinitionStatusInactive {
+			fmt.Printf("Invalid status %q, must be ACTIVE or INACTIVE\n", taskDefinitionStatus)
+			os.Exit(1)
+		}
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		families, err := lib.ListTaskDefinitionFamilies(ctx, AwsSess, familyPrefix, taskDefinitionStatus)
+		if err != nil {
+			exitWithError("Unable to list task definitions: ", err)
+		}
+
+		if latestOnly {
+			for i, family := range families {
+				families[i].Revisions = family.Revisions[len(family.Revisions)-1:]
+			}
+		}
+
+		if output == "json" {
+			printJSON(families)
+			return
+		}
+
+		for _, family := range families {
+			fmt.Println(family.Family)
+			for _, revision := range family.Revisions {
+				fmt.Printf("  %6v  %s\n", revision.Revision, revision.Arn)
+			}
+		}
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(listTaskDefinitionsCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// listTaskDefinitionsCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	listTaskDefinitionsCmd.Flags().StringVar(&familyPrefix, "family-prefix", "", "Only list task definition families starting with this prefix")
+	listTaskDefinitionsCmd.Flags().StringVar(&taskDefinitionStatus, "status", ecs.TaskDefinitionStatusActive, "Task definition status to list, either ACTIVE or INACTIVE")
+	listTaskDefinitionsCmd.Flags().BoolVar(&latestOnly, "latest-only", false, "Only list the highest revision in each family")
+	listTaskDefinitionsCmd.Flags().StringVarP(&output, "output", "o", "text", "Output format, either text or json")
+}
@@ -0,0 +1,157 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+var moveFromCluster string
+var moveToCluster string
+var moveServiceName string
+var scaleDownSource bool
+
+// moveServiceCmd represents the ecsMoveService command
+var moveServiceCmd = &cobra.Command{
+	Use:   "moveService",
+	Short: "Create a copy of an ECS service in another cluster",
+	Long: `Creates a service in the target cluster running the same task definition with the same desired count,
+load balancers, placement, networking and deployment settings as the service in the source cluster.
+With --scale-down-source the source service is scaled to zero once the new service is stable; it is
+not deleted. A capacity provider strategy is only copied when it uses Fargate, otherwise the target
+cluster's default strategy applies.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if moveFromCluster == "" {
+			moveFromCluster = cluster
+		}
+		if moveFromCluster == "" {
+			fmt.Println("Source cluster is required, use --from-cluster")
+			os.Exit(1)
+		}
+		if moveToCluster == "" {
+			fmt.Println("Target cluster is required, use --to-cluster")
+			os.Exit(1)
+		}
+		if service == "" {
+			fmt.Println("Service is required, use --service")
+			os.Exit(1)
+		}
+		if dryRun && scaleDownSource {
+			fmt.Println("--dry-run makes no changes, it can't be used with --scale-down-source")
+			os.Exit(1)
+		}
+
+		moveFromCluster = lib.NormalizeClusterIdentifier(moveFromCluster)
+		moveToCluster = lib.NormalizeClusterIdentifier(moveToCluster)
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		source, err := lib.GetEcsServiceByName(ctx, AwsSess, moveFromCluster, service, ecs.ServiceFieldTags)
+		if err != nil {
+			exitWithError("Unable to find service: ", err)
+		}
+
+		name := moveServiceName
+		if name == "" {
+			name = aws.StringValue(source.ServiceName)
+		}
+
+		exists, err := lib.EcsClusterExists(ctx, AwsSess, moveToCluster)
+		if err != nil {
+			exitWithError("Unable to describe target cluster: ", err)
+		}
+		if !exists {
+			exitWithError("", fmt.Errorf("Target cluster %s %w", moveToCluster, lib.ErrNotFound))
+		}
+
+		input := lib.GetCreateServiceInputForCopy(source, moveToCluster, name)
+
+		if dryRun {
+			if err := lib.CheckServiceNameAvailable(ctx, AwsSess, moveToCluster, name); err != nil {
+				exitWithError("", err)
+			}
+			fmt.Printf("DRY RUN — service %s would be created in cluster %s with:\n", name, moveToCluster)
+			fmt.Println(input)
+			return
+		}
+
+		fmt.Printf("Creating service %s in cluster %s...", name, moveToCluster)
+		created, err := lib.CreateEcsService(ctx, AwsSess, input)
+		if err != nil {
+			fmt.Println()
+			exitWithError("Unable to create service: ", err)
+		}
+		fmt.Printf("done\n")
+		fmt.Println("New service: ", aws.StringValue(created.ServiceArn))
+
+		if !waitStable && !scaleDownSource {
+			return
+		}
+
+		fmt.Println("Waiting for the new service to become stable...")
+		err = lib.WaitForServiceStable(ctx, AwsSess, moveToCluster, aws.StringValue(created.ServiceArn), waitTimeout)
+		if err != nil {
+			exitWithError("", err)
+		}
+		fmt.Println("Service is stable")
+
+		if !scaleDownSource {
+			return
+		}
+
+		fmt.Printf("Scaling source service %s in cluster %s from %v to 0...", aws.StringValue(source.ServiceName),
+			moveFromCluster, aws.Int64Value(source.DesiredCount))
+		err = lib.UpdateServiceDesiredCount(ctx, AwsSess, moveFromCluster, aws.StringValue(source.ServiceArn), 0)
+		if err != nil {
+			fmt.Println()
+			exitWithError("Unable to scale down source service: ", err)
+		}
+		fmt.Printf("done\n")
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(moveServiceCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// moveServiceCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	moveServiceCmd.Flags().StringVar(&moveFromCluster, "from-cluster", "", "Cluster name or ARN the service is in, defaults to --cluster")
+	moveServiceCmd.Flags().StringVar(&moveToCluster, "to-cluster", "", "Cluster name or ARN to create the new service in")
+	moveServiceCmd.Flags().StringVarP(&service, "service", "s", "", "ECS service name or ARN in the source cluster")
+	moveServiceCmd.Flags().StringVar(&moveServiceName, "new-name", "", "Name for the new service, defaults to the source service name")
+	moveServiceCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the CreateService input for the new service without creating it")
+	moveServiceCmd.Flags().BoolVar(&scaleDownSource, "scale-down-source", false, "Scale the source service to zero once the new service is stable")
+	moveServiceCmd.Flags().BoolVar(&waitStable, "wait", false, "Wait for the new service to become stable, always done with --scale-down-source")
+	moveServiceCmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 10*time.Minute, "Maximum time to wait for the new service to become stable")
+	// Complete cluster and service names in bash using the functions from completion.go
+	cobra.MarkFlagCustom(moveServiceCmd.Flags(), "from-cluster", "__awsops_clusters")
+	cobra.MarkFlagCustom(moveServiceCmd.Flags(), "to-cluster", "__awsops_clusters")
+	cobra.MarkFlagCustom(moveServiceCmd.Flags(), "service", "__awsops_services")
+}
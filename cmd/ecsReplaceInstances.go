@@ -15,54 +15,703 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/silinternational/awsops/lib"
 	"github.com/spf13/cobra"
 )
 
+var dryRun bool
+var pollInterval time.Duration
+var initialDelay time.Duration
+var pendingTimeout time.Duration
+var olderThanAmi string
+var onlyUnhealthy bool
+var notifySnsTopic string
+var emitMetrics bool
+var readyTimeout time.Duration
+var reattachOnAbort bool
+var excludeInstances []string
+var filterTags []string
+var excludeTags []string
+var showProgress bool
+var waitForHealthy bool
+var healthyTimeout time.Duration
+var waitForRegistration bool
+var replaceAsgName string
+var registrationTimeout time.Duration
+var replaceOrder string
+var minHealthy int
+var scaleUpFirst bool
+var raiseMax bool
+var metricsAddr string
+var newInstanceTags []string
+var maxParallel int
+var maxUnavailable int
+var spotFirst bool
+var criticalServices []string
+var forceReplace bool
+var continueOnError bool
+var orderByAz bool
+var summaryOnly bool
+
+// replaceSummary is filled in by replaceInstances as it goes, so the outcome and partial progress can be
+// reported however the replacement stops
+var replaceSummary replacementSummary
+
+// maxPollInterval caps the backoff between pending task checks
+const maxPollInterval = 30 * time.Second
+
 // ecsReplaceInstancesCmd represents the ecsReplaceInstances command
 var replaceInstancesCmd = &cobra.Command{
 	Use:   "replaceInstances",
 	Short: "Gracefully replace EC2 instances for given ECS cluster",
 	Long:  ``,
 	Run: func(cmd *cobra.Command, args []string) {
+		if replaceOrder != "oldest" && replaceOrder != "newest" {
+			fmt.Printf("Invalid order %q, must be oldest or newest\n", replaceOrder)
+			os.Exit(1)
+		}
+		if minHealthy < 0 {
+			fmt.Println("--min-healthy must not be negative")
+			os.Exit(1)
+		}
+		if pollInterval <= 0 {
+			fmt.Println("Poll interval must be greater than zero")
+			os.Exit(1)
+		}
+		if maxParallel < 1 {
+			fmt.Println("--max-parallel must be at least 1")
+			os.Exit(1)
+		}
+		if cmd.Flags().Changed("max-unavailable") && (maxUnavailable < 1 || maxUnavailable > 100) {
+			fmt.Println("--max-unavailable must be a percentage between 1 and 100")
+			os.Exit(1)
+		}
+		if cmd.Flags().Changed("max-parallel") && cmd.Flags().Changed("max-unavailable") {
+			fmt.Println("Use either --max-parallel or --max-unavailable, not both")
+			os.Exit(1)
+		}
+		if raiseMax && !scaleUpFirst {
+			fmt.Println("--raise-max only applies when the ASG is scaled up with --scale-up-first")
+			os.Exit(1)
+		}
+		if forceReplace && cmd.Flags().Changed("pending-timeout") {
+			fmt.Println("--force skips waiting for pending tasks, it can't be used with --pending-timeout")
+			os.Exit(1)
+		}
+		if forceReplace && waitForHealthy {
+			fmt.Println("--force terminates instances without waiting, it can't be used with --wait-for-healthy")
+			os.Exit(1)
+		}
+		if summaryOnly && (dryRun || showProgress) {
+			fmt.Println("--summary-only only prints the outcome, it can't be used with --dry-run or --progress")
+			os.Exit(1)
+		}
+		if forceReplace && waitForRegistration {
+			fmt.Println("--force terminates instances without waiting, it can't be used with --wait-for-registration")
+			os.Exit(1)
+		}
+		if _, err := parseTagFlag("--filter-tag", filterTags); err != nil {
+			exitWithError("", err)
+		}
+		if _, err := parseTagFlag("--exclude-tag", excludeTags); err != nil {
+			exitWithError("", err)
+		}
+		if _, err := parseNewInstanceTags(); err != nil {
+			exitWithError("", err)
+		}
 
 		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
 
-		asgName := lib.GetAsgNameForEcsCluster(AwsSess, cluster)
-		if asgName == "" {
-			fmt.Println("Unable to find ASG name for ECS cluster ", cluster)
-			os.Exit(1)
+		if metricsAddr != "" && !dryRun {
+			var err error
+			replaceMetrics, err = startReplacementMetrics(metricsAddr)
+			if err != nil {
+				exitWithError("", err)
+			}
 		}
 
-		instancesToTerminate := lib.GetInstanceListForAsg(AwsSess, asgName)
+		started := time.Now()
+		restoreStdout := func() {}
+		if summaryOnly {
+			restoreStdout = suppressOutput()
+		}
+		libExitWithError := lib.ExitWithError
+		restoreOutput := func() {
+			restoreStdout()
+			lib.ExitWithError = libExitWithError
+		}
 
-		fmt.Println("Replacing EC2 instances one at a time for ECS cluster: ", cluster)
-		fmt.Println("ASG: ", asgName)
+		// lib functions that exit on failure would otherwise exit without the notification or summary
+		lib.ExitWithError = func(err error) {
+			restoreOutput()
+			replaceMetrics.stop()
+			duration := time.Since(started)
+			if notifySnsTopic != "" && !dryRun {
+				notifyReplacementResult(replaceSummary.replaced, duration, err)
+			}
+			if summaryOnly {
+				replaceSummary.print(duration, err)
+				os.Exit(exitCodeForError(err))
+			}
+			exitWithError("", err)
+		}
+		replaced, err := replaceInstances(ctx)
+		duration := time.Since(started)
+		replaceMetrics.stop()
 
-		lib.DetachAndReplaceAsgInstances(AwsSess, asgName, instancesToTerminate)
+		// --wait runs while output is still hidden so its result is part of the summary
+		if summaryOnly && waitStable && err == nil {
+			replaceSummary.waitForServicesStable(ctx)
+		}
 
-		fmt.Printf("Terminating %v instances...\n", len(instancesToTerminate))
-		for _, instanceID := range instancesToTerminate {
-			_, err := terminateInstance(*instanceID)
+		// Warnings about notifications and metrics are still shown with --summary-only
+		restoreOutput()
+		if notifySnsTopic != "" && !dryRun {
+			notifyReplacementResult(replaced, duration, err)
+		}
+		if emitMetrics && !dryRun && err == nil {
+			metricsErr := lib.PutReplacementMetrics(context.Background(), AwsSess, cluster, duration.Seconds(), int64(replaced))
+			if metricsErr != nil {
+				fmt.Println("Warning: unable to emit CloudWatch metrics: ", metricsErr)
+			}
+		}
+		if summaryOnly {
+			replaceSummary.print(duration, err)
 			if err != nil {
-				fmt.Println("Unable to terminate instance: ", err)
-				os.Exit(1)
+				os.Exit(exitCodeForError(err))
+			}
+			if replaceSummary.waitErr != nil {
+				os.Exit(exitCodeForError(replaceSummary.waitErr))
 			}
-			waitForZeroPendingTasks(cluster)
+			if len(replaceSummary.unstable) > 0 {
+				os.Exit(exitTimeout)
+			}
+			return
+		}
+		if err != nil {
+			exitWithError("", err)
 		}
-		fmt.Println("Finished terminating instances")
 
-		instances := lib.GetInstanceListForEcsCluster(AwsSess, cluster)
-		fmt.Println("Final instances in cluster: ", len(instances))
-		fmt.Println("All done. Be sure to tip your waiter and thank AppsDev for making your life better.")
+		if waitStable && !dryRun {
+			waitForServicesStable(ctx)
+		}
 	},
 }
 
+// replaceInstances runs the replacement for the cluster and returns how many instances were terminated
+func replaceInstances(ctx context.Context) (int, error) {
+	asgName, err := getReplacementAsgName(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to find ASG name for ECS cluster: %w", err)
+	}
+
+	instancesToTerminate, err := lib.GetInstanceListForAsg(ctx, AwsSess, asgName)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to list instances in ASG: %w", err)
+	}
+
+	if olderThanAmi != "" {
+		if olderThanAmi == "latest" {
+			instancesToTerminate, err = lib.GetOutdatedInstancesForAsg(ctx, AwsSess, asgName)
+		} else {
+			instancesToTerminate, err = lib.GetInstancesNotUsingAmi(ctx, AwsSess, instancesToTerminate, olderThanAmi)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Unable to compare instance AMIs: %w", err)
+		}
+
+		if len(instancesToTerminate) == 0 {
+			fmt.Println("All instances are already running the expected AMI, nothing to replace")
+			return 0, nil
+		}
+		fmt.Printf("Found %v instances with an outdated AMI\n", len(instancesToTerminate))
+	}
+
+	if onlyUnhealthy {
+		instancesToTerminate, err = filterUnhealthyInstances(ctx, asgName, instancesToTerminate)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(instancesToTerminate) == 0 {
+			fmt.Println("No instances are failing status checks or marked unhealthy, nothing to replace")
+			return 0, nil
+		}
+		fmt.Printf("Found %v unhealthy instances\n", len(instancesToTerminate))
+	}
+
+	if len(filterTags) > 0 || len(excludeTags) > 0 {
+		instancesToTerminate, err = filterInstancesByTags(ctx, instancesToTerminate)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(instancesToTerminate) == 0 {
+			fmt.Println("No instances match the tag filters, nothing to replace")
+			return 0, nil
+		}
+		fmt.Printf("Found %v instances matching the tag filters\n", len(instancesToTerminate))
+	}
+
+	if len(excludeInstances) > 0 {
+		clusterInstances := lib.GetInstanceIDsForEcsCluster(ctx, AwsSess, cluster)
+		for _, id := range lib.RemoveInstanceIDs(aws.StringSlice(excludeInstances), aws.StringValueSlice(clusterInstances)) {
+			fmt.Printf("Warning: excluded instance %s is not in cluster %s\n", *id, cluster)
+		}
+
+		instancesToTerminate = lib.RemoveInstanceIDs(instancesToTerminate, excludeInstances)
+		if len(instancesToTerminate) == 0 {
+			fmt.Println("All instances are excluded, nothing to replace")
+			return 0, nil
+		}
+		fmt.Printf("Replacing %v instances after exclusions\n", len(instancesToTerminate))
+	}
+
+	instancesToTerminate, err = lib.SortInstancesByLaunchTime(ctx, AwsSess, instancesToTerminate, replaceOrder == "oldest")
+	if err != nil {
+		return 0, fmt.Errorf("Unable to order instances by launch time: %w", err)
+	}
+
+	// Spot instances may be interrupted at any time anyway, so move their tasks first. This is done before
+	// --order-by-az, which keeps the order within each zone, so spot instances go first in every zone.
+	if spotFirst {
+		spot, onDemand, err := lib.PartitionSpotInstances(ctx, AwsSess, instancesToTerminate)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to find spot instances: %w", err)
+		}
+		fmt.Printf("Replacing %v spot instances before %v on-demand instances\n", len(spot), len(onDemand))
+		instancesToTerminate = append(spot, onDemand...)
+	}
+	if orderByAz {
+		instancesToTerminate, err = lib.OrderInstancesByAz(ctx, AwsSess, instancesToTerminate)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to order instances by Availability Zone: %w", err)
+		}
+	}
+
+	if len(criticalServices) > 0 {
+		_, missing := lib.FilterServicesByName(lib.ListServicesForEcsCluster(ctx, AwsSess, cluster), criticalServices)
+		if len(missing) > 0 {
+			return 0, fmt.Errorf("Critical services not found in cluster %s: %s", cluster, strings.Join(missing, ", "))
+		}
+	}
+
+	asgInstanceIDs, err := lib.GetInstanceListForAsg(ctx, AwsSess, asgName)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to list instances in ASG: %w", err)
+	}
+	asgInstances := len(asgInstanceIDs)
+	batchSize := replacementBatchSize(asgInstances)
+	if batchSize == 1 {
+		fmt.Println("Replacing EC2 instances one at a time for ECS cluster: ", cluster)
+	} else {
+		fmt.Printf("Replacing EC2 instances up to %v at a time for ECS cluster: %s\n", batchSize, cluster)
+	}
+	fmt.Println("ASG: ", asgName)
+	replaceSummary.asgName = asgName
+	replaceSummary.total = len(instancesToTerminate)
+
+	spareNeeded, err := lib.SpareInstancesNeeded(asgInstances, len(instancesToTerminate), minHealthy, batchSize, scaleUpFirst)
+	if err != nil {
+		return 0, err
+	}
+
+	if forceReplace {
+		fmt.Println("WARNING: --force terminates instances as soon as replacements are ready without waiting for tasks")
+		fmt.Println("WARNING: tasks running on the terminated instances are stopped without draining, services may be interrupted")
+	}
+
+	if dryRun {
+		if spareNeeded > 0 {
+			fmt.Printf("Would first scale up ASG %s by %v instances for spare capacity\n", asgName, spareNeeded)
+		}
+		printReplacementPlan(asgName, instancesToTerminate, batchSize)
+		err = validateTerminatePermissions(ctx, instancesToTerminate)
+		if err != nil {
+			return 0, fmt.Errorf("Permission check failed: %w", err)
+		}
+		fmt.Println("DRY RUN — no changes made")
+		return 0, nil
+	}
+
+	if spareNeeded > 0 {
+		fmt.Printf("Scaling up ASG %s by %v instances for spare capacity during the replacement\n", asgName, spareNeeded)
+		err = lib.ScaleUpAsg(ctx, AwsSess, cluster, asgName, int64(spareNeeded), raiseMax, readyTimeout)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to scale up before replacing instances: %w", err)
+		}
+		defer fmt.Printf("ASG %s was scaled up by %v instances for the replacement, run rightSizeCluster to scale it back down\n", asgName, spareNeeded)
+	}
+
+	originalInstances, err := lib.GetInstanceListForAsg(ctx, AwsSess, asgName)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to list instances in ASG: %w", err)
+	}
+	detached, err := lib.DetachAndReplaceAsgInstances(ctx, AwsSess, cluster, asgName, instancesToTerminate, readyTimeout)
+	if err != nil {
+		abortReplacement(asgName, detached)
+		return 0, fmt.Errorf("Unable to replace instances: %w", err)
+	}
+
+	if len(newInstanceTags) > 0 {
+		tagReplacementInstances(ctx, asgName, originalInstances)
+	}
+
+	var targetGroups []string
+	if waitForHealthy {
+		targetGroups = lib.GetTargetGroupsForEcsServices(lib.ListServicesForEcsCluster(ctx, AwsSess, cluster))
+		if len(targetGroups) == 0 {
+			fmt.Println("No services in the cluster use a target group, skipping target health checks")
+		}
+	}
+
+	fmt.Printf("Terminating %v instances...\n", len(instancesToTerminate))
+	var progress *replaceProgress
+	if showProgress {
+		progress = newReplaceProgress(len(instancesToTerminate))
+	}
+	var succeeded []string
+	var failures []replaceFailure
+	for start := 0; start < len(instancesToTerminate); start += batchSize {
+		end := start + batchSize
+		if end > len(instancesToTerminate) {
+			end = len(instancesToTerminate)
+		}
+		batch := instancesToTerminate[start:end]
+
+		// Don't start terminating another batch once interrupted
+		if ctx.Err() != nil {
+			progress.finish()
+			abortReplacement(asgName, append(notTerminatedInstances(failures), instancesToTerminate[start:]...))
+			return len(succeeded), fmt.Errorf("Interrupted before terminating all instances: %w", ctx.Err())
+		}
+
+		batchStarted := time.Now()
+		terminated, failed, err := terminateBatchAndWait(ctx, batch, targetGroups, progress)
+		failures = append(failures, failed...)
+		replaceSummary.failures = failures
+		if err != nil && continueOnError && ctx.Err() == nil {
+			progress.log(fmt.Sprintf("Failed to replace %s, continuing with the next instance: %s",
+				strings.Join(aws.StringValueSlice(terminated), ", "), err))
+			for _, instanceID := range terminated {
+				failures = append(failures, replaceFailure{instanceID: *instanceID, err: err})
+				progress.instanceDone()
+			}
+			replaceSummary.failures = failures
+			continue
+		}
+		if err != nil {
+			progress.finish()
+			succeeded = append(succeeded, aws.StringValueSlice(terminated)...)
+			abortReplacement(asgName, append(notTerminatedInstances(failures),
+				lib.RemoveInstanceIDs(instancesToTerminate[start:], aws.StringValueSlice(terminated))...))
+			return len(succeeded), err
+		}
+		for _, instanceID := range terminated {
+			succeeded = append(succeeded, *instanceID)
+			replaceMetrics.instanceReplaced(time.Since(batchStarted))
+			progress.instanceDone()
+		}
+		replaceSummary.replaced = len(succeeded)
+	}
+	progress.finish()
+	fmt.Println("Finished terminating instances")
+
+	instances := lib.GetInstanceListForEcsCluster(ctx, AwsSess, cluster)
+	fmt.Println("Final instances in cluster: ", len(instances))
+
+	if len(failures) > 0 {
+		// Instances that failed before termination are still detached, so put them back when asked to
+		detached := notTerminatedInstances(failures)
+		if len(detached) > 0 && reattachOnAbort && reattachInstances(asgName, detached) {
+			for n := range failures {
+				failures[n].reattached = failures[n].notTerminated
+			}
+		}
+		replaceSummary.failures = failures
+		printReplacementReport(asgName, succeeded, failures)
+		return len(succeeded), fmt.Errorf("Failed to replace %v of %v instances, first error: %w",
+			len(failures), len(instancesToTerminate), failures[0].err)
+	}
+
+	fmt.Println("All done. Be sure to tip your waiter and thank AppsDev for making your life better.")
+
+	return len(succeeded), nil
+}
+
+// filterUnhealthyInstances returns the instances the ASG has marked Unhealthy or whose EC2 status checks are
+// failing, keeping the order they were given in
+func filterUnhealthyInstances(ctx context.Context, asgName string, instanceIDs []*string) ([]*string, error) {
+	unhealthy, err := lib.GetUnhealthyInstancesForAsg(ctx, AwsSess, asgName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to check instance health: %w", err)
+	}
+
+	isUnhealthy := map[string]bool{}
+	for _, id := range unhealthy {
+		isUnhealthy[*id] = true
+	}
+
+	filtered := []*string{}
+	for _, id := range instanceIDs {
+		if isUnhealthy[*id] {
+			filtered = append(filtered, id)
+		}
+	}
+
+	return filtered, nil
+}
+
+// getReplacementAsgName returns the ASG given with --asg after checking the cluster's container instances
+// belong to it, or the only ASG they belong to. A cluster backed by several ASGs needs --asg so the wrong
+// one isn't replaced.
+func getReplacementAsgName(ctx context.Context) (string, error) {
+	asgNames, err := lib.GetAsgNamesForEcsCluster(ctx, AwsSess, cluster)
+	if err != nil {
+		return "", err
+	}
+
+	if replaceAsgName == "" {
+		if len(asgNames) > 1 {
+			return "", fmt.Errorf("container instances in cluster %s belong to %v ASGs, choose one with --asg: %s",
+				cluster, len(asgNames), strings.Join(asgNames, ", "))
+		}
+		return asgNames[0], nil
+	}
+
+	for _, asgName := range asgNames {
+		if asgName == replaceAsgName {
+			return asgName, nil
+		}
+	}
+
+	return "", fmt.Errorf("ASG %s %w among those of cluster %s: %s", replaceAsgName, lib.ErrNotFound, cluster,
+		strings.Join(asgNames, ", "))
+}
+
+// replaceFailure records an instance that could not be replaced with --continue-on-error
+type replaceFailure struct {
+	instanceID string
+	err        error
+
+	// notTerminated is true when the instance failed before it was terminated, so it is still running
+	// detached from the ASG unless it was reattached
+	notTerminated bool
+	reattached    bool
+}
+
+// describe returns the instance and error, noting where an instance that was not terminated was left
+func (f replaceFailure) describe(asgName string) string {
+	text := fmt.Sprintf("%s: %s", f.instanceID, f.err)
+	if f.reattached {
+		text += fmt.Sprintf(" (re-attached to ASG %s)", asgName)
+	} else if f.notTerminated {
+		text += fmt.Sprintf(" (still running, detached from ASG %s)", asgName)
+	}
+
+	return text
+}
+
+// notTerminatedInstances returns the instances of failures that are still running detached from the ASG
+func notTerminatedInstances(failures []replaceFailure) []*string {
+	var ids []*string
+	for _, f := range failures {
+		if f.notTerminated && !f.reattached {
+			ids = append(ids, aws.String(f.instanceID))
+		}
+	}
+
+	return ids
+}
+
+// terminateBatchAndWait terminates a batch of detached instances and waits once for their tasks to be
+// rescheduled. terminated is the instances that were terminated or already on their way out, even if
+// waiting afterwards failed. With --continue-on-error an instance that can't be terminated is returned
+// in failed and the rest of the batch carries on, otherwise the batch stops with that error.
+func terminateBatchAndWait(ctx context.Context, batch []*string, targetGroups []string,
+	progress *replaceProgress) (terminated []*string, failed []replaceFailure, err error) {
+	err = waitForTargetsHealthy(ctx, targetGroups, progress)
+	if err != nil {
+		err = fmt.Errorf("Stopped waiting for healthy targets: %w", err)
+	} else if waitForRegistration {
+		progress.log("Waiting for tasks to be registered in Cloud Map")
+		err = lib.WaitForServiceRegistrations(ctx, AwsSess, lib.ListServicesForEcsCluster(ctx, AwsSess, cluster), registrationTimeout)
+		if err != nil {
+			err = fmt.Errorf("Stopped waiting for service discovery registration: %w", err)
+		}
+	}
+	if err != nil {
+		if !continueOnError || ctx.Err() != nil {
+			return nil, nil, err
+		}
+		for _, instanceID := range batch {
+			progress.log(fmt.Sprintf("Failed to replace instance %s, continuing with the next instance: %s", *instanceID, err))
+			failed = append(failed, replaceFailure{instanceID: *instanceID, err: err, notTerminated: true})
+			progress.instanceDone()
+		}
+		return nil, failed, nil
+	}
+
+	// Instances that were already on their way out have no tasks left to wait for
+	waitNeeded := false
+	for _, instanceID := range batch {
+		progress.log(fmt.Sprint("Terminating instance: ", *instanceID))
+		err = lib.TerminateInstance(ctx, AwsSess, *instanceID)
+		if errors.Is(err, lib.ErrInstanceAlreadyTerminating) {
+			progress.log(fmt.Sprint("Skipping, instance is already transitioning: ", err))
+			terminated = append(terminated, instanceID)
+			continue
+		}
+		if err != nil {
+			err = fmt.Errorf("Unable to terminate instance: %w", err)
+			if !continueOnError || ctx.Err() != nil {
+				return terminated, failed, err
+			}
+			progress.log(fmt.Sprintf("Failed to replace instance %s, continuing with the next instance: %s", *instanceID, err))
+			failed = append(failed, replaceFailure{instanceID: *instanceID, err: err, notTerminated: true})
+			progress.instanceDone()
+			continue
+		}
+		terminated = append(terminated, instanceID)
+		waitNeeded = true
+	}
+
+	if forceReplace || !waitNeeded {
+		return terminated, failed, nil
+	}
+
+	err = waitForZeroPendingTasks(ctx, cluster, progress)
+	if err != nil {
+		return terminated, failed, fmt.Errorf("Stopped waiting for pending tasks: %w", err)
+	}
+
+	return terminated, failed, nil
+}
+
+// printReplacementReport lists the instances that were and were not replaced with --continue-on-error
+func printReplacementReport(asgName string, succeeded []string, failures []replaceFailure) {
+	fmt.Printf("Replaced %v instances:\n", len(succeeded))
+	for _, instanceID := range succeeded {
+		fmt.Println("  ", instanceID)
+	}
+
+	fmt.Printf("Failed to replace %v instances:\n", len(failures))
+	for _, f := range failures {
+		fmt.Println("  ", f.describe(asgName))
+	}
+
+	if len(notTerminatedInstances(failures)) > 0 {
+		fmt.Printf("Terminate the instances still running or re-attach them to ASG %s to clean up\n", asgName)
+	}
+}
+
+// notifyReplacementResult publishes the outcome of a replacement to the --notify-sns-topic topic.
+// Failing to publish only logs a warning so it does not change the outcome of the command.
+func notifyReplacementResult(replaced int, duration time.Duration, replaceErr error) {
+	subject := fmt.Sprintf("awsops: instance replacement finished for %s", cluster)
+	if replaceErr != nil {
+		subject = fmt.Sprintf("awsops: instance replacement failed for %s", cluster)
+	}
+	summary := replaceSummary
+	summary.replaced = replaced
+	body := summary.text(duration, replaceErr)
+
+	// Use a fresh context so a notification is still sent when the command was interrupted
+	err := lib.PublishNotification(context.Background(), AwsSess, notifySnsTopic, subject, body)
+	if err != nil {
+		fmt.Println("Warning: unable to publish SNS notification: ", err)
+	}
+}
+
+// replacementSummary is the outcome of a replacement, as printed with --summary-only and sent to --notify-sns-topic
+type replacementSummary struct {
+	asgName  string
+	total    int
+	replaced int
+	failures []replaceFailure
+
+	// waited is set once services were waited on for --wait with --summary-only
+	waited   bool
+	stable   []string
+	unstable []string
+	waitErr  error
+}
+
+// waitForServicesStable waits for the cluster services like --wait, recording the outcome for the summary
+// instead of printing it
+func (s *replacementSummary) waitForServicesStable(ctx context.Context) {
+	s.waited = true
+	s.stable, s.unstable, s.waitErr = lib.WaitForServicesStable(ctx, AwsSess, cluster, waitTimeout)
+}
+
+// text returns the summary with a line for each detail, leaving out the ASG and total when the replacement
+// stopped before finding them
+func (s replacementSummary) text(duration time.Duration, replaceErr error) string {
+	text := fmt.Sprintf("Cluster: %s\n", cluster)
+	if s.asgName != "" {
+		text += fmt.Sprintf("ASG: %s\n", s.asgName)
+	}
+	if s.total > 0 {
+		text += fmt.Sprintf("Instances replaced: %v of %v\n", s.replaced, s.total)
+	} else {
+		text += fmt.Sprintf("Instances replaced: %v\n", s.replaced)
+	}
+	text += fmt.Sprintf("Duration: %s\n", duration.Round(time.Second))
+	for _, f := range s.failures {
+		text += fmt.Sprintf("Failed: %s\n", f.describe(s.asgName))
+	}
+	if replaceErr != nil {
+		text += fmt.Sprintf("Error: %s\n", replaceErr)
+	}
+	if s.waited {
+		if len(s.stable) > 0 {
+			text += fmt.Sprintf("Stable services: %s\n", strings.Join(s.stable, ", "))
+		}
+		if len(s.unstable) > 0 {
+			text += fmt.Sprintf("Services not stable after %s: %s\n", waitTimeout, strings.Join(s.unstable, ", "))
+		}
+		if s.waitErr != nil {
+			text += fmt.Sprintf("Error: Unable to wait for services to become stable: %s\n", s.waitErr)
+		}
+	}
+
+	return text
+}
+
+func (s replacementSummary) print(duration time.Duration, replaceErr error) {
+	fmt.Print(s.text(duration, replaceErr))
+}
+
+// suppressOutput sends everything printed to stdout to the null device for --summary-only, until the
+// returned function is called to restore it
+func suppressOutput() (restore func()) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return func() {}
+	}
+
+	stdout := os.Stdout
+	os.Stdout = devNull
+	return func() {
+		if os.Stdout == devNull {
+			os.Stdout = stdout
+			devNull.Close()
+		}
+	}
+}
+
 func init() {
 	ecsCmd.AddCommand(replaceInstancesCmd)
 
@@ -75,38 +724,395 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// ecsReplaceInstancesCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	replaceInstancesCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the instances that would be replaced and the order of operations without making any changes")
+	replaceInstancesCmd.Flags().DurationVar(&pollInterval, "poll-interval", 5*time.Second, "Initial interval between pending task checks, doubles after each check up to 30s")
+	replaceInstancesCmd.Flags().DurationVar(&pendingTimeout, "pending-timeout", 20*time.Minute, "Maximum time to wait for pending tasks to reach zero after terminating an instance")
+	replaceInstancesCmd.Flags().BoolVar(&onlyUnhealthy, "only-unhealthy", false, "Only replace instances marked Unhealthy by the ASG or with an EC2 instance or system status check that is not ok, including checks still initializing")
+	replaceInstancesCmd.Flags().StringVar(&olderThanAmi, "older-than-ami", "", "Only replace instances not running this AMI ID, or 'latest' for the AMI in the ASG launch configuration/template")
+	replaceInstancesCmd.Flags().IntVar(&maxParallel, "max-parallel", 1, "Number of instances to terminate before waiting for their tasks to be rescheduled")
+	replaceInstancesCmd.Flags().IntVar(&maxUnavailable, "max-unavailable", 0, "Percentage of the ASG's instances to terminate before waiting for their tasks to be rescheduled, rounded down to at least 1, instead of --max-parallel")
+	replaceInstancesCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9100, at /metrics while the replacement runs")
+	replaceInstancesCmd.Flags().StringVar(&notifySnsTopic, "notify-sns-topic", "", "SNS topic ARN to notify when the replacement finishes or fails")
+	replaceInstancesCmd.Flags().BoolVar(&emitMetrics, "emit-metrics", false, "Publish replacement duration and instance count metrics to CloudWatch under the awsops/ECS namespace")
+	replaceInstancesCmd.Flags().DurationVar(&readyTimeout, "ready-timeout", 15*time.Minute, "Maximum time to wait for replacement instances to be InService and ACTIVE in the cluster")
+	replaceInstancesCmd.Flags().DurationVar(&initialDelay, "initial-delay", 0, "Time to wait after terminating an instance before checking for pending tasks")
+	replaceInstancesCmd.Flags().BoolVar(&reattachOnAbort, "reattach-on-abort", false, "Re-attach detached instances that were not terminated to the ASG if the replacement is interrupted, fails, or finishes with --continue-on-error failures")
+	replaceInstancesCmd.Flags().StringArrayVar(&excludeInstances, "exclude-instance", []string{}, "EC2 instance ID to leave alone, may be repeated")
+	replaceInstancesCmd.Flags().StringArrayVar(&filterTags, "filter-tag", []string{}, "Only replace instances with this key=value EC2 tag, may be repeated to require several tags")
+	replaceInstancesCmd.Flags().StringArrayVar(&excludeTags, "exclude-tag", []string{}, "Don't replace instances with this key=value EC2 tag, may be repeated")
+	replaceInstancesCmd.Flags().BoolVar(&spotFirst, "spot-first", false, "Replace spot instances before on-demand instances, keeping --order within each. With --order-by-az this applies within each zone")
+	replaceInstancesCmd.Flags().StringArrayVar(&newInstanceTags, "tag-new-instances", []string{}, "Add this key=value EC2 tag to the replacement instances once they are InService, may be repeated")
+	replaceInstancesCmd.Flags().BoolVar(&waitForHealthy, "wait-for-healthy", false, "Before terminating each instance, wait for all targets in the target groups of the cluster's services to be healthy")
+	replaceInstancesCmd.Flags().DurationVar(&healthyTimeout, "healthy-timeout", 10*time.Minute, "Maximum time to wait for each target group to be healthy with --wait-for-healthy")
+	replaceInstancesCmd.Flags().BoolVar(&waitForRegistration, "wait-for-registration", false, "Before terminating each instance, wait for services with service discovery to have their desired count of tasks registered in Cloud Map")
+	replaceInstancesCmd.Flags().DurationVar(&registrationTimeout, "registration-timeout", 10*time.Minute, "Maximum time to wait for tasks to be registered with --wait-for-registration")
+	replaceInstancesCmd.Flags().StringVar(&replaceAsgName, "asg", "", "ASG to replace instances in, required when the cluster's container instances belong to more than one ASG")
+	replaceInstancesCmd.Flags().StringVar(&replaceOrder, "order", "oldest", "Order to terminate instances in by launch time, either oldest or newest first")
+	replaceInstancesCmd.Flags().BoolVar(&orderByAz, "order-by-az", false, "Rotate through Availability Zones one instance at a time, in --order within each zone, so capacity is not removed from one zone all at once")
+	replaceInstancesCmd.Flags().IntVar(&minHealthy, "min-healthy", 0, "Minimum instances that are not being replaced the ASG must keep, so services have somewhere to run if replacements never come up (default no minimum)")
+	replaceInstancesCmd.Flags().BoolVar(&scaleUpFirst, "scale-up-first", false, "Scale the ASG up by the batch size, or more when needed to satisfy --min-healthy, before starting so there is always spare capacity")
+	replaceInstancesCmd.Flags().BoolVar(&raiseMax, "raise-max", false, "Raise the ASG max size when --scale-up-first needs more instances than it allows, instead of aborting")
+	replaceInstancesCmd.Flags().StringArrayVar(&criticalServices, "critical-service", []string{}, "Only wait for this service to have zero pending tasks and be stable after each termination, may be repeated, defaults to waiting for zero pending tasks in all services")
+	replaceInstancesCmd.Flags().BoolVar(&forceReplace, "force", false, "Terminate instances as soon as replacements are ready without waiting for pending tasks, for emergencies as running tasks are interrupted")
+	replaceInstancesCmd.Flags().BoolVar(&continueOnError, "continue-on-error", false, "Log a failure to replace an instance and continue with the next one, then exit non-zero with a summary of the failures")
+	replaceInstancesCmd.Flags().BoolVar(&summaryOnly, "summary-only", false, "Only print a summary of the cluster, ASG, instances replaced, duration and any failures once the replacement finishes or fails, including the service stability with --wait")
+	replaceInstancesCmd.Flags().BoolVar(&showProgress, "progress", false, "Show a progress line with elapsed time and ETA while terminating instances")
+	addWaitFlags(replaceInstancesCmd)
+}
+
+func printReplacementPlan(asgName string, instancesToTerminate []*string, batchSize int) {
+	fmt.Printf("Instances that would be replaced (%v):\n", len(instancesToTerminate))
+	for _, instanceID := range instancesToTerminate {
+		fmt.Println("  ", *instanceID)
+	}
+
+	fmt.Println("Order of operations:")
+	fmt.Printf("  1. Detach %v instances from ASG %s without decrementing desired capacity\n", len(instancesToTerminate), asgName)
+	fmt.Printf("  2. Wait for %v replacement instances to be InService in the ASG and ACTIVE in the cluster\n", len(instancesToTerminate))
+	atATime := "one at a time"
+	if batchSize > 1 {
+		atATime = fmt.Sprintf("in batches of up to %v", batchSize)
+	}
+	if forceReplace {
+		fmt.Println("  3. Terminate detached instances immediately, without waiting for pending tasks:")
+	} else if len(criticalServices) > 0 {
+		fmt.Printf("  3. Terminate detached instances %s, waiting for zero pending tasks and stable services for %s after each:\n",
+			atATime, strings.Join(criticalServices, ", "))
+	} else {
+		fmt.Printf("  3. Terminate detached instances %s, waiting for zero pending ECS tasks after each:\n", atATime)
+	}
+	for i, instanceID := range instancesToTerminate {
+		fmt.Printf("     %v. %s\n", i/batchSize+1, *instanceID)
+	}
 }
 
-func terminateInstance(id string) (bool, error) {
+// replacementBatchSize returns how many instances may be terminated before waiting for their tasks to be
+// rescheduled, either --max-parallel or --max-unavailable percent of the ASG size rounded down, at least 1
+func replacementBatchSize(asgInstances int) int {
+	if maxUnavailable > 0 {
+		size := asgInstances * maxUnavailable / 100
+		if size < 1 {
+			return 1
+		}
+		return size
+	}
+
+	return maxParallel
+}
+
+// validateTerminatePermissions uses the EC2 DryRun option to confirm the current credentials
+// are allowed to describe and terminate the given instances
+func validateTerminatePermissions(ctx context.Context, ids []*string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
 	svc := ec2.New(AwsSess)
-	instanceStatus, err := svc.DescribeInstanceStatus(&ec2.DescribeInstanceStatusInput{
-		InstanceIds: []*string{&id},
+	_, err := svc.DescribeInstanceStatusWithContext(ctx, &ec2.DescribeInstanceStatusInput{
+		DryRun:      aws.Bool(true),
+		InstanceIds: ids,
 	})
+	if !isDryRunSuccess(err) {
+		return err
+	}
+
+	_, err = svc.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{
+		DryRun:      aws.Bool(true),
+		InstanceIds: ids,
+	})
+	if !isDryRunSuccess(err) {
+		return err
+	}
+
+	fmt.Println("Permissions to describe and terminate instances verified")
+	return nil
+}
+
+// isDryRunSuccess returns true when a DryRun request reports that the call would have succeeded
+func isDryRunSuccess(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == "DryRunOperation"
+	}
+
+	return err == nil
+}
+
+// filterInstancesByTags keeps only the instances matching every --filter-tag and none of the --exclude-tag filters
+func filterInstancesByTags(ctx context.Context, instances []*string) ([]*string, error) {
+	filters := []struct {
+		flagName string
+		tags     []string
+		include  bool
+	}{
+		{flagName: "--filter-tag", tags: filterTags, include: true},
+		{flagName: "--exclude-tag", tags: excludeTags, include: false},
+	}
+
+	for _, f := range filters {
+		for _, tag := range f.tags {
+			key, value, err := parseTag(f.flagName, tag)
+			if err != nil {
+				return nil, err
+			}
+
+			instances, err = lib.FilterInstancesByTag(ctx, AwsSess, instances, key, value, f.include)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to filter instances by tag: %w", err)
+			}
+		}
+	}
+
+	return instances, nil
+}
+
+// parseNewInstanceTags returns the --tag-new-instances key=value tags as a map
+func parseNewInstanceTags() (map[string]string, error) {
+	return parseTagFlag("--tag-new-instances", newInstanceTags)
+}
+
+// tagReplacementInstances applies the --tag-new-instances tags to the instances the ASG launched to replace
+// the detached ones. Failing to tag only logs a warning so it does not stop the replacement.
+func tagReplacementInstances(ctx context.Context, asgName string, originalInstances []*string) {
+	tags, _ := parseNewInstanceTags()
+	asgInstances, err := lib.GetInstanceListForAsg(ctx, AwsSess, asgName)
 	if err != nil {
-		return false, err
+		fmt.Println("Warning: unable to list instances to tag: ", err)
+		return
 	}
 
-	if *instanceStatus.InstanceStatuses[0].InstanceState.Name != "terminated" {
-		fmt.Println("Terminating instance: ", id)
-		_, err := svc.TerminateInstances(&ec2.TerminateInstancesInput{
-			InstanceIds: []*string{&id},
-		})
+	newInstances := lib.RemoveInstanceIDs(asgInstances, aws.StringValueSlice(originalInstances))
+	if len(newInstances) == 0 {
+		return
+	}
+
+	fmt.Printf("Tagging %v new instances: %s\n", len(newInstances), strings.Join(aws.StringValueSlice(newInstances), ", "))
+	if err := lib.TagInstances(ctx, AwsSess, newInstances, tags); err != nil {
+		fmt.Println("Warning: unable to tag new instances: ", err)
+	}
+}
+
+// abortReplacement reports instances left detached from the ASG but not terminated, re-attaching
+// them when --reattach-on-abort is set so the operator knows what is left to clean up
+func abortReplacement(asgName string, notTerminated []*string) {
+	if len(notTerminated) == 0 {
+		return
+	}
+
+	ids := strings.Join(aws.StringValueSlice(notTerminated), ", ")
+	fmt.Printf("Aborting, %v detached instances were not terminated: %s\n", len(notTerminated), ids)
+
+	if !reattachOnAbort {
+		fmt.Printf("Terminate these instances or re-attach them to ASG %s to clean up\n", asgName)
+		return
+	}
+
+	reattachInstances(asgName, notTerminated)
+}
+
+// reattachInstances attaches detached instances back to the ASG and reports whether that succeeded
+func reattachInstances(asgName string, instanceIDs []*string) bool {
+	// The command context may already be cancelled, so re-attach with a fresh one
+	fmt.Printf("Re-attaching instances to ASG %s...", asgName)
+	err := lib.AttachInstancesToAsg(context.Background(), AwsSess, asgName, instanceIDs)
+	if err != nil {
+		fmt.Println()
+		fmt.Printf("Unable to re-attach instances, terminate or re-attach them to ASG %s manually: %s\n", asgName, err)
+		return false
+	}
+	fmt.Printf("done\n")
+
+	return true
+}
+
+// waitForTargetsHealthy waits for every target in each target group to pass its health checks
+func waitForTargetsHealthy(ctx context.Context, targetGroups []string, progress *replaceProgress) error {
+	for _, targetGroup := range targetGroups {
+		progress.log(fmt.Sprint("Waiting for healthy targets in: ", targetGroup))
+		err := lib.WaitForTargetsHealthy(ctx, AwsSess, targetGroup, healthyTimeout)
 		if err != nil {
-			return false, err
+			return err
 		}
 	}
 
-	return true, nil
+	return nil
+}
+
+// waitForZeroPendingTasks waits for the cluster to have no pending tasks, or with --critical-service for
+// only the critical services to have no pending tasks and be stable
+func waitForZeroPendingTasks(ctx context.Context, cluster string, progress *replaceProgress) error {
+	if err := aws.SleepWithContext(ctx, initialDelay); err != nil {
+		return err
+	}
+
+	interval := pollInterval
+	deadline := time.Now().Add(pendingTimeout)
+	for {
+		pendingTasks, notReady := getServicesBlockingReplacement(ctx, cluster)
+		replaceMetrics.setPendingTasks(pendingTasks)
+		status := fmt.Sprintf("Pending tasks: %v", pendingTasks)
+		if len(criticalServices) > 0 {
+			status = fmt.Sprintf("Critical services not ready: %v, pending tasks: %v", len(notReady), pendingTasks)
+		}
+		if progress != nil {
+			progress.setStatus(strings.ToLower(status))
+		} else {
+			fmt.Printf("\r%s ", status)
+		}
+		if len(notReady) == 0 {
+			if progress == nil {
+				fmt.Println()
+			}
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if progress == nil {
+				fmt.Println()
+			}
+			return fmt.Errorf("%w after %s, services not ready: %s", lib.ErrTimeout, pendingTimeout, strings.Join(notReady, ", "))
+		}
+
+		if err := aws.SleepWithContext(ctx, interval); err != nil {
+			if progress == nil {
+				fmt.Println()
+			}
+			return err
+		}
+
+		interval *= 2
+		if interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
 }
 
-func waitForZeroPendingTasks(cluster string) {
+// getServicesBlockingReplacement returns the number of pending tasks and a description of each service
+// that must settle before the next instance is terminated. Without --critical-service that is every
+// service with pending tasks, otherwise only critical services with pending tasks or that are not stable.
+func getServicesBlockingReplacement(ctx context.Context, cluster string) (int64, []string) {
+	services := lib.ListServicesForEcsCluster(ctx, AwsSess, cluster)
+	if len(criticalServices) > 0 {
+		services, _ = lib.FilterServicesByName(services, criticalServices)
+	}
+
 	var pendingTasks int64
+	var notReady []string
+	for _, service := range services {
+		pending := aws.Int64Value(service.PendingCount)
+		pendingTasks += pending
+		if pending > 0 {
+			notReady = append(notReady, fmt.Sprintf("%s (%v pending)", *service.ServiceName, pending))
+		} else if len(criticalServices) > 0 && !lib.IsServiceStable(service) {
+			notReady = append(notReady, fmt.Sprintf("%s (not stable)", *service.ServiceName))
+		}
+	}
+
+	return pendingTasks, notReady
+}
+
+// progressUpdateInterval is how often the progress is printed when stdout is not a terminal
+const progressUpdateInterval = 30 * time.Second
+
+// replaceProgress shows how far through the terminations a replacement is. On a terminal it keeps
+// a single line updated in place, otherwise it prints a line per instance and every
+// progressUpdateInterval in between. A nil *replaceProgress prints log lines unchanged.
+type replaceProgress struct {
+	total     int
+	done      int
+	started   time.Time
+	tty       bool
+	status    string
+	lastPrint time.Time
+	drawn     bool
+}
 
-	time.Sleep(120 * time.Second)
-	for pendingTasks = 1000; pendingTasks > 0; {
-		time.Sleep(30 * time.Second)
-		pendingTasks = lib.GetPendingEcsTasksCount(AwsSess, cluster)
-		fmt.Printf("\rPending tasks: %v", pendingTasks)
+func newReplaceProgress(total int) *replaceProgress {
+	p := &replaceProgress{
+		total:   total,
+		started: time.Now(),
+		tty:     isTerminal(os.Stdout),
 	}
+	p.print()
+
+	return p
+}
+
+// isTerminal returns true when f is a character device such as a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *replaceProgress) line() string {
+	elapsed := time.Since(p.started).Round(time.Second)
+	line := fmt.Sprintf("Replacing %v/%v instances, elapsed %s", p.done, p.total, elapsed)
+	if p.done > 0 && p.done < p.total {
+		eta := time.Since(p.started) / time.Duration(p.done) * time.Duration(p.total-p.done)
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	if p.status != "" {
+		line += ", " + p.status
+	}
+
+	return line
+}
+
+func (p *replaceProgress) print() {
+	p.lastPrint = time.Now()
+	if p.tty {
+		// Return to the start of the line and clear it before redrawing
+		fmt.Printf("\r\033[K%s", p.line())
+		p.drawn = true
+		return
+	}
+
+	fmt.Println(p.line())
+}
+
+// log prints msg on its own line without breaking the progress line
+func (p *replaceProgress) log(msg string) {
+	if p == nil {
+		fmt.Println(msg)
+		return
+	}
+
+	if p.tty && p.drawn {
+		fmt.Print("\r\033[K")
+	}
+	fmt.Println(msg)
+	if p.tty {
+		p.print()
+	}
+}
+
+// setStatus updates the detail shown after the instance count, such as the pending task count
+func (p *replaceProgress) setStatus(status string) {
+	p.status = status
+	if p.tty || time.Since(p.lastPrint) >= progressUpdateInterval {
+		p.print()
+	}
+}
+
+func (p *replaceProgress) instanceDone() {
+	if p == nil {
+		return
+	}
+
+	p.done++
+	p.status = ""
+	p.print()
+}
+
+// finish ends the progress line so following output starts on a new line
+func (p *replaceProgress) finish() {
+	if p == nil || !p.drawn {
+		return
+	}
+
 	fmt.Println()
+	p.drawn = false
 }
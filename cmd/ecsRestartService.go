@@ -0,0 +1,111 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+var allServices bool
+
+// restartServiceCmd represents the ecsRestartService command
+var restartServiceCmd = &cobra.Command{
+	Use:   "restartService",
+	Short: "Force a new deployment of an ECS service",
+	Long: `Starts a rolling restart of an ECS service without changing its task definition,
+for example to pick up a new image for the same tag. Use --all to restart every service in the cluster.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if service == "" && !allServices {
+			fmt.Println("Service is required, use --service or --all")
+			os.Exit(1)
+		}
+		if service != "" && allServices {
+			fmt.Println("Use either --service or --all, not both")
+			os.Exit(1)
+		}
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		var services []string
+		if allServices {
+			var err error
+			services, err = lib.ListServiceNamesForEcsCluster(ctx, AwsSess, cluster)
+			if err != nil {
+				exitWithError("Unable to list services: ", err)
+			}
+		} else {
+			ecsService, err := lib.GetEcsServiceByName(ctx, AwsSess, cluster, service)
+			if err != nil {
+				exitWithError("Unable to find service: ", err)
+			}
+			services = []string{aws.StringValue(ecsService.ServiceName)}
+		}
+
+		for _, s := range services {
+			deploymentID, err := lib.ForceNewServiceDeployment(ctx, AwsSess, cluster, s)
+			if err != nil {
+				exitWithError(fmt.Sprintf("Unable to restart service %s:", s), err)
+			}
+			if deploymentID == "" {
+				fmt.Printf("Restarting service %s\n", s)
+			} else {
+				fmt.Printf("Restarting service %s, deployment ID: %s\n", s, deploymentID)
+			}
+		}
+
+		if !waitStable {
+			return
+		}
+
+		if allServices {
+			waitForServicesStable(ctx)
+			return
+		}
+
+		fmt.Println("Waiting for service to become stable...")
+		err := lib.WaitForServiceStable(ctx, AwsSess, cluster, service, waitTimeout)
+		if err != nil {
+			exitWithError("", err)
+		}
+		fmt.Println("Service is stable")
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(restartServiceCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// restartServiceCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	restartServiceCmd.Flags().StringVarP(&service, "service", "s", "", "ECS service name or ARN")
+	// Complete service names in bash using __awsops_services from completion.go
+	cobra.MarkFlagCustom(restartServiceCmd.Flags(), "service", "__awsops_services")
+	restartServiceCmd.Flags().BoolVar(&allServices, "all", false, "Restart every service in the cluster")
+	restartServiceCmd.Flags().BoolVar(&waitStable, "wait", false, "Wait for the restarted services to become stable")
+	restartServiceCmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 10*time.Minute, "Maximum time to wait for services to become stable with --wait")
+}
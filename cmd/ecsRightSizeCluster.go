@@ -15,30 +15,338 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
 	"github.com/silinternational/awsops/lib"
 	"github.com/spf13/cobra"
 )
 
 var atLeastServiceDesiredCount bool
+var maxHeadroom int64
+var reservedMemoryMb int64
+var instanceTypesFile string
+var respectCooldown bool
+var checkAlarms bool
+var rightSizePlanOut string
+var rightSizePlanFile string
+var rightSizeClusters []string
+var rightSizeAll bool
+var parallelClusters int
 
 // rightSizeClusterCmd represents the scaleCluster command
 var rightSizeClusterCmd = &cobra.Command{
-	Use:   "rightSizeCluster",
-	Short: "Scale ASG for ECS cluster to minimum needed servers",
+	Use:     "rightSizeCluster",
+	Aliases: []string{"rightSize"},
+	Short:   "Scale ASG for ECS cluster to minimum needed servers",
 	Long: `This command calculates total memory and CPU needed
 for all services in the given ECS cluster and then adjusts 
 instance count in the ASG based on instance type/size to 
 support running all tasks with as few servers as is needed.
 
-This function may scale a cluster up or down depending on services.`,
+This function may scale a cluster up or down depending on services.
+Use the plan and apply subcommands to review the change before it
+is made.
+
+Several clusters can be right sized at once with --clusters or --all,
+up to --parallel-clusters at a time, followed by a summary of what
+happened to each. A failure in one cluster doesn't stop the others.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		multiCluster := len(rightSizeClusters) > 0 || rightSizeAll
+		if multiCluster && cmd.Flags().Changed("cluster") {
+			fmt.Println("Use either --cluster or --clusters/--all, not both")
+			os.Exit(1)
+		}
+		if len(rightSizeClusters) > 0 && rightSizeAll {
+			fmt.Println("Use either --clusters or --all, not both")
+			os.Exit(1)
+		}
+		if multiCluster && waitStable {
+			fmt.Println("--wait only applies when right sizing a single cluster with --cluster")
+			os.Exit(1)
+		}
+		if parallelClusters < 1 {
+			fmt.Println("Parallel clusters must be at least 1")
+			os.Exit(1)
+		}
+
 		initAwsSess()
-		lib.RightSizeAsgForEcsCluster(AwsSess, cluster, atLeastServiceDesiredCount)
+		ctx, cancel := initContext()
+		defer cancel()
+
+		opts := getRightSizeOptions()
+		opts.DryRun = dryRun
+
+		if multiCluster {
+			rightSizeMultipleClusters(ctx, opts)
+			return
+		}
+
+		_, err := lib.RightSizeAsgForEcsCluster(ctx, AwsSess, cluster, opts)
+		if err != nil {
+			exitWithError("Unable to right size cluster: ", err)
+		}
+
+		if waitStable && !dryRun {
+			waitForServicesStable(ctx)
+		}
+	},
+}
+
+// rightSizePlanCmd represents the rightSizeCluster plan command
+var rightSizePlanCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the ASG capacity rightSizeCluster would set without changing it",
+	Long: `Calculates the servers needed the same way as rightSizeCluster
+without changing the ASG. Use --out to save the plan as JSON so it
+can be reviewed and then applied with rightSizeCluster apply.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		plan, err := lib.PlanRightSize(ctx, AwsSess, cluster, getRightSizeOptions())
+		if err != nil {
+			exitWithError("Unable to plan right sizing cluster: ", err)
+		}
+
+		if plan.Change {
+			fmt.Printf("Plan: scale ASG %s to desired = %v, min = %v, max = %v\n", plan.AsgName, plan.Desired, plan.Min, plan.Max)
+		} else {
+			fmt.Printf("Plan makes no changes: %s\n", plan.Reason)
+		}
+
+		if rightSizePlanOut == "" {
+			return
+		}
+
+		encoded, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			exitWithError("Unable to encode plan: ", err)
+		}
+		if err := ioutil.WriteFile(rightSizePlanOut, append(encoded, '\n'), 0644); err != nil {
+			exitWithError("Unable to save plan: ", err)
+		}
+		fmt.Printf("Plan saved to %s, apply it with: awsops ecs rightSizeCluster apply --plan-file %s\n", rightSizePlanOut, rightSizePlanOut)
+	},
+}
+
+// rightSizeApplyCmd represents the rightSizeCluster apply command
+var rightSizeApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Set the ASG capacity from a plan",
+	Long: `Sets the ASG capacity from a plan saved by rightSizeCluster plan --out,
+or from a new plan when --plan-file is not given. A saved plan is not
+applied if the ASG capacity changed since it was made.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		var plan *lib.RightSizePlan
+		if rightSizePlanFile != "" {
+			contents, err := ioutil.ReadFile(rightSizePlanFile)
+			if err != nil {
+				exitWithError("Unable to read plan file: ", err)
+			}
+			plan = &lib.RightSizePlan{}
+			if err := json.Unmarshal(contents, plan); err != nil {
+				fmt.Printf("Unable to parse plan file %s: %s\n", rightSizePlanFile, err)
+				os.Exit(1)
+			}
+
+			if cluster != "" && cluster != plan.Cluster {
+				fmt.Printf("Plan file is for cluster %s, not %s\n", plan.Cluster, cluster)
+				os.Exit(1)
+			}
+			cluster = plan.Cluster
+		} else {
+			var err error
+			plan, err = lib.PlanRightSize(ctx, AwsSess, cluster, getRightSizeOptions())
+			if err != nil {
+				exitWithError("Unable to plan right sizing cluster: ", err)
+			}
+		}
+
+		if err := lib.ApplyRightSize(ctx, AwsSess, plan); err != nil {
+			exitWithError("Unable to apply right size plan: ", err)
+		}
+
+		if waitStable && plan.Change {
+			waitForServicesStable(ctx)
+		}
 	},
 }
 
+// rightSizeResult is the outcome of right sizing one of several clusters
+type rightSizeResult struct {
+	cluster string
+	plan    *lib.RightSizePlan
+	err     error
+}
+
+// outcome describes what happened to the cluster ASG for the summary table
+func (r rightSizeResult) outcome() string {
+	switch {
+	case r.err != nil:
+		return "error"
+	case !r.plan.Change:
+		return "unchanged"
+	}
+
+	outcome := "resized"
+	if r.plan.Desired > r.plan.CurrentDesired {
+		outcome = "scaled up"
+	} else if r.plan.Desired < r.plan.CurrentDesired {
+		outcome = "scaled down"
+	}
+	if dryRun {
+		outcome = "would be " + outcome
+	}
+
+	return outcome
+}
+
+// rightSizeMultipleClusters right sizes each cluster from --clusters or --all with up to --parallel-clusters
+// workers, printing each cluster's output once it finishes, then a summary. Exits non-zero if any failed.
+func rightSizeMultipleClusters(ctx context.Context, opts lib.RightSizeOptions) {
+	clusters := rightSizeClusters
+	if rightSizeAll {
+		clusterArns, err := lib.ListEcsClusterArns(ctx, AwsSess)
+		if err != nil {
+			exitWithError("Unable to list clusters: ", err)
+		}
+		clusters = nil
+		for _, clusterArn := range clusterArns {
+			clusters = append(clusters, *clusterArn)
+		}
+	}
+	for i := range clusters {
+		clusters[i] = lib.NormalizeClusterIdentifier(clusters[i])
+	}
+
+	results := make([]rightSizeResult, len(clusters))
+	jobs := make(chan int)
+	var printMu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < parallelClusters; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var log bytes.Buffer
+				clusterOpts := opts
+				clusterOpts.Out = &log
+
+				plan, err := lib.RightSizeAsgForEcsCluster(ctx, AwsSess, clusters[i], clusterOpts)
+				results[i] = rightSizeResult{cluster: clusters[i], plan: plan, err: err}
+
+				printMu.Lock()
+				fmt.Printf("==> %s\n%s", clusters[i], log.String())
+				if err != nil {
+					fmt.Println("Unable to right size cluster: ", err)
+				}
+				fmt.Println()
+				printMu.Unlock()
+			}
+		}()
+	}
+	for i := range clusters {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	printRightSizeSummary(results)
+
+	var failed []rightSizeResult
+	for _, result := range results {
+		if result.err != nil {
+			failed = append(failed, result)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Printf("Failed to right size %v of %v clusters\n", len(failed), len(results))
+		os.Exit(exitCodeForError(failed[0].err))
+	}
+}
+
+func printRightSizeSummary(results []rightSizeResult) {
+	nameWidth, outcomeWidth := len("CLUSTER"), len("RESULT")
+	for _, r := range results {
+		if len(r.cluster) > nameWidth {
+			nameWidth = len(r.cluster)
+		}
+		if len(r.outcome()) > outcomeWidth {
+			outcomeWidth = len(r.outcome())
+		}
+	}
+
+	format := fmt.Sprintf("%%-%vs  %%-%vs  %%s\n", nameWidth, outcomeWidth)
+	fmt.Printf(format, "CLUSTER", "RESULT", "DETAIL")
+	for _, r := range results {
+		detail := ""
+		switch {
+		case r.err != nil:
+			detail = r.err.Error()
+		case !r.plan.Change:
+			detail = r.plan.Reason
+		default:
+			detail = fmt.Sprintf("desired %v -> %v, min %v -> %v, max %v -> %v", r.plan.CurrentDesired, r.plan.Desired,
+				r.plan.CurrentMin, r.plan.Min, r.plan.CurrentMax, r.plan.Max)
+		}
+		fmt.Printf(format, r.cluster, r.outcome(), detail)
+	}
+}
+
+// getRightSizeOptions validates the sizing flags and loads the instance types file, exiting if either fails
+func getRightSizeOptions() lib.RightSizeOptions {
+	if maxHeadroom < 0 {
+		fmt.Println("Max headroom cannot be negative")
+		os.Exit(1)
+	}
+
+	if reservedMemoryMb < 0 {
+		fmt.Println("Reserved memory cannot be negative")
+		os.Exit(1)
+	}
+
+	if instanceTypesFile != "" {
+		if err := lib.LoadInstanceCapacityFile(instanceTypesFile); err != nil {
+			exitWithError("Unable to load instance types file: ", err)
+		}
+	}
+
+	return lib.RightSizeOptions{
+		AtLeastServiceDesiredCount: atLeastServiceDesiredCount,
+		MaxHeadroom:                maxHeadroom,
+		ReservedMemoryMb:           reservedMemoryMb,
+		RespectCooldown:            respectCooldown,
+		CheckAlarms:                checkAlarms,
+	}
+}
+
+// addRightSizeFlags adds the flags that change how rightSizeCluster and its plan and apply subcommands size the ASG
+func addRightSizeFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&atLeastServiceDesiredCount, "at-least-desired-count", false, "Ensure at least as many EC2 instances as largest ECS service desired count.")
+	cmd.Flags().BoolVar(&atLeastServiceDesiredCount, "atLeastServiceDesiredCount", false, "Ensure at least as many EC2 instances as largest ECS service desired count.")
+	cmd.Flags().MarkDeprecated("atLeastServiceDesiredCount", "use --at-least-desired-count instead")
+	cmd.Flags().Int64Var(&maxHeadroom, "max-headroom", 0, "Set ASG max to this many servers above desired to leave room for autoscaling")
+	cmd.Flags().Int64Var(&reservedMemoryMb, "reserved-memory-mb", 0, "Memory in MB to hold back on each server for the OS and ECS agent when no instances of the ASG instance type are registered yet, instead of counting 985 of every 1024 MB")
+	cmd.Flags().StringVar(&instanceTypesFile, "instance-types-file", "", "JSON or YAML file mapping instance types to cpuUnits and memoryMb, types not in the file are looked up with the EC2 API")
+	cmd.Flags().BoolVar(&checkAlarms, "check-alarms", false, "Don't scale down while any alarm of the ASG scaling policies is in ALARM state")
+	cmd.Flags().BoolVar(&respectCooldown, "respect-cooldown", false, "Don't scale while the ASG has a scaling activity in progress or is within its default cooldown")
+}
+
 func init() {
 	ecsCmd.AddCommand(rightSizeClusterCmd)
+	rightSizeClusterCmd.AddCommand(rightSizePlanCmd)
+	rightSizeClusterCmd.AddCommand(rightSizeApplyCmd)
 
 	// Here you will define your flags and configuration settings.
 
@@ -48,5 +356,17 @@ func init() {
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
-	rightSizeClusterCmd.Flags().BoolVar(&atLeastServiceDesiredCount, "atLeastServiceDesiredCount", false, "Ensure at least as many EC2 instances as largest ECS service desired count.")
+	addRightSizeFlags(rightSizeClusterCmd)
+	rightSizeClusterCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the computed server count without changing the ASG")
+	rightSizeClusterCmd.Flags().StringSliceVar(&rightSizeClusters, "clusters", []string{}, "Right size these clusters instead of --cluster, comma separated or repeated")
+	rightSizeClusterCmd.Flags().BoolVar(&rightSizeAll, "all", false, "Right size every cluster in the account and region instead of --cluster")
+	rightSizeClusterCmd.Flags().IntVar(&parallelClusters, "parallel-clusters", 4, "Most clusters to right size at once with --clusters or --all")
+	addWaitFlags(rightSizeClusterCmd)
+
+	addRightSizeFlags(rightSizePlanCmd)
+	rightSizePlanCmd.Flags().StringVar(&rightSizePlanOut, "out", "", "Save the plan as JSON to this file")
+
+	addRightSizeFlags(rightSizeApplyCmd)
+	rightSizeApplyCmd.Flags().StringVar(&rightSizePlanFile, "plan-file", "", "Apply a plan saved by rightSizeCluster plan --out instead of making a new one, sizing flags are ignored")
+	addWaitFlags(rightSizeApplyCmd)
 }
@@ -0,0 +1,93 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+var desiredCount int64
+
+// scaleServiceCmd represents the ecsScaleService command
+var scaleServiceCmd = &cobra.Command{
+	Use:   "scaleService",
+	Short: "Change the desired count of an ECS service",
+	Long:  "Sets the desired task count for a single ECS service, optionally waiting for the service to become stable at the new count",
+	Run: func(cmd *cobra.Command, args []string) {
+		if service == "" {
+			fmt.Println("Service is required, use --service")
+			os.Exit(1)
+		}
+		if !cmd.Flags().Changed("desired-count") {
+			fmt.Println("Desired count is required, use --desired-count")
+			os.Exit(1)
+		}
+		if desiredCount < 0 {
+			fmt.Println("Desired count cannot be negative")
+			os.Exit(1)
+		}
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		ecsService, err := lib.GetEcsServiceByName(ctx, AwsSess, cluster, service)
+		if err != nil {
+			exitWithError("Unable to find service: ", err)
+		}
+
+		fmt.Printf("Scaling service %s from %v to %v...", *ecsService.ServiceName, *ecsService.DesiredCount, desiredCount)
+		err = lib.UpdateServiceDesiredCount(ctx, AwsSess, cluster, *ecsService.ServiceArn, desiredCount)
+		if err != nil {
+			exitWithError("Unable to scale service: ", err)
+		}
+		fmt.Printf("done\n")
+
+		if !waitStable {
+			return
+		}
+
+		fmt.Println("Waiting for service to become stable...")
+		err = lib.WaitForServiceStable(ctx, AwsSess, cluster, *ecsService.ServiceArn, waitTimeout)
+		if err != nil {
+			exitWithError("", err)
+		}
+		fmt.Println("Service is stable")
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(scaleServiceCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// scaleServiceCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	scaleServiceCmd.Flags().StringVarP(&service, "service", "s", "", "ECS service name or ARN")
+	// Complete service names in bash using __awsops_services from completion.go
+	cobra.MarkFlagCustom(scaleServiceCmd.Flags(), "service", "__awsops_services")
+	scaleServiceCmd.Flags().Int64Var(&desiredCount, "desired-count", 0, "New desired task count for the service")
+	scaleServiceCmd.Flags().BoolVar(&waitStable, "wait", false, "Wait for the service to become stable at the new count")
+	scaleServiceCmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 10*time.Minute, "Maximum time to wait for the service to become stable with --wait")
+}
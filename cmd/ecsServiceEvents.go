@@ -0,0 +1,126 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+var service string
+var eventCount int
+var follow bool
+
+const followPollInterval = 5 * time.Second
+
+// serviceEventsCmd represents the ecsServiceEvents command
+var serviceEventsCmd = &cobra.Command{
+	Use:   "serviceEvents",
+	Short: "Print recent events for an ECS service",
+	Long: `Prints the most recent events for an ECS service, such as task placement failures.
+With --follow, keeps polling and prints new events as they arrive until interrupted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if service == "" {
+			fmt.Println("Service is required, use --service")
+			os.Exit(1)
+		}
+		if eventCount < 1 {
+			fmt.Println("Count must be at least 1")
+			os.Exit(1)
+		}
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		events, err := lib.GetServiceEvents(ctx, AwsSess, cluster, service)
+		if err != nil {
+			exitWithError("Unable to get service events: ", err)
+		}
+
+		if len(events) > eventCount {
+			events = events[:eventCount]
+		}
+
+		seen := map[string]bool{}
+		printServiceEvents(newServiceEvents(events, seen))
+
+		if !follow {
+			return
+		}
+
+		for {
+			if err := aws.SleepWithContext(ctx, followPollInterval); err != nil {
+				return
+			}
+
+			events, err := lib.GetServiceEvents(ctx, AwsSess, cluster, service)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				exitWithError("Unable to get service events: ", err)
+			}
+
+			printServiceEvents(newServiceEvents(events, seen))
+		}
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(serviceEventsCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// serviceEventsCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	serviceEventsCmd.Flags().StringVarP(&service, "service", "s", "", "ECS service name or ARN")
+	// Complete service names in bash using __awsops_services from completion.go
+	cobra.MarkFlagCustom(serviceEventsCmd.Flags(), "service", "__awsops_services")
+	serviceEventsCmd.Flags().IntVarP(&eventCount, "count", "n", 10, "Number of recent events to print")
+	serviceEventsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "Keep polling and print new events as they arrive")
+}
+
+// newServiceEvents returns the events not already in seen, oldest first, and marks them as seen.
+// ECS returns service events newest first.
+func newServiceEvents(events []*ecs.ServiceEvent, seen map[string]bool) []*ecs.ServiceEvent {
+	var unseen []*ecs.ServiceEvent
+	for i := len(events) - 1; i >= 0; i-- {
+		id := aws.StringValue(events[i].Id)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		unseen = append(unseen, events[i])
+	}
+
+	return unseen
+}
+
+func printServiceEvents(events []*ecs.ServiceEvent) {
+	for _, event := range events {
+		fmt.Printf("%s  %s\n", aws.TimeValue(event.CreatedAt).Local().Format(time.RFC3339), aws.StringValue(event.Message))
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+// taskDefDiffCmd represents the ecsTaskDefDiff command
+var taskDefDiffCmd = &cobra.Command{
+	Use:   "taskDefDiff <old> <new>",
+	Short: "Show what changed between two task definition revisions",
+	Long: `Prints the changes to image, CPU, memory, environment and port mappings from the first task definition
+to the second. Each may be a family for its latest ACTIVE revision, family:revision or an ARN, for example
+to compare the revision a service runs with the latest:
+
+  awsops ecs taskDefDiff web:41 web`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			fmt.Println("Two task definitions are required, e.g. awsops ecs taskDefDiff web:41 web:42")
+			os.Exit(1)
+		}
+		checkOutputFormat()
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		diff, err := lib.GetTaskDefinitionDiff(ctx, AwsSess, args[0], args[1])
+		if err != nil {
+			exitWithError("Unable to compare task definitions: ", err)
+		}
+
+		if output == "json" {
+			printJSON(diff)
+			return
+		}
+
+		printTaskDefinitionDiff(diff)
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(taskDefDiffCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// taskDefDiffCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	taskDefDiffCmd.Flags().StringVarP(&output, "output", "o", "text", "Output format, either text or json")
+}
+
+func printTaskDefinitionDiff(diff lib.TaskDefinitionDiff) {
+	fmt.Printf("--- %s\n+++ %s\n", diff.A, diff.B)
+	if len(diff.Changes) == 0 {
+		fmt.Println("No differences")
+		return
+	}
+
+	container := ""
+	for _, change := range diff.Changes {
+		if change.Container != container {
+			container = change.Container
+			fmt.Printf("container %s:\n", container)
+		}
+
+		indent := ""
+		if change.Container != "" {
+			indent = "  "
+		}
+
+		field := change.Field
+		if change.Key != "" {
+			field += " " + change.Key
+		}
+
+		if change.Field == "container" {
+			if change.New == "" {
+				fmt.Printf("%s- removed, was %s\n", indent, change.Old)
+			} else {
+				fmt.Printf("%s+ added with image %s\n", indent, change.New)
+			}
+			continue
+		}
+
+		if change.Old != "" {
+			fmt.Printf("%s- %s: %s\n", indent, field, change.Old)
+		}
+		if change.New != "" {
+			fmt.Printf("%s+ %s: %s\n", indent, field, change.New)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+// undrainInstanceCmd represents the ecsUndrainInstance command
+var undrainInstanceCmd = &cobra.Command{
+	Use:     "undrainInstance",
+	Aliases: []string{"uncordon"},
+	Short:   "Set drained container instances in an ECS cluster back to ACTIVE",
+	Long:    "Sets the container instances for the given EC2 instances back to ACTIVE after drainInstance or cordon so ECS can place tasks on them again",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(drainInstanceIDs) == 0 {
+			fmt.Println("At least one instance ID is required, use --instance-id")
+			os.Exit(1)
+		}
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		// Check every instance before changing any of them
+		instances := getContainerInstancesToDrain(ctx)
+		for _, instance := range instances {
+			switch aws.StringValue(instance.Status) {
+			case ecs.ContainerInstanceStatusActive, ecs.ContainerInstanceStatusDraining:
+			default:
+				fmt.Printf("Instance %s is %s, only DRAINING instances can be set back to ACTIVE\n", *instance.Ec2InstanceId, *instance.Status)
+				os.Exit(1)
+			}
+		}
+
+		for _, instance := range instances {
+			if aws.StringValue(instance.Status) == ecs.ContainerInstanceStatusActive {
+				fmt.Printf("Warning: instance %s is already ACTIVE, nothing to do\n", *instance.Ec2InstanceId)
+				continue
+			}
+
+			fmt.Printf("Activating instance %s (%s)...", *instance.Ec2InstanceId, *instance.ContainerInstanceArn)
+			err := lib.UpdateContainerInstanceState(ctx, AwsSess, cluster, *instance.ContainerInstanceArn, ecs.ContainerInstanceStatusActive)
+			if err != nil {
+				fmt.Println()
+				exitWithError("Unable to activate instance: ", err)
+			}
+			fmt.Printf("done\n")
+		}
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(undrainInstanceCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// undrainInstanceCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	undrainInstanceCmd.Flags().StringArrayVarP(&drainInstanceIDs, "instance-id", "i", []string{}, "EC2 instance ID of the container instance, may be repeated")
+}
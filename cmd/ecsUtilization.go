@@ -0,0 +1,63 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/silinternational/awsops/lib"
+	"github.com/spf13/cobra"
+)
+
+// utilizationCmd represents the ecsUtilization command
+var utilizationCmd = &cobra.Command{
+	Use:   "utilization",
+	Short: "Show total CPU and memory reserved across an ECS cluster",
+	Long:  "Command prints the registered and remaining CPU and memory summed across all container instances in an ECS cluster and the percentage of each reserved by tasks",
+	Run: func(cmd *cobra.Command, args []string) {
+		checkOutputFormat()
+
+		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
+
+		utilization := lib.GetClusterResourceUtilization(ctx, AwsSess, cluster)
+
+		if output == "json" {
+			printJSON(utilization)
+			return
+		}
+
+		fmt.Println("Container instances: ", utilization.Instances)
+		fmt.Printf("CPU:    %v of %v reserved (%.1f%%), %v remaining\n", utilization.RegisteredCPU-utilization.RemainingCPU,
+			utilization.RegisteredCPU, utilization.CPUPercent, utilization.RemainingCPU)
+		fmt.Printf("Memory: %v of %v reserved (%.1f%%), %v remaining\n", utilization.RegisteredMemory-utilization.RemainingMemory,
+			utilization.RegisteredMemory, utilization.MemoryPercent, utilization.RemainingMemory)
+	},
+}
+
+func init() {
+	ecsCmd.AddCommand(utilizationCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// utilizationCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	utilizationCmd.Flags().StringVarP(&output, "output", "o", "text", "Output format, either text or json")
+}
@@ -0,0 +1,78 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/silinternational/awsops/lib"
+)
+
+// Exit codes for the kinds of failure wrapper scripts may want to react to, any other failure exits with 1
+const (
+	exitGeneric    = 1
+	exitNotFound   = 2
+	exitTimeout    = 3
+	exitPermission = 4
+	exitCapacity   = 5
+
+	// deploymentStatus exits with these so CI can loop until a deployment finishes and stop when it fails
+	exitInProgress       = 6
+	exitDeploymentFailed = 7
+)
+
+func init() {
+	lib.ExitWithError = func(err error) {
+		exitWithError("", err)
+	}
+}
+
+// exitCodeForError returns the exit code for the category of err
+func exitCodeForError(err error) int {
+	if commandTimedOut() || errors.Is(err, context.DeadlineExceeded) {
+		return exitTimeout
+	}
+
+	switch lib.ErrorCategory(err) {
+	case lib.ErrNotFound:
+		return exitNotFound
+	case lib.ErrTimeout:
+		return exitTimeout
+	case lib.ErrPermissionDenied:
+		return exitPermission
+	case lib.ErrInsufficientCapacity:
+		return exitCapacity
+	}
+
+	return exitGeneric
+}
+
+// commandTimedOut returns true once the --timeout for the command has passed
+func commandTimedOut() bool {
+	return commandCtx != nil && errors.Is(commandCtx.Err(), context.DeadlineExceeded)
+}
+
+// exitWithError prints message followed by err and exits with the code for the category of err
+func exitWithError(message string, err error) {
+	if message == "" {
+		fmt.Println(err)
+	} else {
+		fmt.Println(message, err)
+	}
+	os.Exit(exitCodeForError(err))
+}
@@ -18,7 +18,6 @@ import (
 	"fmt"
 	"github.com/silinternational/awsops/lib"
 	"github.com/spf13/cobra"
-	"os"
 )
 
 var functionName string
@@ -31,11 +30,12 @@ var invokeCmd = &cobra.Command{
 	Long:  "Invoke a lambda function",
 	Run: func(cmd *cobra.Command, args []string) {
 		initAwsSess()
+		ctx, cancel := initContext()
+		defer cancel()
 
-		result, err := lib.LambdaInvoke(AwsSess, functionName, payload)
+		result, err := lib.LambdaInvoke(ctx, AwsSess, functionName, payload)
 		if err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
+			exitWithError("", err)
 		}
 
 		fmt.Printf("Response: [code: %v] %s", *result.StatusCode, result.Payload)
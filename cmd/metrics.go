@@ -0,0 +1,91 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/silinternational/awsops/lib"
+)
+
+// replacementMetrics serves Prometheus metrics for a replacement in progress with --metrics-addr. A nil
+// *replacementMetrics is valid and ignores updates, so callers don't need to check whether it is enabled.
+type replacementMetrics struct {
+	metrics *lib.ReplacementMetrics
+	server  *http.Server
+}
+
+// replaceMetrics is set while replaceInstances serves metrics, nil otherwise
+var replaceMetrics *replacementMetrics
+
+// startReplacementMetrics listens on addr and serves metrics at /metrics until stop is called
+func startReplacementMetrics(addr string) (*replacementMetrics, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen for metrics on %s: %w", addr, err)
+	}
+
+	m := &replacementMetrics{metrics: lib.NewReplacementMetrics()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.metrics.WritePrometheus(w)
+	})
+	m.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := m.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Println("Warning: metrics server stopped: ", err)
+		}
+	}()
+	fmt.Printf("Serving metrics at http://%s/metrics\n", listener.Addr())
+
+	return m, nil
+}
+
+// stop shuts the metrics server down, giving in-flight scrapes a few seconds to finish
+func (m *replacementMetrics) stop() {
+	if m == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.server.Shutdown(ctx); err != nil {
+		fmt.Println("Warning: unable to shut down metrics server cleanly: ", err)
+	}
+}
+
+// instanceReplaced counts a replaced instance and records how long replacing it took
+func (m *replacementMetrics) instanceReplaced(duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.metrics.InstanceReplaced(duration)
+}
+
+// setPendingTasks records the pending task count from the latest check
+func (m *replacementMetrics) setPendingTasks(pending int64) {
+	if m == nil {
+		return
+	}
+
+	m.metrics.SetPendingTasks(pending)
+}
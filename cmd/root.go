@@ -15,21 +15,49 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/mitchellh/go-homedir"
+	"github.com/silinternational/awsops/lib"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 )
 
 var AwsSess *session.Session
 var cfgFile string
 var Profile string
 var Region string
+var AssumeRoleArn string
+var ExternalID string
+var EndpointURL string
+var WebIdentityTokenFile string
+var LogLevel string
+var CommandTimeout time.Duration
+
+// commandCtx is the context returned by initContext, kept so Execute can tell when --timeout was hit
+var commandCtx context.Context
+
+// defaultEndpointRegion is used with --endpoint-url when no region is configured, since the SDK
+// requires a region to sign requests even when a local endpoint ignores it
+const defaultEndpointRegion = "us-east-1"
+
+// regionPattern matches region names such as us-east-1, eu-central-1 or us-gov-west-1
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -39,14 +67,21 @@ var rootCmd = &cobra.Command{
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	//	Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		applyConfigDefaults(cmd)
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		exitWithError("", err)
+	}
+
+	// Commands that stop early when cancelled may still return normally, make sure a timeout fails the command
+	if commandTimedOut() {
+		os.Exit(exitTimeout)
 	}
 }
 
@@ -57,8 +92,14 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.awsops.yaml)")
-	rootCmd.PersistentFlags().StringVarP(&Profile, "profile", "p", "", "AWS shared credentials profile to use")
-	rootCmd.PersistentFlags().StringVarP(&Region, "region", "r", "us-east-1", "AWS shared credentials profile to use")
+	rootCmd.PersistentFlags().StringVarP(&Profile, "profile", "p", "", "AWS shared credentials profile to use, takes precedence over AWS_PROFILE")
+	rootCmd.PersistentFlags().StringVarP(&Region, "region", "r", "", "AWS region to use (defaults to AWS_REGION or the shared config file)")
+	rootCmd.PersistentFlags().StringVar(&AssumeRoleArn, "assume-role-arn", "", "IAM role ARN to assume with the profile credentials before running the command")
+	rootCmd.PersistentFlags().StringVar(&WebIdentityTokenFile, "web-identity-token-file", "", "OIDC token file to assume AWS_ROLE_ARN with, in place of AWS_WEB_IDENTITY_TOKEN_FILE and any profile credentials")
+	rootCmd.PersistentFlags().StringVar(&EndpointURL, "endpoint-url", "", "Send all AWS API calls to this URL instead of the AWS endpoints, intended for testing against LocalStack")
+	rootCmd.PersistentFlags().StringVar(&ExternalID, "external-id", "", "External ID to pass when assuming --assume-role-arn")
+	rootCmd.PersistentFlags().StringVar(&LogLevel, "log-level", "info", "Set to debug to log each AWS API request, retry and error to stderr")
+	rootCmd.PersistentFlags().DurationVar(&CommandTimeout, "timeout", 0, "Overall time limit for the command, AWS calls and waits are cancelled once it is reached (default no limit)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -74,8 +115,7 @@ func initConfig() {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			exitWithError("", err)
 		}
 
 		// Search config in home directory with name ".awsops" (without extension).
@@ -85,23 +125,212 @@ func initConfig() {
 
 	viper.AutomaticEnv() // read in environment variables that match
 
-	// If a config file is found, read it in.
+	// If a config file is found, read it in. Report it on stderr so it doesn't end up in --output json.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	} else if cfgFile != "" {
+		fmt.Printf("Unable to read config file %s: %s\n", cfgFile, err)
+		os.Exit(1)
 	}
 }
 
+// applyConfigDefaults sets flags that were not given on the command line from matching top level keys in
+// the config file, such as region, profile or cluster. Command line flags override config values, and
+// config values override environment variables such as AWS_REGION and AWS_PROFILE. Cobra only runs the
+// PersistentPreRun closest to the command, so subcommands with their own must call this too.
+func applyConfigDefaults(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed || !viper.InConfig(f.Name) {
+			return
+		}
+
+		values := []string{viper.GetString(f.Name)}
+		if list, ok := viper.Get(f.Name).([]interface{}); ok {
+			values = []string{}
+			for _, v := range list {
+				values = append(values, fmt.Sprint(v))
+			}
+		}
+
+		// Setting through the flag set marks the flag as changed, like a flag given on the command line
+		for _, v := range values {
+			if err := cmd.Flags().Set(f.Name, v); err != nil {
+				fmt.Printf("Invalid value %q for %s in config file %s: %s\n", v, f.Name, viper.ConfigFileUsed(), err)
+				os.Exit(1)
+			}
+		}
+	})
+}
+
 func initAwsSess() {
-	// If profile is provided, use shared creds file and specific profile,
-	// otherwise use default credential identification order
-	if Profile != "" {
-		AwsSess = session.Must(session.NewSession(&aws.Config{
-			Region:      aws.String(Region),
-			Credentials: credentials.NewSharedCredentials("", Profile),
-		}))
-	} else {
-		AwsSess = session.Must(session.NewSession(&aws.Config{
-			Region:      aws.String(Region),
-		}))
+	config := aws.Config{}
+
+	// If region is provided use it, otherwise fall back to the SDK's default
+	// region resolution (AWS_REGION env var, then the shared config file)
+	if Region != "" || rootCmd.PersistentFlags().Changed("region") {
+		if !regionPattern.MatchString(Region) {
+			fmt.Printf("Invalid region provided: %q\n", Region)
+			os.Exit(1)
+		}
+		config.Region = aws.String(Region)
+	}
+
+	if EndpointURL != "" {
+		endpoint, err := url.Parse(EndpointURL)
+		if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+			fmt.Printf("Invalid endpoint URL provided: %q\n", EndpointURL)
+			os.Exit(1)
+		}
+		config.Endpoint = aws.String(EndpointURL)
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	switch LogLevel {
+	case "info":
+	case "debug":
+		// Log to stderr so requests don't end up in --output json
+		config.LogLevel = aws.LogLevel(aws.LogDebugWithRequestRetries | aws.LogDebugWithRequestErrors)
+		config.Logger = aws.LoggerFunc(func(args ...interface{}) {
+			fmt.Fprintln(os.Stderr, args...)
+		})
+	default:
+		fmt.Printf("Invalid log level provided: %q, must be info or debug\n", LogLevel)
+		os.Exit(1)
+	}
+
+	// If profile is provided, use it from the shared credentials/config files in place
+	// of AWS_PROFILE, otherwise use default credential identification order
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            config,
+		Profile:           Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		if _, ok := err.(session.SharedConfigProfileNotExistsError); ok {
+			fmt.Printf("AWS profile %q not found in shared credentials or config file\n", Profile)
+		} else {
+			fmt.Println("Unable to create AWS session: ", err)
+		}
+		os.Exit(1)
+	}
+
+	if EndpointURL != "" && aws.StringValue(sess.Config.Region) == "" {
+		sess.Config.Region = aws.String(defaultEndpointRegion)
+	}
+
+	if tokenFile := webIdentityTokenFile(); tokenFile != "" {
+		sess = assumeRoleWithWebIdentity(sess, tokenFile)
+	}
+
+	if AssumeRoleArn != "" {
+		sess = assumeRole(sess)
+	} else if ExternalID != "" {
+		fmt.Println("--external-id can only be used with --assume-role-arn")
+		os.Exit(1)
+	}
+
+	AwsSess = sess
+}
+
+// webIdentityTokenFile returns the token file to assume AWS_ROLE_ARN with, when --web-identity-token-file or
+// AWS_WEB_IDENTITY_TOKEN_FILE is where credentials come from
+func webIdentityTokenFile() string {
+	switch lib.GetCredentialSource(WebIdentityTokenFile, Profile, os.Getenv) {
+	case lib.CredentialsWebIdentityFlag:
+		return WebIdentityTokenFile
+	case lib.CredentialsWebIdentityEnv:
+		return os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+
+	return ""
+}
+
+// assumeRoleWithWebIdentity returns a copy of sess using temporary credentials for AWS_ROLE_ARN, as used
+// with EKS service account roles (IRSA) and CI OIDC providers. Like assumeRole, the role is assumed up front
+// so a missing token or untrusted role is reported before the command starts.
+func assumeRoleWithWebIdentity(sess *session.Session, tokenFile string) *session.Session {
+	roleArn := os.Getenv("AWS_ROLE_ARN")
+	if roleArn == "" {
+		fmt.Printf("AWS_ROLE_ARN must be set to the role to assume with web identity token file %s\n", tokenFile)
+		os.Exit(1)
+	}
+
+	creds := stscreds.NewWebIdentityCredentials(sess, roleArn, os.Getenv("AWS_ROLE_SESSION_NAME"), tokenFile)
+
+	if _, err := creds.Get(); err != nil {
+		// The provider wraps the STS or file error, report that one
+		if aerr, ok := err.(awserr.Error); ok && aerr.OrigErr() != nil {
+			err = aerr.OrigErr()
+		}
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "AccessDenied" {
+			fmt.Printf("Not allowed to assume role %s with web identity token file %s, check the role trust policy "+
+				"allows the token's issuer and subject: %s\n", roleArn, tokenFile, aerr.Message())
+		} else {
+			fmt.Printf("Unable to assume role %s with web identity token file %s: %s\n", roleArn, tokenFile, err)
+		}
+		os.Exit(exitCodeForError(err))
+	}
+
+	return sess.Copy(&aws.Config{Credentials: creds})
+}
+
+// assumeRole returns a copy of sess using temporary credentials for --assume-role-arn. The role is
+// assumed up front so a failure is reported before the command starts making changes.
+func assumeRole(sess *session.Session) *session.Session {
+	roleArn, err := arn.Parse(AssumeRoleArn)
+	if err != nil || roleArn.Service != "iam" || !strings.HasPrefix(roleArn.Resource, "role/") {
+		fmt.Printf("Invalid role ARN provided: %q, expected arn:aws:iam::<account>:role/<name>\n", AssumeRoleArn)
+		os.Exit(1)
+	}
+
+	creds := stscreds.NewCredentials(sess, AssumeRoleArn, func(p *stscreds.AssumeRoleProvider) {
+		if ExternalID != "" {
+			p.ExternalID = aws.String(ExternalID)
+		}
+	})
+
+	if _, err := creds.Get(); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "AccessDenied" {
+			fmt.Printf("Not allowed to assume role %s, check the role trust policy, the external ID and "+
+				"that the current credentials may call sts:AssumeRole: %s\n", AssumeRoleArn, aerr.Message())
+		} else if ok && aerr.Code() == "ValidationError" {
+			fmt.Printf("Unable to assume role %s, the role ARN or external ID is invalid: %s\n", AssumeRoleArn, aerr.Message())
+		} else {
+			fmt.Printf("Unable to assume role %s: %s\n", AssumeRoleArn, err)
+		}
+		os.Exit(exitCodeForError(err))
+	}
+
+	// The credentials include the session token and are refreshed before they expire
+	return sess.Copy(&aws.Config{Credentials: creds})
+}
+
+// initContext returns a context that is cancelled when the user interrupts the
+// command (Ctrl-C) or it is sent SIGTERM, so in-flight AWS calls and polling loops can stop cleanly.
+// With --timeout the context is also cancelled once the timeout has passed.
+func initContext() (context.Context, context.CancelFunc) {
+	if CommandTimeout < 0 {
+		fmt.Println("--timeout must not be negative")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if CommandTimeout == 0 {
+		commandCtx = ctx
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, CommandTimeout)
+	commandCtx = ctx
+	go func() {
+		<-ctx.Done()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			fmt.Fprintf(os.Stderr, "\nOperation timed out after %s\n", CommandTimeout)
+		}
+	}()
+
+	return ctx, func() {
+		cancel()
+		stop()
 	}
 }
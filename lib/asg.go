@@ -1,107 +1,324 @@
 package lib
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
 	"math"
-	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func GetAsgNameForEcsCluster(awsSess *session.Session, cluster string) string {
-	instanceIDs := GetInstanceIDsForEcsCluster(awsSess, cluster)
+// GetAsgNameForEcsCluster returns the ASG the cluster's container instances belong to. An error is returned
+// when they belong to more than one, as during a migration between ASGs, so the wrong one isn't acted on.
+func GetAsgNameForEcsCluster(ctx context.Context, awsSess *session.Session, cluster string) (string, error) {
+	containerInstances, err := describeInstancesForEcsCluster(ctx, awsSess, cluster)
+	if err != nil {
+		return "", err
+	}
 
-	svc := ec2.New(awsSess)
-	instanceDetails, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{
-		InstanceIds: instanceIDs,
-	})
+	return asgNameForContainerInstances(ctx, awsSess, cluster, containerInstances)
+}
+
+// asgNameForContainerInstances is GetAsgNameForEcsCluster for container instances already described
+func asgNameForContainerInstances(ctx context.Context, awsSess *session.Session, cluster string,
+	containerInstances []*ecs.ContainerInstance) (string, error) {
+	asgNames, err := asgNamesForContainerInstances(ctx, awsSess, cluster, containerInstances)
+	if err != nil {
+		return "", err
+	}
+
+	if len(asgNames) > 1 {
+		return "", fmt.Errorf("container instances in cluster %q belong to %v ASGs: %s", cluster, len(asgNames),
+			strings.Join(asgNames, ", "))
+	}
+
+	return asgNames[0], nil
+}
+
+// GetAsgNamesForEcsCluster returns every distinct ASG named by the aws:autoscaling:groupName tag of the
+// cluster's container instances, sorted by name
+func GetAsgNamesForEcsCluster(ctx context.Context, awsSess *session.Session, cluster string) ([]string, error) {
+	containerInstances, err := describeInstancesForEcsCluster(ctx, awsSess, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return asgNamesForContainerInstances(ctx, awsSess, cluster, containerInstances)
+}
+
+// asgNamesForContainerInstances is GetAsgNamesForEcsCluster for container instances already described
+func asgNamesForContainerInstances(ctx context.Context, awsSess *session.Session, cluster string,
+	containerInstances []*ecs.ContainerInstance) ([]string, error) {
+	var instanceIDs []*string
+	for _, instance := range containerInstances {
+		instanceIDs = append(instanceIDs, instance.Ec2InstanceId)
+	}
+	if len(instanceIDs) == 0 {
+		return nil, fmt.Errorf("cluster %q has no container instances", cluster)
+	}
+
+	instances, err := DescribeEc2Instances(ctx, awsSess, instanceIDs)
 	if err != nil {
-		fmt.Println("Unable to get asg name from instance: ", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("unable to get asg name from instances: %w", err)
 	}
 
-	for _, tag := range instanceDetails.Reservations[0].Instances[0].Tags {
-		if *tag.Key == "aws:autoscaling:groupName" {
-			return *tag.Value
+	// Instances terminated since the cluster was listed may not be returned at all
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("none of the %v container instances in cluster %q were found in EC2, they may have "+
+			"just been terminated", len(instanceIDs), cluster)
+	}
+
+	// Not every instance is guaranteed to be tagged, so check them all before giving up
+	seen := map[string]bool{}
+	var asgNames []string
+	for _, i := range instances {
+		for _, tag := range i.Tags {
+			if aws.StringValue(tag.Key) == "aws:autoscaling:groupName" && !seen[aws.StringValue(tag.Value)] {
+				seen[aws.StringValue(tag.Value)] = true
+				asgNames = append(asgNames, aws.StringValue(tag.Value))
+			}
 		}
 	}
 
-	return ""
+	if len(asgNames) == 0 {
+		return nil, fmt.Errorf("no container instances in cluster %q have an aws:autoscaling:groupName tag", cluster)
+	}
+
+	sort.Strings(asgNames)
+	return asgNames, nil
 }
 
-func DetachAndReplaceAsgInstances(awsSess *session.Session, asgName string, instancesToTerminate []*string) {
-	svc := autoscaling.New(awsSess)
+// DetachAndReplaceAsgInstances detaches the given instances from the ASG so it launches replacements,
+// then waits for the replacements to be InService, ACTIVE in the ECS cluster and have their ECS agent
+// connected, so they can run tasks before the detached instances are terminated. If any step fails
+// after instances were detached they are re-attached to the ASG. It returns the instances still
+// detached from the ASG, which is all of them on success.
+func DetachAndReplaceAsgInstances(ctx context.Context, awsSess *session.Session, cluster, asgName string,
+	instancesToTerminate []*string, timeout time.Duration) (detached []*string, err error) {
+	svc := newAutoscalingClient(awsSess)
 
 	decrement := false
 
-	fmt.Printf("Detaching %v instances...", len(instancesToTerminate))
-	_, err := svc.DetachInstances(&autoscaling.DetachInstancesInput{
-		AutoScalingGroupName:           &asgName,
-		InstanceIds:                    instancesToTerminate,
-		ShouldDecrementDesiredCapacity: &decrement,
-	})
+	// Instances remaining in the ASG plus the replacements it launches should
+	// bring it back up to its current size
+	asgInstances, err := GetInstanceListForAsg(ctx, awsSess, asgName)
 	if err != nil {
-		fmt.Println("Unable to detach instances: ", err)
-		os.Exit(1)
+		return nil, err
 	}
+	expectedCount := len(asgInstances)
 
-	fmt.Printf("done\n")
+	// Put detached instances back so the ASG isn't left short of capacity when something fails before
+	// they are terminated. The command context may be cancelled, so re-attach with a fresh one.
+	defer func() {
+		if err == nil || len(detached) == 0 {
+			return
+		}
+
+		fmt.Printf("Re-attaching %v detached instances to ASG %s after error: %s\n", len(detached), asgName, err)
+		if attachErr := AttachInstancesToAsg(context.Background(), awsSess, asgName, detached); attachErr != nil {
+			fmt.Println("Unable to re-attach instances: ", attachErr)
+			err = fmt.Errorf("%w, and unable to re-attach detached instances: %s", err, attachErr)
+			return
+		}
+		detached = nil
+	}()
 
-	for ready := false; ready != true; {
-		time.Sleep(15 * time.Second)
-		instances := GetInstanceListForAsg(awsSess, asgName)
-		fmt.Printf("\rNew instances created: %v", len(instances))
-		if len(instances) == len(instancesToTerminate) {
-			ready = true
+	fmt.Printf("Detaching %v instances...", len(instancesToTerminate))
+	for _, chunk := range chunkStrings(instancesToTerminate, attachInstancesMaxInstances) {
+		_, err = svc.DetachInstancesWithContext(ctx, &autoscaling.DetachInstancesInput{
+			AutoScalingGroupName:           &asgName,
+			InstanceIds:                    chunk,
+			ShouldDecrementDesiredCapacity: &decrement,
+		})
+		if err != nil {
 			fmt.Println()
-			fmt.Println("Finished creating new instances")
+			return detached, fmt.Errorf("unable to detach instances: %w", err)
+		}
+		detached = append(detached, chunk...)
+	}
+
+	fmt.Printf("done\n")
+
+	inService, err := WaitForAsgInstancesInService(ctx, awsSess, asgName, expectedCount, timeout)
+	if err != nil {
+		return detached, err
+	}
+	fmt.Println("Finished creating new instances")
+
+	err = WaitForInstancesActiveInCluster(ctx, awsSess, cluster, inService, timeout)
+	if err != nil {
+		return detached, err
+	}
+
+	err = WaitForAgentsConnected(ctx, awsSess, cluster, inService, timeout)
+
+	return detached, err
+}
+
+// attachInstancesMaxInstances is the most instances the AttachInstances and DetachInstances APIs
+// accept per call
+const attachInstancesMaxInstances = 20
+
+// AttachInstancesToAsg attaches instances back to an ASG, increasing its desired capacity to match
+func AttachInstancesToAsg(ctx context.Context, awsSess *session.Session, asgName string, instanceIDs []*string) error {
+	svc := newAutoscalingClient(awsSess)
+
+	for _, chunk := range chunkStrings(instanceIDs, attachInstancesMaxInstances) {
+		_, err := svc.AttachInstancesWithContext(ctx, &autoscaling.AttachInstancesInput{
+			AutoScalingGroupName: aws.String(asgName),
+			InstanceIds:          chunk,
+		})
+		if err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
 
-func GetInstanceListForAsg(awsSess *session.Session, asgName string) []*string {
-	asg := GetAsg(awsSess, asgName)
+// GetInstanceTypeForAsg returns the instance type from the ASG launch configuration or launch template
+func GetInstanceTypeForAsg(ctx context.Context, awsSess *session.Session, asgName string) string {
+	instanceType, err := getSingleInstanceTypeForAsg(ctx, awsSess, GetAsg(ctx, awsSess, asgName))
+	if err != nil {
+		ExitWithError(err)
+	}
 
-	var instanceIds []*string
-	for _, ins := range asg.Instances {
-		instanceIds = append(instanceIds, ins.InstanceId)
+	return instanceType
+}
+
+// getSingleInstanceTypeForAsg returns the instance type an ASG without mixed instance type overrides launches
+func getSingleInstanceTypeForAsg(ctx context.Context, awsSess *session.Session, asg *autoscaling.Group) (string, error) {
+	if asg.LaunchConfigurationName != nil {
+		svc := newAutoscalingClient(awsSess)
+		lc, err := svc.DescribeLaunchConfigurationsWithContext(ctx, &autoscaling.DescribeLaunchConfigurationsInput{
+			LaunchConfigurationNames: []*string{asg.LaunchConfigurationName},
+		})
+		if err != nil {
+			return "", fmt.Errorf("unable to describe launch configuration %s: %w", *asg.LaunchConfigurationName, err)
+		}
+
+		if len(lc.LaunchConfigurations) != 1 {
+			return "", fmt.Errorf("DescribeLaunchConfigurations did not return expected number of results. Expected: 1, Actual: %v",
+				len(lc.LaunchConfigurations))
+		}
+
+		return aws.StringValue(lc.LaunchConfigurations[0].InstanceType), nil
+	}
+
+	template := getLaunchTemplateForAsg(asg)
+	if template == nil {
+		return "", fmt.Errorf("ASG %s has neither a launch configuration nor a launch template", aws.StringValue(asg.AutoScalingGroupName))
+	}
+
+	data, err := getLaunchTemplateData(ctx, awsSess, template)
+	if err != nil {
+		return "", err
+	}
+
+	if data.InstanceType == nil {
+		return "", fmt.Errorf("version %s of launch template %s does not specify an instance type",
+			launchTemplateVersion(template), launchTemplateName(template))
 	}
 
-	return instanceIds
+	return *data.InstanceType, nil
 }
 
-func GetInstanceTypeForAsg(awsSess *session.Session, asgName string) string {
-	svc := autoscaling.New(awsSess)
+// AsgInstanceType is an instance type an ASG launches, how many capacity units each instance of it counts
+// for in the ASG desired capacity, and how many instances of it are in the ASG
+type AsgInstanceType struct {
+	InstanceType     string
+	WeightedCapacity int64
+	Instances        int64
+}
 
-	asg := GetAsg(awsSess, asgName)
+// GetWeightedInstanceTypesForAsg returns the instance types an ASG launches. For an ASG with a mixed
+// instances policy these are the launch template overrides with their weighted capacity, in priority order.
+// Any other ASG has the single instance type from its launch configuration or launch template with a weight of 1.
+func GetWeightedInstanceTypesForAsg(ctx context.Context, awsSess *session.Session, asgName string) ([]AsgInstanceType, error) {
+	asg, err := DescribeAsg(ctx, awsSess, asgName)
+	if err != nil {
+		return nil, err
+	}
 
-	input := &autoscaling.DescribeLaunchConfigurationsInput{
-		LaunchConfigurationNames: []*string{
-			aws.String(*asg.LaunchConfigurationName),
-		},
+	var instanceTypes []AsgInstanceType
+	if policy := asg.MixedInstancesPolicy; policy != nil && policy.LaunchTemplate != nil && len(policy.LaunchTemplate.Overrides) > 0 {
+		for _, override := range policy.LaunchTemplate.Overrides {
+			if aws.StringValue(override.InstanceType) == "" {
+				return nil, fmt.Errorf("ASG %s has a launch template override without an instance type", asgName)
+			}
+
+			weight := int64(1)
+			if override.WeightedCapacity != nil {
+				weight, err = strconv.ParseInt(*override.WeightedCapacity, 10, 64)
+				if err != nil || weight < 1 {
+					return nil, fmt.Errorf("invalid weighted capacity %q for instance type %s in ASG %s",
+						*override.WeightedCapacity, *override.InstanceType, asgName)
+				}
+			}
+
+			instanceTypes = append(instanceTypes, AsgInstanceType{InstanceType: *override.InstanceType, WeightedCapacity: weight})
+		}
+	} else {
+		instanceType, err := getSingleInstanceTypeForAsg(ctx, awsSess, asg)
+		if err != nil {
+			return nil, err
+		}
+
+		instanceTypes = []AsgInstanceType{{InstanceType: instanceType, WeightedCapacity: 1}}
+	}
+
+	for i := range instanceTypes {
+		for _, instance := range asg.Instances {
+			if aws.StringValue(instance.InstanceType) == instanceTypes[i].InstanceType {
+				instanceTypes[i].Instances++
+			}
+		}
 	}
 
-	lc, err := svc.DescribeLaunchConfigurations(input)
+	return instanceTypes, nil
+}
+
+// GetInstanceTypesForAsg returns the names of the instance types the ASG launches
+func GetInstanceTypesForAsg(ctx context.Context, awsSess *session.Session, asgName string) []string {
+	instanceTypes, err := GetWeightedInstanceTypesForAsg(ctx, awsSess, asgName)
 	if err != nil {
-		fmt.Println("Unable to describe launch configuration, err: ", err.Error())
+		ExitWithError(err)
 	}
 
-	if len(lc.LaunchConfigurations) != 1 {
-		fmt.Println("DescribeLaunchConfigurations did not return expected number of results. Expected: 1, Actual: ", len(lc.LaunchConfigurations))
-		os.Exit(1)
+	var names []string
+	for _, instanceType := range instanceTypes {
+		names = append(names, instanceType.InstanceType)
 	}
 
-	return *lc.LaunchConfigurations[0].InstanceType
+	return names
 }
 
-func HowManyServersNeededForAsg(serverType string, memory, cpu int64) int64 {
-	instanceSpecs, valid := InstanceTypes[serverType]
-	if !valid {
-		fmt.Println("Invalid server type provided: ", serverType)
-		os.Exit(1)
+// DominantInstanceType returns the instance type with the most instances in the ASG, or the highest priority
+// type when several have the same number of instances
+func DominantInstanceType(instanceTypes []AsgInstanceType) AsgInstanceType {
+	var dominant AsgInstanceType
+	for i, instanceType := range instanceTypes {
+		if i == 0 || instanceType.Instances > dominant.Instances {
+			dominant = instanceType
+		}
+	}
+
+	return dominant
+}
+
+func HowManyServersNeededForAsg(ctx context.Context, awsSess *session.Session, serverType string, memory, cpu int64) int64 {
+	instanceSpecs, err := GetInstanceTypeCapacity(ctx, awsSess, serverType, 0)
+	if err != nil {
+		ExitWithError(fmt.Errorf("Invalid server type provided: %w", err))
 	}
 
 	neededForMem := math.Ceil(float64(memory) / float64(instanceSpecs.MemoryMb))
@@ -114,41 +331,162 @@ func HowManyServersNeededForAsg(serverType string, memory, cpu int64) int64 {
 	return int64(neededForCPU)
 }
 
-func GetAsgServerCount(awsSess *session.Session, asgName string) (desired int64, min int64, max int64) {
-	asg := GetAsg(awsSess, asgName)
+// TaskResources is the memory and CPU reserved by a single ECS task
+type TaskResources struct {
+	MemoryMb int64
+	CPUUnits int64
+}
+
+// HowManyServersNeededForTasks places each task onto servers with the given capacity using first-fit-decreasing
+// bin packing and returns the number of servers used. Unlike HowManyServersNeededForAsg it accounts for
+// tasks not being splittable across servers.
+func HowManyServersNeededForTasks(capacity TaskResources, tasks []TaskResources) (int64, error) {
+	if capacity.MemoryMb <= 0 || capacity.CPUUnits <= 0 {
+		return 0, fmt.Errorf("invalid server capacity: %v memory, %v cpu", capacity.MemoryMb, capacity.CPUUnits)
+	}
+
+	size := func(task TaskResources) float64 {
+		return math.Max(float64(task.MemoryMb)/float64(capacity.MemoryMb),
+			float64(task.CPUUnits)/float64(capacity.CPUUnits))
+	}
+
+	sorted := make([]TaskResources, len(tasks))
+	copy(sorted, tasks)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return size(sorted[i]) > size(sorted[j])
+	})
+
+	// remaining capacity of each server in use
+	var servers []TaskResources
+	for _, task := range sorted {
+		if task.MemoryMb > capacity.MemoryMb || task.CPUUnits > capacity.CPUUnits {
+			return 0, withCategory(fmt.Errorf("task needing %v memory and %v cpu does not fit on a server with %v memory and %v cpu",
+				task.MemoryMb, task.CPUUnits, capacity.MemoryMb, capacity.CPUUnits), ErrInsufficientCapacity)
+		}
+
+		placed := false
+		for i := range servers {
+			if servers[i].MemoryMb >= task.MemoryMb && servers[i].CPUUnits >= task.CPUUnits {
+				servers[i].MemoryMb -= task.MemoryMb
+				servers[i].CPUUnits -= task.CPUUnits
+				placed = true
+				break
+			}
+		}
+
+		if !placed {
+			servers = append(servers, TaskResources{
+				MemoryMb: capacity.MemoryMb - task.MemoryMb,
+				CPUUnits: capacity.CPUUnits - task.CPUUnits,
+			})
+		}
+	}
+
+	return int64(len(servers)), nil
+}
+
+// GetAsgServerCount returns the desired, min and max size of the ASG
+func GetAsgServerCount(ctx context.Context, awsSess *session.Session, asgName string) (desired int64, min int64, max int64, err error) {
+	asg, err := DescribeAsg(ctx, awsSess, asgName)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return aws.Int64Value(asg.DesiredCapacity), aws.Int64Value(asg.MinSize), aws.Int64Value(asg.MaxSize), nil
+}
+
+func GetAsg(ctx context.Context, awsSess *session.Session, asgName string) *autoscaling.Group {
+	asg, err := DescribeAsg(ctx, awsSess, asgName)
+	if err != nil {
+		ExitWithError(err)
+	}
+
+	return asg
+}
+
+// GetBlockingScalingActivity returns the most recent scaling activity if it is still in progress or
+// finished within the ASG's default cooldown, along with the reason it blocks scaling. Returns nil
+// if the ASG can be scaled.
+func GetBlockingScalingActivity(ctx context.Context, awsSess *session.Session, asgName string) (*autoscaling.Activity, string, error) {
+	asg, err := DescribeAsg(ctx, awsSess, asgName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	svc := newAutoscalingClient(awsSess)
+
+	// Activities are returned newest first
+	activities, err := svc.DescribeScalingActivitiesWithContext(ctx, &autoscaling.DescribeScalingActivitiesInput{
+		AutoScalingGroupName: aws.String(asgName),
+		MaxRecords:           aws.Int64(1),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to describe scaling activities for ASG %s: %w", asgName, err)
+	}
+
+	if len(activities.Activities) == 0 {
+		return nil, "", nil
+	}
+
+	activity := activities.Activities[0]
+	switch aws.StringValue(activity.StatusCode) {
+	case autoscaling.ScalingActivityStatusCodeSuccessful, autoscaling.ScalingActivityStatusCodeFailed,
+		autoscaling.ScalingActivityStatusCodeCancelled:
+	default:
+		return activity, "scaling activity in progress", nil
+	}
 
-	return *asg.DesiredCapacity, *asg.MinSize, *asg.MaxSize
+	cooldown := time.Duration(aws.Int64Value(asg.DefaultCooldown)) * time.Second
+	if activity.EndTime != nil && time.Since(*activity.EndTime) < cooldown {
+		return activity, fmt.Sprintf("within %s cooldown after last scaling activity", cooldown), nil
+	}
+
+	return nil, "", nil
 }
 
-func GetAsg(awsSess *session.Session, asgName string) *autoscaling.Group {
-	svc := autoscaling.New(awsSess)
+// DescribeAsg returns the named ASG, or an error if it does not exist
+func DescribeAsg(ctx context.Context, awsSess *session.Session, asgName string) (*autoscaling.Group, error) {
+	svc := newAutoscalingClient(awsSess)
 
-	groups, err := svc.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+	groups, err := svc.DescribeAutoScalingGroupsWithContext(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: []*string{&asgName},
 	})
 	if err != nil {
-		fmt.Println("Unable to get list of ASG groups: ", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("unable to get list of ASG groups: %w", err)
+	}
+
+	if len(groups.AutoScalingGroups) == 0 {
+		return nil, fmt.Errorf("ASG %s %w", asgName, ErrNotFound)
 	}
 
 	if len(groups.AutoScalingGroups) != 1 {
-		fmt.Println("DescribeAutoScalingGroups did not return expected number of results. Expected: 1, Actual: ", len(groups.AutoScalingGroups))
-		os.Exit(1)
+		return nil, fmt.Errorf("DescribeAutoScalingGroups did not return expected number of results. Expected: 1, Actual: %v",
+			len(groups.AutoScalingGroups))
 	}
 
-	return groups.AutoScalingGroups[0]
+	return groups.AutoScalingGroups[0], nil
+}
+
+// UpdateAsgServerCount sets the ASG min, max and desired capacity all to serverCount
+func UpdateAsgServerCount(ctx context.Context, awsSess *session.Session, asgName string, serverCount int64) error {
+	return UpdateAsgCapacity(ctx, awsSess, asgName, serverCount, serverCount, serverCount)
 }
 
-func UpdateAsgServerCount(awsSess *session.Session, asgName string, serverCount int64) error {
-	svc := autoscaling.New(awsSess)
+// UpdateAsgCapacity sets the ASG min, desired and max capacity, which must satisfy min <= desired <= max
+func UpdateAsgCapacity(ctx context.Context, awsSess *session.Session, asgName string, min, desired, max int64) error {
+	if min < 0 || min > desired || desired > max {
+		return fmt.Errorf("invalid ASG capacity, must have 0 <= min (%v) <= desired (%v) <= max (%v)", min, desired, max)
+	}
+
+	svc := newAutoscalingClient(awsSess)
 	input := &autoscaling.UpdateAutoScalingGroupInput{
 		AutoScalingGroupName: aws.String(asgName),
-		MaxSize:              aws.Int64(serverCount),
-		MinSize:              aws.Int64(serverCount),
-		DesiredCapacity:      aws.Int64(serverCount),
+		MaxSize:              aws.Int64(max),
+		MinSize:              aws.Int64(min),
+		DesiredCapacity:      aws.Int64(desired),
 	}
 
-	_, err := svc.UpdateAutoScalingGroup(input)
+	_, err := svc.UpdateAutoScalingGroupWithContext(ctx, input)
 	if err != nil {
 		fmt.Println(err.Error())
 		return err
@@ -156,3 +494,424 @@ func UpdateAsgServerCount(awsSess *session.Session, asgName string, serverCount
 
 	return nil
 }
+
+// SpareInstancesNeeded returns how many instances to add to an ASG of asgInstances before replacing replacing of
+// them. Replacements are ready before any instance is terminated, so none are needed by default. minHealthy,
+// when more than 0, is the fewest instances not being replaced the ASG must keep in case replacements never come
+// up, and an error wrapping ErrInsufficientCapacity is returned when it isn't met without scaleUpFirst. With
+// scaleUpFirst at least batchSize instances are added, so each batch of tasks has somewhere to move to.
+func SpareInstancesNeeded(asgInstances, replacing, minHealthy, batchSize int, scaleUpFirst bool) (int, error) {
+	shortfall := minHealthy - (asgInstances - replacing)
+	if !scaleUpFirst {
+		if minHealthy > 0 && shortfall > 0 {
+			return 0, fmt.Errorf("replacing %v of %v instances would leave fewer than --min-healthy %v instances that are "+
+				"not being replaced (%w). Use --scale-up-first to add spare capacity before starting, or replace fewer instances",
+				replacing, asgInstances, minHealthy, ErrInsufficientCapacity)
+		}
+		return 0, nil
+	}
+
+	if shortfall > batchSize {
+		return shortfall, nil
+	}
+	return batchSize, nil
+}
+
+// ScaleUpAsg increases the ASG desired capacity by count and waits for the new instances to be InService in
+// the ASG, ACTIVE in the cluster and have their ECS agent connected. When the new desired capacity is more than the ASG max size, the max is
+// raised to match if raiseMax is true, otherwise an error wrapping ErrInsufficientCapacity is returned
+// before the ASG is changed.
+func ScaleUpAsg(ctx context.Context, awsSess *session.Session, cluster, asgName string, count int64, raiseMax bool,
+	timeout time.Duration) error {
+	desired, min, max, err := GetAsgServerCount(ctx, awsSess, asgName)
+	if err != nil {
+		return err
+	}
+
+	newDesired := desired + count
+	if newDesired > max {
+		if !raiseMax {
+			return fmt.Errorf("scaling ASG %s up to desired capacity %v would exceed its max size %v (%w), "+
+				"raise the ASG max size first or allow it to be raised", asgName, newDesired, max, ErrInsufficientCapacity)
+		}
+		fmt.Printf("Raising ASG %s max size from %v to %v\n", asgName, max, newDesired)
+		max = newDesired
+	}
+
+	err = UpdateAsgCapacity(ctx, awsSess, asgName, min, newDesired, max)
+	if err != nil {
+		return fmt.Errorf("unable to scale up ASG %s: %w", asgName, err)
+	}
+
+	inService, err := WaitForAsgInstancesInService(ctx, awsSess, asgName, int(newDesired), timeout)
+	if err != nil {
+		return err
+	}
+
+	err = WaitForInstancesActiveInCluster(ctx, awsSess, cluster, inService, timeout)
+	if err != nil {
+		return err
+	}
+
+	return WaitForAgentsConnected(ctx, awsSess, cluster, inService, timeout)
+}
+
+// instanceRefreshPollInterval is how often an instance refresh is checked while waiting for it to finish
+var instanceRefreshPollInterval = 30 * time.Second
+
+// StartInstanceRefresh starts an ASG instance refresh that replaces instances in batches while keeping at
+// least minHealthyPercentage of the desired capacity InService. The ASG waits warmup after a new instance
+// is InService before counting it as healthy and moving on, a zero warmup uses the ASG health check grace
+// period. Returns the instance refresh ID.
+func StartInstanceRefresh(ctx context.Context, awsSess *session.Session, asgName string, minHealthyPercentage int64,
+	warmup time.Duration) (string, error) {
+	if minHealthyPercentage < 0 || minHealthyPercentage > 100 {
+		return "", fmt.Errorf("min healthy percentage must be between 0 and 100, got %v", minHealthyPercentage)
+	}
+
+	preferences := &autoscaling.RefreshPreferences{
+		MinHealthyPercentage: aws.Int64(minHealthyPercentage),
+	}
+	if warmup > 0 {
+		preferences.InstanceWarmup = aws.Int64(int64(warmup.Seconds()))
+	}
+
+	svc := newAutoscalingClient(awsSess)
+	result, err := svc.StartInstanceRefreshWithContext(ctx, &autoscaling.StartInstanceRefreshInput{
+		AutoScalingGroupName: aws.String(asgName),
+		Strategy:             aws.String(autoscaling.RefreshStrategyRolling),
+		Preferences:          preferences,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to start instance refresh for ASG %s: %w", asgName, err)
+	}
+
+	return aws.StringValue(result.InstanceRefreshId), nil
+}
+
+// GetInstanceRefresh returns the current state of an ASG instance refresh
+func GetInstanceRefresh(ctx context.Context, awsSess *session.Session, asgName, refreshID string) (*autoscaling.InstanceRefresh, error) {
+	svc := newAutoscalingClient(awsSess)
+	result, err := svc.DescribeInstanceRefreshesWithContext(ctx, &autoscaling.DescribeInstanceRefreshesInput{
+		AutoScalingGroupName: aws.String(asgName),
+		InstanceRefreshIds:   []*string{aws.String(refreshID)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe instance refresh %s: %w", refreshID, err)
+	}
+
+	if len(result.InstanceRefreshes) != 1 {
+		return nil, fmt.Errorf("instance refresh %s %w for ASG %s", refreshID, ErrNotFound, asgName)
+	}
+
+	return result.InstanceRefreshes[0], nil
+}
+
+// WaitForInstanceRefresh waits up to timeout for an instance refresh to finish, printing its progress. It
+// returns an error if the refresh fails or is cancelled. whileWaiting, if not nil, is called after each
+// check of an unfinished refresh and an error from it stops the wait.
+func WaitForInstanceRefresh(ctx context.Context, awsSess *session.Session, asgName, refreshID string, timeout time.Duration,
+	whileWaiting func(ctx context.Context) error) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		refresh, err := GetInstanceRefresh(ctx, awsSess, asgName, refreshID)
+		if err != nil {
+			fmt.Println()
+			return err
+		}
+
+		status := aws.StringValue(refresh.Status)
+		fmt.Printf("\rInstance refresh %s: %v%% complete, %v instances to update ", status,
+			aws.Int64Value(refresh.PercentageComplete), aws.Int64Value(refresh.InstancesToUpdate))
+
+		switch status {
+		case autoscaling.InstanceRefreshStatusSuccessful:
+			fmt.Println()
+			return nil
+		case autoscaling.InstanceRefreshStatusFailed, autoscaling.InstanceRefreshStatusCancelled:
+			fmt.Println()
+			return fmt.Errorf("instance refresh %s %s: %s", refreshID, strings.ToLower(status), aws.StringValue(refresh.StatusReason))
+		}
+
+		if time.Now().After(deadline) {
+			fmt.Println()
+			return fmt.Errorf("%w after %s waiting for instance refresh %s, it is still %s", ErrTimeout, timeout, refreshID, status)
+		}
+
+		if whileWaiting != nil {
+			if err := whileWaiting(ctx); err != nil {
+				fmt.Println()
+				return err
+			}
+		}
+
+		if err := aws.SleepWithContext(ctx, instanceRefreshPollInterval); err != nil {
+			fmt.Println()
+			return err
+		}
+	}
+}
+
+// GetTerminatingLifecycleHooks returns the names of the ASG lifecycle hooks that hold instances in
+// Terminating:Wait, delaying their termination until each hook is completed or its heartbeat times out
+func GetTerminatingLifecycleHooks(ctx context.Context, awsSess *session.Session, asgName string) ([]string, error) {
+	svc := newAutoscalingClient(awsSess)
+
+	result, err := svc.DescribeLifecycleHooksWithContext(ctx, &autoscaling.DescribeLifecycleHooksInput{
+		AutoScalingGroupName: aws.String(asgName),
+	})
+	if err != nil {
+		return nil, withCategory(fmt.Errorf("unable to describe lifecycle hooks for ASG %s: %s", asgName, err), ErrorCategory(err))
+	}
+
+	hooks := []string{}
+	for _, hook := range result.LifecycleHooks {
+		if aws.StringValue(hook.LifecycleTransition) == "autoscaling:EC2_INSTANCE_TERMINATING" {
+			hooks = append(hooks, aws.StringValue(hook.LifecycleHookName))
+		}
+	}
+
+	return hooks, nil
+}
+
+// CompleteTerminatingLifecycleHooks drains the container instance of each ASG instance held in
+// Terminating:Wait and, once it has no running tasks, completes hooks with CONTINUE so the ASG can
+// terminate it. Instances still draining get a heartbeat for each hook so the hooks don't time out while
+// tasks move. It returns the instances whose hooks were completed.
+func CompleteTerminatingLifecycleHooks(ctx context.Context, awsSess *session.Session, cluster, asgName string,
+	hooks []string) ([]string, error) {
+	asg, err := DescribeAsg(ctx, awsSess, asgName)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := newAutoscalingClient(awsSess)
+	var completed []string
+	for _, instance := range asg.Instances {
+		if aws.StringValue(instance.LifecycleState) != autoscaling.LifecycleStateTerminatingWait {
+			continue
+		}
+		instanceID := aws.StringValue(instance.InstanceId)
+
+		drained := true
+		containerInstance, err := GetContainerInstanceForEc2Instance(ctx, awsSess, cluster, instanceID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return completed, err
+		}
+		// An instance that never registered with the cluster has nothing to drain
+		if err == nil {
+			if aws.StringValue(containerInstance.Status) != ecs.ContainerInstanceStatusDraining {
+				err := UpdateContainerInstanceState(ctx, awsSess, cluster, aws.StringValue(containerInstance.ContainerInstanceArn),
+					ecs.ContainerInstanceStatusDraining)
+				if err != nil {
+					return completed, err
+				}
+			}
+			drained = aws.Int64Value(containerInstance.RunningTasksCount) == 0
+		}
+
+		for _, hook := range hooks {
+			if drained {
+				_, err = svc.CompleteLifecycleActionWithContext(ctx, &autoscaling.CompleteLifecycleActionInput{
+					AutoScalingGroupName:  aws.String(asgName),
+					LifecycleHookName:     aws.String(hook),
+					InstanceId:            aws.String(instanceID),
+					LifecycleActionResult: aws.String("CONTINUE"),
+				})
+			} else {
+				_, err = svc.RecordLifecycleActionHeartbeatWithContext(ctx, &autoscaling.RecordLifecycleActionHeartbeatInput{
+					AutoScalingGroupName: aws.String(asgName),
+					LifecycleHookName:    aws.String(hook),
+					InstanceId:           aws.String(instanceID),
+				})
+			}
+			// Another hook handler, such as a drain Lambda, may have completed the action already
+			if err != nil && !isNoActiveLifecycleAction(err) {
+				return completed, withCategory(fmt.Errorf("unable to update lifecycle hook %s for %s: %s", hook, instanceID, err),
+					ErrorCategory(err))
+			}
+		}
+
+		if drained {
+			completed = append(completed, instanceID)
+		}
+	}
+
+	return completed, nil
+}
+
+// isNoActiveLifecycleAction returns true for the validation error Auto Scaling returns when an instance has
+// no lifecycle action waiting on the hook, usually because it was completed or timed out in the meantime
+func isNoActiveLifecycleAction(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == "ValidationError" && strings.Contains(aerr.Message(), "No active Lifecycle Action")
+}
+
+// GetCurrentAmiForAsg returns the AMI ID new instances in the ASG are launched with. The ASG may use
+// a launch configuration or a launch template (directly or through a mixed instances policy), and a
+// launch template version may be pinned or one of $Latest/$Default
+func GetCurrentAmiForAsg(ctx context.Context, awsSess *session.Session, asgName string) (string, error) {
+	asg, err := DescribeAsg(ctx, awsSess, asgName)
+	if err != nil {
+		return "", err
+	}
+
+	if asg.LaunchConfigurationName != nil {
+		return getAmiForLaunchConfiguration(ctx, awsSess, *asg.LaunchConfigurationName)
+	}
+
+	if template := getLaunchTemplateForAsg(asg); template != nil {
+		return getAmiForLaunchTemplate(ctx, awsSess, template)
+	}
+
+	return "", fmt.Errorf("ASG %s has neither a launch configuration nor a launch template", asgName)
+}
+
+func getAmiForLaunchConfiguration(ctx context.Context, awsSess *session.Session, name string) (string, error) {
+	svc := newAutoscalingClient(awsSess)
+	lc, err := svc.DescribeLaunchConfigurationsWithContext(ctx, &autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to describe launch configuration %s: %w", name, err)
+	}
+
+	if len(lc.LaunchConfigurations) != 1 || lc.LaunchConfigurations[0].ImageId == nil {
+		return "", fmt.Errorf("launch configuration %s %w", name, ErrNotFound)
+	}
+
+	return *lc.LaunchConfigurations[0].ImageId, nil
+}
+
+// getLaunchTemplateForAsg returns the launch template an ASG launches instances from, or nil if it uses
+// a launch configuration
+func getLaunchTemplateForAsg(asg *autoscaling.Group) *autoscaling.LaunchTemplateSpecification {
+	if asg.LaunchTemplate != nil {
+		return asg.LaunchTemplate
+	}
+
+	if asg.MixedInstancesPolicy != nil && asg.MixedInstancesPolicy.LaunchTemplate != nil {
+		return asg.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
+	}
+
+	return nil
+}
+
+// launchTemplateVersion returns the template version an ASG uses, which is $Default when it doesn't specify one
+func launchTemplateVersion(template *autoscaling.LaunchTemplateSpecification) string {
+	if version := aws.StringValue(template.Version); version != "" {
+		return version
+	}
+
+	return "$Default"
+}
+
+// launchTemplateName returns the template name for messages, or its ID when the ASG refers to it by ID
+func launchTemplateName(template *autoscaling.LaunchTemplateSpecification) string {
+	if name := aws.StringValue(template.LaunchTemplateName); name != "" {
+		return name
+	}
+
+	return aws.StringValue(template.LaunchTemplateId)
+}
+
+// getLaunchTemplateData returns the data for the version of the launch template an ASG uses. $Latest and
+// $Default are resolved by DescribeLaunchTemplateVersions itself.
+func getLaunchTemplateData(ctx context.Context, awsSess *session.Session,
+	template *autoscaling.LaunchTemplateSpecification) (*ec2.ResponseLaunchTemplateData, error) {
+
+	version := launchTemplateVersion(template)
+	name := launchTemplateName(template)
+
+	input := &ec2.DescribeLaunchTemplateVersionsInput{
+		Versions: []*string{aws.String(version)},
+	}
+	// The API rejects requests that set both the template ID and name
+	if template.LaunchTemplateId != nil {
+		input.LaunchTemplateId = template.LaunchTemplateId
+	} else {
+		input.LaunchTemplateName = template.LaunchTemplateName
+	}
+
+	svc := newEc2Client(awsSess)
+	versions, err := svc.DescribeLaunchTemplateVersionsWithContext(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe version %s of launch template %s: %w", version, name, err)
+	}
+
+	if len(versions.LaunchTemplateVersions) != 1 || versions.LaunchTemplateVersions[0].LaunchTemplateData == nil {
+		return nil, fmt.Errorf("version %s of launch template %s %w", version, name, ErrNotFound)
+	}
+
+	return versions.LaunchTemplateVersions[0].LaunchTemplateData, nil
+}
+
+func getAmiForLaunchTemplate(ctx context.Context, awsSess *session.Session,
+	template *autoscaling.LaunchTemplateSpecification) (string, error) {
+
+	data, err := getLaunchTemplateData(ctx, awsSess, template)
+	if err != nil {
+		return "", err
+	}
+
+	amiID := aws.StringValue(data.ImageId)
+	if amiID == "" {
+		return "", fmt.Errorf("version %s of launch template %s does not specify an AMI",
+			launchTemplateVersion(template), launchTemplateName(template))
+	}
+
+	return amiID, nil
+}
+
+// describeInstanceStatusBatchSize is the most instance IDs DescribeInstanceStatus accepts in one call
+const describeInstanceStatusBatchSize = 100
+
+// GetUnhealthyInstancesForAsg returns the instances in the ASG that it has marked Unhealthy, or whose EC2
+// instance or system status check is not ok. Checks that are still initializing or have insufficient data,
+// as on instances that just launched, count as failing too.
+func GetUnhealthyInstancesForAsg(ctx context.Context, awsSess *session.Session, asgName string) ([]*string, error) {
+	asg, err := DescribeAsg(ctx, awsSess, asgName)
+	if err != nil {
+		return nil, err
+	}
+
+	unhealthy := []*string{}
+	var toCheck []*string
+	for _, ins := range asg.Instances {
+		if aws.StringValue(ins.HealthStatus) == "Unhealthy" {
+			unhealthy = append(unhealthy, ins.InstanceId)
+		} else {
+			toCheck = append(toCheck, ins.InstanceId)
+		}
+	}
+
+	svc := newEc2Client(awsSess)
+	for _, chunk := range chunkStrings(toCheck, describeInstanceStatusBatchSize) {
+		input := &ec2.DescribeInstanceStatusInput{InstanceIds: chunk}
+		for {
+			result, err := svc.DescribeInstanceStatusWithContext(ctx, input)
+			if err != nil {
+				return nil, withCategory(fmt.Errorf("unable to describe instance status: %s", err), ErrorCategory(err))
+			}
+
+			for _, status := range result.InstanceStatuses {
+				if instanceStatusFailing(status.InstanceStatus) || instanceStatusFailing(status.SystemStatus) {
+					unhealthy = append(unhealthy, status.InstanceId)
+				}
+			}
+
+			if result.NextToken == nil {
+				break
+			}
+			input.NextToken = result.NextToken
+		}
+	}
+
+	return unhealthy, nil
+}
+
+// instanceStatusFailing returns true when an EC2 instance or system status check is anything other than ok
+func instanceStatusFailing(summary *ec2.InstanceStatusSummary) bool {
+	return summary != nil && aws.StringValue(summary.Status) != ec2.SummaryStatusOk
+}
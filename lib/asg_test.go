@@ -1,6 +1,20 @@
 package lib
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
 
 func TestHowManyServersNeededFor(t *testing.T) {
 	tests := []struct {
@@ -41,11 +55,879 @@ func TestHowManyServersNeededFor(t *testing.T) {
 		},
 	}
 
+	setMockInstanceTypes(t)
+
 	for _, i := range tests {
-		results := HowManyServersNeededForAsg(i.ServerType, i.MemNeeded, i.CPUNeeded)
+		results := HowManyServersNeededForAsg(context.Background(), nil, i.ServerType, i.MemNeeded, i.CPUNeeded)
 		if results != i.ExpectedNum {
 			t.Errorf("Did not get back expected number of %s servers needed for %v mem and %v cpu, expected %v, got %v",
 				i.ServerType, i.MemNeeded, i.CPUNeeded, i.ExpectedNum, results)
 		}
 	}
 }
+
+func TestHowManyServersNeededForTasks(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Tasks       []TaskResources
+		ServerType  string
+		ExpectedNum int64
+	}{
+		{
+			Name:        "no tasks",
+			ServerType:  "t2.small",
+			ExpectedNum: 0,
+		},
+		{
+			Name:        "tasks that pack evenly",
+			Tasks:       repeatTask(TaskResources{MemoryMb: 985, CPUUnits: 256}, 4),
+			ServerType:  "t2.small",
+			ExpectedNum: 2,
+		},
+		{
+			Name:        "tasks slightly larger than half a server",
+			Tasks:       repeatTask(TaskResources{MemoryMb: 1000, CPUUnits: 256}, 4),
+			ServerType:  "t2.small",
+			ExpectedNum: 4,
+		},
+		{
+			Name:        "tasks that would fit two to a server without the ECS agent overhead",
+			Tasks:       repeatTask(TaskResources{MemoryMb: 1020, CPUUnits: 256}, 4),
+			ServerType:  "t2.small",
+			ExpectedNum: 4,
+		},
+		{
+			Name: "large tasks placed first leave room for small ones",
+			Tasks: append(repeatTask(TaskResources{MemoryMb: 300, CPUUnits: 128}, 6),
+				repeatTask(TaskResources{MemoryMb: 1500, CPUUnits: 512}, 3)...),
+			ServerType:  "t2.small",
+			ExpectedNum: 4,
+		},
+		{
+			Name:        "cpu bound tasks on dual cpu servers",
+			Tasks:       repeatTask(TaskResources{MemoryMb: 128, CPUUnits: 600}, 4),
+			ServerType:  "t2.medium",
+			ExpectedNum: 2,
+		},
+		{
+			Name:        "cpu bound tasks on single cpu servers",
+			Tasks:       repeatTask(TaskResources{MemoryMb: 128, CPUUnits: 600}, 3),
+			ServerType:  "t2.small",
+			ExpectedNum: 3,
+		},
+	}
+
+	setMockInstanceTypes(t)
+
+	for _, i := range tests {
+		capacity, err := GetInstanceTypeCapacity(context.Background(), nil, i.ServerType, 0)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", i.Name, err)
+		}
+
+		results, err := HowManyServersNeededForTasks(capacity, i.Tasks)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", i.Name, err)
+			continue
+		}
+		if results != i.ExpectedNum {
+			t.Errorf("%s: did not get back expected number of %s servers, expected %v, got %v",
+				i.Name, i.ServerType, i.ExpectedNum, results)
+		}
+	}
+}
+
+func TestHowManyServersNeededForTasksVsNaiveDivision(t *testing.T) {
+	// Three tasks each using just over half a server's memory can't share a server,
+	// even though their total memory only needs two servers
+	tasks := repeatTask(TaskResources{MemoryMb: 1000, CPUUnits: 128}, 3)
+
+	var memory, cpu int64
+	for _, task := range tasks {
+		memory += task.MemoryMb
+		cpu += task.CPUUnits
+	}
+
+	setMockInstanceTypes(t)
+
+	naive := HowManyServersNeededForAsg(context.Background(), nil, "t2.small", memory, cpu)
+	if naive != 2 {
+		t.Errorf("Expected naive division to need 2 servers, got %v", naive)
+	}
+
+	capacity, err := GetInstanceTypeCapacity(context.Background(), nil, "t2.small", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	packed, err := HowManyServersNeededForTasks(capacity, tasks)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if packed != 3 {
+		t.Errorf("Expected bin packing to need 3 servers, got %v", packed)
+	}
+}
+
+func TestHowManyServersNeededForTasksErrors(t *testing.T) {
+	if _, err := HowManyServersNeededForTasks(TaskResources{}, nil); err == nil {
+		t.Error("Expected error for empty server capacity")
+	}
+
+	tasks := []TaskResources{{MemoryMb: 4000, CPUUnits: 256}}
+	if _, err := HowManyServersNeededForTasks(TaskResources{MemoryMb: 1970, CPUUnits: 1024}, tasks); err == nil {
+		t.Error("Expected error for task larger than a server")
+	}
+}
+
+func TestGetInstanceTypeCapacity(t *testing.T) {
+	setMockInstanceTypes(t)
+
+	capacity, err := GetInstanceTypeCapacity(context.Background(), nil, "t2.small", 200)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if capacity.MemoryMb != 2048-200 || capacity.CPUUnits != SingleCPUUnits {
+		t.Errorf("Did not get expected capacity, got: %+v", capacity)
+	}
+
+	// Without reserved memory only MbInGb of every 1024 MB is usable, as with the original instance type table
+	capacity, err = GetInstanceTypeCapacity(context.Background(), nil, "t2.small", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if capacity.MemoryMb != 2*MbInGb || capacity.CPUUnits != SingleCPUUnits {
+		t.Errorf("Did not get expected capacity without reserved memory, got: %+v", capacity)
+	}
+
+	for _, reserved := range []int64{-1, 512} {
+		if _, err := GetInstanceTypeCapacity(context.Background(), nil, "t2.nano", reserved); err == nil {
+			t.Errorf("Expected error for %v MB reserved on t2.nano", reserved)
+		}
+	}
+
+	if _, err := GetInstanceTypeCapacity(context.Background(), nil, "not.a.type", 0); err == nil {
+		t.Error("Expected error for unknown instance type")
+	}
+}
+
+func repeatTask(task TaskResources, count int) []TaskResources {
+	tasks := make([]TaskResources, count)
+	for i := range tasks {
+		tasks[i] = task
+	}
+
+	return tasks
+}
+
+func TestUpdateAsgCapacityValidation(t *testing.T) {
+	tests := []struct {
+		Min, Desired, Max int64
+	}{
+		{Min: 3, Desired: 2, Max: 5},
+		{Min: 1, Desired: 6, Max: 5},
+		{Min: -1, Desired: 0, Max: 1},
+	}
+
+	for _, i := range tests {
+		err := UpdateAsgCapacity(context.Background(), nil, "test", i.Min, i.Desired, i.Max)
+		if err == nil {
+			t.Errorf("Expected error for min = %v, desired = %v, max = %v", i.Min, i.Desired, i.Max)
+		}
+	}
+}
+
+func TestGetUnhealthyInstancesForAsg(t *testing.T) {
+	setMockAutoscalingClient(t, &mockAutoscalingClient{groups: map[string]*autoscaling.Group{
+		"test-asg": {
+			AutoScalingGroupName: aws.String("test-asg"),
+			Instances: []*autoscaling.Instance{
+				{InstanceId: aws.String("i-healthy"), HealthStatus: aws.String("Healthy")},
+				{InstanceId: aws.String("i-asg-unhealthy"), HealthStatus: aws.String("Unhealthy")},
+				{InstanceId: aws.String("i-instance-impaired"), HealthStatus: aws.String("Healthy")},
+				{InstanceId: aws.String("i-system-impaired"), HealthStatus: aws.String("Healthy")},
+				{InstanceId: aws.String("i-initializing"), HealthStatus: aws.String("Healthy")},
+			},
+		},
+	}})
+
+	status := func(id, instance, system string) *ec2.InstanceStatus {
+		return &ec2.InstanceStatus{
+			InstanceId:     aws.String(id),
+			InstanceStatus: &ec2.InstanceStatusSummary{Status: aws.String(instance)},
+			SystemStatus:   &ec2.InstanceStatusSummary{Status: aws.String(system)},
+		}
+	}
+	setMockEc2Client(t, &mockEc2Client{instanceStatuses: map[string]*ec2.InstanceStatus{
+		"i-healthy":           status("i-healthy", "ok", "ok"),
+		"i-asg-unhealthy":     status("i-asg-unhealthy", "ok", "ok"),
+		"i-instance-impaired": status("i-instance-impaired", "impaired", "ok"),
+		"i-system-impaired":   status("i-system-impaired", "ok", "impaired"),
+		"i-initializing":      status("i-initializing", "initializing", "insufficient-data"),
+	}})
+
+	unhealthy, err := GetUnhealthyInstancesForAsg(context.Background(), nil, "test-asg")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "i-asg-unhealthy,i-instance-impaired,i-system-impaired,i-initializing"
+	if got := strings.Join(aws.StringValueSlice(unhealthy), ","); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+
+	if _, err := GetUnhealthyInstancesForAsg(context.Background(), nil, "missing-asg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an ASG that does not exist, got %v", err)
+	}
+}
+
+func TestAttachInstancesToAsg(t *testing.T) {
+	mock := &mockAutoscalingClient{}
+	setMockAutoscalingClient(t, mock)
+
+	err := AttachInstancesToAsg(context.Background(), nil, "test", makeArns("instance", 25))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(mock.attachInstancesInputs) != 2 {
+		t.Errorf("Expected instances to be attached in 2 calls, got %v", len(mock.attachInstancesInputs))
+	}
+}
+
+func TestDetachAndReplaceAsgInstancesReattachesOnFailure(t *testing.T) {
+	ids := []*string{aws.String("i-1"), aws.String("i-2")}
+	var instances []*autoscaling.Instance
+	for _, id := range ids {
+		instances = append(instances, &autoscaling.Instance{
+			InstanceId:     id,
+			LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+		})
+	}
+
+	mock := &mockAutoscalingClient{groups: map[string]*autoscaling.Group{
+		"test": {AutoScalingGroupName: aws.String("test"), Instances: instances},
+	}}
+	setMockAutoscalingClient(t, mock)
+
+	// No replacements are launched, so waiting for them times out straight away
+	detached, err := DetachAndReplaceAsgInstances(context.Background(), nil, "test", "test", ids, 0)
+	if err == nil {
+		t.Fatal("Expected error waiting for replacement instances")
+	}
+
+	if len(detached) != 0 {
+		t.Errorf("Expected no instances to remain detached, got: %v", aws.StringValueSlice(detached))
+	}
+
+	if len(mock.attachInstancesInputs) != 1 || len(mock.attachInstancesInputs[0].InstanceIds) != 2 {
+		t.Errorf("Expected both detached instances to be re-attached, got: %v", mock.attachInstancesInputs)
+	}
+}
+
+func makeAsgInstance(id, asgName string) *ec2.Instance {
+	instance := &ec2.Instance{InstanceId: aws.String(id)}
+	if asgName != "" {
+		instance.Tags = []*ec2.Tag{{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String(asgName)}}
+	}
+
+	return instance
+}
+
+func TestGetAsgNamesForEcsCluster(t *testing.T) {
+	ctx := context.Background()
+
+	setMockCluster(t, []*ec2.Instance{makeAsgInstance("i-1", ""), makeAsgInstance("i-2", "web"), makeAsgInstance("i-3", "web")})
+	asgName, err := GetAsgNameForEcsCluster(ctx, nil, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if asgName != "web" {
+		t.Errorf("Expected ASG web, got %s", asgName)
+	}
+
+	setMockCluster(t, []*ec2.Instance{makeAsgInstance("i-1", "web-new"), makeAsgInstance("i-2", "web-old"), makeAsgInstance("i-3", "web-new")})
+	asgNames, err := GetAsgNamesForEcsCluster(ctx, nil, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Join(asgNames, ",") != "web-new,web-old" {
+		t.Errorf("Expected ASGs web-new and web-old, got %v", asgNames)
+	}
+	if _, err := GetAsgNameForEcsCluster(ctx, nil, "test"); err == nil || !strings.Contains(err.Error(), "web-new, web-old") {
+		t.Errorf("Expected error naming both ASGs, got %v", err)
+	}
+
+	setMockCluster(t, []*ec2.Instance{makeAsgInstance("i-1", "")})
+	if _, err := GetAsgNamesForEcsCluster(ctx, nil, "test"); err == nil {
+		t.Error("Expected error for a cluster with no instances in an ASG")
+	}
+}
+
+func TestGetAsgNamesForEcsClusterErrors(t *testing.T) {
+	ctx := context.Background()
+
+	// Right-sizing several clusters relies on one cluster failing not exiting for the rest
+	original := ExitWithError
+	ExitWithError = func(err error) {
+		t.Fatalf("Expected an error to be returned, not exited with: %s", err)
+	}
+	t.Cleanup(func() {
+		ExitWithError = original
+	})
+
+	mock := setMockCluster(t, []*ec2.Instance{makeAsgInstance("i-1", "web")})
+	mock.listContainerInstancesErrors = map[string]error{
+		"broken": awserr.New(ecs.ErrCodeClusterNotFoundException, "Cluster not found.", nil),
+	}
+
+	if _, err := GetAsgNamesForEcsCluster(ctx, nil, "broken"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a not found error for the broken cluster, got %v", err)
+	}
+	if _, err := PlanRightSize(ctx, nil, "broken", RightSizeOptions{Out: ioutil.Discard}); err == nil {
+		t.Error("Expected an error planning the broken cluster")
+	}
+
+	asgNames, err := GetAsgNamesForEcsCluster(ctx, nil, "test")
+	if err != nil || strings.Join(asgNames, ",") != "web" {
+		t.Errorf("Expected ASG web for the other cluster, got %v (%v)", asgNames, err)
+	}
+}
+
+func TestGetAsgNameForEcsClusterWithTerminatedInstances(t *testing.T) {
+	ctx := context.Background()
+
+	// i-1 was terminated after the cluster was listed, so EC2 only returns i-2
+	setMockCluster(t, []*ec2.Instance{makeAsgInstance("i-1", "web"), makeAsgInstance("i-2", "web")})
+	setMockEc2Client(t, &mockEc2Client{instances: []*ec2.Instance{makeAsgInstance("i-2", "web")}})
+	asgName, err := GetAsgNameForEcsCluster(ctx, nil, "test")
+	if err != nil || asgName != "web" {
+		t.Errorf("Expected ASG web from the remaining instance, got %q (%v)", asgName, err)
+	}
+
+	// No reservations at all once every instance is gone
+	setMockEc2Client(t, &mockEc2Client{})
+	_, err = GetAsgNameForEcsCluster(ctx, nil, "test")
+	if err == nil || !strings.Contains(err.Error(), "were found in EC2") {
+		t.Errorf("Expected error explaining no instances were found, got %v", err)
+	}
+}
+
+func TestSpareInstancesNeeded(t *testing.T) {
+	tests := []struct {
+		Name         string
+		AsgInstances int
+		Replacing    int
+		MinHealthy   int
+		BatchSize    int
+		ScaleUpFirst bool
+		Expected     int
+		WantErr      bool
+	}{
+		{Name: "default full replacement", AsgInstances: 3, Replacing: 3, BatchSize: 1, Expected: 0},
+		{Name: "default single instance cluster", AsgInstances: 1, Replacing: 1, BatchSize: 1, Expected: 0},
+		{Name: "min healthy met", AsgInstances: 4, Replacing: 2, MinHealthy: 2, BatchSize: 1, Expected: 0},
+		{Name: "min healthy not met", AsgInstances: 3, Replacing: 3, MinHealthy: 1, BatchSize: 1, WantErr: true},
+		{Name: "scale up by batch size", AsgInstances: 4, Replacing: 4, BatchSize: 2, ScaleUpFirst: true, Expected: 2},
+		{Name: "scale up for min healthy", AsgInstances: 4, Replacing: 4, MinHealthy: 3, BatchSize: 2, ScaleUpFirst: true, Expected: 3},
+	}
+
+	for _, i := range tests {
+		spare, err := SpareInstancesNeeded(i.AsgInstances, i.Replacing, i.MinHealthy, i.BatchSize, i.ScaleUpFirst)
+		if i.WantErr {
+			if !errors.Is(err, ErrInsufficientCapacity) {
+				t.Errorf("%s: expected ErrInsufficientCapacity, got %v", i.Name, err)
+			}
+			continue
+		}
+		if err != nil || spare != i.Expected {
+			t.Errorf("%s: expected %v spare instances, got %v (%v)", i.Name, i.Expected, spare, err)
+		}
+	}
+}
+
+func TestGetAsgServerCount(t *testing.T) {
+	setMockAutoscalingClient(t, &mockAutoscalingClient{groups: map[string]*autoscaling.Group{
+		"test": {
+			AutoScalingGroupName: aws.String("test"),
+			DesiredCapacity:      aws.Int64(3),
+			MinSize:              aws.Int64(2),
+			MaxSize:              aws.Int64(5),
+		},
+	}})
+
+	desired, min, max, err := GetAsgServerCount(context.Background(), nil, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if desired != 3 || min != 2 || max != 5 {
+		t.Errorf("Expected desired = 3, min = 2, max = 5, got desired = %v, min = %v, max = %v", desired, min, max)
+	}
+
+	if _, _, _, err := GetAsgServerCount(context.Background(), nil, "missing"); err == nil {
+		t.Error("Expected error for ASG that does not exist")
+	}
+}
+
+func TestDescribeAsgKeepsErrorCategory(t *testing.T) {
+	setMockAutoscalingClient(t, &mockAutoscalingClient{
+		describeAutoScalingGroupsError: awserr.New("AccessDenied", "not authorized to describe ASGs", nil),
+	})
+
+	_, err := DescribeAsg(context.Background(), nil, "web")
+	if ErrorCategory(err) != ErrPermissionDenied {
+		t.Errorf("Expected the AccessDenied to be categorized as permission denied, got %v (%v)", ErrorCategory(err), err)
+	}
+}
+
+func TestGetBlockingScalingActivity(t *testing.T) {
+	activity := func(status string, ended time.Duration) *autoscaling.Activity {
+		a := &autoscaling.Activity{
+			Description: aws.String("Launching a new EC2 instance"),
+			StatusCode:  aws.String(status),
+		}
+		if ended > 0 {
+			a.EndTime = aws.Time(time.Now().Add(-ended))
+		}
+		return a
+	}
+
+	tests := []struct {
+		Name             string
+		Activities       []*autoscaling.Activity
+		ExpectedBlocking bool
+	}{
+		{
+			Name:             "no activities",
+			ExpectedBlocking: false,
+		},
+		{
+			Name:             "activity in progress",
+			Activities:       []*autoscaling.Activity{activity(autoscaling.ScalingActivityStatusCodeInProgress, 0)},
+			ExpectedBlocking: true,
+		},
+		{
+			Name:             "activity finished within cooldown",
+			Activities:       []*autoscaling.Activity{activity(autoscaling.ScalingActivityStatusCodeSuccessful, time.Minute)},
+			ExpectedBlocking: true,
+		},
+		{
+			Name:             "activity finished before cooldown",
+			Activities:       []*autoscaling.Activity{activity(autoscaling.ScalingActivityStatusCodeSuccessful, time.Hour)},
+			ExpectedBlocking: false,
+		},
+	}
+
+	for _, i := range tests {
+		setMockAutoscalingClient(t, &mockAutoscalingClient{
+			groups: map[string]*autoscaling.Group{
+				"test": {AutoScalingGroupName: aws.String("test"), DefaultCooldown: aws.Int64(300)},
+			},
+			activities: i.Activities,
+		})
+
+		blocking, reason, err := GetBlockingScalingActivity(context.Background(), nil, "test")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", i.Name, err)
+			continue
+		}
+		if (blocking != nil) != i.ExpectedBlocking {
+			t.Errorf("%s: expected blocking to be %v, got activity %v (%s)", i.Name, i.ExpectedBlocking, blocking, reason)
+		}
+	}
+}
+
+func TestGetCurrentAmiForAsg(t *testing.T) {
+	templateVersion := func(number int64, isDefault bool, ami string) *ec2.LaunchTemplateVersion {
+		return &ec2.LaunchTemplateVersion{
+			LaunchTemplateName: aws.String("ecs-template"),
+			VersionNumber:      aws.Int64(number),
+			DefaultVersion:     aws.Bool(isDefault),
+			LaunchTemplateData: &ec2.ResponseLaunchTemplateData{ImageId: aws.String(ami)},
+		}
+	}
+	templateName := func(version string) *autoscaling.LaunchTemplateSpecification {
+		spec := &autoscaling.LaunchTemplateSpecification{LaunchTemplateName: aws.String("ecs-template")}
+		if version != "" {
+			spec.Version = aws.String(version)
+		}
+		return spec
+	}
+
+	tests := []struct {
+		Name        string
+		Group       *autoscaling.Group
+		ExpectedAmi string
+		ExpectError bool
+	}{
+		{
+			Name:        "launch configuration",
+			Group:       &autoscaling.Group{LaunchConfigurationName: aws.String("ecs-lc")},
+			ExpectedAmi: "ami-lc",
+		},
+		{
+			Name:        "launch template pinned version",
+			Group:       &autoscaling.Group{LaunchTemplate: templateName("1")},
+			ExpectedAmi: "ami-1",
+		},
+		{
+			Name:        "launch template $Latest",
+			Group:       &autoscaling.Group{LaunchTemplate: templateName("$Latest")},
+			ExpectedAmi: "ami-3",
+		},
+		{
+			Name:        "launch template $Default",
+			Group:       &autoscaling.Group{LaunchTemplate: templateName("$Default")},
+			ExpectedAmi: "ami-2",
+		},
+		{
+			Name:        "launch template without version uses $Default",
+			Group:       &autoscaling.Group{LaunchTemplate: templateName("")},
+			ExpectedAmi: "ami-2",
+		},
+		{
+			Name: "launch template by ID",
+			Group: &autoscaling.Group{LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId:   aws.String("lt-123"),
+				LaunchTemplateName: aws.String("ecs-template"),
+				Version:            aws.String("3"),
+			}},
+			ExpectedAmi: "ami-3",
+		},
+		{
+			Name: "mixed instances policy",
+			Group: &autoscaling.Group{MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{
+				LaunchTemplate: &autoscaling.LaunchTemplate{LaunchTemplateSpecification: templateName("$Latest")},
+			}},
+			ExpectedAmi: "ami-3",
+		},
+		{
+			Name:        "missing template version",
+			Group:       &autoscaling.Group{LaunchTemplate: templateName("7")},
+			ExpectError: true,
+		},
+		{
+			Name:        "neither configured",
+			Group:       &autoscaling.Group{},
+			ExpectError: true,
+		},
+	}
+
+	setMockEc2Client(t, &mockEc2Client{
+		launchTemplateVersions: map[string][]*ec2.LaunchTemplateVersion{
+			"lt-123": {templateVersion(1, false, "ami-1"), templateVersion(2, true, "ami-2"), templateVersion(3, false, "ami-3")},
+		},
+	})
+
+	for _, i := range tests {
+		i.Group.AutoScalingGroupName = aws.String("test")
+		setMockAutoscalingClient(t, &mockAutoscalingClient{
+			groups: map[string]*autoscaling.Group{"test": i.Group},
+			launchConfigurations: map[string]*autoscaling.LaunchConfiguration{
+				"ecs-lc": {LaunchConfigurationName: aws.String("ecs-lc"), ImageId: aws.String("ami-lc")},
+			},
+		})
+
+		ami, err := GetCurrentAmiForAsg(context.Background(), nil, "test")
+		if i.ExpectError {
+			if err == nil {
+				t.Errorf("%s: expected an error, got AMI %s", i.Name, ami)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", i.Name, err)
+			continue
+		}
+		if ami != i.ExpectedAmi {
+			t.Errorf("%s: expected AMI %s, got %s", i.Name, i.ExpectedAmi, ami)
+		}
+	}
+}
+
+func TestScaleUpAsg(t *testing.T) {
+	setMockCluster(t, []*ec2.Instance{
+		{InstanceId: aws.String("i-1")},
+		{InstanceId: aws.String("i-launched-1")},
+	})
+	mock := &mockAutoscalingClient{
+		groups: map[string]*autoscaling.Group{
+			"test": {
+				AutoScalingGroupName: aws.String("test"),
+				MinSize:              aws.Int64(1),
+				DesiredCapacity:      aws.Int64(1),
+				MaxSize:              aws.Int64(1),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-1"), LifecycleState: aws.String(autoscaling.LifecycleStateInService)},
+				},
+			},
+		},
+	}
+	setMockAutoscalingClient(t, mock)
+
+	err := ScaleUpAsg(context.Background(), nil, "test", "test", 1, true, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(mock.updateAutoScalingGroupInputs) != 1 {
+		t.Fatalf("Expected 1 UpdateAutoScalingGroup call, got %v", len(mock.updateAutoScalingGroupInputs))
+	}
+	input := mock.updateAutoScalingGroupInputs[0]
+	if *input.MinSize != 1 || *input.DesiredCapacity != 2 || *input.MaxSize != 2 {
+		t.Errorf("Expected min 1, desired 2 and max raised to 2, got %v/%v/%v", *input.MinSize, *input.DesiredCapacity, *input.MaxSize)
+	}
+}
+
+func TestScaleUpAsgExceedsMax(t *testing.T) {
+	mock := &mockAutoscalingClient{
+		groups: map[string]*autoscaling.Group{
+			"test": {
+				AutoScalingGroupName: aws.String("test"),
+				MinSize:              aws.Int64(1),
+				DesiredCapacity:      aws.Int64(2),
+				MaxSize:              aws.Int64(3),
+			},
+		},
+	}
+	setMockAutoscalingClient(t, mock)
+
+	err := ScaleUpAsg(context.Background(), nil, "test", "test", 2, false, time.Second)
+	if !errors.Is(err, ErrInsufficientCapacity) {
+		t.Fatalf("Expected an insufficient capacity error when desired would exceed max, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "max size 3") {
+		t.Errorf("Expected the error to explain the max size must be raised, got: %s", err)
+	}
+
+	if len(mock.updateAutoScalingGroupInputs) != 0 {
+		t.Errorf("Expected the ASG not to be updated, got %v UpdateAutoScalingGroup calls", len(mock.updateAutoScalingGroupInputs))
+	}
+}
+
+func TestStartInstanceRefresh(t *testing.T) {
+	mock := &mockAutoscalingClient{}
+	setMockAutoscalingClient(t, mock)
+
+	id, err := StartInstanceRefresh(context.Background(), nil, "test", 90, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if id != "refresh-1" {
+		t.Errorf("Expected refresh ID refresh-1, got %s", id)
+	}
+
+	prefs := mock.startInstanceRefreshInputs[0].Preferences
+	if *prefs.MinHealthyPercentage != 90 || *prefs.InstanceWarmup != 300 {
+		t.Errorf("Expected 90%% min healthy and 300s warmup, got %v%% and %vs", *prefs.MinHealthyPercentage, *prefs.InstanceWarmup)
+	}
+
+	if _, err := StartInstanceRefresh(context.Background(), nil, "test", 101, time.Minute); err == nil {
+		t.Error("Expected an error for a min healthy percentage over 100")
+	}
+}
+
+func TestWaitForInstanceRefresh(t *testing.T) {
+	original := instanceRefreshPollInterval
+	instanceRefreshPollInterval = time.Millisecond
+	t.Cleanup(func() {
+		instanceRefreshPollInterval = original
+	})
+
+	refresh := func(status string, percent int64) *autoscaling.InstanceRefresh {
+		return &autoscaling.InstanceRefresh{
+			InstanceRefreshId:  aws.String("refresh-1"),
+			Status:             aws.String(status),
+			StatusReason:       aws.String("reason for " + status),
+			PercentageComplete: aws.Int64(percent),
+		}
+	}
+
+	setMockAutoscalingClient(t, &mockAutoscalingClient{instanceRefreshes: []*autoscaling.InstanceRefresh{
+		refresh(autoscaling.InstanceRefreshStatusPending, 0),
+		refresh(autoscaling.InstanceRefreshStatusInProgress, 50),
+		refresh(autoscaling.InstanceRefreshStatusSuccessful, 100),
+	}})
+	checks := 0
+	whileWaiting := func(ctx context.Context) error {
+		checks++
+		return nil
+	}
+	if err := WaitForInstanceRefresh(context.Background(), nil, "test", "refresh-1", time.Second, whileWaiting); err != nil {
+		t.Errorf("Expected the refresh to succeed, got: %s", err)
+	}
+	if checks != 2 {
+		t.Errorf("Expected whileWaiting to be called for the 2 unfinished checks, got %v", checks)
+	}
+
+	setMockAutoscalingClient(t, &mockAutoscalingClient{instanceRefreshes: []*autoscaling.InstanceRefresh{
+		refresh(autoscaling.InstanceRefreshStatusInProgress, 50),
+		refresh(autoscaling.InstanceRefreshStatusFailed, 50),
+	}})
+	err := WaitForInstanceRefresh(context.Background(), nil, "test", "refresh-1", time.Second, nil)
+	if err == nil || !strings.Contains(err.Error(), "reason for Failed") {
+		t.Errorf("Expected an error with the failure reason, got: %v", err)
+	}
+}
+
+func TestGetTerminatingLifecycleHooks(t *testing.T) {
+	setMockAutoscalingClient(t, &mockAutoscalingClient{lifecycleHooks: []*autoscaling.LifecycleHook{
+		{LifecycleHookName: aws.String("drain"), LifecycleTransition: aws.String("autoscaling:EC2_INSTANCE_TERMINATING")},
+		{LifecycleHookName: aws.String("bootstrap"), LifecycleTransition: aws.String("autoscaling:EC2_INSTANCE_LAUNCHING")},
+	}})
+
+	hooks, err := GetTerminatingLifecycleHooks(context.Background(), nil, "test")
+	if err != nil || len(hooks) != 1 || hooks[0] != "drain" {
+		t.Errorf("Expected only the drain hook, got %v (%v)", hooks, err)
+	}
+}
+
+func TestCompleteTerminatingLifecycleHooks(t *testing.T) {
+	ecsMock := setMockCluster(t, []*ec2.Instance{{InstanceId: aws.String("i-1")}, {InstanceId: aws.String("i-2")}})
+	for _, instance := range ecsMock.containerInstances {
+		if *instance.Ec2InstanceId == "i-2" {
+			instance.RunningTasksCount = aws.Int64(3)
+		}
+	}
+
+	asgMock := &mockAutoscalingClient{groups: map[string]*autoscaling.Group{
+		"test-asg": {
+			AutoScalingGroupName: aws.String("test-asg"),
+			Instances: []*autoscaling.Instance{
+				{InstanceId: aws.String("i-1"), LifecycleState: aws.String(autoscaling.LifecycleStateTerminatingWait)},
+				{InstanceId: aws.String("i-2"), LifecycleState: aws.String(autoscaling.LifecycleStateTerminatingWait)},
+				{InstanceId: aws.String("i-3"), LifecycleState: aws.String(autoscaling.LifecycleStateInService)},
+			},
+		},
+	}}
+	setMockAutoscalingClient(t, asgMock)
+
+	completed, err := CompleteTerminatingLifecycleHooks(context.Background(), nil, "test", "test-asg", []string{"drain"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(completed) != 1 || completed[0] != "i-1" {
+		t.Errorf("Expected the hook of the drained i-1 to be completed, got %v", completed)
+	}
+	if len(asgMock.completeLifecycleActionInputs) != 1 || *asgMock.completeLifecycleActionInputs[0].LifecycleActionResult != "CONTINUE" {
+		t.Errorf("Expected one CONTINUE lifecycle action, got %v", asgMock.completeLifecycleActionInputs)
+	}
+	if len(asgMock.recordLifecycleActionHeartbeatInputs) != 1 || *asgMock.recordLifecycleActionHeartbeatInputs[0].InstanceId != "i-2" {
+		t.Errorf("Expected a heartbeat for i-2 which still has tasks, got %v", asgMock.recordLifecycleActionHeartbeatInputs)
+	}
+	for _, instance := range ecsMock.containerInstances {
+		if *instance.Status != "DRAINING" {
+			t.Errorf("Expected %s to be set DRAINING, got %s", *instance.Ec2InstanceId, *instance.Status)
+		}
+	}
+}
+
+func TestGetWeightedInstanceTypesForAsg(t *testing.T) {
+	instances := func(types ...string) []*autoscaling.Instance {
+		var list []*autoscaling.Instance
+		for n, instanceType := range types {
+			list = append(list, &autoscaling.Instance{InstanceId: aws.String(fmt.Sprintf("i-%v", n)), InstanceType: aws.String(instanceType)})
+		}
+		return list
+	}
+	template := &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-123"), Version: aws.String("1")}
+
+	tests := []struct {
+		Name          string
+		Group         *autoscaling.Group
+		Expected      []AsgInstanceType
+		ExpectedTypes string
+	}{
+		{
+			Name:     "launch configuration",
+			Group:    &autoscaling.Group{LaunchConfigurationName: aws.String("ecs-lc"), Instances: instances("t3.small", "t3.small")},
+			Expected: []AsgInstanceType{{InstanceType: "t3.small", WeightedCapacity: 1, Instances: 2}},
+		},
+		{
+			Name:     "launch template",
+			Group:    &autoscaling.Group{LaunchTemplate: template, Instances: instances("m5.large")},
+			Expected: []AsgInstanceType{{InstanceType: "m5.large", WeightedCapacity: 1, Instances: 1}},
+		},
+		{
+			Name: "mixed instances policy without overrides",
+			Group: &autoscaling.Group{MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{
+				LaunchTemplate: &autoscaling.LaunchTemplate{LaunchTemplateSpecification: template},
+			}},
+			Expected: []AsgInstanceType{{InstanceType: "m5.large", WeightedCapacity: 1}},
+		},
+		{
+			Name: "mixed instances policy with weighted overrides",
+			Group: &autoscaling.Group{
+				MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{
+					LaunchTemplate: &autoscaling.LaunchTemplate{
+						LaunchTemplateSpecification: template,
+						Overrides: []*autoscaling.LaunchTemplateOverrides{
+							{InstanceType: aws.String("m5.large"), WeightedCapacity: aws.String("2")},
+							{InstanceType: aws.String("m5.xlarge"), WeightedCapacity: aws.String("4")},
+							{InstanceType: aws.String("m4.large")},
+						},
+					},
+				},
+				Instances: instances("m5.xlarge", "m5.large", "m5.xlarge"),
+			},
+			Expected: []AsgInstanceType{
+				{InstanceType: "m5.large", WeightedCapacity: 2, Instances: 1},
+				{InstanceType: "m5.xlarge", WeightedCapacity: 4, Instances: 2},
+				{InstanceType: "m4.large", WeightedCapacity: 1},
+			},
+		},
+	}
+
+	setMockEc2Client(t, &mockEc2Client{
+		launchTemplateVersions: map[string][]*ec2.LaunchTemplateVersion{
+			"lt-123": {{
+				VersionNumber:      aws.Int64(1),
+				LaunchTemplateData: &ec2.ResponseLaunchTemplateData{InstanceType: aws.String("m5.large")},
+			}},
+		},
+	})
+
+	for _, i := range tests {
+		i.Group.AutoScalingGroupName = aws.String("test")
+		setMockAutoscalingClient(t, &mockAutoscalingClient{
+			groups: map[string]*autoscaling.Group{"test": i.Group},
+			launchConfigurations: map[string]*autoscaling.LaunchConfiguration{
+				"ecs-lc": {LaunchConfigurationName: aws.String("ecs-lc"), InstanceType: aws.String("t3.small")},
+			},
+		})
+
+		instanceTypes, err := GetWeightedInstanceTypesForAsg(context.Background(), nil, "test")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", i.Name, err)
+			continue
+		}
+		if fmt.Sprint(instanceTypes) != fmt.Sprint(i.Expected) {
+			t.Errorf("%s: expected %v, got %v", i.Name, i.Expected, instanceTypes)
+		}
+
+		var names []string
+		for _, expected := range i.Expected {
+			names = append(names, expected.InstanceType)
+		}
+		if got := GetInstanceTypesForAsg(context.Background(), nil, "test"); strings.Join(got, " ") != strings.Join(names, " ") {
+			t.Errorf("%s: expected instance types %v, got %v", i.Name, names, got)
+		}
+	}
+}
+
+func TestDominantInstanceType(t *testing.T) {
+	instanceTypes := []AsgInstanceType{
+		{InstanceType: "m5.large", WeightedCapacity: 2, Instances: 1},
+		{InstanceType: "m5.xlarge", WeightedCapacity: 4, Instances: 3},
+		{InstanceType: "m4.xlarge", WeightedCapacity: 4, Instances: 3},
+	}
+	if dominant := DominantInstanceType(instanceTypes); dominant.InstanceType != "m5.xlarge" {
+		t.Errorf("Expected the first type with the most instances, got %s", dominant.InstanceType)
+	}
+
+	// A new ASG without instances is sized for its highest priority type
+	instanceTypes = []AsgInstanceType{{InstanceType: "m5.large", WeightedCapacity: 2}, {InstanceType: "m5.xlarge", WeightedCapacity: 4}}
+	if dominant := DominantInstanceType(instanceTypes); dominant.InstanceType != "m5.large" {
+		t.Errorf("Expected the first type when there are no instances, got %s", dominant.InstanceType)
+	}
+}
@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	"github.com/aws/aws-sdk-go/service/servicediscovery/servicediscoveryiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// Service clients are created through these functions rather than calling the SDK
+// constructors directly so tests can replace them with mocks
+var newEcsClient = func(awsSess *session.Session) ecsiface.ECSAPI {
+	return ecs.New(awsSess)
+}
+
+var newEc2Client = func(awsSess *session.Session) ec2iface.EC2API {
+	return ec2.New(awsSess)
+}
+
+var newAutoscalingClient = func(awsSess *session.Session) autoscalingiface.AutoScalingAPI {
+	return autoscaling.New(awsSess)
+}
+
+var newSnsClient = func(awsSess *session.Session) snsiface.SNSAPI {
+	return sns.New(awsSess)
+}
+
+var newCloudwatchClient = func(awsSess *session.Session) cloudwatchiface.CloudWatchAPI {
+	return cloudwatch.New(awsSess)
+}
+
+var newElbv2Client = func(awsSess *session.Session) elbv2iface.ELBV2API {
+	return elbv2.New(awsSess)
+}
+
+var newServiceDiscoveryClient = func(awsSess *session.Session) servicediscoveryiface.ServiceDiscoveryAPI {
+	return servicediscovery.New(awsSess)
+}
@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"sort"
+	"strings"
+)
+
+// MetricsNamespace is the CloudWatch namespace custom awsops metrics are published to
+const MetricsNamespace = "awsops/ECS"
+
+func PutReplacementMetrics(ctx context.Context, awsSess *session.Session, cluster string, durationSeconds float64, instanceCount int64) error {
+	svc := newCloudwatchClient(awsSess)
+
+	dimensions := []*cloudwatch.Dimension{
+		{
+			Name:  aws.String("ClusterName"),
+			Value: aws.String(cluster),
+		},
+	}
+
+	metrics := []*cloudwatch.MetricDatum{
+		{
+			MetricName: aws.String("ReplacementDuration"),
+			Dimensions: dimensions,
+			Unit:       aws.String(cloudwatch.StandardUnitSeconds),
+			Value:      aws.Float64(durationSeconds),
+		},
+		{
+			MetricName: aws.String("InstancesReplaced"),
+			Dimensions: dimensions,
+			Unit:       aws.String(cloudwatch.StandardUnitCount),
+			Value:      aws.Float64(float64(instanceCount)),
+		},
+	}
+
+	if instanceCount > 0 {
+		metrics = append(metrics, &cloudwatch.MetricDatum{
+			MetricName: aws.String("ReplacementDurationPerInstance"),
+			Dimensions: dimensions,
+			Unit:       aws.String(cloudwatch.StandardUnitSeconds),
+			Value:      aws.Float64(durationSeconds / float64(instanceCount)),
+		})
+	}
+
+	_, err := svc.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(MetricsNamespace),
+		MetricData: metrics,
+	})
+
+	return err
+}
+
+// describeAlarmsMaxNames is the most alarm names the CloudWatch DescribeAlarms API accepts per call
+const describeAlarmsMaxNames = 100
+
+// AsgScalingAlarmsInAlarm returns true and the names of the alarms in ALARM state among those that trigger
+// the scaling policies of the ASG to scale out, including the high alarm target tracking policies create.
+// Alarms of policies that only scale in are left out, since their being in ALARM agrees with scaling down.
+func AsgScalingAlarmsInAlarm(ctx context.Context, awsSess *session.Session, asgName string) (bool, []string, error) {
+	asgSvc := newAutoscalingClient(awsSess)
+
+	var alarmNames []*string
+	err := asgSvc.DescribePoliciesPagesWithContext(ctx, &autoscaling.DescribePoliciesInput{
+		AutoScalingGroupName: aws.String(asgName),
+	}, func(page *autoscaling.DescribePoliciesOutput, lastPage bool) bool {
+		for _, policy := range page.ScalingPolicies {
+			for _, alarm := range policy.Alarms {
+				if !isScaleInAlarm(policy, aws.StringValue(alarm.AlarmName)) {
+					alarmNames = append(alarmNames, alarm.AlarmName)
+				}
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return false, nil, withCategory(fmt.Errorf("unable to describe scaling policies for ASG %s: %s", asgName, err), ErrorCategory(err))
+	}
+
+	svc := newCloudwatchClient(awsSess)
+	inAlarm := []string{}
+	for _, chunk := range chunkStrings(alarmNames, describeAlarmsMaxNames) {
+		err := svc.DescribeAlarmsPagesWithContext(ctx, &cloudwatch.DescribeAlarmsInput{
+			AlarmNames: chunk,
+			StateValue: aws.String(cloudwatch.StateValueAlarm),
+		}, func(page *cloudwatch.DescribeAlarmsOutput, lastPage bool) bool {
+			for _, alarm := range page.MetricAlarms {
+				inAlarm = append(inAlarm, aws.StringValue(alarm.AlarmName))
+			}
+			return !lastPage
+		})
+		if err != nil {
+			return false, nil, withCategory(fmt.Errorf("unable to describe alarms for ASG %s: %s", asgName, err), ErrorCategory(err))
+		}
+	}
+	sort.Strings(inAlarm)
+
+	return len(inAlarm) > 0, inAlarm, nil
+}
+
+// isScaleInAlarm returns true when the alarm makes the policy remove instances: the low alarm of a target
+// tracking policy, or any alarm of a simple or step scaling policy whose adjustments are all negative
+func isScaleInAlarm(policy *autoscaling.ScalingPolicy, alarmName string) bool {
+	switch aws.StringValue(policy.PolicyType) {
+	case "TargetTrackingScaling":
+		return strings.Contains(alarmName, "-AlarmLow-")
+	case "StepScaling":
+		if len(policy.StepAdjustments) == 0 {
+			return false
+		}
+		for _, step := range policy.StepAdjustments {
+			if aws.Int64Value(step.ScalingAdjustment) >= 0 {
+				return false
+			}
+		}
+		return true
+	default:
+		return aws.Int64Value(policy.ScalingAdjustment) < 0
+	}
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func TestAsgScalingAlarmsInAlarm(t *testing.T) {
+	setMockAutoscalingClient(t, &mockAutoscalingClient{scalingPolicies: []*autoscaling.ScalingPolicy{
+		{PolicyName: aws.String("scale-out"), PolicyType: aws.String("SimpleScaling"), ScalingAdjustment: aws.Int64(2),
+			Alarms: []*autoscaling.Alarm{{AlarmName: aws.String("cpu-high")}}},
+		{PolicyName: aws.String("scale-in"), PolicyType: aws.String("StepScaling"),
+			StepAdjustments: []*autoscaling.StepAdjustment{{ScalingAdjustment: aws.Int64(-1)}},
+			Alarms:          []*autoscaling.Alarm{{AlarmName: aws.String("cpu-low")}}},
+		{PolicyName: aws.String("memory"), PolicyType: aws.String("TargetTrackingScaling"), Alarms: []*autoscaling.Alarm{
+			{AlarmName: aws.String("TargetTracking-test-AlarmHigh-1234")},
+			{AlarmName: aws.String("TargetTracking-test-AlarmLow-5678")},
+		}},
+	}})
+	mock := &mockCloudwatchClient{alarmStates: map[string]string{
+		"cpu-high":                           "ALARM",
+		"cpu-low":                            "ALARM",
+		"TargetTracking-test-AlarmHigh-1234": "ALARM",
+		"TargetTracking-test-AlarmLow-5678":  "ALARM",
+		"unrelated":                          "ALARM",
+	}}
+	setMockCloudwatchClient(t, mock)
+
+	firing, alarms, err := AsgScalingAlarmsInAlarm(context.Background(), nil, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !firing || strings.Join(alarms, ",") != "TargetTracking-test-AlarmHigh-1234,cpu-high" {
+		t.Errorf("Expected only the scale out alarms, got %v %v", firing, alarms)
+	}
+
+	mock.alarmStates["cpu-high"] = "OK"
+	mock.alarmStates["TargetTracking-test-AlarmHigh-1234"] = "INSUFFICIENT_DATA"
+	firing, alarms, err = AsgScalingAlarmsInAlarm(context.Background(), nil, "test")
+	if err != nil || firing || len(alarms) != 0 {
+		t.Errorf("Expected no alarms firing, got %v %v (%v)", firing, alarms, err)
+	}
+}
@@ -0,0 +1,40 @@
+package lib
+
+// CredentialSource is where the AWS credentials for a command come from, numbered in the order of
+// precedence documented in the README
+type CredentialSource int
+
+const (
+	// CredentialsWebIdentityFlag is --web-identity-token-file with AWS_ROLE_ARN
+	CredentialsWebIdentityFlag CredentialSource = iota + 1
+	// CredentialsProfileFlag is the --profile profile in the shared credentials or config file
+	CredentialsProfileFlag
+	// CredentialsEnvironmentKeys is AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+	CredentialsEnvironmentKeys
+	// CredentialsWebIdentityEnv is AWS_WEB_IDENTITY_TOKEN_FILE with AWS_ROLE_ARN
+	CredentialsWebIdentityEnv
+	// CredentialsEnvironmentProfile is the AWS_PROFILE profile in the shared credentials or config file
+	CredentialsEnvironmentProfile
+	// CredentialsDefault leaves the SDK to use the default profile if there is one, otherwise the ECS task
+	// role or EC2 instance profile
+	CredentialsDefault
+)
+
+// GetCredentialSource returns which credentials are used given the --web-identity-token-file and --profile
+// flags and the environment, read with getenv so it can be called with os.Getenv
+func GetCredentialSource(webIdentityTokenFile, profile string, getenv func(string) string) CredentialSource {
+	switch {
+	case webIdentityTokenFile != "":
+		return CredentialsWebIdentityFlag
+	case profile != "":
+		return CredentialsProfileFlag
+	case getenv("AWS_ACCESS_KEY_ID") != "":
+		return CredentialsEnvironmentKeys
+	case getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "":
+		return CredentialsWebIdentityEnv
+	case getenv("AWS_PROFILE") != "":
+		return CredentialsEnvironmentProfile
+	}
+
+	return CredentialsDefault
+}
@@ -0,0 +1,58 @@
+package lib
+
+import "testing"
+
+func TestGetCredentialSource(t *testing.T) {
+	tests := []struct {
+		Name                 string
+		WebIdentityTokenFile string
+		Profile              string
+		Env                  map[string]string
+		Expected             CredentialSource
+	}{
+		{
+			Name:                 "token file flag takes precedence over everything",
+			WebIdentityTokenFile: "/tmp/token",
+			Profile:              "ops",
+			Env: map[string]string{"AWS_ACCESS_KEY_ID": "AKIA", "AWS_WEB_IDENTITY_TOKEN_FILE": "/var/token",
+				"AWS_PROFILE": "dev"},
+			Expected: CredentialsWebIdentityFlag,
+		},
+		{
+			Name:     "profile flag over environment keys",
+			Profile:  "ops",
+			Env:      map[string]string{"AWS_ACCESS_KEY_ID": "AKIA", "AWS_WEB_IDENTITY_TOKEN_FILE": "/var/token"},
+			Expected: CredentialsProfileFlag,
+		},
+		{
+			Name:     "environment keys over the web identity token file",
+			Env:      map[string]string{"AWS_ACCESS_KEY_ID": "AKIA", "AWS_WEB_IDENTITY_TOKEN_FILE": "/var/token"},
+			Expected: CredentialsEnvironmentKeys,
+		},
+		{
+			Name:     "web identity token file over AWS_PROFILE",
+			Env:      map[string]string{"AWS_WEB_IDENTITY_TOKEN_FILE": "/var/token", "AWS_PROFILE": "dev"},
+			Expected: CredentialsWebIdentityEnv,
+		},
+		{
+			Name:     "AWS_PROFILE when nothing else is set",
+			Env:      map[string]string{"AWS_PROFILE": "dev"},
+			Expected: CredentialsEnvironmentProfile,
+		},
+		{
+			Name:     "default profile or instance role",
+			Env:      map[string]string{},
+			Expected: CredentialsDefault,
+		},
+	}
+
+	for _, i := range tests {
+		getenv := func(key string) string {
+			return i.Env[key]
+		}
+
+		if source := GetCredentialSource(i.WebIdentityTokenFile, i.Profile, getenv); source != i.Expected {
+			t.Errorf("%s: expected credential source %v, got %v", i.Name, i.Expected, source)
+		}
+	}
+}
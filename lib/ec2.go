@@ -1,13 +1,39 @@
 package lib
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SingleCPUUnits is how many ECS CPU units one vCPU provides
+var SingleCPUUnits int64 = 1024
+
+var MbInGb int64 = 985 // only 985 out of 1024 is available for use due to ECS agent
+
+// InstanceType is the memory and CPU units of an entry in InstanceTypes.
+//
+// Deprecated: use TaskResources from GetInstanceTypeCapacity instead.
 type InstanceType struct {
 	MemoryMb int64
 	CPUUnits int64
 }
 
-var SingleCPUUnits int64 = 1024
-var MbInGb int64 = 985 // only 985 out of 1024 is available for use due to ECS agent
-
+// InstanceTypes is the capacity of the t2 instance types, no longer used for sizing.
+//
+// Deprecated: use GetInstanceTypeCapacity, which looks up any instance type.
 var InstanceTypes = map[string]InstanceType{
 	"t2.nano": {
 		CPUUnits: 1 * SingleCPUUnits,
@@ -38,3 +64,338 @@ var InstanceTypes = map[string]InstanceType{
 		MemoryMb: 32 * MbInGb,
 	},
 }
+
+// GetInstanceTypeCapacity returns the memory and CPU available to tasks on an instance type. When
+// reservedMemoryMb is zero only MbInGb of every 1024 MB reported by GetInstanceCapacity is counted,
+// otherwise reservedMemoryMb is subtracted from it instead.
+func GetInstanceTypeCapacity(ctx context.Context, awsSess *session.Session, serverType string, reservedMemoryMb int64) (TaskResources, error) {
+	instanceCapacity, err := GetInstanceCapacity(ctx, awsSess, serverType)
+	if err != nil {
+		return TaskResources{}, err
+	}
+
+	if reservedMemoryMb < 0 || reservedMemoryMb >= instanceCapacity.MemoryMB {
+		return TaskResources{}, fmt.Errorf("reserved memory %v MB is not valid for instance type %s with %v MB",
+			reservedMemoryMb, serverType, instanceCapacity.MemoryMB)
+	}
+
+	memoryMb := instanceCapacity.MemoryMB * MbInGb / 1024
+	if reservedMemoryMb > 0 {
+		memoryMb = instanceCapacity.MemoryMB - reservedMemoryMb
+	}
+
+	return TaskResources{
+		MemoryMb: memoryMb,
+		CPUUnits: instanceCapacity.CpuUnits,
+	}, nil
+}
+
+// InstanceCapacity is the CPU units and memory of an EC2 instance type
+type InstanceCapacity struct {
+	CpuUnits int64 `json:"cpuUnits" yaml:"cpuUnits"`
+	MemoryMB int64 `json:"memoryMb" yaml:"memoryMb"`
+}
+
+// instanceCapacityOverrides are loaded from an instance types file and take precedence over the EC2 API
+var instanceCapacityOverrides = map[string]InstanceCapacity{}
+
+// instanceCapacityCache holds capacities looked up from the EC2 API for the rest of the run, guarded by
+// instanceCapacityCacheMu as several clusters may be right sized at once
+var instanceCapacityCache = map[string]InstanceCapacity{}
+var instanceCapacityCacheMu sync.Mutex
+
+// LoadInstanceCapacityFile reads instance type capacities from a JSON or YAML file mapping instance
+// type names to cpuUnits and memoryMb, used by GetInstanceCapacity before calling the EC2 API
+func LoadInstanceCapacityFile(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	capacities := map[string]InstanceCapacity{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &capacities)
+	default:
+		err = json.Unmarshal(contents, &capacities)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to parse instance types file %s: %s", path, err)
+	}
+
+	for instanceType, capacity := range capacities {
+		if capacity.CpuUnits <= 0 || capacity.MemoryMB <= 0 {
+			return fmt.Errorf("instance type %s in %s must have cpuUnits and memoryMb greater than zero", instanceType, path)
+		}
+		instanceCapacityOverrides[instanceType] = capacity
+	}
+
+	return nil
+}
+
+// GetInstanceCapacity returns the capacity for an instance type from the loaded instance types file,
+// falling back to the EC2 API
+func GetInstanceCapacity(ctx context.Context, awsSess *session.Session, instanceType string) (InstanceCapacity, error) {
+	if capacity, ok := instanceCapacityOverrides[instanceType]; ok {
+		return capacity, nil
+	}
+
+	return GetInstanceCapacityFromAPI(ctx, awsSess, instanceType)
+}
+
+// GetInstanceCapacityFromAPI looks up an instance type with DescribeInstanceTypes and returns its CPU units
+// (1024 per vCPU) and memory. Results are cached so each type is only looked up once per run.
+func GetInstanceCapacityFromAPI(ctx context.Context, awsSess *session.Session, instanceType string) (InstanceCapacity, error) {
+	instanceCapacityCacheMu.Lock()
+	capacity, ok := instanceCapacityCache[instanceType]
+	instanceCapacityCacheMu.Unlock()
+	if ok {
+		return capacity, nil
+	}
+
+	svc := newEc2Client(awsSess)
+
+	result, err := svc.DescribeInstanceTypesWithContext(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []*string{aws.String(instanceType)},
+	})
+	if err != nil {
+		return InstanceCapacity{}, fmt.Errorf("unable to describe instance type %s: %w", instanceType, err)
+	}
+
+	if len(result.InstanceTypes) != 1 || result.InstanceTypes[0].VCpuInfo == nil || result.InstanceTypes[0].MemoryInfo == nil {
+		return InstanceCapacity{}, fmt.Errorf("instance type %s %w", instanceType, ErrNotFound)
+	}
+
+	info := result.InstanceTypes[0]
+	capacity = InstanceCapacity{
+		CpuUnits: aws.Int64Value(info.VCpuInfo.DefaultVCpus) * SingleCPUUnits,
+		MemoryMB: aws.Int64Value(info.MemoryInfo.SizeInMiB),
+	}
+	instanceCapacityCacheMu.Lock()
+	instanceCapacityCache[instanceType] = capacity
+	instanceCapacityCacheMu.Unlock()
+
+	return capacity, nil
+}
+
+// RemoveInstanceIDs returns the instance IDs in instances that are not in remove
+func RemoveInstanceIDs(instances []*string, remove []string) []*string {
+	removeIDs := map[string]bool{}
+	for _, id := range remove {
+		removeIDs[id] = true
+	}
+
+	remaining := []*string{}
+	for _, id := range instances {
+		if !removeIDs[*id] {
+			remaining = append(remaining, id)
+		}
+	}
+
+	return remaining
+}
+
+// SortInstancesByLaunchTime returns the instance IDs ordered by EC2 launch time, oldest first when ascending
+// is true. Instances EC2 doesn't return details for are left at the end in their original order.
+func SortInstancesByLaunchTime(ctx context.Context, awsSess *session.Session, instanceIDs []*string, ascending bool) ([]*string, error) {
+	instances, err := DescribeEc2Instances(ctx, awsSess, instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	launchTimes := map[string]time.Time{}
+	for _, instance := range instances {
+		launchTimes[aws.StringValue(instance.InstanceId)] = aws.TimeValue(instance.LaunchTime)
+	}
+
+	sorted := make([]*string, len(instanceIDs))
+	copy(sorted, instanceIDs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ti, iok := launchTimes[*sorted[i]]
+		tj, jok := launchTimes[*sorted[j]]
+		if !iok || !jok {
+			return iok
+		}
+		if ascending {
+			return ti.Before(tj)
+		}
+		return ti.After(tj)
+	})
+
+	return sorted, nil
+}
+
+// GetInstanceIDsByAz returns the given instance IDs keyed by the Availability Zone they are placed in, keeping
+// their order within each zone. Instances EC2 doesn't return details for are keyed by an empty string.
+func GetInstanceIDsByAz(ctx context.Context, awsSess *session.Session, instanceIDs []*string) (map[string][]*string, error) {
+	instances, err := DescribeEc2Instances(ctx, awsSess, instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := map[string]string{}
+	for _, instance := range instances {
+		if instance.Placement != nil {
+			zones[aws.StringValue(instance.InstanceId)] = aws.StringValue(instance.Placement.AvailabilityZone)
+		}
+	}
+
+	byAz := map[string][]*string{}
+	for _, id := range instanceIDs {
+		az := zones[*id]
+		byAz[az] = append(byAz[az], id)
+	}
+
+	return byAz, nil
+}
+
+// OrderInstancesByAz returns the instance IDs interleaved across Availability Zones, taking one instance from
+// each zone in turn so consecutive instances are in different zones where possible. The order within each
+// zone is kept, zones are visited in the order their first instance appears, and instances EC2 doesn't return details for are left at the end.
+func OrderInstancesByAz(ctx context.Context, awsSess *session.Session, instanceIDs []*string) ([]*string, error) {
+	byAz, err := GetInstanceIDsByAz(ctx, awsSess, instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start each round with the zone of the earliest instance so the given order decides which goes first
+	var zones []string
+	seen := map[string]bool{}
+	for _, id := range instanceIDs {
+		for az, ids := range byAz {
+			if az != "" && !seen[az] && ids[0] == id {
+				zones = append(zones, az)
+				seen[az] = true
+			}
+		}
+	}
+
+	ordered := []*string{}
+	for n := 0; len(ordered) < len(instanceIDs)-len(byAz[""]); n++ {
+		for _, az := range zones {
+			if n < len(byAz[az]) {
+				ordered = append(ordered, byAz[az][n])
+			}
+		}
+	}
+
+	return append(ordered, byAz[""]...), nil
+}
+
+// PartitionSpotInstances splits the instance IDs into spot and on-demand instances, keeping their order.
+// Instances EC2 doesn't return details for are counted as on-demand.
+func PartitionSpotInstances(ctx context.Context, awsSess *session.Session, instanceIDs []*string) (spot, onDemand []*string, err error) {
+	instances, err := DescribeEc2Instances(ctx, awsSess, instanceIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	isSpot := map[string]bool{}
+	for _, instance := range instances {
+		isSpot[aws.StringValue(instance.InstanceId)] = aws.StringValue(instance.InstanceLifecycle) == ec2.InstanceLifecycleTypeSpot
+	}
+
+	for _, id := range instanceIDs {
+		if isSpot[*id] {
+			spot = append(spot, id)
+		} else {
+			onDemand = append(onDemand, id)
+		}
+	}
+
+	return spot, onDemand, nil
+}
+
+// TagInstances adds the tags to the given EC2 instances, replacing the value of any tag with the same key.
+// Tags with the reserved aws: prefix, such as the ASG group name tag, are skipped so tags applied by the ASG
+// are never overwritten.
+func TagInstances(ctx context.Context, awsSess *session.Session, instanceIDs []*string, tags map[string]string) error {
+	var keys []string
+	for key := range tags {
+		if strings.HasPrefix(key, "aws:") {
+			fmt.Printf("Warning: not setting tag %s, tags with the aws: prefix are reserved for AWS\n", key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if len(instanceIDs) == 0 || len(keys) == 0 {
+		return nil
+	}
+
+	var ec2Tags []*ec2.Tag
+	for _, key := range keys {
+		ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+
+	svc := newEc2Client(awsSess)
+	_, err := svc.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: instanceIDs,
+		Tags:      ec2Tags,
+	})
+	if err != nil {
+		return withCategory(fmt.Errorf("unable to tag instances: %s", err), ErrorCategory(err))
+	}
+
+	return nil
+}
+
+// ErrInstanceAlreadyTerminating is returned by TerminateInstance when the instance is already gone or on its
+// way out, so callers can skip it rather than treat it as a failure
+var ErrInstanceAlreadyTerminating = errors.New("instance is already terminating")
+
+// TerminateInstance terminates an EC2 instance. If the instance is already terminated, shutting down or
+// stopping, or EC2 no longer returns a status for it, termination is not requested again and an error
+// wrapping ErrInstanceAlreadyTerminating is returned. TerminateInstances is retried with a backoff while EC2
+// reports IncorrectInstanceState, other errors are returned straight away.
+func TerminateInstance(ctx context.Context, awsSess *session.Session, instanceID string) error {
+	svc := newEc2Client(awsSess)
+
+	instanceStatus, err := svc.DescribeInstanceStatusWithContext(ctx, &ec2.DescribeInstanceStatusInput{
+		InstanceIds:         []*string{aws.String(instanceID)},
+		IncludeAllInstances: aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(instanceStatus.InstanceStatuses) == 0 {
+		return fmt.Errorf("no status found for instance %s: %w", instanceID, ErrInstanceAlreadyTerminating)
+	}
+
+	switch state := aws.StringValue(instanceStatus.InstanceStatuses[0].InstanceState.Name); state {
+	case ec2.InstanceStateNameTerminated, ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameStopping:
+		return fmt.Errorf("instance %s is %s: %w", instanceID, state, ErrInstanceAlreadyTerminating)
+	}
+
+	delay := terminateRetryDelay
+	for attempt := 1; ; attempt++ {
+		_, err = svc.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{
+			InstanceIds: []*string{aws.String(instanceID)},
+		})
+		if !isIncorrectInstanceState(err) || attempt == terminateRetryAttempts {
+			return err
+		}
+
+		// The instance may still be detaching from its ASG, give it a moment before trying again
+		if err := aws.SleepWithContext(ctx, delay); err != nil {
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// terminateRetryAttempts is how many times TerminateInstance tries TerminateInstances while EC2 reports
+// the instance is in the wrong state, as it does briefly after the instance is detached from an ASG
+const terminateRetryAttempts = 5
+
+// terminateRetryDelay is the wait before the first TerminateInstances retry, doubling after each attempt
+var terminateRetryDelay = 2 * time.Second
+
+// isIncorrectInstanceState returns true for the recoverable error EC2 returns when an instance can't be
+// terminated yet because it is still changing state
+func isIncorrectInstanceState(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "IncorrectInstanceState"
+}
@@ -0,0 +1,337 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestGetInstanceCapacityFromAPI(t *testing.T) {
+	mock := &mockEc2Client{
+		instanceTypes: map[string]*ec2.InstanceTypeInfo{
+			"m5.large": {
+				InstanceType: aws.String("m5.large"),
+				VCpuInfo:     &ec2.VCpuInfo{DefaultVCpus: aws.Int64(2)},
+				MemoryInfo:   &ec2.MemoryInfo{SizeInMiB: aws.Int64(8192)},
+			},
+		},
+	}
+	setMockEc2Client(t, mock)
+	resetInstanceCapacities(t)
+
+	for n := 0; n < 2; n++ {
+		capacity, err := GetInstanceCapacityFromAPI(context.Background(), nil, "m5.large")
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if capacity.CpuUnits != 2048 || capacity.MemoryMB != 8192 {
+			t.Errorf("Did not get expected capacity for m5.large, got: %+v", capacity)
+		}
+	}
+
+	if mock.describeInstanceTypesCalls != 1 {
+		t.Errorf("Expected capacity to be cached after 1 DescribeInstanceTypes call, got %v calls", mock.describeInstanceTypesCalls)
+	}
+
+	if _, err := GetInstanceCapacityFromAPI(context.Background(), nil, "not.a.type"); err == nil {
+		t.Error("Expected error for unknown instance type")
+	}
+}
+
+func TestLoadInstanceCapacityFile(t *testing.T) {
+	files := map[string]string{
+		"types.json": `{"t3.small": {"cpuUnits": 2048, "memoryMb": 1993}}`,
+		"types.yaml": "t3.small:\n  cpuUnits: 2048\n  memoryMb: 1993\n",
+	}
+
+	for name, contents := range files {
+		mock := &mockEc2Client{}
+		setMockEc2Client(t, mock)
+		resetInstanceCapacities(t)
+
+		path := writeTempFile(t, name, contents)
+		if err := LoadInstanceCapacityFile(path); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+
+		capacity, err := GetInstanceCapacity(context.Background(), nil, "t3.small")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		if capacity.CpuUnits != 2048 || capacity.MemoryMB != 1993 {
+			t.Errorf("%s: did not get expected capacity, got: %+v", name, capacity)
+		}
+		if mock.describeInstanceTypesCalls != 0 {
+			t.Errorf("%s: expected file capacity to be used without calling the EC2 API", name)
+		}
+	}
+
+	invalid := writeTempFile(t, "invalid.json", `{"t3.small": {"cpuUnits": 2048}}`)
+	if err := LoadInstanceCapacityFile(invalid); err == nil {
+		t.Error("Expected error for instance type without memory")
+	}
+}
+
+func TestTerminateInstance(t *testing.T) {
+	status := func(state string) *ec2.InstanceStatus {
+		return &ec2.InstanceStatus{InstanceState: &ec2.InstanceState{Name: aws.String(state)}}
+	}
+
+	tests := []struct {
+		Name               string
+		Statuses           map[string]*ec2.InstanceStatus
+		ExpectedTerminated bool
+	}{
+		{
+			Name:               "running instance",
+			Statuses:           map[string]*ec2.InstanceStatus{"i-1": status(ec2.InstanceStateNameRunning)},
+			ExpectedTerminated: true,
+		},
+		{
+			Name:               "shutting down instance",
+			Statuses:           map[string]*ec2.InstanceStatus{"i-1": status(ec2.InstanceStateNameShuttingDown)},
+			ExpectedTerminated: false,
+		},
+		{
+			Name:               "stopping instance",
+			Statuses:           map[string]*ec2.InstanceStatus{"i-1": status(ec2.InstanceStateNameStopping)},
+			ExpectedTerminated: false,
+		},
+		{
+			Name:               "already terminated instance",
+			Statuses:           map[string]*ec2.InstanceStatus{"i-1": status(ec2.InstanceStateNameTerminated)},
+			ExpectedTerminated: false,
+		},
+		{
+			// DescribeInstanceStatus returns no statuses for instances that are long gone
+			Name:               "no statuses returned",
+			Statuses:           map[string]*ec2.InstanceStatus{},
+			ExpectedTerminated: false,
+		},
+	}
+
+	for _, i := range tests {
+		mock := &mockEc2Client{instanceStatuses: i.Statuses}
+		setMockEc2Client(t, mock)
+
+		err := TerminateInstance(context.Background(), nil, "i-1")
+		if i.ExpectedTerminated && err != nil {
+			t.Errorf("%s: unexpected error: %s", i.Name, err)
+			continue
+		}
+		if !i.ExpectedTerminated && !errors.Is(err, ErrInstanceAlreadyTerminating) {
+			t.Errorf("%s: expected ErrInstanceAlreadyTerminating, got: %v", i.Name, err)
+		}
+		if i.ExpectedTerminated != (len(mock.terminatedInstanceIDs) == 1) {
+			t.Errorf("%s: expected TerminateInstances to be called only when terminating, got calls for %v",
+				i.Name, mock.terminatedInstanceIDs)
+		}
+	}
+}
+
+func TestTerminateInstanceRetriesIncorrectInstanceState(t *testing.T) {
+	original := terminateRetryDelay
+	terminateRetryDelay = time.Millisecond
+	t.Cleanup(func() {
+		terminateRetryDelay = original
+	})
+
+	running := map[string]*ec2.InstanceStatus{"i-1": {
+		InstanceId:    aws.String("i-1"),
+		InstanceState: &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+	}}
+	incorrectState := awserr.New("IncorrectInstanceState", "instance is detaching", nil)
+
+	mock := &mockEc2Client{instanceStatuses: running, terminateErrors: []error{incorrectState}}
+	setMockEc2Client(t, mock)
+	if err := TerminateInstance(context.Background(), nil, "i-1"); err != nil {
+		t.Errorf("Expected the retry to succeed, got: %s", err)
+	}
+	if mock.terminateCallCount != 2 || len(mock.terminatedInstanceIDs) != 1 {
+		t.Errorf("Expected a failed then successful TerminateInstances call, got %v calls terminating %v",
+			mock.terminateCallCount, mock.terminatedInstanceIDs)
+	}
+
+	permanent := awserr.New("UnauthorizedOperation", "not allowed", nil)
+	mock = &mockEc2Client{instanceStatuses: running, terminateErrors: []error{permanent}}
+	setMockEc2Client(t, mock)
+	if err := TerminateInstance(context.Background(), nil, "i-1"); err != permanent {
+		t.Errorf("Expected the permanent error without retrying, got: %v", err)
+	}
+	if mock.terminateCallCount != 1 {
+		t.Errorf("Expected a single TerminateInstances call for a permanent error, got %v", mock.terminateCallCount)
+	}
+
+	var errs []error
+	for i := 0; i < terminateRetryAttempts+1; i++ {
+		errs = append(errs, incorrectState)
+	}
+	mock = &mockEc2Client{instanceStatuses: running, terminateErrors: errs}
+	setMockEc2Client(t, mock)
+	if err := TerminateInstance(context.Background(), nil, "i-1"); !isIncorrectInstanceState(err) {
+		t.Errorf("Expected IncorrectInstanceState after running out of attempts, got: %v", err)
+	}
+	if mock.terminateCallCount != terminateRetryAttempts {
+		t.Errorf("Expected %v TerminateInstances calls, got %v", terminateRetryAttempts, mock.terminateCallCount)
+	}
+}
+
+func TestRemoveInstanceIDs(t *testing.T) {
+	instances := aws.StringSlice([]string{"i-1", "i-2", "i-3"})
+
+	remaining := RemoveInstanceIDs(instances, []string{"i-2", "i-9"})
+	if strings.Join(aws.StringValueSlice(remaining), " ") != "i-1 i-3" {
+		t.Errorf("Expected i-2 to be removed, got: %v", aws.StringValueSlice(remaining))
+	}
+
+	if len(RemoveInstanceIDs(instances, []string{"i-1", "i-2", "i-3"})) != 0 {
+		t.Error("Expected all instances to be removed")
+	}
+}
+
+func writeTempFile(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(contents), os.ModePerm); err != nil {
+		t.Fatalf("Unable to write %s: %s", path, err)
+	}
+
+	return path
+}
+
+func TestSortInstancesByLaunchTime(t *testing.T) {
+	now := time.Now()
+	setMockEc2Client(t, &mockEc2Client{
+		instances: []*ec2.Instance{
+			{InstanceId: aws.String("i-new"), LaunchTime: aws.Time(now)},
+			{InstanceId: aws.String("i-old"), LaunchTime: aws.Time(now.Add(-48 * time.Hour))},
+			{InstanceId: aws.String("i-mid"), LaunchTime: aws.Time(now.Add(-time.Hour))},
+		},
+	})
+	ids := aws.StringSlice([]string{"i-new", "i-unknown", "i-old", "i-mid"})
+
+	oldest, err := SortInstancesByLaunchTime(context.Background(), nil, ids, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Join(aws.StringValueSlice(oldest), " ") != "i-old i-mid i-new i-unknown" {
+		t.Errorf("Expected oldest first with unknown instances last, got %v", aws.StringValueSlice(oldest))
+	}
+
+	newest, err := SortInstancesByLaunchTime(context.Background(), nil, ids, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Join(aws.StringValueSlice(newest), " ") != "i-new i-mid i-old i-unknown" {
+		t.Errorf("Expected newest first with unknown instances last, got %v", aws.StringValueSlice(newest))
+	}
+
+	if *ids[0] != "i-new" {
+		t.Error("Expected the given instance IDs to be left unchanged")
+	}
+}
+
+func TestOrderInstancesByAz(t *testing.T) {
+	placement := func(az string) *ec2.Placement {
+		return &ec2.Placement{AvailabilityZone: aws.String(az)}
+	}
+	setMockEc2Client(t, &mockEc2Client{
+		instances: []*ec2.Instance{
+			{InstanceId: aws.String("i-b1"), Placement: placement("us-east-1b")},
+			{InstanceId: aws.String("i-a1"), Placement: placement("us-east-1a")},
+			{InstanceId: aws.String("i-a2"), Placement: placement("us-east-1a")},
+			{InstanceId: aws.String("i-a3"), Placement: placement("us-east-1a")},
+			{InstanceId: aws.String("i-b2"), Placement: placement("us-east-1b")},
+			{InstanceId: aws.String("i-c1"), Placement: placement("us-east-1c")},
+		},
+	})
+	ids := aws.StringSlice([]string{"i-a1", "i-a2", "i-unknown", "i-b1", "i-a3", "i-b2", "i-c1"})
+
+	byAz, err := GetInstanceIDsByAz(context.Background(), nil, ids)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Join(aws.StringValueSlice(byAz["us-east-1a"]), " ") != "i-a1 i-a2 i-a3" {
+		t.Errorf("Expected the us-east-1a instances in their given order, got %v", aws.StringValueSlice(byAz["us-east-1a"]))
+	}
+	if len(byAz[""]) != 1 || *byAz[""][0] != "i-unknown" {
+		t.Errorf("Expected the unknown instance without a zone, got %v", aws.StringValueSlice(byAz[""]))
+	}
+
+	ordered, err := OrderInstancesByAz(context.Background(), nil, ids)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Join(aws.StringValueSlice(ordered), " ") != "i-a1 i-b1 i-c1 i-a2 i-b2 i-a3 i-unknown" {
+		t.Errorf("Expected instances interleaved across zones with unknown instances last, got %v", aws.StringValueSlice(ordered))
+	}
+}
+
+func TestPartitionSpotInstances(t *testing.T) {
+	setMockEc2Client(t, &mockEc2Client{
+		instances: []*ec2.Instance{
+			{InstanceId: aws.String("i-1")},
+			{InstanceId: aws.String("i-2"), InstanceLifecycle: aws.String("spot")},
+			{InstanceId: aws.String("i-3"), InstanceLifecycle: aws.String("scheduled")},
+			{InstanceId: aws.String("i-4"), InstanceLifecycle: aws.String("spot")},
+		},
+	})
+
+	ids := aws.StringSlice([]string{"i-4", "i-1", "i-unknown", "i-2", "i-3"})
+	spot, onDemand, err := PartitionSpotInstances(context.Background(), nil, ids)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Join(aws.StringValueSlice(spot), " ") != "i-4 i-2" {
+		t.Errorf("Expected spot instances i-4 i-2 in their given order, got %v", aws.StringValueSlice(spot))
+	}
+	if strings.Join(aws.StringValueSlice(onDemand), " ") != "i-1 i-unknown i-3" {
+		t.Errorf("Expected the other instances in their given order, got %v", aws.StringValueSlice(onDemand))
+	}
+}
+
+func TestTagInstances(t *testing.T) {
+	mock := &mockEc2Client{}
+	setMockEc2Client(t, mock)
+
+	ids := aws.StringSlice([]string{"i-1", "i-2"})
+	tags := map[string]string{
+		"rotated-by":                "awsops",
+		"aws:autoscaling:groupName": "other",
+		"rotation-date":             "2026-10-14",
+	}
+	if err := TagInstances(context.Background(), nil, ids, tags); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(mock.createTagsInputs) != 1 {
+		t.Fatalf("Expected 1 CreateTags call, got %v", len(mock.createTagsInputs))
+	}
+	input := mock.createTagsInputs[0]
+	if strings.Join(aws.StringValueSlice(input.Resources), " ") != "i-1 i-2" {
+		t.Errorf("Expected both instances to be tagged, got %v", aws.StringValueSlice(input.Resources))
+	}
+
+	var keys []string
+	for _, tag := range input.Tags {
+		keys = append(keys, *tag.Key)
+	}
+	if strings.Join(keys, " ") != "rotated-by rotation-date" {
+		t.Errorf("Expected the ASG group name tag to be skipped, got tags %v", keys)
+	}
+
+	if err := TagInstances(context.Background(), nil, ids, map[string]string{"aws:autoscaling:groupName": "other"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(mock.createTagsInputs) != 1 {
+		t.Error("Expected no CreateTags call when every tag is reserved")
+	}
+}
@@ -1,38 +1,141 @@
 package lib
 
 import (
+	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ecs"
+	"io"
+	"math"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-func GetInstanceListForEcsCluster(awsSess *session.Session, clusterName string) []*ecs.ContainerInstance {
-	svc := ecs.New(awsSess)
-	listResult, err := svc.ListContainerInstances(&ecs.ListContainerInstancesInput{
-		Cluster: aws.String(clusterName),
-	})
+// describeContainerInstancesMaxInstances is the most container instances the ECS
+// DescribeContainerInstances API accepts per call
+const describeContainerInstancesMaxInstances = 100
+
+// describeInstancesBatchSize is how many instance IDs are passed to each EC2 DescribeInstances
+// call, comfortably under the API limit
+const describeInstancesBatchSize = 200
+
+// describeInstancesWorkers is the most DescribeInstances calls made concurrently
+const describeInstancesWorkers = 5
+
+// NormalizeClusterIdentifier returns the cluster name from a cluster ARN such as
+// arn:aws:ecs:us-east-1:123456789012:cluster/foo, or s unchanged if it is not an ARN
+func NormalizeClusterIdentifier(s string) string {
+	parts := strings.SplitN(s, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "ecs" {
+		return s
+	}
+
+	if !strings.HasPrefix(parts[5], "cluster/") {
+		return s
+	}
+
+	return strings.TrimPrefix(parts[5], "cluster/")
+}
+
+// RegionFromArn returns the region of an ARN, or an error if s is not an ARN or has no region,
+// as with global resources such as IAM roles
+func RegionFromArn(s string) (string, error) {
+	parsed, err := arn.Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	if parsed.Region == "" {
+		return "", fmt.Errorf("ARN %s does not include a region", s)
+	}
+
+	return parsed.Region, nil
+}
+
+// handleEcsError adds a description of common ECS error codes to err, leaving the
+// decision of whether to exit up to the caller. The result keeps the category of the error code.
+func handleEcsError(err error) error {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+
+	var description string
+	switch aerr.Code() {
+	case ecs.ErrCodeServerException:
+		description = "ECS server error"
+	case ecs.ErrCodeClientException:
+		description = "ECS client error, check permissions and parameters"
+	case ecs.ErrCodeInvalidParameterException:
+		description = "invalid parameter"
+	case ecs.ErrCodeClusterNotFoundException:
+		description = "cluster not found"
+	case ecs.ErrCodeServiceNotFoundException:
+		description = "service not found"
+	case ecs.ErrCodeServiceNotActiveException:
+		description = "service not active"
+	case ecs.ErrCodeAccessDeniedException:
+		description = "access denied"
+	default:
+		return withCategory(fmt.Errorf("%s: %s", aerr.Code(), aerr.Message()), awsErrorCategories[aerr.Code()])
+	}
+
+	return withCategory(fmt.Errorf("%s (%s): %s", description, aerr.Code(), aerr.Message()), awsErrorCategories[aerr.Code()])
+}
+
+// GetInstanceListForEcsCluster describes every container instance in the cluster, exiting on error
+func GetInstanceListForEcsCluster(ctx context.Context, awsSess *session.Session, clusterName string) []*ecs.ContainerInstance {
+	instances, err := describeInstancesForEcsCluster(ctx, awsSess, clusterName)
 	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
+		ExitWithError(err)
 	}
 
-	descResult, err := svc.DescribeContainerInstances(&ecs.DescribeContainerInstancesInput{
-		Cluster:            aws.String(clusterName),
-		ContainerInstances: listResult.ContainerInstanceArns,
+	return instances
+}
+
+// describeInstancesForEcsCluster describes every container instance in the cluster
+func describeInstancesForEcsCluster(ctx context.Context, awsSess *session.Session, clusterName string) ([]*ecs.ContainerInstance, error) {
+	svc := newEcsClient(awsSess)
+
+	var instanceArns []*string
+	err := svc.ListContainerInstancesPagesWithContext(ctx, &ecs.ListContainerInstancesInput{
+		Cluster: aws.String(clusterName),
+	}, func(page *ecs.ListContainerInstancesOutput, lastPage bool) bool {
+		instanceArns = append(instanceArns, page.ContainerInstanceArns...)
+		return !lastPage
 	})
 	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
+		return nil, handleEcsError(err)
+	}
+
+	instances := []*ecs.ContainerInstance{}
+	for _, chunk := range chunkStrings(instanceArns, describeContainerInstancesMaxInstances) {
+		descResult, err := svc.DescribeContainerInstancesWithContext(ctx, &ecs.DescribeContainerInstancesInput{
+			Cluster:            aws.String(clusterName),
+			ContainerInstances: chunk,
+		})
+		if err != nil {
+			return nil, handleEcsError(err)
+		}
+
+		instances = append(instances, descResult.ContainerInstances...)
 	}
 
-	return descResult.ContainerInstances
+	return instances, nil
 }
 
-func GetInstanceIDsForEcsCluster(awsSess *session.Session, clusterName string) []*string {
-	instances := GetInstanceListForEcsCluster(awsSess, clusterName)
+func GetInstanceIDsForEcsCluster(ctx context.Context, awsSess *session.Session, clusterName string) []*string {
+	instances := GetInstanceListForEcsCluster(ctx, awsSess, clusterName)
 	instanceIDs := []*string{}
 
 	for _, instance := range instances {
@@ -42,22 +145,27 @@ func GetInstanceIDsForEcsCluster(awsSess *session.Session, clusterName string) [
 	return instanceIDs
 }
 
-func GetInstanceIPsForEcsCluster(awsSess *session.Session, clusterName string) []string {
-	instanceIDs := GetInstanceIDsForEcsCluster(awsSess, clusterName)
-
-	svc := ec2.New(awsSess)
-	instanceDetails, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{
-		InstanceIds: instanceIDs,
-	})
+// GetInstanceListForAsg returns the IDs of the instances in the ASG, or an error wrapping ErrNotFound when
+// the ASG does not exist or an error when DescribeAutoScalingGroups returns more than one group
+func GetInstanceListForAsg(ctx context.Context, awsSess *session.Session, asgName string) ([]*string, error) {
+	asg, err := DescribeAsg(ctx, awsSess, asgName)
 	if err != nil {
-		fmt.Println("Unable to get instance details", err)
-		os.Exit(1)
+		return nil, err
+	}
+
+	instanceIds := []*string{}
+	for _, ins := range asg.Instances {
+		instanceIds = append(instanceIds, ins.InstanceId)
 	}
 
+	return instanceIds, nil
+}
+
+func GetInstanceIPsForEcsCluster(ctx context.Context, awsSess *session.Session, clusterName string) []string {
 	var instanceIPs []string
 
-	for _, r := range instanceDetails.Reservations {
-		for _, i := range r.Instances {
+	for _, i := range getEc2InstancesForEcsCluster(ctx, awsSess, clusterName) {
+		if i.PrivateIpAddress != nil {
 			instanceIPs = append(instanceIPs, *i.PrivateIpAddress)
 		}
 	}
@@ -65,8 +173,227 @@ func GetInstanceIPsForEcsCluster(awsSess *session.Session, clusterName string) [
 	return instanceIPs
 }
 
-func GetPendingEcsTasksCount(awsSess *session.Session, cluster string) int64 {
-	ecsServices := ListServicesForEcsCluster(awsSess, cluster)
+// GetInstancePublicIPsForEcsCluster returns the public IPs of instances in the cluster,
+// skipping any instances without a public IP
+func GetInstancePublicIPsForEcsCluster(ctx context.Context, awsSess *session.Session, clusterName string) []string {
+	var instanceIPs []string
+
+	for _, i := range getEc2InstancesForEcsCluster(ctx, awsSess, clusterName) {
+		if i.PublicIpAddress != nil {
+			instanceIPs = append(instanceIPs, *i.PublicIpAddress)
+		}
+	}
+
+	return instanceIPs
+}
+
+func getEc2InstancesForEcsCluster(ctx context.Context, awsSess *session.Session, clusterName string) []*ec2.Instance {
+	instances, err := DescribeEc2Instances(ctx, awsSess, GetInstanceIDsForEcsCluster(ctx, awsSess, clusterName))
+	if err != nil {
+		ExitWithError(fmt.Errorf("Unable to get instance details %w", err))
+	}
+
+	return instances
+}
+
+// DescribeEc2Instances returns the EC2 details for the given instance IDs in the same order
+// as instanceIDs, skipping any IDs EC2 doesn't return. Large sets of IDs are looked up in
+// batches of describeInstancesBatchSize with up to describeInstancesWorkers calls in flight.
+func DescribeEc2Instances(ctx context.Context, awsSess *session.Session, instanceIDs []*string) ([]*ec2.Instance, error) {
+	// DescribeInstances with no IDs returns every instance in the account
+	if len(instanceIDs) == 0 {
+		return []*ec2.Instance{}, nil
+	}
+
+	var batches [][]*string
+	for start := 0; start < len(instanceIDs); start += describeInstancesBatchSize {
+		end := start + describeInstancesBatchSize
+		if end > len(instanceIDs) {
+			end = len(instanceIDs)
+		}
+		batches = append(batches, instanceIDs[start:end])
+	}
+
+	svc := newEc2Client(awsSess)
+	results := make([][]*ec2.Instance, len(batches))
+	errs := make([]error, len(batches))
+
+	// Each worker writes only to its own batch's slots, so no locking is needed
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, describeInstancesWorkers)
+	for n, batch := range batches {
+		wg.Add(1)
+		go func(n int, batch []*string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			instanceDetails, err := svc.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
+				InstanceIds: batch,
+			})
+			if err != nil {
+				errs[n] = err
+				return
+			}
+
+			for _, r := range instanceDetails.Reservations {
+				results[n] = append(results[n], r.Instances...)
+			}
+		}(n, batch)
+	}
+	wg.Wait()
+
+	byID := map[string]*ec2.Instance{}
+	for n := range batches {
+		if errs[n] != nil {
+			return nil, errs[n]
+		}
+		for _, instance := range results[n] {
+			byID[aws.StringValue(instance.InstanceId)] = instance
+		}
+	}
+
+	instances := make([]*ec2.Instance, 0, len(byID))
+	for _, id := range instanceIDs {
+		if instance, ok := byID[aws.StringValue(id)]; ok {
+			instances = append(instances, instance)
+			delete(byID, aws.StringValue(id))
+		}
+	}
+
+	return instances, nil
+}
+
+// FilterInstancesByTag returns the instances that have the EC2 tag key=value when include is true,
+// or the instances that don't have it when include is false
+func FilterInstancesByTag(ctx context.Context, awsSess *session.Session, instanceIDs []*string, key, value string, include bool) ([]*string, error) {
+	instances, err := DescribeEc2Instances(ctx, awsSess, instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := map[string]bool{}
+	for _, instance := range instances {
+		for _, tag := range instance.Tags {
+			if aws.StringValue(tag.Key) == key && aws.StringValue(tag.Value) == value {
+				matches[aws.StringValue(instance.InstanceId)] = true
+			}
+		}
+	}
+
+	filtered := []*string{}
+	for _, id := range instanceIDs {
+		if matches[*id] == include {
+			filtered = append(filtered, id)
+		}
+	}
+
+	return filtered, nil
+}
+
+// InstanceUtilization is a snapshot of the tasks and resources on a container instance
+type InstanceUtilization struct {
+	InstanceID       string `json:"instanceId"`
+	PrivateIP        string `json:"privateIp"`
+	AmiID            string `json:"amiId"`
+	AvailabilityZone string `json:"availabilityZone"`
+	RunningTasks     int64  `json:"runningTasks"`
+	PendingTasks     int64  `json:"pendingTasks"`
+	RegisteredCPU    int64  `json:"registeredCpu"`
+	RemainingCPU     int64  `json:"remainingCpu"`
+	RegisteredMemory int64  `json:"registeredMemory"`
+	RemainingMemory  int64  `json:"remainingMemory"`
+}
+
+// GetInstanceUtilizationForEcsCluster returns task counts and registered vs remaining resources for each
+// container instance in the cluster, sorted by remaining memory so the most loaded instances are first
+func GetInstanceUtilizationForEcsCluster(ctx context.Context, awsSess *session.Session, cluster string) ([]InstanceUtilization, error) {
+	containerInstances := GetInstanceListForEcsCluster(ctx, awsSess, cluster)
+
+	var instanceIDs []*string
+	for _, instance := range containerInstances {
+		instanceIDs = append(instanceIDs, instance.Ec2InstanceId)
+	}
+
+	ec2Instances, err := DescribeEc2Instances(ctx, awsSess, instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ec2ByID := map[string]*ec2.Instance{}
+	for _, instance := range ec2Instances {
+		ec2ByID[aws.StringValue(instance.InstanceId)] = instance
+	}
+
+	utilization := []InstanceUtilization{}
+	for _, instance := range containerInstances {
+		u := InstanceUtilization{
+			InstanceID:       aws.StringValue(instance.Ec2InstanceId),
+			RunningTasks:     aws.Int64Value(instance.RunningTasksCount),
+			PendingTasks:     aws.Int64Value(instance.PendingTasksCount),
+			RegisteredCPU:    getContainerInstanceResource(instance.RegisteredResources, "CPU"),
+			RemainingCPU:     getContainerInstanceResource(instance.RemainingResources, "CPU"),
+			RegisteredMemory: getContainerInstanceResource(instance.RegisteredResources, "MEMORY"),
+			RemainingMemory:  getContainerInstanceResource(instance.RemainingResources, "MEMORY"),
+		}
+		if ec2Instance, ok := ec2ByID[u.InstanceID]; ok {
+			u.PrivateIP = aws.StringValue(ec2Instance.PrivateIpAddress)
+			u.AmiID = aws.StringValue(ec2Instance.ImageId)
+			if ec2Instance.Placement != nil {
+				u.AvailabilityZone = aws.StringValue(ec2Instance.Placement.AvailabilityZone)
+			}
+		}
+		utilization = append(utilization, u)
+	}
+
+	sort.SliceStable(utilization, func(i, j int) bool {
+		return utilization[i].RemainingMemory < utilization[j].RemainingMemory
+	})
+
+	return utilization, nil
+}
+
+// ClusterUtilization is the registered and remaining resources summed across all container instances in a cluster
+type ClusterUtilization struct {
+	Instances        int64   `json:"instances"`
+	RegisteredCPU    int64   `json:"registeredCpu"`
+	RemainingCPU     int64   `json:"remainingCpu"`
+	CPUPercent       float64 `json:"cpuPercent"`
+	RegisteredMemory int64   `json:"registeredMemory"`
+	RemainingMemory  int64   `json:"remainingMemory"`
+	MemoryPercent    float64 `json:"memoryPercent"`
+}
+
+// GetClusterResourceUtilization returns total registered and remaining CPU and memory for the container
+// instances in the cluster and the percentage of each that is reserved by tasks. A cluster without
+// instances has zero for every value.
+func GetClusterResourceUtilization(ctx context.Context, awsSess *session.Session, cluster string) ClusterUtilization {
+	var u ClusterUtilization
+	for _, instance := range GetInstanceListForEcsCluster(ctx, awsSess, cluster) {
+		u.Instances++
+		u.RegisteredCPU += getContainerInstanceResource(instance.RegisteredResources, "CPU")
+		u.RemainingCPU += getContainerInstanceResource(instance.RemainingResources, "CPU")
+		u.RegisteredMemory += getContainerInstanceResource(instance.RegisteredResources, "MEMORY")
+		u.RemainingMemory += getContainerInstanceResource(instance.RemainingResources, "MEMORY")
+	}
+
+	u.CPUPercent = percentUsed(u.RegisteredCPU, u.RemainingCPU)
+	u.MemoryPercent = percentUsed(u.RegisteredMemory, u.RemainingMemory)
+
+	return u
+}
+
+// percentUsed returns the percentage of registered that is not remaining, or zero when nothing is registered
+func percentUsed(registered, remaining int64) float64 {
+	if registered <= 0 {
+		return 0
+	}
+
+	return float64(registered-remaining) / float64(registered) * 100
+}
+
+func GetPendingEcsTasksCount(ctx context.Context, awsSess *session.Session, cluster string) int64 {
+	ecsServices := ListServicesForEcsCluster(ctx, awsSess, cluster)
 
 	var pendingTasks int64
 
@@ -77,54 +404,260 @@ func GetPendingEcsTasksCount(awsSess *session.Session, cluster string) int64 {
 	return pendingTasks
 }
 
-func ListServicesForEcsCluster(awsSess *session.Session, cluster string) []*ecs.Service {
-	svc := ecs.New(awsSess)
+func GetServicesWithPendingTasks(ctx context.Context, awsSess *session.Session, cluster string) []*ecs.Service {
+	var pendingServices []*ecs.Service
+
+	for _, service := range ListServicesForEcsCluster(ctx, awsSess, cluster) {
+		if *service.PendingCount > 0 {
+			pendingServices = append(pendingServices, service)
+		}
+	}
+
+	return pendingServices
+}
+
+// ListServicesForEcsCluster describes every service in the cluster, exiting on error. include is passed
+// on to DescribeEcsServicesForArns.
+func ListServicesForEcsCluster(ctx context.Context, awsSess *session.Session, cluster string, include ...string) []*ecs.Service {
+	services, err := describeServicesForEcsCluster(ctx, awsSess, cluster, include...)
+	if err != nil {
+		ExitWithError(err)
+	}
+
+	return services
+}
+
+// describeServicesForEcsCluster describes every service in the cluster
+func describeServicesForEcsCluster(ctx context.Context, awsSess *session.Session, cluster string, include ...string) ([]*ecs.Service, error) {
+	svc := newEcsClient(awsSess)
 
 	var allServices []*ecs.Service
-	err := svc.ListServicesPages(&ecs.ListServicesInput{
+	var describeErr error
+	err := svc.ListServicesPagesWithContext(ctx, &ecs.ListServicesInput{
 		Cluster: aws.String(cluster),
 	}, func(page *ecs.ListServicesOutput, lastPage bool) bool {
-		services, err := DescribeEcsServicesForArns(awsSess, page.ServiceArns, cluster)
+		services, err := DescribeEcsServicesForArns(ctx, awsSess, page.ServiceArns, cluster, include...)
 		if err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
+			describeErr = err
+			return false
 		}
 
-		for _, service := range services {
-			allServices = append(allServices, service)
+		allServices = append(allServices, services...)
+
+		return !lastPage
+	})
+	if describeErr != nil {
+		err = describeErr
+	}
+	if err != nil {
+		return nil, handleEcsError(err)
+	}
+
+	return allServices, nil
+}
+
+// ListServiceNamesForEcsCluster returns the name of every service in the cluster from ListServices alone,
+// without the DescribeServices calls ListServicesForEcsCluster makes for service details
+func ListServiceNamesForEcsCluster(ctx context.Context, awsSess *session.Session, cluster string) ([]string, error) {
+	svc := newEcsClient(awsSess)
+
+	names := []string{}
+	err := svc.ListServicesPagesWithContext(ctx, &ecs.ListServicesInput{
+		Cluster: aws.String(cluster),
+	}, func(page *ecs.ListServicesOutput, lastPage bool) bool {
+		for _, arn := range page.ServiceArns {
+			names = append(names, serviceNameFromArn(aws.StringValue(arn)))
 		}
 
 		return !lastPage
 	})
 	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
+		return nil, handleEcsError(err)
 	}
 
-	return allServices
+	return names, nil
+}
+
+// serviceNameFromArn returns the service name from a service ARN in either the
+// arn:aws:ecs:region:account:service/cluster/name or the older arn:aws:ecs:region:account:service/name format
+func serviceNameFromArn(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
 }
 
-func DescribeEcsServicesForArns(awsSess *session.Session, serviceArns []*string, cluster string) ([]*ecs.Service, error) {
-	svc := ecs.New(awsSess)
+// FilterServicesByName returns the services matching the given service names or ARNs, in the order of
+// services, along with any names that did not match a service
+func FilterServicesByName(services []*ecs.Service, names []string) ([]*ecs.Service, []string) {
+	found := map[string]bool{}
+	var matched []*ecs.Service
 
-	descResult, err := svc.DescribeServices(&ecs.DescribeServicesInput{
-		Cluster:  aws.String(cluster),
-		Services: serviceArns,
+	for _, service := range services {
+		for _, name := range names {
+			if name == aws.StringValue(service.ServiceName) || name == aws.StringValue(service.ServiceArn) {
+				matched = append(matched, service)
+				found[name] = true
+				break
+			}
+		}
+	}
+
+	var missing []string
+	for _, name := range names {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	return matched, missing
+}
+
+// FilterServicesByTags returns the services with every one of the tags. The services must have been
+// described with their tags, see DescribeEcsServicesForArns.
+func FilterServicesByTags(services []*ecs.Service, tags map[string]string) []*ecs.Service {
+	matched := []*ecs.Service{}
+	for _, service := range services {
+		serviceTags := map[string]string{}
+		for _, tag := range service.Tags {
+			serviceTags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+
+		matchesAll := true
+		for key, value := range tags {
+			if tagValue, ok := serviceTags[key]; !ok || tagValue != value {
+				matchesAll = false
+				break
+			}
+		}
+		if matchesAll {
+			matched = append(matched, service)
+		}
+	}
+
+	return matched
+}
+
+// describeTasksMaxTasks is the most tasks the ECS DescribeTasks API accepts per call
+const describeTasksMaxTasks = 100
+
+// InstanceTask is a task running on a container instance
+type InstanceTask struct {
+	TaskArn        string     `json:"taskArn"`
+	Group          string     `json:"group"`
+	TaskDefinition string     `json:"taskDefinition"`
+	LastStatus     string     `json:"lastStatus"`
+	StartedAt      *time.Time `json:"startedAt,omitempty"`
+}
+
+// GetRunningTasksForInstance returns the tasks with a desired status of RUNNING on the container instance.
+// Group is service:<name> for tasks started by a service.
+func GetRunningTasksForInstance(ctx context.Context, awsSess *session.Session, cluster, containerInstanceArn string) ([]InstanceTask, error) {
+	svc := newEcsClient(awsSess)
+
+	var taskArns []*string
+	err := svc.ListTasksPagesWithContext(ctx, &ecs.ListTasksInput{
+		Cluster:           aws.String(cluster),
+		ContainerInstance: aws.String(containerInstanceArn),
+		DesiredStatus:     aws.String(ecs.DesiredStatusRunning),
+	}, func(page *ecs.ListTasksOutput, lastPage bool) bool {
+		taskArns = append(taskArns, page.TaskArns...)
+		return !lastPage
+	})
+	if err != nil {
+		return nil, handleEcsError(err)
+	}
+
+	tasks := []InstanceTask{}
+	for _, chunk := range chunkStrings(taskArns, describeTasksMaxTasks) {
+		descResult, err := svc.DescribeTasksWithContext(ctx, &ecs.DescribeTasksInput{
+			Cluster: aws.String(cluster),
+			Tasks:   chunk,
+		})
+		if err != nil {
+			return nil, handleEcsError(err)
+		}
+
+		for _, task := range descResult.Tasks {
+			tasks = append(tasks, InstanceTask{
+				TaskArn:        aws.StringValue(task.TaskArn),
+				Group:          aws.StringValue(task.Group),
+				TaskDefinition: aws.StringValue(task.TaskDefinitionArn),
+				LastStatus:     aws.StringValue(task.LastStatus),
+				StartedAt:      task.StartedAt,
+			})
+		}
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].Group != tasks[j].Group {
+			return tasks[i].Group < tasks[j].Group
+		}
+		return tasks[i].TaskArn < tasks[j].TaskArn
 	})
+
+	return tasks, nil
+}
+
+// describeServicesMaxServices is the most services the ECS DescribeServices API accepts per call
+const describeServicesMaxServices = 10
+
+// DescribeEcsServicesForArns describes the services in batches of the most the API accepts. include is passed
+// on as the optional fields DescribeServices should return, e.g. ecs.ServiceFieldTags for service tags.
+func DescribeEcsServicesForArns(ctx context.Context, awsSess *session.Session, serviceArns []*string, cluster string,
+	include ...string) ([]*ecs.Service, error) {
+	svc := newEcsClient(awsSess)
+
+	services := []*ecs.Service{}
+	for _, chunk := range chunkStrings(serviceArns, describeServicesMaxServices) {
+		input := &ecs.DescribeServicesInput{
+			Cluster:  aws.String(cluster),
+			Services: chunk,
+		}
+		if len(include) > 0 {
+			input.Include = aws.StringSlice(include)
+		}
+
+		descResult, err := svc.DescribeServicesWithContext(ctx, input)
+		if err != nil {
+			return []*ecs.Service{}, err
+		}
+
+		services = append(services, descResult.Services...)
+	}
+
+	return services, nil
+}
+
+// chunkStrings splits items into consecutive slices of at most size elements
+func chunkStrings(items []*string, size int) [][]*string {
+	var chunks [][]*string
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+		chunks = append(chunks, items[start:end])
+	}
+
+	return chunks
+}
+
+// GetTasksNeededForEcsServices returns the memory and CPU of every task that may need to run at once
+// for the given services, so they can be placed onto servers individually, exiting on error
+func GetTasksNeededForEcsServices(ctx context.Context, awsSess *session.Session, ecsServices []*ecs.Service) []TaskResources {
+	tasks, err := tasksNeededForEcsServices(ctx, awsSess, ecsServices)
 	if err != nil {
-		return []*ecs.Service{}, err
+		ExitWithError(err)
 	}
 
-	return descResult.Services, nil
+	return tasks
 }
 
-func GetMemoryCpuNeededForEcsServices(awsSess *session.Session, ecsServices []*ecs.Service) (int64, int64) {
-	var memoryNeeded int64 = 0
-	var cpuNeeded int64 = 0
+// tasksNeededForEcsServices is GetTasksNeededForEcsServices returning an error rather than exiting
+func tasksNeededForEcsServices(ctx context.Context, awsSess *session.Session, ecsServices []*ecs.Service) ([]TaskResources, error) {
+	var tasks []TaskResources
 	var largestServiceMemory int64 = 0
 	var largestServiceCpu int64 = 0
 
-	svc := ecs.New(awsSess)
+	svc := newEcsClient(awsSess)
 
 	for _, service := range ecsServices {
 		if *service.DesiredCount == 0 {
@@ -132,22 +665,14 @@ func GetMemoryCpuNeededForEcsServices(awsSess *session.Session, ecsServices []*e
 		}
 
 		// fmt.Printf("Looking at service %s, count = %v\n", *service.ServiceName, *service.DesiredCount)
-		taskDef, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		taskDef, err := svc.DescribeTaskDefinitionWithContext(ctx, &ecs.DescribeTaskDefinitionInput{
 			TaskDefinition: service.TaskDefinition,
 		})
 		if err != nil {
-			fmt.Printf("Unable to describe task definition %s\n", *service.TaskDefinition)
-			os.Exit(1)
+			return nil, fmt.Errorf("Unable to describe task definition %s: %w", *service.TaskDefinition, err)
 		}
 
-		var serviceMemory int64 = 0
-		var serviceCpu int64 = 0
-
-		for _, c := range taskDef.TaskDefinition.ContainerDefinitions {
-			// fmt.Printf("    Looking at container %s, needs %v mem and %v cpu\n", *c.Name, *c.Memory, *c.Cpu)
-			serviceMemory += *c.Memory
-			serviceCpu += *c.Cpu
-		}
+		serviceMemory, serviceCpu := GetMemoryCpuForTaskDefinition(taskDef.TaskDefinition)
 
 		if serviceMemory > largestServiceMemory {
 			largestServiceMemory = serviceMemory
@@ -157,78 +682,1313 @@ func GetMemoryCpuNeededForEcsServices(awsSess *session.Session, ecsServices []*e
 			largestServiceCpu = serviceCpu
 		}
 
-		memoryNeeded += serviceMemory * *service.DesiredCount
-		cpuNeeded += serviceCpu * *service.DesiredCount
+		// Size for the peak task count during a deployment, not just the steady state desired count
+		peakCount := GetPeakTaskCountForService(service)
+		for n := int64(0); n < peakCount; n++ {
+			tasks = append(tasks, TaskResources{MemoryMb: serviceMemory, CPUUnits: serviceCpu})
+		}
 	}
 
 	// Add back in the largest service memory and cpu needs to ensure there is enough extra capacity
 	// to launch another instance of the largest service for rolling updates
-	memoryNeeded += largestServiceMemory
-	cpuNeeded += largestServiceCpu
+	if largestServiceMemory > 0 || largestServiceCpu > 0 {
+		tasks = append(tasks, TaskResources{MemoryMb: largestServiceMemory, CPUUnits: largestServiceCpu})
+	}
 
-	return memoryNeeded, cpuNeeded
+	return tasks, nil
 }
 
-func RightSizeAsgForEcsCluster(awsSess *session.Session, cluster string, atLeastServiceDesiredCount bool) error {
-	asgName := GetAsgNameForEcsCluster(awsSess, cluster)
-	if asgName == "" {
-		fmt.Println("Unable to find ASG name for ECS cluster ", cluster)
-		os.Exit(1)
+// defaultDeploymentMaximumPercent is what ECS uses when a service does not specify a maximum percent
+const defaultDeploymentMaximumPercent = 200
+
+// GetPeakTaskCountForService returns the most tasks a service may run at once during a rolling
+// deployment, based on its desired count and deployment maximum percent
+func GetPeakTaskCountForService(service *ecs.Service) int64 {
+	maxPercent := int64(defaultDeploymentMaximumPercent)
+	if service.DeploymentConfiguration != nil && service.DeploymentConfiguration.MaximumPercent != nil {
+		maxPercent = *service.DeploymentConfiguration.MaximumPercent
 	}
 
-	fmt.Println("ASG found: ", asgName)
+	peak := int64(math.Ceil(float64(aws.Int64Value(service.DesiredCount)*maxPercent) / 100))
+	if peak < aws.Int64Value(service.DesiredCount) {
+		return aws.Int64Value(service.DesiredCount)
+	}
 
-	instanceType := GetInstanceTypeForAsg(awsSess, asgName)
-	fmt.Println("ASG uses instance type: ", instanceType)
+	return peak
+}
 
-	ecsServices := ListServicesForEcsCluster(awsSess, cluster)
-	memoryNeeded, cpuNeeded := GetMemoryCpuNeededForEcsServices(awsSess, ecsServices)
-	fmt.Printf("Memory needed for all services with desired count > 0: %v, CPU needed: %v\n", memoryNeeded, cpuNeeded)
+// GetMemoryCpuForTaskDefinition returns the memory (MiB) and CPU units needed to run one copy of a task.
+// Task level values are used when present, otherwise the container level values are summed.
+func GetMemoryCpuForTaskDefinition(taskDef *ecs.TaskDefinition) (int64, int64) {
+	var containerMemory int64 = 0
+	var containerCpu int64 = 0
 
-	serversNeeded := HowManyServersNeededForAsg(instanceType, memoryNeeded, cpuNeeded)
-	fmt.Printf("ASG should have %v servers to fit all tasks\n", serversNeeded)
+	for _, c := range taskDef.ContainerDefinitions {
+		containerMemory += GetMemoryForContainerDefinition(c)
+		containerCpu += aws.Int64Value(c.Cpu)
+	}
 
-	// If an ECS service has a desired count > serversNeeded, and atLeastServiceDesiredCount is true, set serversNeeded to
-	// largest ecs service desired count value
-	largestDesiredCount := GetLargestDesiredCountFromEcsServices(ecsServices)
-	if largestDesiredCount > serversNeeded && atLeastServiceDesiredCount {
-		serversNeeded = largestDesiredCount
+	memory, err := parseTaskResource(aws.StringValue(taskDef.Memory), "GB")
+	if err != nil {
+		memory = containerMemory
 	}
 
-	asgDesired, asgMin, asgMax := GetAsgServerCount(awsSess, asgName)
-	fmt.Printf("ASG server count currently set to: desired = %v, min = %v, max = %v\n", asgDesired, asgMin, asgMax)
+	cpu, err := parseTaskResource(aws.StringValue(taskDef.Cpu), "vCPU")
+	if err != nil {
+		cpu = containerCpu
+	}
 
-	if asgMin < serversNeeded {
-		fmt.Printf("ASG needs to be scaled up by %v servers\n", serversNeeded-asgMin)
-		fmt.Printf("Scaling ASG to %v servers...", serversNeeded)
-		err := UpdateAsgServerCount(awsSess, asgName, serversNeeded)
-		if err != nil {
-			return err
-		}
-		fmt.Printf("done.\n")
-	} else if asgMin > serversNeeded {
-		fmt.Printf("ASG can be scaled down by %v servers\n", asgMin-serversNeeded)
-		fmt.Printf("Scaling ASG to %v servers (desired/min/max)...", serversNeeded)
-		err := UpdateAsgServerCount(awsSess, asgName, serversNeeded)
+	return memory, cpu
+}
+
+// GetMemoryForContainerDefinition returns the memory (MiB) to plan for a container, the larger of its hard
+// limit (memory) and soft limit (memoryReservation), as containers often only set the soft limit
+func GetMemoryForContainerDefinition(c *ecs.ContainerDefinition) int64 {
+	memory := aws.Int64Value(c.Memory)
+	if reservation := aws.Int64Value(c.MemoryReservation); reservation > memory {
+		return reservation
+	}
+
+	return memory
+}
+
+// parseTaskResource parses a task level cpu or memory value, which is either a plain number of
+// CPU units/MiB (e.g. "512") or a number of the given large unit (e.g. "1 vCPU", "0.5 GB")
+func parseTaskResource(value, largeUnit string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("no value")
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) == 2 && strings.EqualFold(fields[1], largeUnit) {
+		amount, err := strconv.ParseFloat(fields[0], 64)
 		if err != nil {
-			return err
+			return 0, err
 		}
-		fmt.Printf("done.\n")
-	} else {
-		fmt.Printf("Looks like this ASG is already right sized, good day sir.\n")
+		return int64(amount * 1024), nil
 	}
 
-	return nil
+	return strconv.ParseInt(value, 10, 64)
 }
 
-func GetLargestDesiredCountFromEcsServices(ecsServices []*ecs.Service) int64 {
-	largestDesiredCount := int64(0)
+// IsFargateCluster returns true when the cluster has no EC2 container instances, meaning any
+// tasks in it run on Fargate and there is no ASG to manage
+func IsFargateCluster(ctx context.Context, awsSess *session.Session, cluster string) bool {
+	return len(GetInstanceListForEcsCluster(ctx, awsSess, cluster)) == 0
+}
 
-	for _, service := range ecsServices {
-		if *service.DesiredCount > largestDesiredCount {
-			largestDesiredCount = *service.DesiredCount
-		}
+// RightSizeOptions controls how RightSizeAsgForEcsCluster sizes and updates the ASG
+type RightSizeOptions struct {
+	// AtLeastServiceDesiredCount ensures at least as many servers as the largest service desired count
+	AtLeastServiceDesiredCount bool
+
+	// DryRun prints the computed server count without changing the ASG
+	DryRun bool
+
+	// MaxHeadroom is how many servers above the computed count the ASG max is set to, leaving
+	// room for autoscaling. With zero headroom min, desired and max are all set to the same value.
+	MaxHeadroom int64
+
+	// ReservedMemoryMb is held back on each server when capacity comes from the instance types file or
+	// EC2 API because no container instances of the ASG instance type are registered with the cluster yet.
+	// When zero, only MbInGb of every 1024 MB is counted instead.
+	ReservedMemoryMb int64
+
+	// RespectCooldown skips scaling while the ASG has a scaling activity in progress or is within
+	// its default cooldown after the last one, to avoid fighting scaling alarms
+	RespectCooldown bool
+
+	// CheckAlarms skips scaling down while any alarm of the ASG's scaling policies is in ALARM state
+	CheckAlarms bool
+
+	// Out is where progress is written, stdout when nil. Right-sizing several clusters at once gives each
+	// its own buffer so their output isn't interleaved.
+	Out io.Writer
+}
+
+func (o RightSizeOptions) output() io.Writer {
+	if o.Out == nil {
+		return os.Stdout
 	}
 
-	return largestDesiredCount
+	return o.Out
+}
+
+// RightSizePlan is the ASG capacity PlanRightSize decided a cluster needs, for ApplyRightSize to set. It is
+// saved as JSON by the rightSizeCluster plan command so it can be reviewed before it is applied.
+type RightSizePlan struct {
+	Cluster string `json:"cluster"`
+	AsgName string `json:"asgName,omitempty"`
+
+	// Current capacity of the ASG when the plan was made, ApplyRightSize refuses to apply a plan once it changes
+	CurrentDesired int64 `json:"currentDesired"`
+	CurrentMin     int64 `json:"currentMin"`
+	CurrentMax     int64 `json:"currentMax"`
+
+	Desired int64 `json:"desired"`
+	Min     int64 `json:"min"`
+	Max     int64 `json:"max"`
+
+	// Change is false when the ASG is already right sized or should not be scaled, with Reason saying why
+	Change bool   `json:"change"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// RightSizeAsgForEcsCluster scales the cluster ASG to the fewest servers that fit all services and returns
+// the plan it followed, which has no change when the ASG was left alone
+func RightSizeAsgForEcsCluster(ctx context.Context, awsSess *session.Session, cluster string, opts RightSizeOptions) (*RightSizePlan, error) {
+	out := opts.output()
+
+	plan, err := PlanRightSize(ctx, awsSess, cluster, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.Change {
+		return plan, nil
+	}
+
+	if opts.DryRun {
+		fmt.Fprintf(out, "DRY RUN — ASG would be scaled to desired = %v, min = %v, max = %v, no changes made\n",
+			plan.Desired, plan.Min, plan.Max)
+		return plan, nil
+	}
+
+	return plan, applyRightSize(ctx, awsSess, plan, out)
+}
+
+// PlanRightSize works out the fewest servers the cluster ASG needs to fit all services without changing
+// anything. opts.DryRun is ignored, a plan never makes changes.
+func PlanRightSize(ctx context.Context, awsSess *session.Session, cluster string, opts RightSizeOptions) (*RightSizePlan, error) {
+	out := opts.output()
+	plan := &RightSizePlan{Cluster: cluster}
+
+	containerInstances, err := describeInstancesForEcsCluster(ctx, awsSess, cluster)
+	if err != nil {
+		return nil, err
+	}
+	if len(containerInstances) == 0 {
+		plan.Reason = "cluster has no container instances, Fargate clusters don't require right-sizing"
+		fmt.Fprintf(out, "Cluster %s has no container instances, Fargate clusters don't require right-sizing\n", cluster)
+		return plan, nil
+	}
+
+	asgName, err := asgNameForContainerInstances(ctx, awsSess, cluster, containerInstances)
+	if err != nil {
+		return nil, err
+	}
+	plan.AsgName = asgName
+
+	fmt.Fprintln(out, "ASG found: ", asgName)
+
+	providers, err := GetCapacityProvidersForCluster(ctx, awsSess, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	// Setting the ASG desired count directly would fight ECS managed scaling
+	if provider := GetManagedCapacityProviderForAsg(providers, asgName); provider != nil {
+		plan.Reason = fmt.Sprintf("ASG is scaled by capacity provider %s", aws.StringValue(provider.Name))
+		fmt.Fprintf(out, "ASG %s is scaled by capacity provider %s with a target capacity of %v%%, not changing the ASG directly.\n",
+			asgName, aws.StringValue(provider.Name), aws.Int64Value(provider.AutoScalingGroupProvider.ManagedScaling.TargetCapacity))
+		fmt.Fprintln(out, "Adjust the capacity provider target capacity instead to change how much spare capacity is kept.")
+		return plan, nil
+	}
+
+	instanceTypes, err := GetWeightedInstanceTypesForAsg(ctx, awsSess, asgName)
+	if err != nil {
+		return nil, err
+	}
+
+	// With mixed instance types, size for the type most instances run and convert to the ASG's capacity units
+	dominant := DominantInstanceType(instanceTypes)
+	instanceType := dominant.InstanceType
+	if len(instanceTypes) > 1 {
+		var names []string
+		for _, t := range instanceTypes {
+			names = append(names, fmt.Sprintf("%s (weight %v, %v instances)", t.InstanceType, t.WeightedCapacity, t.Instances))
+		}
+		fmt.Fprintln(out, "ASG uses mixed instance types: ", strings.Join(names, ", "))
+		fmt.Fprintf(out, "Sizing for the most used instance type %s, each counting for %v capacity units\n", instanceType, dominant.WeightedCapacity)
+	} else {
+		fmt.Fprintln(out, "ASG uses instance type: ", instanceType)
+	}
+
+	ecsServices, err := describeServicesForEcsCluster(ctx, awsSess, cluster)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := tasksNeededForEcsServices(ctx, awsSess, ecsServices)
+	if err != nil {
+		return nil, err
+	}
+	var memoryNeeded, cpuNeeded int64
+	for _, task := range tasks {
+		memoryNeeded += task.MemoryMb
+		cpuNeeded += task.CPUUnits
+	}
+	fmt.Fprintf(out, "Memory needed for all services with desired count > 0: %v, CPU needed: %v\n", memoryNeeded, cpuNeeded)
+
+	capacity, found := GetRegisteredCapacityForInstanceType(containerInstances, instanceType)
+	if found {
+		fmt.Fprintf(out, "Using capacity registered by existing instances: memory = %v, CPU = %v\n", capacity.MemoryMb, capacity.CPUUnits)
+	} else {
+		capacity, err = GetInstanceTypeCapacity(ctx, awsSess, instanceType, opts.ReservedMemoryMb)
+		if err != nil {
+			return nil, err
+		}
+		if opts.ReservedMemoryMb > 0 {
+			fmt.Fprintf(out, "Using instance type capacity less %v MB reserved: memory = %v, CPU = %v\n",
+				opts.ReservedMemoryMb, capacity.MemoryMb, capacity.CPUUnits)
+		} else {
+			fmt.Fprintf(out, "Using instance type capacity with %v of every 1024 MB usable: memory = %v, CPU = %v\n",
+				MbInGb, capacity.MemoryMb, capacity.CPUUnits)
+		}
+	}
+
+	serversNeeded, err := HowManyServersNeededForTasks(capacity, tasks)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "ASG should have %v servers to fit all tasks\n", serversNeeded)
+
+	placementMinimum, placementNotes, err := GetPlacementMinimumServers(ctx, awsSess, ecsServices, containerInstances, capacity)
+	if err != nil {
+		return nil, err
+	}
+	for _, note := range placementNotes {
+		fmt.Fprintln(out, "Placement constraints: ", note)
+	}
+	if placementMinimum > serversNeeded {
+		fmt.Fprintf(out, "ASG should have %v servers to satisfy service placement constraints\n", placementMinimum)
+		serversNeeded = placementMinimum
+	}
+
+	// If an ECS service has a desired count > serversNeeded, and atLeastServiceDesiredCount is true, set serversNeeded to
+	// largest ecs service desired count value
+	largestDesiredCount := GetLargestDesiredCountFromEcsServices(ecsServices)
+	if largestDesiredCount > serversNeeded && opts.AtLeastServiceDesiredCount {
+		serversNeeded = largestDesiredCount
+	}
+
+	maxNeeded := serversNeeded + opts.MaxHeadroom
+
+	// ASG capacity is in capacity units when instance types are weighted
+	if dominant.WeightedCapacity > 1 {
+		serversNeeded *= dominant.WeightedCapacity
+		maxNeeded *= dominant.WeightedCapacity
+		fmt.Fprintf(out, "ASG capacity needed in weighted capacity units: desired = %v, max = %v\n", serversNeeded, maxNeeded)
+	}
+
+	asgDesired, asgMin, asgMax, err := GetAsgServerCount(ctx, awsSess, asgName)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "ASG server count currently set to: desired = %v, min = %v, max = %v\n", asgDesired, asgMin, asgMax)
+
+	plan.CurrentDesired, plan.CurrentMin, plan.CurrentMax = asgDesired, asgMin, asgMax
+	plan.Desired, plan.Min, plan.Max = serversNeeded, serversNeeded, maxNeeded
+
+	if asgMin == serversNeeded && asgMax == maxNeeded {
+		plan.Reason = "ASG is already right sized"
+		fmt.Fprintf(out, "Looks like this ASG is already right sized, good day sir.\n")
+		return plan, nil
+	}
+
+	if opts.RespectCooldown {
+		activity, reason, err := GetBlockingScalingActivity(ctx, awsSess, asgName)
+		if err != nil {
+			return nil, err
+		}
+		if activity != nil {
+			plan.Reason = reason
+			fmt.Fprintf(out, "Not scaling ASG, %s: %s\n", reason, aws.StringValue(activity.Description))
+			return plan, nil
+		}
+	}
+
+	if opts.CheckAlarms && serversNeeded < asgDesired {
+		firing, alarms, err := AsgScalingAlarmsInAlarm(ctx, awsSess, asgName)
+		if err != nil {
+			return nil, err
+		}
+		if firing {
+			plan.Reason = "scaling alarms are in ALARM state: " + strings.Join(alarms, ", ")
+			fmt.Fprintf(out, "Not scaling ASG down, scaling alarms are in ALARM state: %s\n", strings.Join(alarms, ", "))
+			return plan, nil
+		}
+	}
+
+	plan.Change = true
+
+	return plan, nil
+}
+
+// ApplyRightSize sets the ASG capacity decided by plan. Plans that make no change are skipped, and an
+// error is returned without changing anything if the ASG capacity is no longer what the plan was made from.
+func ApplyRightSize(ctx context.Context, awsSess *session.Session, plan *RightSizePlan) error {
+	return applyRightSize(ctx, awsSess, plan, os.Stdout)
+}
+
+func applyRightSize(ctx context.Context, awsSess *session.Session, plan *RightSizePlan, out io.Writer) error {
+	if !plan.Change {
+		fmt.Fprintf(out, "Plan for cluster %s makes no changes: %s\n", plan.Cluster, plan.Reason)
+		return nil
+	}
+
+	asgDesired, asgMin, asgMax, err := GetAsgServerCount(ctx, awsSess, plan.AsgName)
+	if err != nil {
+		return err
+	}
+	if asgDesired != plan.CurrentDesired || asgMin != plan.CurrentMin || asgMax != plan.CurrentMax {
+		return fmt.Errorf("ASG %s capacity changed since the plan was made, from desired = %v, min = %v, max = %v "+
+			"to desired = %v, min = %v, max = %v, make a new plan", plan.AsgName,
+			plan.CurrentDesired, plan.CurrentMin, plan.CurrentMax, asgDesired, asgMin, asgMax)
+	}
+
+	if asgMin < plan.Min {
+		fmt.Fprintf(out, "ASG needs to be scaled up by %v servers\n", plan.Min-asgMin)
+	} else if asgMin > plan.Min {
+		fmt.Fprintf(out, "ASG can be scaled down by %v servers\n", asgMin-plan.Min)
+	}
+
+	fmt.Fprintf(out, "Scaling ASG to desired = %v, min = %v, max = %v...", plan.Desired, plan.Min, plan.Max)
+	err = UpdateAsgCapacity(ctx, awsSess, plan.AsgName, plan.Min, plan.Desired, plan.Max)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "done.\n")
+
+	return nil
+}
+
+// GetRegisteredCapacityForInstanceType returns the smallest memory and CPU registered with ECS by the
+// container instances of the given instance type. Registered resources already exclude what the ECS
+// agent reserves, so they reflect the real capacity available to tasks, unlike remaining resources
+// which also subtract tasks currently running. Returns false if no instance of that type is found.
+func GetRegisteredCapacityForInstanceType(instances []*ecs.ContainerInstance, instanceType string) (TaskResources, bool) {
+	var capacity TaskResources
+	found := false
+
+	for _, instance := range instances {
+		if getContainerInstanceAttribute(instance, "ecs.instance-type") != instanceType {
+			continue
+		}
+
+		memory := getContainerInstanceResource(instance.RegisteredResources, "MEMORY")
+		cpu := getContainerInstanceResource(instance.RegisteredResources, "CPU")
+		if memory <= 0 || cpu <= 0 {
+			continue
+		}
+
+		if !found || memory < capacity.MemoryMb {
+			capacity.MemoryMb = memory
+		}
+		if !found || cpu < capacity.CPUUnits {
+			capacity.CPUUnits = cpu
+		}
+		found = true
+	}
+
+	return capacity, found
+}
+
+func getContainerInstanceAttribute(instance *ecs.ContainerInstance, name string) string {
+	for _, attribute := range instance.Attributes {
+		if aws.StringValue(attribute.Name) == name {
+			return aws.StringValue(attribute.Value)
+		}
+	}
+
+	return ""
+}
+
+func getContainerInstanceResource(resources []*ecs.Resource, name string) int64 {
+	for _, resource := range resources {
+		if aws.StringValue(resource.Name) == name {
+			return aws.Int64Value(resource.IntegerValue)
+		}
+	}
+
+	return 0
+}
+
+// GetCapacityProvidersForCluster returns the capacity providers associated with the cluster, excluding
+// the built in Fargate providers which have no ASG
+func GetCapacityProvidersForCluster(ctx context.Context, awsSess *session.Session, cluster string) ([]*ecs.CapacityProvider, error) {
+	svc := newEcsClient(awsSess)
+
+	descResult, err := svc.DescribeClustersWithContext(ctx, &ecs.DescribeClustersInput{
+		Clusters: []*string{aws.String(cluster)},
+	})
+	if err != nil {
+		return nil, handleEcsError(err)
+	}
+
+	if len(descResult.Clusters) != 1 {
+		return nil, fmt.Errorf("cluster %q %w", cluster, ErrNotFound)
+	}
+
+	var names []*string
+	for _, name := range descResult.Clusters[0].CapacityProviders {
+		if *name != "FARGATE" && *name != "FARGATE_SPOT" {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return []*ecs.CapacityProvider{}, nil
+	}
+
+	providersResult, err := svc.DescribeCapacityProvidersWithContext(ctx, &ecs.DescribeCapacityProvidersInput{
+		CapacityProviders: names,
+	})
+	if err != nil {
+		return nil, handleEcsError(err)
+	}
+
+	return providersResult.CapacityProviders, nil
+}
+
+// GetManagedCapacityProviderForAsg returns the capacity provider that manages scaling of the named ASG,
+// or nil if none of the providers do
+func GetManagedCapacityProviderForAsg(providers []*ecs.CapacityProvider, asgName string) *ecs.CapacityProvider {
+	for _, provider := range providers {
+		asgProvider := provider.AutoScalingGroupProvider
+		if asgProvider == nil || asgProvider.ManagedScaling == nil {
+			continue
+		}
+
+		if aws.StringValue(asgProvider.ManagedScaling.Status) != ecs.ManagedScalingStatusEnabled {
+			continue
+		}
+
+		if strings.HasSuffix(aws.StringValue(asgProvider.AutoScalingGroupArn), ":autoScalingGroupName/"+asgName) {
+			return provider
+		}
+	}
+
+	return nil
+}
+
+func GetLargestDesiredCountFromEcsServices(ecsServices []*ecs.Service) int64 {
+	largestDesiredCount := int64(0)
+
+	for _, service := range ecsServices {
+		if *service.DesiredCount > largestDesiredCount {
+			largestDesiredCount = *service.DesiredCount
+		}
+	}
+
+	return largestDesiredCount
+}
+
+// ClusterReport is a summary of an ECS cluster's instances and services
+type ClusterReport struct {
+	ClusterName string                  `json:"clusterName"`
+	AsgName     string                  `json:"asgName"`
+	Instances   []ClusterReportInstance `json:"instances"`
+	Services    []ClusterReportService  `json:"services"`
+}
+
+type ClusterReportInstance struct {
+	InstanceID       string `json:"instanceId"`
+	PrivateIP        string `json:"privateIp"`
+	AvailabilityZone string `json:"availabilityZone"`
+}
+
+type ClusterReportService struct {
+	ServiceName  string `json:"serviceName"`
+	RunningCount int64  `json:"runningCount"`
+	PendingCount int64  `json:"pendingCount"`
+}
+
+// ListEcsClusterArns returns the ARNs of every ECS cluster in the account and region
+func ListEcsClusterArns(ctx context.Context, awsSess *session.Session) ([]*string, error) {
+	svc := newEcsClient(awsSess)
+
+	var clusterArns []*string
+	err := svc.ListClustersPagesWithContext(ctx, &ecs.ListClustersInput{}, func(page *ecs.ListClustersOutput, lastPage bool) bool {
+		clusterArns = append(clusterArns, page.ClusterArns...)
+		return !lastPage
+	})
+	if err != nil {
+		return nil, handleEcsError(err)
+	}
+
+	return clusterArns, nil
+}
+
+// describeClustersMaxClusters is the most clusters the ECS DescribeClusters API accepts per call
+const describeClustersMaxClusters = 100
+
+// ClusterSummary is the status and container instance, service and task counts for a cluster
+type ClusterSummary struct {
+	ClusterName                  string `json:"clusterName"`
+	Status                       string `json:"status"`
+	RegisteredContainerInstances int64  `json:"registeredContainerInstances"`
+	ActiveServices               int64  `json:"activeServices"`
+	RunningTasks                 int64  `json:"runningTasks"`
+	PendingTasks                 int64  `json:"pendingTasks"`
+}
+
+// GetClusterSummaries returns a summary of every ECS cluster in the account and region, sorted by name
+func GetClusterSummaries(ctx context.Context, awsSess *session.Session) ([]ClusterSummary, error) {
+	clusterArns, err := ListEcsClusterArns(ctx, awsSess)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := newEcsClient(awsSess)
+
+	summaries := []ClusterSummary{}
+	for _, chunk := range chunkStrings(clusterArns, describeClustersMaxClusters) {
+		descResult, err := svc.DescribeClustersWithContext(ctx, &ecs.DescribeClustersInput{
+			Clusters: chunk,
+		})
+		if err != nil {
+			return nil, handleEcsError(err)
+		}
+
+		for _, c := range descResult.Clusters {
+			summaries = append(summaries, ClusterSummary{
+				ClusterName:                  aws.StringValue(c.ClusterName),
+				Status:                       aws.StringValue(c.Status),
+				RegisteredContainerInstances: aws.Int64Value(c.RegisteredContainerInstancesCount),
+				ActiveServices:               aws.Int64Value(c.ActiveServicesCount),
+				RunningTasks:                 aws.Int64Value(c.RunningTasksCount),
+				PendingTasks:                 aws.Int64Value(c.PendingTasksCount),
+			})
+		}
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].ClusterName < summaries[j].ClusterName
+	})
+
+	return summaries, nil
+}
+
+// findServiceWorkers is the most clusters searched concurrently by FindService
+const findServiceWorkers = 5
+
+// ServiceLocation is a service found in a cluster by FindService
+type ServiceLocation struct {
+	ClusterName  string `json:"clusterName"`
+	ServiceName  string `json:"serviceName"`
+	Status       string `json:"status"`
+	DesiredCount int64  `json:"desiredCount"`
+	RunningCount int64  `json:"runningCount"`
+}
+
+// FindService searches the services in every ECS cluster in the account and region for those with a name
+// accepted by match, sorted by cluster and service name. Clusters that can't be searched don't stop the
+// search, the services found in the others are returned along with an error naming the failed clusters.
+func FindService(ctx context.Context, awsSess *session.Session, match func(name string) bool) ([]ServiceLocation, error) {
+	clusterArns, err := ListEcsClusterArns(ctx, awsSess)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each worker writes only to its own cluster's slots, so no locking is needed
+	results := make([][]ServiceLocation, len(clusterArns))
+	errs := make([]error, len(clusterArns))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, findServiceWorkers)
+	for n, clusterArn := range clusterArns {
+		wg.Add(1)
+		go func(n int, cluster string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			services, err := describeServicesForEcsCluster(ctx, awsSess, cluster)
+			if err != nil {
+				errs[n] = err
+				return
+			}
+
+			for _, service := range services {
+				if !match(aws.StringValue(service.ServiceName)) {
+					continue
+				}
+				results[n] = append(results[n], ServiceLocation{
+					ClusterName:  NormalizeClusterIdentifier(cluster),
+					ServiceName:  aws.StringValue(service.ServiceName),
+					Status:       aws.StringValue(service.Status),
+					DesiredCount: aws.Int64Value(service.DesiredCount),
+					RunningCount: aws.Int64Value(service.RunningCount),
+				})
+			}
+		}(n, aws.StringValue(clusterArn))
+	}
+	wg.Wait()
+
+	locations := []ServiceLocation{}
+	var failed []string
+	var firstErr error
+	for n, found := range results {
+		locations = append(locations, found...)
+		if errs[n] != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", NormalizeClusterIdentifier(aws.StringValue(clusterArns[n])), errs[n]))
+			if firstErr == nil {
+				firstErr = errs[n]
+			}
+		}
+	}
+
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].ClusterName != locations[j].ClusterName {
+			return locations[i].ClusterName < locations[j].ClusterName
+		}
+		return locations[i].ServiceName < locations[j].ServiceName
+	})
+
+	if len(failed) > 0 {
+		return locations, withCategory(fmt.Errorf("unable to search %v of %v clusters: %s",
+			len(failed), len(clusterArns), strings.Join(failed, "; ")), ErrorCategory(firstErr))
+	}
+
+	return locations, nil
+}
+
+func EcsClusterExists(ctx context.Context, awsSess *session.Session, cluster string) (bool, error) {
+	svc := newEcsClient(awsSess)
+
+	descResult, err := svc.DescribeClustersWithContext(ctx, &ecs.DescribeClustersInput{
+		Clusters: []*string{aws.String(cluster)},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, c := range descResult.Clusters {
+		if aws.StringValue(c.Status) == "ACTIVE" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func GetClusterReport(ctx context.Context, awsSess *session.Session, cluster string) (ClusterReport, error) {
+	exists, err := EcsClusterExists(ctx, awsSess, cluster)
+	if err != nil {
+		return ClusterReport{}, err
+	}
+	if !exists {
+		return ClusterReport{}, withCategory(fmt.Errorf("cluster %q does not exist", cluster), ErrNotFound)
+	}
+
+	report := ClusterReport{
+		ClusterName: cluster,
+		Instances:   []ClusterReportInstance{},
+		Services:    []ClusterReportService{},
+	}
+
+	containerInstances, err := describeInstancesForEcsCluster(ctx, awsSess, cluster)
+	if err != nil {
+		return ClusterReport{}, err
+	}
+
+	var instanceIDs []*string
+	for _, instance := range containerInstances {
+		instanceIDs = append(instanceIDs, instance.Ec2InstanceId)
+	}
+	if len(instanceIDs) > 0 {
+		// Report every ASG while a cluster is being migrated between them rather than failing
+		asgNames, err := asgNamesForContainerInstances(ctx, awsSess, cluster, containerInstances)
+		if err != nil {
+			return ClusterReport{}, err
+		}
+		report.AsgName = strings.Join(asgNames, ", ")
+
+		instances, err := DescribeEc2Instances(ctx, awsSess, instanceIDs)
+		if err != nil {
+			return ClusterReport{}, err
+		}
+
+		for _, i := range instances {
+			reportInstance := ClusterReportInstance{
+				InstanceID: aws.StringValue(i.InstanceId),
+				PrivateIP:  aws.StringValue(i.PrivateIpAddress),
+			}
+			if i.Placement != nil {
+				reportInstance.AvailabilityZone = aws.StringValue(i.Placement.AvailabilityZone)
+			}
+			report.Instances = append(report.Instances, reportInstance)
+		}
+	}
+
+	services, err := describeServicesForEcsCluster(ctx, awsSess, cluster)
+	if err != nil {
+		return ClusterReport{}, err
+	}
+
+	for _, service := range services {
+		report.Services = append(report.Services, ClusterReportService{
+			ServiceName:  aws.StringValue(service.ServiceName),
+			RunningCount: aws.Int64Value(service.RunningCount),
+			PendingCount: aws.Int64Value(service.PendingCount),
+		})
+	}
+
+	return report, nil
+}
+
+func GetContainerInstanceForEc2Instance(ctx context.Context, awsSess *session.Session, cluster, instanceID string) (*ecs.ContainerInstance, error) {
+	svc := newEcsClient(awsSess)
+
+	listResult, err := svc.ListContainerInstancesWithContext(ctx, &ecs.ListContainerInstancesInput{
+		Cluster: aws.String(cluster),
+		Filter:  aws.String(fmt.Sprintf("ec2InstanceId == %s", instanceID)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(listResult.ContainerInstanceArns) == 0 {
+		return nil, withCategory(fmt.Errorf("instance %s is not registered with cluster %q", instanceID, cluster), ErrNotFound)
+	}
+
+	return DescribeContainerInstance(ctx, awsSess, cluster, *listResult.ContainerInstanceArns[0])
+}
+
+func DescribeContainerInstance(ctx context.Context, awsSess *session.Session, cluster, containerInstanceArn string) (*ecs.ContainerInstance, error) {
+	svc := newEcsClient(awsSess)
+
+	descResult, err := svc.DescribeContainerInstancesWithContext(ctx, &ecs.DescribeContainerInstancesInput{
+		Cluster:            aws.String(cluster),
+		ContainerInstances: []*string{aws.String(containerInstanceArn)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(descResult.ContainerInstances) != 1 {
+		return nil, fmt.Errorf("container instance %s %w in cluster %q", containerInstanceArn, ErrNotFound, cluster)
+	}
+
+	return descResult.ContainerInstances[0], nil
+}
+
+// UpdateContainerInstanceState sets the status of a container instance, either ACTIVE or DRAINING
+func UpdateContainerInstanceState(ctx context.Context, awsSess *session.Session, cluster, containerInstanceArn, status string) error {
+	svc := newEcsClient(awsSess)
+
+	updateResult, err := svc.UpdateContainerInstancesStateWithContext(ctx, &ecs.UpdateContainerInstancesStateInput{
+		Cluster:            aws.String(cluster),
+		ContainerInstances: []*string{aws.String(containerInstanceArn)},
+		Status:             aws.String(status),
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(updateResult.Failures) > 0 {
+		return fmt.Errorf("unable to set container instance %s to %s: %s",
+			containerInstanceArn, status, aws.StringValue(updateResult.Failures[0].Reason))
+	}
+
+	return nil
+}
+
+// DescribeEcsService returns a single ECS service, or an error if it does not exist in the cluster. include
+// is passed on as the optional fields DescribeServices should return.
+func DescribeEcsService(ctx context.Context, awsSess *session.Session, cluster, service string, include ...string) (*ecs.Service, error) {
+	svc := newEcsClient(awsSess)
+
+	input := &ecs.DescribeServicesInput{
+		Cluster:  aws.String(cluster),
+		Services: []*string{aws.String(service)},
+	}
+	if len(include) > 0 {
+		input.Include = aws.StringSlice(include)
+	}
+
+	descResult, err := svc.DescribeServicesWithContext(ctx, input)
+	if err != nil {
+		return nil, handleEcsError(err)
+	}
+
+	if len(descResult.Failures) > 0 {
+		return nil, fmt.Errorf("unable to describe service %s: %s", service, aws.StringValue(descResult.Failures[0].Reason))
+	}
+
+	// Deleted services are still returned for a while with an INACTIVE status
+	if len(descResult.Services) != 1 || aws.StringValue(descResult.Services[0].Status) == "INACTIVE" {
+		return nil, fmt.Errorf("service %s %w in cluster %q", service, ErrNotFound, cluster)
+	}
+
+	return descResult.Services[0], nil
+}
+
+// GetEcsServiceByName returns a single ACTIVE ECS service by name or ARN with one DescribeServices call
+// rather than listing every service in the cluster. DRAINING and INACTIVE services are reported as not found.
+func GetEcsServiceByName(ctx context.Context, awsSess *session.Session, cluster, serviceName string, include ...string) (*ecs.Service, error) {
+	ecsService, err := DescribeEcsService(ctx, awsSess, cluster, serviceName, include...)
+	if err != nil {
+		return nil, err
+	}
+
+	if status := aws.StringValue(ecsService.Status); status != "ACTIVE" {
+		return nil, fmt.Errorf("service %s %w in cluster %q, it is %s", serviceName, ErrNotFound, cluster, status)
+	}
+
+	return ecsService, nil
+}
+
+// DeploymentStatus is the state of the primary deployment of an ECS service
+type DeploymentStatus struct {
+	ServiceName        string `json:"serviceName"`
+	TaskDefinition     string `json:"taskDefinition"`
+	RolloutState       string `json:"rolloutState"`
+	RolloutStateReason string `json:"rolloutStateReason"`
+	DesiredCount       int64  `json:"desiredCount"`
+	RunningCount       int64  `json:"runningCount"`
+	PendingCount       int64  `json:"pendingCount"`
+	Deployments        int    `json:"deployments"`
+	Complete           bool   `json:"complete"`
+	Failed             bool   `json:"failed"`
+}
+
+// GetServiceDeploymentStatus returns the counts and rollout state of the primary deployment of a service.
+// Services that don't report a rollout state are complete once they have a single deployment running its
+// desired count with nothing pending. The deployment has failed when any deployment of the service has a
+// FAILED rollout state, since a circuit breaker rollback makes the previous deployment primary again, or
+// when taskDefinition is given and the primary deployment runs a different one, as it does once the rollback
+// has finished. taskDefinition may be an ARN or family:revision.
+func GetServiceDeploymentStatus(ctx context.Context, awsSess *session.Session, cluster, service, taskDefinition string) (DeploymentStatus, error) {
+	ecsService, err := GetEcsServiceByName(ctx, awsSess, cluster, service)
+	if err != nil {
+		return DeploymentStatus{}, err
+	}
+
+	var primary *ecs.Deployment
+	for _, deployment := range ecsService.Deployments {
+		if aws.StringValue(deployment.Status) == "PRIMARY" {
+			primary = deployment
+		}
+	}
+	if primary == nil {
+		return DeploymentStatus{}, fmt.Errorf("service %s has no primary deployment", service)
+	}
+
+	status := DeploymentStatus{
+		ServiceName:        aws.StringValue(ecsService.ServiceName),
+		TaskDefinition:     aws.StringValue(primary.TaskDefinition),
+		RolloutState:       aws.StringValue(primary.RolloutState),
+		RolloutStateReason: aws.StringValue(primary.RolloutStateReason),
+		DesiredCount:       aws.Int64Value(primary.DesiredCount),
+		RunningCount:       aws.Int64Value(primary.RunningCount),
+		PendingCount:       aws.Int64Value(primary.PendingCount),
+		Deployments:        len(ecsService.Deployments),
+	}
+
+	switch status.RolloutState {
+	case ecs.DeploymentRolloutStateCompleted:
+		status.Complete = true
+	case ecs.DeploymentRolloutStateFailed:
+		status.Failed = true
+	case "":
+		status.Complete = status.Deployments == 1 && status.RunningCount == status.DesiredCount && status.PendingCount == 0
+	}
+
+	for _, deployment := range ecsService.Deployments {
+		if deployment != primary && aws.StringValue(deployment.RolloutState) == ecs.DeploymentRolloutStateFailed {
+			status.Complete = false
+			status.Failed = true
+			status.RolloutStateReason = fmt.Sprintf("deployment of %s failed: %s", aws.StringValue(deployment.TaskDefinition),
+				aws.StringValue(deployment.RolloutStateReason))
+		}
+	}
+
+	if taskDefinition != "" && status.TaskDefinition != taskDefinition && !strings.HasSuffix(status.TaskDefinition, "/"+taskDefinition) {
+		status.Complete = false
+		status.Failed = true
+		status.RolloutStateReason = fmt.Sprintf("primary deployment runs %s rather than %s, the deployment was rolled back "+
+			"or replaced", status.TaskDefinition, taskDefinition)
+	}
+
+	return status, nil
+}
+
+// GetServiceEvents returns the events for an ECS service, newest first as ECS reports them
+func GetServiceEvents(ctx context.Context, awsSess *session.Session, cluster, service string) ([]*ecs.ServiceEvent, error) {
+	ecsService, err := DescribeEcsService(ctx, awsSess, cluster, service)
+	if err != nil {
+		return nil, err
+	}
+
+	return ecsService.Events, nil
+}
+
+// UpdateServiceDesiredCount sets the desired task count of an ECS service
+func UpdateServiceDesiredCount(ctx context.Context, awsSess *session.Session, cluster, service string, desiredCount int64) error {
+	if desiredCount < 0 {
+		return fmt.Errorf("desired count cannot be negative, got %v", desiredCount)
+	}
+
+	svc := newEcsClient(awsSess)
+
+	_, err := svc.UpdateServiceWithContext(ctx, &ecs.UpdateServiceInput{
+		Cluster:      aws.String(cluster),
+		Service:      aws.String(service),
+		DesiredCount: aws.Int64(desiredCount),
+	})
+	if err != nil {
+		return handleEcsError(err)
+	}
+
+	return nil
+}
+
+// ForceNewServiceDeployment starts a rolling restart of an ECS service without changing its task definition
+// and returns the ID of the new primary deployment. The ID is empty when ECS doesn't report a primary
+// deployment yet, the restart has still been started.
+func ForceNewServiceDeployment(ctx context.Context, awsSess *session.Session, cluster, service string) (string, error) {
+	svc := newEcsClient(awsSess)
+
+	updateResult, err := svc.UpdateServiceWithContext(ctx, &ecs.UpdateServiceInput{
+		Cluster:            aws.String(cluster),
+		Service:            aws.String(service),
+		ForceNewDeployment: aws.Bool(true),
+	})
+	if err != nil {
+		return "", handleEcsError(err)
+	}
+
+	if id := primaryDeploymentID(updateResult.Service); id != "" {
+		return id, nil
+	}
+
+	// The update succeeded, so only the ID to report is missing
+	described, err := DescribeEcsService(ctx, awsSess, cluster, service)
+	if err != nil {
+		return "", nil
+	}
+
+	return primaryDeploymentID(described), nil
+}
+
+// primaryDeploymentID returns the ID of the service's PRIMARY deployment, or "" if it has none
+func primaryDeploymentID(service *ecs.Service) string {
+	if service == nil {
+		return ""
+	}
+
+	for _, deployment := range service.Deployments {
+		if aws.StringValue(deployment.Status) == "PRIMARY" {
+			return aws.StringValue(deployment.Id)
+		}
+	}
+
+	return ""
+}
+
+// WaitForServiceStable waits up to timeout for a single service to reach a steady state
+func WaitForServiceStable(ctx context.Context, awsSess *session.Session, cluster, service string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	svc := newEcsClient(awsSess)
+
+	err := svc.WaitUntilServicesStableWithContext(ctx, &ecs.DescribeServicesInput{
+		Cluster:  aws.String(cluster),
+		Services: []*string{aws.String(service)},
+	}, request.WithWaiterDelay(request.ConstantWaiterDelay(servicesStablePollInterval)), request.WithWaiterMaxAttempts(0),
+		failOnFailedRollout)
+	if err != nil {
+		// Use a fresh context since the wait context may have expired
+		if described, descErr := DescribeEcsService(context.Background(), awsSess, cluster, service); descErr == nil {
+			if failed := FailedDeployment(described); failed != nil {
+				return fmt.Errorf("deployment %s of service %s failed: %s", aws.StringValue(failed.Id), service,
+					aws.StringValue(failed.RolloutStateReason))
+			}
+		}
+		return withCategory(fmt.Errorf("service %s did not become stable within %s: %s", service, timeout, err), ErrTimeout)
+	}
+
+	return nil
+}
+
+// failOnFailedRollout makes the ServicesStable waiter stop as soon as any deployment reports a FAILED
+// rollout, such as when the deployment circuit breaker gives up, rather than waiting for the timeout
+func failOnFailedRollout(w *request.Waiter) {
+	w.Acceptors = append([]request.WaiterAcceptor{{
+		State:    request.FailureWaiterState,
+		Matcher:  request.PathAnyWaiterMatch,
+		Argument: "services[].deployments[].rolloutState",
+		Expected: ecs.DeploymentRolloutStateFailed,
+	}}, w.Acceptors...)
+}
+
+// FailedDeployment returns the first deployment of the service whose rollout has FAILED, or nil if there is none
+func FailedDeployment(service *ecs.Service) *ecs.Deployment {
+	for _, deployment := range service.Deployments {
+		if aws.StringValue(deployment.RolloutState) == ecs.DeploymentRolloutStateFailed {
+			return deployment
+		}
+	}
+
+	return nil
+}
+
+// GetFailedDeployments returns the names of services in the cluster with a deployment whose rollout has FAILED
+func GetFailedDeployments(ctx context.Context, awsSess *session.Session, cluster string) ([]string, error) {
+	services, err := describeServicesForEcsCluster(ctx, awsSess, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := []string{}
+	for _, service := range services {
+		if FailedDeployment(service) != nil {
+			failed = append(failed, aws.StringValue(service.ServiceName))
+		}
+	}
+
+	sort.Strings(failed)
+	return failed, nil
+}
+
+// servicesStablePollInterval matches the delay used by the ECS ServicesStable waiter
+const servicesStablePollInterval = 15 * time.Second
+
+// WaitForServicesStable waits up to timeout for every service in the cluster to reach a steady state, with
+// one deployment and running count equal to desired count. It returns the names of services that became
+// stable and those that did not before the timeout. It returns an error without waiting any longer as soon as
+// a service deployment reports a FAILED rollout.
+func WaitForServicesStable(ctx context.Context, awsSess *session.Session, cluster string, timeout time.Duration) ([]string, []string, error) {
+	var stable, unstable []string
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	svc := newEcsClient(awsSess)
+
+	services, err := describeServicesForEcsCluster(ctx, awsSess, cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var serviceArns []*string
+	serviceNames := map[string]string{}
+	for _, service := range services {
+		serviceArns = append(serviceArns, service.ServiceArn)
+		serviceNames[aws.StringValue(service.ServiceArn)] = aws.StringValue(service.ServiceName)
+	}
+
+	// The waiter uses DescribeServices, which only accepts a limited number of services per call
+	for _, chunk := range chunkStrings(serviceArns, describeServicesMaxServices) {
+		err := svc.WaitUntilServicesStableWithContext(ctx, &ecs.DescribeServicesInput{
+			Cluster:  aws.String(cluster),
+			Services: chunk,
+		}, request.WithWaiterDelay(request.ConstantWaiterDelay(servicesStablePollInterval)), request.WithWaiterMaxAttempts(0),
+			failOnFailedRollout)
+
+		if err == nil {
+			for _, arn := range chunk {
+				stable = append(stable, serviceNames[*arn])
+			}
+			continue
+		}
+
+		// Check each service in the chunk to report which ones did not become stable, using a fresh
+		// context since the wait context has likely expired
+		services, descErr := DescribeEcsServicesForArns(context.Background(), awsSess, chunk, cluster)
+		if descErr != nil {
+			return stable, unstable, descErr
+		}
+
+		var failed []string
+		for _, service := range services {
+			if deployment := FailedDeployment(service); deployment != nil {
+				failed = append(failed, fmt.Sprintf("%s (%s)", aws.StringValue(service.ServiceName),
+					aws.StringValue(deployment.RolloutStateReason)))
+			}
+		}
+		if len(failed) > 0 {
+			return stable, unstable, fmt.Errorf("deployment failed for services: %s", strings.Join(failed, ", "))
+		}
+
+		for _, service := range services {
+			if IsServiceStable(service) {
+				stable = append(stable, aws.StringValue(service.ServiceName))
+			} else {
+				unstable = append(unstable, aws.StringValue(service.ServiceName))
+			}
+		}
+	}
+
+	return stable, unstable, nil
+}
+
+// IsServiceStable returns true when a service has a single deployment and is running its desired count,
+// the same check used by the ECS ServicesStable waiter
+func IsServiceStable(service *ecs.Service) bool {
+	return len(service.Deployments) == 1 && aws.Int64Value(service.RunningCount) == aws.Int64Value(service.DesiredCount)
+}
+
+// GetOutdatedInstancesForAsg returns the instances in the ASG that are not running the AMI
+// the ASG currently launches new instances with
+func GetOutdatedInstancesForAsg(ctx context.Context, awsSess *session.Session, asgName string) ([]*string, error) {
+	amiID, err := GetCurrentAmiForAsg(ctx, awsSess, asgName)
+	if err != nil {
+		return []*string{}, err
+	}
+
+	instanceIDs, err := GetInstanceListForAsg(ctx, awsSess, asgName)
+	if err != nil {
+		return []*string{}, err
+	}
+
+	return GetInstancesNotUsingAmi(ctx, awsSess, instanceIDs, amiID)
+}
+
+// GetInstancesNotUsingAmi returns the instances that were not launched from the given AMI
+func GetInstancesNotUsingAmi(ctx context.Context, awsSess *session.Session, instanceIDs []*string, amiID string) ([]*string, error) {
+	instances, err := DescribeEc2Instances(ctx, awsSess, instanceIDs)
+	if err != nil {
+		return []*string{}, err
+	}
+
+	outdated := []*string{}
+	for _, i := range instances {
+		if aws.StringValue(i.ImageId) != amiID {
+			outdated = append(outdated, i.InstanceId)
+		}
+	}
+
+	return outdated, nil
+}
+
+// asgPollInterval is how often ASG and cluster state is checked while waiting for instances
+const asgPollInterval = 15 * time.Second
+
+// WaitForAsgInstancesInService waits until the ASG has at least expectedCount instances with a
+// lifecycle state of InService and returns their IDs
+func WaitForAsgInstancesInService(ctx context.Context, awsSess *session.Session, asgName string,
+	expectedCount int, timeout time.Duration) ([]*string, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		asg, err := DescribeAsg(ctx, awsSess, asgName)
+		if err != nil {
+			fmt.Println()
+			return nil, err
+		}
+
+		var inService []*string
+		for _, instance := range asg.Instances {
+			if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+				inService = append(inService, instance.InstanceId)
+			}
+		}
+
+		fmt.Printf("\rInstances InService: %v/%v ", len(inService), expectedCount)
+		if len(inService) >= expectedCount {
+			fmt.Println()
+			return inService, nil
+		}
+
+		if time.Now().After(deadline) {
+			fmt.Println()
+			return nil, fmt.Errorf("%w after %s waiting for %v instances to be InService in ASG %s, %v are InService",
+				ErrTimeout, timeout, expectedCount, asgName, len(inService))
+		}
+
+		if err := aws.SleepWithContext(ctx, asgPollInterval); err != nil {
+			fmt.Println()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// WaitForInstancesActiveInCluster waits until every given EC2 instance is registered as an ACTIVE
+// container instance in the ECS cluster
+func WaitForInstancesActiveInCluster(ctx context.Context, awsSess *session.Session, cluster string,
+	instanceIDs []*string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		instances, err := describeInstancesForEcsCluster(ctx, awsSess, cluster)
+		if err != nil {
+			fmt.Println()
+			return err
+		}
+
+		statuses := map[string]string{}
+		for _, instance := range instances {
+			statuses[aws.StringValue(instance.Ec2InstanceId)] = aws.StringValue(instance.Status)
+		}
+
+		var notActive []string
+		for _, id := range instanceIDs {
+			if statuses[*id] != ecs.ContainerInstanceStatusActive {
+				notActive = append(notActive, *id)
+			}
+		}
+
+		fmt.Printf("\rInstances ACTIVE in cluster: %v/%v ", len(instanceIDs)-len(notActive), len(instanceIDs))
+		if len(notActive) == 0 {
+			fmt.Println()
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			fmt.Println()
+			return fmt.Errorf("%w after %s waiting for instances to be ACTIVE in cluster %q: %s",
+				ErrTimeout, timeout, cluster, strings.Join(notActive, ", "))
+		}
+
+		if err := aws.SleepWithContext(ctx, asgPollInterval); err != nil {
+			fmt.Println()
+			return ctx.Err()
+		}
+	}
+}
+
+// WaitForAgentsConnected waits until the ECS agent on every given EC2 instance reports it is connected. An
+// instance can be ACTIVE in the cluster before its agent connects, and until then ECS can't place tasks on it.
+func WaitForAgentsConnected(ctx context.Context, awsSess *session.Session, cluster string,
+	instanceIDs []*string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		instances, err := describeInstancesForEcsCluster(ctx, awsSess, cluster)
+		if err != nil {
+			fmt.Println()
+			return err
+		}
+
+		connected := map[string]bool{}
+		for _, instance := range instances {
+			connected[aws.StringValue(instance.Ec2InstanceId)] = aws.BoolValue(instance.AgentConnected)
+		}
+
+		var notConnected []string
+		for _, id := range instanceIDs {
+			if !connected[*id] {
+				notConnected = append(notConnected, *id)
+			}
+		}
+
+		fmt.Printf("\rECS agents connected: %v/%v ", len(instanceIDs)-len(notConnected), len(instanceIDs))
+		if len(notConnected) == 0 {
+			fmt.Println()
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			fmt.Println()
+			return fmt.Errorf("%w after %s waiting for ECS agents to connect in cluster %q: %s",
+				ErrTimeout, timeout, cluster, strings.Join(notConnected, ", "))
+		}
+
+		if err := aws.SleepWithContext(ctx, asgPollInterval); err != nil {
+			fmt.Println()
+			return ctx.Err()
+		}
+	}
 }
@@ -0,0 +1,1341 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestDescribeEcsServicesForArns(t *testing.T) {
+	mock := &mockEcsClient{}
+	setMockEcsClient(t, mock)
+
+	services, err := DescribeEcsServicesForArns(context.Background(), nil, makeArns("service", 25), "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if mock.describeServicesCalls != 3 {
+		t.Errorf("Expected 3 DescribeServices calls, got %v", mock.describeServicesCalls)
+	}
+	if len(services) != 25 {
+		t.Errorf("Expected 25 services, got %v", len(services))
+	}
+}
+
+func TestDescribeEcsServicesForArnsWithTags(t *testing.T) {
+	arns := makeArns("service", 2)
+	setMockEcsClient(t, &mockEcsClient{services: map[string]*ecs.Service{
+		*arns[0]: {ServiceArn: arns[0], Tags: []*ecs.Tag{{Key: aws.String("team"), Value: aws.String("web")}}},
+		*arns[1]: {ServiceArn: arns[1]},
+	}})
+
+	untagged, err := DescribeEcsServicesForArns(context.Background(), nil, arns, "test")
+	if err != nil || untagged[0].Tags != nil {
+		t.Errorf("Expected no tags without including them, got %v (%v)", untagged[0].Tags, err)
+	}
+
+	tagged, err := DescribeEcsServicesForArns(context.Background(), nil, arns, "test", ecs.ServiceFieldTags)
+	if err != nil || len(tagged[0].Tags) != 1 {
+		t.Errorf("Expected the team tag when including tags, got %v (%v)", tagged[0].Tags, err)
+	}
+}
+
+func TestListServicesForEcsCluster(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Arns     []*string
+		PageSize int
+	}{
+		{
+			Name:     "no services",
+			Arns:     []*string{},
+			PageSize: 10,
+		},
+		{
+			Name:     "single page",
+			Arns:     makeArns("service", 7),
+			PageSize: 10,
+		},
+		{
+			// Pages larger than the DescribeServices limit do not line up with its chunks
+			Name:     "multiple pages",
+			Arns:     makeArns("service", 25),
+			PageSize: 12,
+		},
+	}
+
+	for _, i := range tests {
+		setMockEcsClient(t, &mockEcsClient{serviceArnPages: chunkStrings(i.Arns, i.PageSize)})
+
+		services := ListServicesForEcsCluster(context.Background(), nil, "test")
+		if len(services) != len(i.Arns) {
+			t.Errorf("%s: expected %v services, got %v", i.Name, len(i.Arns), len(services))
+			continue
+		}
+
+		for n, service := range services {
+			if *service.ServiceArn != *i.Arns[n] {
+				t.Errorf("%s: expected service %v to be %s, got %s", i.Name, n, *i.Arns[n], *service.ServiceArn)
+			}
+		}
+	}
+}
+
+func TestListServiceNamesForEcsCluster(t *testing.T) {
+	arns := aws.StringSlice([]string{
+		"arn:aws:ecs:us-east-1:123456789012:service/test/web",
+		"arn:aws:ecs:us-east-1:123456789012:service/worker",
+	})
+	arns = append(arns, makeArns("service/test", 23)...)
+	mock := &mockEcsClient{serviceArnPages: chunkStrings(arns, 10)}
+	setMockEcsClient(t, mock)
+
+	names, err := ListServiceNamesForEcsCluster(context.Background(), nil, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(names) != 25 {
+		t.Fatalf("Expected 25 service names from 3 pages, got %v", len(names))
+	}
+	if names[0] != "web" || names[1] != "worker" || names[24] != "22" {
+		t.Errorf("Expected names taken from the end of each ARN, got %v", names)
+	}
+	if mock.describeServicesCalls != 0 {
+		t.Errorf("Expected no DescribeServices calls, got %v", mock.describeServicesCalls)
+	}
+}
+
+func TestGetInstanceListForEcsCluster(t *testing.T) {
+	arns := makeArns("container-instance", 250)
+	mock := &mockEcsClient{containerInstanceArnPages: chunkStrings(arns, 100)}
+	setMockEcsClient(t, mock)
+
+	instances := GetInstanceListForEcsCluster(context.Background(), nil, "test")
+	if len(instances) != len(arns) {
+		t.Fatalf("Expected %v instances, got %v", len(arns), len(instances))
+	}
+
+	if mock.describeContainerInstancesCalls != 3 {
+		t.Errorf("Expected 3 DescribeContainerInstances calls, got %v", mock.describeContainerInstancesCalls)
+	}
+
+	for i, instance := range instances {
+		if *instance.ContainerInstanceArn != *arns[i] {
+			t.Errorf("Expected instance %v to be %s, got %s", i, *arns[i], *instance.ContainerInstanceArn)
+		}
+	}
+}
+
+func TestGetInstanceIPsForEcsCluster(t *testing.T) {
+	setMockCluster(t, []*ec2.Instance{
+		{InstanceId: aws.String("i-1"), PrivateIpAddress: aws.String("10.0.0.1"), PublicIpAddress: aws.String("54.0.0.1")},
+		{InstanceId: aws.String("i-2"), PrivateIpAddress: aws.String("10.0.0.2")},
+		{InstanceId: aws.String("i-3"), PrivateIpAddress: aws.String("10.0.0.3"), PublicIpAddress: aws.String("54.0.0.3")},
+	})
+
+	privateIPs := GetInstanceIPsForEcsCluster(context.Background(), nil, "test")
+	if strings.Join(privateIPs, " ") != "10.0.0.1 10.0.0.2 10.0.0.3" {
+		t.Errorf("Did not get expected private IPs, got: %v", privateIPs)
+	}
+
+	publicIPs := GetInstancePublicIPsForEcsCluster(context.Background(), nil, "test")
+	if strings.Join(publicIPs, " ") != "54.0.0.1 54.0.0.3" {
+		t.Errorf("Expected instance without a public IP to be skipped, got: %v", publicIPs)
+	}
+}
+
+func TestGetInstanceListForAsg(t *testing.T) {
+	mock := &mockAutoscalingClient{groups: map[string]*autoscaling.Group{
+		"test-asg": {
+			AutoScalingGroupName: aws.String("test-asg"),
+			Instances: []*autoscaling.Instance{
+				{InstanceId: aws.String("i-1")},
+				{InstanceId: aws.String("i-2")},
+			},
+		},
+	}}
+	setMockAutoscalingClient(t, mock)
+
+	instanceIDs, err := GetInstanceListForAsg(context.Background(), nil, "test-asg")
+	if err != nil || strings.Join(aws.StringValueSlice(instanceIDs), ",") != "i-1,i-2" {
+		t.Errorf("Expected i-1 and i-2, got %v (%v)", aws.StringValueSlice(instanceIDs), err)
+	}
+
+	if _, err := GetInstanceListForAsg(context.Background(), nil, "missing-asg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound when no groups are returned, got %v", err)
+	}
+
+	mock.duplicateGroups = true
+	_, err = GetInstanceListForAsg(context.Background(), nil, "test-asg")
+	if err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "Actual: 2") {
+		t.Errorf("Expected an unexpected count error when two groups are returned, got %v", err)
+	}
+}
+
+func TestGetInstanceIPsForEcsClusterBatchesLookups(t *testing.T) {
+	var instances []*ec2.Instance
+	var expected []string
+	for n := 0; n < 500; n++ {
+		ip := fmt.Sprintf("10.0.%v.%v", n/256, n%256)
+		instances = append(instances, &ec2.Instance{
+			InstanceId:       aws.String(fmt.Sprintf("i-%v", n)),
+			PrivateIpAddress: aws.String(ip),
+		})
+		expected = append(expected, ip)
+	}
+	setMockCluster(t, instances)
+	ec2Mock := &mockEc2Client{instances: instances}
+	setMockEc2Client(t, ec2Mock)
+
+	ips := GetInstanceIPsForEcsCluster(context.Background(), nil, "test")
+	if strings.Join(ips, " ") != strings.Join(expected, " ") {
+		t.Errorf("Did not get all 500 IPs in instance order, got %v IPs", len(ips))
+	}
+
+	if ec2Mock.describeInstancesCalls != 3 {
+		t.Errorf("Expected 3 DescribeInstances calls, got %v", ec2Mock.describeInstancesCalls)
+	}
+	if ec2Mock.maxDescribeInstanceIDs > describeInstancesBatchSize {
+		t.Errorf("Expected at most %v IDs per DescribeInstances call, got %v",
+			describeInstancesBatchSize, ec2Mock.maxDescribeInstanceIDs)
+	}
+}
+
+func TestGetInstancesNotUsingAmiBatchesLookups(t *testing.T) {
+	var instances []*ec2.Instance
+	var ids []*string
+	for n := 0; n < 500; n++ {
+		ami := "ami-current"
+		if n%2 == 0 {
+			ami = "ami-old"
+		}
+		instances = append(instances, &ec2.Instance{
+			InstanceId: aws.String(fmt.Sprintf("i-%v", n)),
+			ImageId:    aws.String(ami),
+		})
+		ids = append(ids, instances[n].InstanceId)
+	}
+	ec2Mock := &mockEc2Client{instances: instances}
+	setMockEc2Client(t, ec2Mock)
+
+	outdated, err := GetInstancesNotUsingAmi(context.Background(), nil, ids, "ami-current")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(outdated) != 250 || *outdated[0] != "i-0" || *outdated[249] != "i-498" {
+		t.Errorf("Expected the 250 instances on the old AMI in instance order, got %v", len(outdated))
+	}
+	if ec2Mock.maxDescribeInstanceIDs > describeInstancesBatchSize {
+		t.Errorf("Expected at most %v IDs per DescribeInstances call, got %v",
+			describeInstancesBatchSize, ec2Mock.maxDescribeInstanceIDs)
+	}
+}
+
+func TestWaitForServicesStable(t *testing.T) {
+	arns := makeArns("service", 12)
+	services := map[string]*ecs.Service{}
+	for n, arn := range arns {
+		services[*arn] = &ecs.Service{
+			ServiceArn:   arn,
+			ServiceName:  aws.String(fmt.Sprintf("service-%v", n)),
+			DesiredCount: aws.Int64(2),
+			RunningCount: aws.Int64(2),
+			Deployments:  []*ecs.Deployment{{}},
+		}
+	}
+	services[*arns[11]].RunningCount = aws.Int64(1)
+
+	mock := &mockEcsClient{serviceArnPages: [][]*string{arns}, services: services}
+	setMockEcsClient(t, mock)
+
+	stable, unstable, err := WaitForServicesStable(context.Background(), nil, "test", time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if mock.waitUntilServicesStableCalls != 2 {
+		t.Errorf("Expected services to be waited on in 2 chunks, got %v waiter calls", mock.waitUntilServicesStableCalls)
+	}
+	if len(stable) != 11 {
+		t.Errorf("Expected 11 stable services, got: %v", stable)
+	}
+	if len(unstable) != 1 || unstable[0] != "service-11" {
+		t.Errorf("Expected only service-11 to be unstable, got: %v", unstable)
+	}
+}
+
+func TestWaitForServicesStableFailedRollout(t *testing.T) {
+	arns := makeArns("service", 12)
+	services := map[string]*ecs.Service{}
+	for n, arn := range arns {
+		services[*arn] = &ecs.Service{
+			ServiceArn:   arn,
+			ServiceName:  aws.String(fmt.Sprintf("service-%v", n)),
+			DesiredCount: aws.Int64(2),
+			RunningCount: aws.Int64(2),
+			Deployments:  []*ecs.Deployment{{RolloutState: aws.String(ecs.DeploymentRolloutStateCompleted)}},
+		}
+	}
+	services[*arns[3]].Deployments = []*ecs.Deployment{
+		{RolloutState: aws.String(ecs.DeploymentRolloutStateFailed), RolloutStateReason: aws.String("circuit breaker triggered")},
+		{RolloutState: aws.String(ecs.DeploymentRolloutStateInProgress)},
+	}
+
+	mock := &mockEcsClient{serviceArnPages: [][]*string{arns}, services: services}
+	setMockEcsClient(t, mock)
+
+	_, _, err := WaitForServicesStable(context.Background(), nil, "test", time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "service-3 (circuit breaker triggered)") {
+		t.Errorf("Expected an error naming the failed service, got: %v", err)
+	}
+	if mock.waitUntilServicesStableCalls != 1 {
+		t.Errorf("Expected to stop after the first chunk, got %v waiter calls", mock.waitUntilServicesStableCalls)
+	}
+
+	failed, err := GetFailedDeployments(context.Background(), nil, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(failed) != 1 || failed[0] != "service-3" {
+		t.Errorf("Expected only service-3 to have a failed deployment, got: %v", failed)
+	}
+}
+
+func TestFailOnFailedRolloutAcceptor(t *testing.T) {
+	w := &request.Waiter{}
+	failOnFailedRollout(w)
+
+	if len(w.Acceptors) != 1 || w.Acceptors[0].State != request.FailureWaiterState {
+		t.Fatalf("Expected a failure acceptor to be added, got %v", w.Acceptors)
+	}
+
+	acceptor := w.Acceptors[0]
+	if acceptor.Matcher != request.PathAnyWaiterMatch || acceptor.Argument != "services[].deployments[].rolloutState" ||
+		acceptor.Expected != ecs.DeploymentRolloutStateFailed {
+		t.Errorf("Expected the acceptor to match any FAILED deployment rollout, got %v", acceptor)
+	}
+}
+
+func TestDescribeEcsService(t *testing.T) {
+	setMockEcsClient(t, &mockEcsClient{services: map[string]*ecs.Service{
+		"web":    {ServiceName: aws.String("web"), Status: aws.String("ACTIVE")},
+		"legacy": {ServiceName: aws.String("legacy"), Status: aws.String("INACTIVE")},
+	}})
+
+	if _, err := DescribeEcsService(context.Background(), nil, "test", "web"); err != nil {
+		t.Errorf("Unexpected error for active service: %s", err)
+	}
+
+	if _, err := DescribeEcsService(context.Background(), nil, "test", "legacy"); err == nil {
+		t.Error("Expected error for inactive service")
+	}
+}
+
+func TestGetEcsServiceByName(t *testing.T) {
+	mock := &mockEcsClient{services: map[string]*ecs.Service{
+		"web":      {ServiceName: aws.String("web"), Status: aws.String("ACTIVE")},
+		"retiring": {ServiceName: aws.String("retiring"), Status: aws.String("DRAINING")},
+		"legacy":   {ServiceName: aws.String("legacy"), Status: aws.String("INACTIVE")},
+	}}
+	setMockEcsClient(t, mock)
+
+	service, err := GetEcsServiceByName(context.Background(), nil, "test", "web")
+	if err != nil {
+		t.Fatalf("Unexpected error for active service: %s", err)
+	}
+	if *service.ServiceName != "web" || mock.describeServicesCalls != 1 {
+		t.Errorf("Expected web from a single DescribeServices call, got %s after %v calls", *service.ServiceName, mock.describeServicesCalls)
+	}
+
+	for _, name := range []string{"retiring", "legacy", "missing"} {
+		if _, err := GetEcsServiceByName(context.Background(), nil, "test", name); err == nil {
+			t.Errorf("Expected not found error for %s service", name)
+		}
+	}
+}
+
+func TestGetServiceDeploymentStatus(t *testing.T) {
+	deployment := func(status, rolloutState string, desired, running, pending int64) *ecs.Deployment {
+		return &ecs.Deployment{
+			Status:         aws.String(status),
+			TaskDefinition: aws.String("web:" + status),
+			RolloutState:   aws.String(rolloutState),
+			DesiredCount:   aws.Int64(desired),
+			RunningCount:   aws.Int64(running),
+			PendingCount:   aws.Int64(pending),
+		}
+	}
+	service := func(deployments ...*ecs.Deployment) *ecs.Service {
+		return &ecs.Service{ServiceName: aws.String("web"), Status: aws.String("ACTIVE"), Deployments: deployments}
+	}
+
+	tests := []struct {
+		Name             string
+		Service          *ecs.Service
+		TaskDefinition   string
+		ExpectedComplete bool
+		ExpectedFailed   bool
+	}{
+		{
+			Name:             "completed rollout",
+			Service:          service(deployment("PRIMARY", "COMPLETED", 3, 3, 0)),
+			ExpectedComplete: true,
+		},
+		{
+			Name:    "rollout in progress",
+			Service: service(deployment("PRIMARY", "IN_PROGRESS", 3, 1, 2), deployment("ACTIVE", "COMPLETED", 3, 3, 0)),
+		},
+		{
+			Name:           "failed rollout",
+			Service:        service(deployment("PRIMARY", "FAILED", 3, 0, 0), deployment("ACTIVE", "COMPLETED", 3, 3, 0)),
+			ExpectedFailed: true,
+		},
+		{
+			Name:             "no rollout state and steady",
+			Service:          service(deployment("PRIMARY", "", 2, 2, 0)),
+			ExpectedComplete: true,
+		},
+		{
+			Name:    "no rollout state and old deployment still running",
+			Service: service(deployment("PRIMARY", "", 2, 2, 0), deployment("ACTIVE", "", 2, 1, 0)),
+		},
+		{
+			Name:           "circuit breaker rolling back",
+			Service:        service(deployment("PRIMARY", "IN_PROGRESS", 3, 1, 2), deployment("ACTIVE", "FAILED", 3, 0, 0)),
+			ExpectedFailed: true,
+		},
+		{
+			Name:           "rolled back to the previous task definition",
+			Service:        service(deployment("PRIMARY", "COMPLETED", 3, 3, 0)),
+			TaskDefinition: "web:2",
+			ExpectedFailed: true,
+		},
+		{
+			Name:             "expected task definition deployed",
+			Service:          service(deployment("PRIMARY", "COMPLETED", 3, 3, 0)),
+			TaskDefinition:   "web:PRIMARY",
+			ExpectedComplete: true,
+		},
+	}
+
+	for _, i := range tests {
+		setMockEcsClient(t, &mockEcsClient{services: map[string]*ecs.Service{"web": i.Service}})
+
+		status, err := GetServiceDeploymentStatus(context.Background(), nil, "test", "web", i.TaskDefinition)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", i.Name, err)
+			continue
+		}
+		if status.Complete != i.ExpectedComplete || status.Failed != i.ExpectedFailed {
+			t.Errorf("%s: expected complete %v and failed %v, got %+v", i.Name, i.ExpectedComplete, i.ExpectedFailed, status)
+		}
+		if status.TaskDefinition != "web:PRIMARY" {
+			t.Errorf("%s: expected the primary deployment to be reported, got %s", i.Name, status.TaskDefinition)
+		}
+	}
+}
+
+func TestUpdateServiceDesiredCount(t *testing.T) {
+	mock := &mockEcsClient{}
+	setMockEcsClient(t, mock)
+
+	if err := UpdateServiceDesiredCount(context.Background(), nil, "test", "web", -1); err == nil {
+		t.Error("Expected error for negative desired count")
+	}
+
+	if err := UpdateServiceDesiredCount(context.Background(), nil, "test", "web", 3); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(mock.updateServiceInputs) != 1 || *mock.updateServiceInputs[0].DesiredCount != 3 {
+		t.Errorf("Expected a single UpdateService call with desired count 3, got: %v", mock.updateServiceInputs)
+	}
+}
+
+func TestForceNewServiceDeployment(t *testing.T) {
+	mock := &mockEcsClient{services: map[string]*ecs.Service{
+		"web": {
+			ServiceName: aws.String("web"),
+			Deployments: []*ecs.Deployment{
+				{Id: aws.String("ecs-svc/2"), Status: aws.String("PRIMARY")},
+				{Id: aws.String("ecs-svc/1"), Status: aws.String("ACTIVE")},
+			},
+		},
+	}}
+	setMockEcsClient(t, mock)
+
+	id, err := ForceNewServiceDeployment(context.Background(), nil, "test", "web")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if id != "ecs-svc/2" {
+		t.Errorf("Expected primary deployment ID ecs-svc/2, got %s", id)
+	}
+	if len(mock.updateServiceInputs) != 1 || !*mock.updateServiceInputs[0].ForceNewDeployment {
+		t.Errorf("Expected a single UpdateService call forcing a new deployment, got: %v", mock.updateServiceInputs)
+	}
+}
+
+func TestForceNewServiceDeploymentWithoutPrimary(t *testing.T) {
+	// The restart was accepted even though no primary deployment is reported yet
+	setMockEcsClient(t, &mockEcsClient{services: map[string]*ecs.Service{
+		"web": {ServiceName: aws.String("web"), Status: aws.String("ACTIVE")},
+	}})
+
+	id, err := ForceNewServiceDeployment(context.Background(), nil, "test", "web")
+	if err != nil || id != "" {
+		t.Errorf("Expected success without a deployment ID, got %q (%v)", id, err)
+	}
+}
+
+func TestGetInstanceUtilizationForEcsCluster(t *testing.T) {
+	instances := []*ec2.Instance{
+		{InstanceId: aws.String("i-1"), PrivateIpAddress: aws.String("10.0.0.1"), ImageId: aws.String("ami-1"),
+			Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")}},
+		{InstanceId: aws.String("i-2"), PrivateIpAddress: aws.String("10.0.0.2"), ImageId: aws.String("ami-2")},
+	}
+	mock := setMockCluster(t, instances)
+	remaining := map[string]int64{"i-1": 1500, "i-2": 200}
+	for _, instance := range mock.containerInstances {
+		instance.RunningTasksCount = aws.Int64(3)
+		instance.RegisteredResources = []*ecs.Resource{
+			{Name: aws.String("CPU"), IntegerValue: aws.Int64(1024)},
+			{Name: aws.String("MEMORY"), IntegerValue: aws.Int64(1993)},
+		}
+		instance.RemainingResources = []*ecs.Resource{
+			{Name: aws.String("CPU"), IntegerValue: aws.Int64(512)},
+			{Name: aws.String("MEMORY"), IntegerValue: aws.Int64(remaining[*instance.Ec2InstanceId])},
+		}
+	}
+
+	utilization, err := GetInstanceUtilizationForEcsCluster(context.Background(), nil, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(utilization) != 2 {
+		t.Fatalf("Expected 2 instances, got %v", len(utilization))
+	}
+
+	if utilization[0].InstanceID != "i-2" || utilization[0].RemainingMemory != 200 {
+		t.Errorf("Expected the instance with the least remaining memory first, got: %+v", utilization[0])
+	}
+
+	if utilization[1].AmiID != "ami-1" || utilization[1].PrivateIP != "10.0.0.1" || utilization[1].RegisteredMemory != 1993 ||
+		utilization[1].AvailabilityZone != "us-east-1a" {
+		t.Errorf("Did not get expected details for i-1, got: %+v", utilization[1])
+	}
+}
+
+func TestFilterInstancesByTag(t *testing.T) {
+	tag := func(key, value string) []*ec2.Tag {
+		return []*ec2.Tag{{Key: aws.String(key), Value: aws.String(value)}}
+	}
+
+	setMockEc2Client(t, &mockEc2Client{instances: []*ec2.Instance{
+		{InstanceId: aws.String("i-1"), Tags: tag("Maintenance", "skip")},
+		{InstanceId: aws.String("i-2"), Tags: tag("Maintenance", "allow")},
+		{InstanceId: aws.String("i-3")},
+	}})
+
+	ids := aws.StringSlice([]string{"i-1", "i-2", "i-3"})
+
+	included, err := FilterInstancesByTag(context.Background(), nil, ids, "Maintenance", "skip", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Join(aws.StringValueSlice(included), " ") != "i-1" {
+		t.Errorf("Expected only i-1 to match Maintenance=skip, got: %v", aws.StringValueSlice(included))
+	}
+
+	excluded, err := FilterInstancesByTag(context.Background(), nil, ids, "Maintenance", "skip", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if strings.Join(aws.StringValueSlice(excluded), " ") != "i-2 i-3" {
+		t.Errorf("Expected i-2 and i-3 to not match Maintenance=skip, got: %v", aws.StringValueSlice(excluded))
+	}
+}
+
+func TestIsFargateCluster(t *testing.T) {
+	setMockEcsClient(t, &mockEcsClient{})
+	if !IsFargateCluster(context.Background(), nil, "test") {
+		t.Error("Expected cluster without container instances to be Fargate")
+	}
+
+	setMockEcsClient(t, &mockEcsClient{containerInstanceArnPages: [][]*string{makeArns("container-instance", 1)}})
+	if IsFargateCluster(context.Background(), nil, "test") {
+		t.Error("Expected cluster with container instances not to be Fargate")
+	}
+}
+
+func TestRightSizeAsgForFargateCluster(t *testing.T) {
+	// With no container instances no ASG or EC2 calls should be made, the nil
+	// embedded interfaces in the mock would panic if they were
+	setMockEcsClient(t, &mockEcsClient{})
+
+	_, err := RightSizeAsgForEcsCluster(context.Background(), nil, "test", RightSizeOptions{})
+	if err != nil {
+		t.Errorf("Expected no error right sizing a Fargate cluster, got: %s", err)
+	}
+}
+
+func TestRightSizeOutput(t *testing.T) {
+	setMockEcsClient(t, &mockEcsClient{})
+
+	var out bytes.Buffer
+	plan, err := RightSizeAsgForEcsCluster(context.Background(), nil, "test", RightSizeOptions{Out: &out})
+	if err != nil || plan.Change {
+		t.Errorf("Expected a Fargate cluster to be left alone, got %+v (%v)", plan, err)
+	}
+	if !strings.Contains(out.String(), "Fargate clusters don't require right-sizing") {
+		t.Errorf("Expected progress to be written to Out, got %q", out.String())
+	}
+}
+
+func TestTasksNeededForEcsServicesError(t *testing.T) {
+	setMockEcsClient(t, &mockEcsClient{})
+
+	services := []*ecs.Service{{DesiredCount: aws.Int64(1), TaskDefinition: aws.String("missing")}}
+	if _, err := tasksNeededForEcsServices(context.Background(), nil, services); err == nil {
+		t.Error("Expected an error for a task definition that can't be described")
+	}
+}
+
+func TestPlanRightSizeForFargateCluster(t *testing.T) {
+	setMockEcsClient(t, &mockEcsClient{})
+
+	plan, err := PlanRightSize(context.Background(), nil, "test", RightSizeOptions{})
+	if err != nil || plan.Change || plan.Reason == "" {
+		t.Errorf("Expected a plan without changes and a reason for a Fargate cluster, got %+v (%v)", plan, err)
+	}
+}
+
+func TestApplyRightSize(t *testing.T) {
+	mock := &mockAutoscalingClient{groups: map[string]*autoscaling.Group{
+		"test-asg": {
+			AutoScalingGroupName: aws.String("test-asg"),
+			DesiredCapacity:      aws.Int64(4),
+			MinSize:              aws.Int64(4),
+			MaxSize:              aws.Int64(4),
+		},
+	}}
+	setMockAutoscalingClient(t, mock)
+
+	plan := &RightSizePlan{Cluster: "test", AsgName: "test-asg", Desired: 2, Min: 2, Max: 3, Change: true,
+		CurrentDesired: 3, CurrentMin: 3, CurrentMax: 3}
+	if err := ApplyRightSize(context.Background(), nil, plan); err == nil {
+		t.Error("Expected an error applying a plan made before the ASG capacity changed")
+	}
+	if len(mock.updateAutoScalingGroupInputs) != 0 {
+		t.Error("Expected the ASG not to be updated by a stale plan")
+	}
+
+	plan.Change = false
+	plan.CurrentDesired, plan.CurrentMin, plan.CurrentMax = 4, 4, 4
+	if err := ApplyRightSize(context.Background(), nil, plan); err != nil || len(mock.updateAutoScalingGroupInputs) != 0 {
+		t.Errorf("Expected a plan without changes to be skipped, got %v", err)
+	}
+
+	plan.Change = true
+	if err := ApplyRightSize(context.Background(), nil, plan); err != nil {
+		t.Fatalf("Expected no error applying the plan, got: %s", err)
+	}
+	group := mock.groups["test-asg"]
+	if aws.Int64Value(group.DesiredCapacity) != 2 || aws.Int64Value(group.MinSize) != 2 || aws.Int64Value(group.MaxSize) != 3 {
+		t.Errorf("Expected ASG scaled to desired = 2, min = 2, max = 3, got %v, %v, %v", aws.Int64Value(group.DesiredCapacity),
+			aws.Int64Value(group.MinSize), aws.Int64Value(group.MaxSize))
+	}
+}
+
+func TestTasksNeededForEcsServices(t *testing.T) {
+	taskDefinitions := map[string]*ecs.TaskDefinition{
+		"small": {
+			ContainerDefinitions: []*ecs.ContainerDefinition{
+				{Memory: aws.Int64(256), Cpu: aws.Int64(128)},
+			},
+		},
+		"large": {
+			ContainerDefinitions: []*ecs.ContainerDefinition{
+				{Memory: aws.Int64(1024), Cpu: aws.Int64(512)},
+				{Memory: aws.Int64(512), Cpu: aws.Int64(256)},
+			},
+		},
+	}
+
+	noSurge := &ecs.DeploymentConfiguration{MaximumPercent: aws.Int64(100)}
+
+	tests := []struct {
+		Name           string
+		Services       []*ecs.Service
+		ExpectedMemory int64
+		ExpectedCPU    int64
+	}{
+		{
+			Name:           "no services",
+			Services:       []*ecs.Service{},
+			ExpectedMemory: 0,
+			ExpectedCPU:    0,
+		},
+		{
+			Name: "single service",
+			Services: []*ecs.Service{
+				{DesiredCount: aws.Int64(2), TaskDefinition: aws.String("small"), DeploymentConfiguration: noSurge},
+			},
+			ExpectedMemory: 3 * 256,
+			ExpectedCPU:    3 * 128,
+		},
+		{
+			Name: "services with desired count of zero are skipped",
+			Services: []*ecs.Service{
+				{DesiredCount: aws.Int64(2), TaskDefinition: aws.String("small"), DeploymentConfiguration: noSurge},
+				{DesiredCount: aws.Int64(0), TaskDefinition: aws.String("large"), DeploymentConfiguration: noSurge},
+			},
+			ExpectedMemory: 3 * 256,
+			ExpectedCPU:    3 * 128,
+		},
+		{
+			Name: "extra capacity is for the largest service",
+			Services: []*ecs.Service{
+				{DesiredCount: aws.Int64(4), TaskDefinition: aws.String("small"), DeploymentConfiguration: noSurge},
+				{DesiredCount: aws.Int64(1), TaskDefinition: aws.String("large"), DeploymentConfiguration: noSurge},
+			},
+			ExpectedMemory: 4*256 + 2*1536,
+			ExpectedCPU:    4*128 + 2*768,
+		},
+		{
+			Name: "peak deployment count is used",
+			Services: []*ecs.Service{
+				{
+					DesiredCount:            aws.Int64(3),
+					TaskDefinition:          aws.String("small"),
+					DeploymentConfiguration: &ecs.DeploymentConfiguration{MaximumPercent: aws.Int64(150)},
+				},
+			},
+			ExpectedMemory: 5*256 + 256,
+			ExpectedCPU:    5*128 + 128,
+		},
+	}
+
+	for _, i := range tests {
+		setMockEcsClient(t, &mockEcsClient{taskDefinitions: taskDefinitions})
+
+		tasks, err := tasksNeededForEcsServices(context.Background(), nil, i.Services)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", i.Name, err)
+		}
+		var memory, cpu int64
+		for _, task := range tasks {
+			memory += task.MemoryMb
+			cpu += task.CPUUnits
+		}
+		if memory != i.ExpectedMemory || cpu != i.ExpectedCPU {
+			t.Errorf("%s: expected %v memory and %v cpu, got %v memory and %v cpu",
+				i.Name, i.ExpectedMemory, i.ExpectedCPU, memory, cpu)
+		}
+	}
+}
+
+func TestGetPeakTaskCountForService(t *testing.T) {
+	tests := []struct {
+		DesiredCount  int64
+		MaxPercent    *int64
+		ExpectedCount int64
+	}{
+		{DesiredCount: 4, MaxPercent: nil, ExpectedCount: 8},
+		{DesiredCount: 4, MaxPercent: aws.Int64(100), ExpectedCount: 4},
+		{DesiredCount: 3, MaxPercent: aws.Int64(150), ExpectedCount: 5},
+		{DesiredCount: 1, MaxPercent: aws.Int64(200), ExpectedCount: 2},
+		{DesiredCount: 0, MaxPercent: aws.Int64(200), ExpectedCount: 0},
+	}
+
+	for _, i := range tests {
+		service := &ecs.Service{
+			DesiredCount:            aws.Int64(i.DesiredCount),
+			DeploymentConfiguration: &ecs.DeploymentConfiguration{MaximumPercent: i.MaxPercent},
+		}
+
+		count := GetPeakTaskCountForService(service)
+		if count != i.ExpectedCount {
+			t.Errorf("Expected peak of %v for desired count %v, got %v", i.ExpectedCount, i.DesiredCount, count)
+		}
+	}
+}
+
+func TestGetMemoryCpuForTaskDefinition(t *testing.T) {
+	tests := []struct {
+		Name           string
+		TaskDefinition *ecs.TaskDefinition
+		ExpectedMemory int64
+		ExpectedCPU    int64
+	}{
+		{
+			Name: "container level only",
+			TaskDefinition: &ecs.TaskDefinition{
+				ContainerDefinitions: []*ecs.ContainerDefinition{
+					{Memory: aws.Int64(256), Cpu: aws.Int64(128)},
+					{Memory: aws.Int64(512), Cpu: aws.Int64(256)},
+				},
+			},
+			ExpectedMemory: 768,
+			ExpectedCPU:    384,
+		},
+		{
+			Name: "container soft limit only",
+			TaskDefinition: &ecs.TaskDefinition{
+				ContainerDefinitions: []*ecs.ContainerDefinition{
+					{MemoryReservation: aws.Int64(256), Cpu: aws.Int64(128)},
+					{MemoryReservation: aws.Int64(512), Cpu: aws.Int64(256)},
+				},
+			},
+			ExpectedMemory: 768,
+			ExpectedCPU:    384,
+		},
+		{
+			Name: "container hard and soft limits use the larger",
+			TaskDefinition: &ecs.TaskDefinition{
+				ContainerDefinitions: []*ecs.ContainerDefinition{
+					{Memory: aws.Int64(512), MemoryReservation: aws.Int64(256), Cpu: aws.Int64(128)},
+					{Memory: aws.Int64(128), MemoryReservation: aws.Int64(384), Cpu: aws.Int64(256)},
+				},
+			},
+			ExpectedMemory: 896,
+			ExpectedCPU:    384,
+		},
+		{
+			Name: "containers mixing hard only and soft only limits",
+			TaskDefinition: &ecs.TaskDefinition{
+				ContainerDefinitions: []*ecs.ContainerDefinition{
+					{Memory: aws.Int64(256), Cpu: aws.Int64(128)},
+					{MemoryReservation: aws.Int64(512), Cpu: aws.Int64(256)},
+				},
+			},
+			ExpectedMemory: 768,
+			ExpectedCPU:    384,
+		},
+		{
+			Name: "task level only",
+			TaskDefinition: &ecs.TaskDefinition{
+				Memory: aws.String("2048"),
+				Cpu:    aws.String("1024"),
+				ContainerDefinitions: []*ecs.ContainerDefinition{
+					{},
+					{},
+				},
+			},
+			ExpectedMemory: 2048,
+			ExpectedCPU:    1024,
+		},
+		{
+			Name: "task level with units",
+			TaskDefinition: &ecs.TaskDefinition{
+				Memory:               aws.String("0.5 GB"),
+				Cpu:                  aws.String("2 vCPU"),
+				ContainerDefinitions: []*ecs.ContainerDefinition{{}},
+			},
+			ExpectedMemory: 512,
+			ExpectedCPU:    2048,
+		},
+		{
+			Name: "task level preferred over container level",
+			TaskDefinition: &ecs.TaskDefinition{
+				Memory: aws.String("1024"),
+				Cpu:    aws.String("512"),
+				ContainerDefinitions: []*ecs.ContainerDefinition{
+					{Memory: aws.Int64(256), Cpu: aws.Int64(128)},
+				},
+			},
+			ExpectedMemory: 1024,
+			ExpectedCPU:    512,
+		},
+		{
+			Name: "mixed task level memory and container level cpu",
+			TaskDefinition: &ecs.TaskDefinition{
+				Memory: aws.String("1024"),
+				ContainerDefinitions: []*ecs.ContainerDefinition{
+					{Cpu: aws.Int64(128)},
+					{Memory: aws.Int64(256), Cpu: aws.Int64(64)},
+				},
+			},
+			ExpectedMemory: 1024,
+			ExpectedCPU:    192,
+		},
+	}
+
+	for _, i := range tests {
+		memory, cpu := GetMemoryCpuForTaskDefinition(i.TaskDefinition)
+		if memory != i.ExpectedMemory || cpu != i.ExpectedCPU {
+			t.Errorf("%s: expected %v memory and %v cpu, got %v memory and %v cpu",
+				i.Name, i.ExpectedMemory, i.ExpectedCPU, memory, cpu)
+		}
+	}
+}
+
+func TestGetRegisteredCapacityForInstanceType(t *testing.T) {
+	instance := func(instanceType string, memory, cpu int64) *ecs.ContainerInstance {
+		return &ecs.ContainerInstance{
+			Attributes: []*ecs.Attribute{
+				{Name: aws.String("ecs.instance-type"), Value: aws.String(instanceType)},
+			},
+			RegisteredResources: []*ecs.Resource{
+				{Name: aws.String("CPU"), IntegerValue: aws.Int64(cpu)},
+				{Name: aws.String("MEMORY"), IntegerValue: aws.Int64(memory)},
+			},
+			RemainingResources: []*ecs.Resource{
+				{Name: aws.String("CPU"), IntegerValue: aws.Int64(0)},
+				{Name: aws.String("MEMORY"), IntegerValue: aws.Int64(0)},
+			},
+		}
+	}
+
+	instances := []*ecs.ContainerInstance{
+		instance("t2.small", 1993, 1024),
+		instance("t2.small", 1985, 1024),
+		instance("t2.medium", 3942, 2048),
+	}
+
+	capacity, found := GetRegisteredCapacityForInstanceType(instances, "t2.small")
+	if !found {
+		t.Fatal("Expected registered capacity to be found for t2.small")
+	}
+	if capacity.MemoryMb != 1985 || capacity.CPUUnits != 1024 {
+		t.Errorf("Expected smallest registered capacity, got: %+v", capacity)
+	}
+
+	if _, found := GetRegisteredCapacityForInstanceType(instances, "t2.large"); found {
+		t.Error("Did not expect registered capacity for t2.large")
+	}
+}
+
+func TestGetCapacityProvidersForCluster(t *testing.T) {
+	asgProvider := func(asgName, status string) *ecs.AutoScalingGroupProvider {
+		return &ecs.AutoScalingGroupProvider{
+			AutoScalingGroupArn: aws.String("arn:aws:autoscaling:us-east-1:123456789012:autoScalingGroup:uuid:autoScalingGroupName/" + asgName),
+			ManagedScaling: &ecs.ManagedScaling{
+				Status:         aws.String(status),
+				TargetCapacity: aws.Int64(100),
+			},
+		}
+	}
+
+	setMockEcsClient(t, &mockEcsClient{
+		clusters: map[string]*ecs.Cluster{
+			"test": {
+				ClusterName:       aws.String("test"),
+				CapacityProviders: aws.StringSlice([]string{"FARGATE", "managed", "unmanaged"}),
+			},
+		},
+		capacityProviders: map[string]*ecs.CapacityProvider{
+			"managed":   {Name: aws.String("managed"), AutoScalingGroupProvider: asgProvider("managed-asg", ecs.ManagedScalingStatusEnabled)},
+			"unmanaged": {Name: aws.String("unmanaged"), AutoScalingGroupProvider: asgProvider("unmanaged-asg", ecs.ManagedScalingStatusDisabled)},
+		},
+	})
+
+	providers, err := GetCapacityProvidersForCluster(context.Background(), nil, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(providers) != 2 {
+		t.Fatalf("Expected 2 capacity providers excluding FARGATE, got %v", len(providers))
+	}
+
+	provider := GetManagedCapacityProviderForAsg(providers, "managed-asg")
+	if provider == nil || *provider.Name != "managed" {
+		t.Errorf("Expected managed-asg to be scaled by the managed capacity provider, got: %v", provider)
+	}
+
+	for _, asgName := range []string{"unmanaged-asg", "other-asg", "managed"} {
+		if provider := GetManagedCapacityProviderForAsg(providers, asgName); provider != nil {
+			t.Errorf("Did not expect %s to be scaled by a capacity provider, got: %s", asgName, *provider.Name)
+		}
+	}
+}
+
+func TestFilterServicesByTags(t *testing.T) {
+	makeService := func(name string, tags map[string]string) *ecs.Service {
+		service := &ecs.Service{ServiceName: aws.String(name)}
+		for key, value := range tags {
+			service.Tags = append(service.Tags, &ecs.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+		return service
+	}
+	services := []*ecs.Service{
+		makeService("web", map[string]string{"team": "web", "env": "prod"}),
+		makeService("web-staging", map[string]string{"team": "web", "env": "staging"}),
+		makeService("worker", map[string]string{"team": "data", "env": "prod"}),
+		makeService("legacy", nil),
+	}
+
+	tests := []struct {
+		Tags     map[string]string
+		Expected []string
+	}{
+		{Tags: map[string]string{"team": "web"}, Expected: []string{"web", "web-staging"}},
+		{Tags: map[string]string{"team": "web", "env": "prod"}, Expected: []string{"web"}},
+		{Tags: map[string]string{"team": "ops"}, Expected: []string{}},
+		{Tags: map[string]string{}, Expected: []string{"web", "web-staging", "worker", "legacy"}},
+	}
+
+	for _, i := range tests {
+		var names []string
+		for _, service := range FilterServicesByTags(services, i.Tags) {
+			names = append(names, *service.ServiceName)
+		}
+		if strings.Join(names, ",") != strings.Join(i.Expected, ",") {
+			t.Errorf("Expected %v for %v, got %v", i.Expected, i.Tags, names)
+		}
+	}
+}
+
+func TestNormalizeClusterIdentifier(t *testing.T) {
+	tests := []struct {
+		Identifier string
+		Expected   string
+	}{
+		{Identifier: "foo", Expected: "foo"},
+		{Identifier: "arn:aws:ecs:us-east-1:123456789012:cluster/foo", Expected: "foo"},
+		{Identifier: "arn:aws-us-gov:ecs:us-gov-west-1:123456789012:cluster/foo-bar", Expected: "foo-bar"},
+		{Identifier: "arn:aws:ecs:us-east-1:123456789012:service/foo", Expected: "arn:aws:ecs:us-east-1:123456789012:service/foo"},
+		{Identifier: "", Expected: ""},
+	}
+
+	for _, i := range tests {
+		result := NormalizeClusterIdentifier(i.Identifier)
+		if result != i.Expected {
+			t.Errorf("Expected %q for %q, got %q", i.Expected, i.Identifier, result)
+		}
+	}
+}
+
+func TestRegionFromArn(t *testing.T) {
+	tests := []struct {
+		Arn      string
+		Expected string
+		Error    bool
+	}{
+		{Arn: "arn:aws:ecs:us-east-1:123456789012:cluster/foo", Expected: "us-east-1"},
+		{Arn: "arn:aws-us-gov:ecs:us-gov-west-1:123456789012:cluster/foo", Expected: "us-gov-west-1"},
+		{Arn: "arn:aws:iam::123456789012:role/foo", Error: true},
+		{Arn: "foo", Error: true},
+		{Arn: "", Error: true},
+	}
+
+	for _, i := range tests {
+		region, err := RegionFromArn(i.Arn)
+		if (err != nil) != i.Error || region != i.Expected {
+			t.Errorf("Expected %q and error %v for %q, got %q (%v)", i.Expected, i.Error, i.Arn, region, err)
+		}
+	}
+}
+
+func TestHandleEcsError(t *testing.T) {
+	codes := []string{
+		ecs.ErrCodeServerException,
+		ecs.ErrCodeClientException,
+		ecs.ErrCodeInvalidParameterException,
+		ecs.ErrCodeClusterNotFoundException,
+		ecs.ErrCodeServiceNotFoundException,
+		ecs.ErrCodeServiceNotActiveException,
+		ecs.ErrCodeAccessDeniedException,
+		"SomeOtherException",
+	}
+
+	for _, code := range codes {
+		err := handleEcsError(awserr.New(code, "test message", nil))
+		if !strings.Contains(err.Error(), code) {
+			t.Errorf("Expected error for %s to include the code, got: %s", code, err)
+		}
+		if !strings.Contains(err.Error(), "test message") {
+			t.Errorf("Expected error for %s to include the message, got: %s", code, err)
+		}
+	}
+
+	plainErr := fmt.Errorf("not an aws error")
+	if handleEcsError(plainErr) != plainErr {
+		t.Error("Expected non-AWS errors to be returned unchanged")
+	}
+}
+
+func TestGetRunningTasksForInstance(t *testing.T) {
+	tasks := map[string]*ecs.Task{}
+	task := func(arn, instance, group, desired string) {
+		tasks[arn] = &ecs.Task{
+			TaskArn:              aws.String(arn),
+			ContainerInstanceArn: aws.String(instance),
+			Group:                aws.String(group),
+			TaskDefinitionArn:    aws.String("td-" + group),
+			DesiredStatus:        aws.String(desired),
+			LastStatus:           aws.String(ecs.DesiredStatusRunning),
+		}
+	}
+	for n := 0; n < 150; n++ {
+		task(fmt.Sprintf("task-web-%03d", n), "ci-1", "service:web", ecs.DesiredStatusRunning)
+	}
+	task("task-api", "ci-1", "service:api", ecs.DesiredStatusRunning)
+	task("task-stopping", "ci-1", "service:api", ecs.DesiredStatusStopped)
+	task("task-other", "ci-2", "service:api", ecs.DesiredStatusRunning)
+	setMockEcsClient(t, &mockEcsClient{tasks: tasks})
+
+	running, err := GetRunningTasksForInstance(context.Background(), nil, "test", "ci-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(running) != 151 {
+		t.Fatalf("Expected 151 running tasks on the instance, got %v", len(running))
+	}
+	if running[0].TaskArn != "task-api" || running[0].TaskDefinition != "td-service:api" {
+		t.Errorf("Expected tasks sorted by group, got %v first", running[0])
+	}
+}
+
+func TestGetClusterSummaries(t *testing.T) {
+	clusters := map[string]*ecs.Cluster{}
+	for n := 0; n < 250; n++ {
+		arn := fmt.Sprintf("arn:aws:ecs:us-east-1:123456789012:cluster/cluster-%03d", n)
+		clusters[arn] = &ecs.Cluster{
+			ClusterArn:                        aws.String(arn),
+			ClusterName:                       aws.String(fmt.Sprintf("cluster-%03d", n)),
+			Status:                            aws.String("ACTIVE"),
+			RegisteredContainerInstancesCount: aws.Int64(3),
+			ActiveServicesCount:               aws.Int64(2),
+			RunningTasksCount:                 aws.Int64(int64(n)),
+			PendingTasksCount:                 aws.Int64(1),
+		}
+	}
+	setMockEcsClient(t, &mockEcsClient{clusters: clusters})
+
+	summaries, err := GetClusterSummaries(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(summaries) != 250 {
+		t.Fatalf("Expected clusters from every page, got %v", len(summaries))
+	}
+	last := summaries[249]
+	if last.ClusterName != "cluster-249" || last.RunningTasks != 249 || last.RegisteredContainerInstances != 3 ||
+		last.ActiveServices != 2 || last.PendingTasks != 1 {
+		t.Errorf("Did not get expected summary for the last cluster, got %+v", last)
+	}
+}
+
+func TestGetPendingEcsTasksCount(t *testing.T) {
+	arns := makeArns("service", 25)
+	services := map[string]*ecs.Service{}
+	for n, arn := range arns {
+		services[*arn] = &ecs.Service{
+			ServiceArn:   arn,
+			ServiceName:  aws.String(fmt.Sprintf("service-%v", n)),
+			PendingCount: aws.Int64(int64(n % 3)),
+		}
+	}
+
+	// Three ListServices pages, each more than one DescribeServices call
+	mock := &mockEcsClient{
+		serviceArnPages: [][]*string{arns[:12], arns[12:24], arns[24:]},
+		services:        services,
+	}
+	setMockEcsClient(t, mock)
+
+	pending := GetPendingEcsTasksCount(context.Background(), nil, "test")
+	if pending != 24 {
+		t.Errorf("Expected 24 pending tasks across all pages, got %v", pending)
+	}
+
+	if len(GetServicesWithPendingTasks(context.Background(), nil, "test")) != 16 {
+		t.Error("Expected 16 services with pending tasks across all pages")
+	}
+}
+
+func TestGetClusterResourceUtilization(t *testing.T) {
+	mock := setMockCluster(t, []*ec2.Instance{{InstanceId: aws.String("i-1")}, {InstanceId: aws.String("i-2")}})
+	for _, instance := range mock.containerInstances {
+		instance.RegisteredResources = []*ecs.Resource{
+			{Name: aws.String("CPU"), IntegerValue: aws.Int64(1024)},
+			{Name: aws.String("MEMORY"), IntegerValue: aws.Int64(2000)},
+		}
+		instance.RemainingResources = []*ecs.Resource{
+			{Name: aws.String("CPU"), IntegerValue: aws.Int64(512)},
+			{Name: aws.String("MEMORY"), IntegerValue: aws.Int64(500)},
+		}
+	}
+
+	u := GetClusterResourceUtilization(context.Background(), nil, "test")
+	expected := ClusterUtilization{
+		Instances:        2,
+		RegisteredCPU:    2048,
+		RemainingCPU:     1024,
+		CPUPercent:       50,
+		RegisteredMemory: 4000,
+		RemainingMemory:  1000,
+		MemoryPercent:    75,
+	}
+	if u != expected {
+		t.Errorf("Expected %+v, got %+v", expected, u)
+	}
+
+	setMockCluster(t, nil)
+	u = GetClusterResourceUtilization(context.Background(), nil, "test")
+	if u != (ClusterUtilization{}) {
+		t.Errorf("Expected zeros for a cluster without instances, got %+v", u)
+	}
+}
+
+func TestFilterServicesByName(t *testing.T) {
+	services := []*ecs.Service{
+		{ServiceName: aws.String("web"), ServiceArn: aws.String("arn:aws:ecs:us-east-1:123456789012:service/test/web")},
+		{ServiceName: aws.String("worker"), ServiceArn: aws.String("arn:aws:ecs:us-east-1:123456789012:service/test/worker")},
+		{ServiceName: aws.String("reports"), ServiceArn: aws.String("arn:aws:ecs:us-east-1:123456789012:service/test/reports")},
+	}
+
+	matched, missing := FilterServicesByName(services, []string{"arn:aws:ecs:us-east-1:123456789012:service/test/reports", "web", "api"})
+	if len(matched) != 2 || *matched[0].ServiceName != "web" || *matched[1].ServiceName != "reports" {
+		t.Errorf("Expected web and reports to match in service order, got %v", matched)
+	}
+	if len(missing) != 1 || missing[0] != "api" {
+		t.Errorf("Expected api to be missing, got %v", missing)
+	}
+
+	matched, missing = FilterServicesByName(services, nil)
+	if len(matched) != 0 || len(missing) != 0 {
+		t.Errorf("Expected no matches or missing names without names, got %v and %v", matched, missing)
+	}
+}
+
+func TestFindService(t *testing.T) {
+	clusterArn := func(name string) string {
+		return "arn:aws:ecs:us-east-1:123456789012:cluster/" + name
+	}
+	serviceArn := func(cluster, name string) *string {
+		return aws.String(fmt.Sprintf("arn:aws:ecs:us-east-1:123456789012:service/%s/%s", cluster, name))
+	}
+
+	mock := &mockEcsClient{
+		clusters:           map[string]*ecs.Cluster{},
+		clusterServiceArns: map[string][]*string{},
+		services:           map[string]*ecs.Service{},
+	}
+	clusterServices := map[string][]string{
+		"prod":    {"web", "worker"},
+		"staging": {"web-canary", "web"},
+		"tools":   {"reports"},
+	}
+	for cluster, names := range clusterServices {
+		mock.clusters[clusterArn(cluster)] = &ecs.Cluster{ClusterArn: aws.String(clusterArn(cluster)), ClusterName: aws.String(cluster)}
+		for _, name := range names {
+			arn := serviceArn(cluster, name)
+			mock.clusterServiceArns[clusterArn(cluster)] = append(mock.clusterServiceArns[clusterArn(cluster)], arn)
+			mock.services[*arn] = &ecs.Service{ServiceArn: arn, ServiceName: aws.String(name), Status: aws.String("ACTIVE")}
+		}
+	}
+	setMockEcsClient(t, mock)
+
+	found, err := FindService(context.Background(), nil, func(name string) bool { return name == "web" })
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(found) != 2 || found[0].ClusterName != "prod" || found[1].ClusterName != "staging" {
+		t.Errorf("Expected web in prod and staging, got %+v", found)
+	}
+
+	found, err = FindService(context.Background(), nil, func(name string) bool { return name == "api" })
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(found) != 0 {
+		t.Errorf("Expected no matches, got %+v", found)
+	}
+
+	// A cluster that can't be searched must not exit or hide the matches in the other clusters
+	original := ExitWithError
+	ExitWithError = func(err error) {
+		t.Fatalf("Expected an error to be returned, not exited with: %s", err)
+	}
+	t.Cleanup(func() {
+		ExitWithError = original
+	})
+	mock.listServicesErrors = map[string]error{
+		clusterArn("staging"): awserr.New("AccessDeniedException", "not authorized", nil),
+	}
+
+	found, err = FindService(context.Background(), nil, func(name string) bool { return name == "web" })
+	if !errors.Is(err, ErrPermissionDenied) || !strings.Contains(err.Error(), "staging") {
+		t.Errorf("Expected a permission error naming the staging cluster, got: %v", err)
+	}
+	if len(found) != 1 || found[0].ClusterName != "prod" {
+		t.Errorf("Expected web in prod to still be found, got %+v", found)
+	}
+}
+
+func TestWaitForAgentsConnected(t *testing.T) {
+	mock := setMockCluster(t, []*ec2.Instance{
+		{InstanceId: aws.String("i-1")},
+		{InstanceId: aws.String("i-2")},
+	})
+
+	ids := aws.StringSlice([]string{"i-1", "i-2"})
+	if err := WaitForAgentsConnected(context.Background(), nil, "test", ids, 0); err != nil {
+		t.Fatalf("Expected no error when every agent is connected, got: %s", err)
+	}
+
+	for _, instance := range mock.containerInstances {
+		if *instance.Ec2InstanceId == "i-2" {
+			instance.AgentConnected = aws.Bool(false)
+		}
+	}
+	err := WaitForAgentsConnected(context.Background(), nil, "test", ids, 0)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected a timeout while an agent is not connected, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "i-2") || strings.Contains(err.Error(), "i-1") {
+		t.Errorf("Expected only i-2 to be reported as not connected, got: %s", err)
+	}
+}
+
+func TestWaitForInstancesReturnsErrors(t *testing.T) {
+	// Replacing instances re-attaches detached instances when a wait fails, so waits must not exit
+	original := ExitWithError
+	ExitWithError = func(err error) {
+		t.Fatalf("Expected an error to be returned, not exited with: %s", err)
+	}
+	t.Cleanup(func() {
+		ExitWithError = original
+	})
+
+	mock := setMockCluster(t, []*ec2.Instance{
+		{InstanceId: aws.String("i-1")},
+	})
+	mock.listContainerInstancesErrors = map[string]error{
+		"broken": awserr.New(ecs.ErrCodeClusterNotFoundException, "Cluster not found.", nil),
+	}
+
+	ids := aws.StringSlice([]string{"i-1", "i-2"})
+	err := WaitForInstancesActiveInCluster(context.Background(), nil, "broken", ids, time.Minute)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a not found error for the broken cluster, got: %v", err)
+	}
+	if err := WaitForAgentsConnected(context.Background(), nil, "broken", ids, time.Minute); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a not found error for the broken cluster, got: %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = WaitForAgentsConnected(cancelled, nil, "test", ids, time.Minute)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the wait to stop with the context error, got: %v", err)
+	}
+}
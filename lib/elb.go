@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"sort"
+	"time"
+)
+
+// targetsHealthyPollInterval is how often target health is checked while waiting for targets to be healthy
+var targetsHealthyPollInterval = 10 * time.Second
+
+// GetTargetGroupsForEcsServices returns the ARNs of the target groups the services are registered with.
+// Services without a load balancer, or behind a classic load balancer, don't contribute any.
+func GetTargetGroupsForEcsServices(services []*ecs.Service) []string {
+	seen := map[string]bool{}
+	var targetGroups []string
+	for _, service := range services {
+		for _, lb := range service.LoadBalancers {
+			arn := aws.StringValue(lb.TargetGroupArn)
+			if arn == "" || seen[arn] {
+				continue
+			}
+			seen[arn] = true
+			targetGroups = append(targetGroups, arn)
+		}
+	}
+
+	sort.Strings(targetGroups)
+	return targetGroups
+}
+
+// GetUnhealthyTargets returns a description of each target in the target group that is not healthy,
+// ignoring targets that are draining because they are being deregistered
+func GetUnhealthyTargets(ctx context.Context, awsSess *session.Session, targetGroupArn string) ([]string, error) {
+	svc := newElbv2Client(awsSess)
+
+	health, err := svc.DescribeTargetHealthWithContext(ctx, &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(targetGroupArn),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe target health for %s: %w", targetGroupArn, err)
+	}
+
+	var unhealthy []string
+	for _, target := range health.TargetHealthDescriptions {
+		if target.TargetHealth == nil {
+			continue
+		}
+
+		state := aws.StringValue(target.TargetHealth.State)
+		if state == elbv2.TargetHealthStateEnumHealthy || state == elbv2.TargetHealthStateEnumDraining {
+			continue
+		}
+
+		id := ""
+		if target.Target != nil {
+			id = fmt.Sprintf("%s:%v", aws.StringValue(target.Target.Id), aws.Int64Value(target.Target.Port))
+		}
+		unhealthy = append(unhealthy, fmt.Sprintf("%s (%s)", id, state))
+	}
+
+	return unhealthy, nil
+}
+
+// WaitForTargetsHealthy waits up to timeout for every target in the target group to pass its
+// load balancer health checks
+func WaitForTargetsHealthy(ctx context.Context, awsSess *session.Session, targetGroupArn string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		unhealthy, err := GetUnhealthyTargets(ctx, awsSess, targetGroupArn)
+		if err != nil {
+			return err
+		}
+
+		if len(unhealthy) == 0 {
+			return nil
+		}
+
+		if err := aws.SleepWithContext(ctx, targetsHealthyPollInterval); err != nil {
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("interrupted while waiting for targets in %s to become healthy: %w", targetGroupArn, ctx.Err())
+			}
+			return withCategory(fmt.Errorf("targets in %s did not become healthy within %s, still waiting on: %v", targetGroupArn, timeout, unhealthy), ErrTimeout)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+func TestGetTargetGroupsForEcsServices(t *testing.T) {
+	services := []*ecs.Service{
+		{ServiceName: aws.String("no-lb")},
+		{ServiceName: aws.String("classic"), LoadBalancers: []*ecs.LoadBalancer{{LoadBalancerName: aws.String("classic-elb")}}},
+		{ServiceName: aws.String("web"), LoadBalancers: []*ecs.LoadBalancer{{TargetGroupArn: aws.String("tg-web")}}},
+		{ServiceName: aws.String("api"), LoadBalancers: []*ecs.LoadBalancer{
+			{TargetGroupArn: aws.String("tg-api")},
+			{TargetGroupArn: aws.String("tg-web")},
+		}},
+	}
+
+	targetGroups := GetTargetGroupsForEcsServices(services)
+	if strings.Join(targetGroups, " ") != "tg-api tg-web" {
+		t.Errorf("Expected target groups tg-api and tg-web, got %v", targetGroups)
+	}
+}
+
+func TestWaitForTargetsHealthy(t *testing.T) {
+	original := targetsHealthyPollInterval
+	targetsHealthyPollInterval = time.Millisecond
+	t.Cleanup(func() {
+		targetsHealthyPollInterval = original
+	})
+
+	target := func(id, state string) *elbv2.TargetHealthDescription {
+		return &elbv2.TargetHealthDescription{
+			Target:       &elbv2.TargetDescription{Id: aws.String(id), Port: aws.Int64(32768)},
+			TargetHealth: &elbv2.TargetHealth{State: aws.String(state)},
+		}
+	}
+
+	mock := &mockElbv2Client{
+		targetHealth: map[string][][]*elbv2.TargetHealthDescription{
+			"tg-web": {
+				{target("i-1", elbv2.TargetHealthStateEnumDraining), target("i-2", elbv2.TargetHealthStateEnumInitial)},
+				{target("i-1", elbv2.TargetHealthStateEnumDraining), target("i-2", elbv2.TargetHealthStateEnumHealthy)},
+			},
+			"tg-broken": {
+				{target("i-3", elbv2.TargetHealthStateEnumUnhealthy)},
+			},
+		},
+	}
+	setMockElbv2Client(t, mock)
+
+	err := WaitForTargetsHealthy(context.Background(), nil, "tg-web", time.Second)
+	if err != nil {
+		t.Errorf("Expected draining targets to be ignored once the rest are healthy, got: %s", err)
+	}
+	if mock.describeTargetHealthCalls != 2 {
+		t.Errorf("Expected 2 DescribeTargetHealth calls, got %v", mock.describeTargetHealthCalls)
+	}
+
+	err = WaitForTargetsHealthy(context.Background(), nil, "tg-broken", 20*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "i-3:32768 (unhealthy)") {
+		t.Errorf("Expected a timeout naming the unhealthy target, got: %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = WaitForTargetsHealthy(cancelled, nil, "tg-broken", time.Second)
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected an interrupted wait not to be reported as a timeout, got: %v", err)
+	}
+
+	err = WaitForTargetsHealthy(context.Background(), nil, "tg-missing", time.Second)
+	if err == nil {
+		t.Error("Expected an error for a missing target group")
+	}
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Errors returned by lib wrap one of these categories when callers may want to react to the kind of
+// failure, use ErrorCategory or errors.Is to check for them
+var (
+	// ErrNotFound is wrapped when a cluster, service, ASG or other resource does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrTimeout is wrapped when a wait gives up before the resource reaches the expected state
+	ErrTimeout = errors.New("timed out")
+
+	// ErrPermissionDenied is wrapped when AWS rejects a call because the credentials are not allowed to make it
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrInsufficientCapacity is wrapped when the cluster or ASG does not have room for what was asked of it
+	ErrInsufficientCapacity = errors.New("insufficient capacity")
+)
+
+// awsErrorCategories maps AWS error codes to the category they belong to
+var awsErrorCategories = map[string]error{
+	"ClusterNotFoundException":     ErrNotFound,
+	"ServiceNotFoundException":     ErrNotFound,
+	"ResourceNotFoundException":    ErrNotFound,
+	"InvalidInstanceID.NotFound":   ErrNotFound,
+	"TargetGroupNotFound":          ErrNotFound,
+	"NamespaceNotFound":            ErrNotFound,
+	"ServiceNotFound":              ErrNotFound,
+	"AccessDenied":                 ErrPermissionDenied,
+	"AccessDeniedException":        ErrPermissionDenied,
+	"UnauthorizedOperation":        ErrPermissionDenied,
+	"InsufficientInstanceCapacity": ErrInsufficientCapacity,
+	"ResourceNotReady":             ErrTimeout,
+}
+
+// ErrorCategory returns ErrNotFound, ErrTimeout, ErrPermissionDenied or ErrInsufficientCapacity when err
+// wraps one of them or an AWS error with a code in that category, or nil for any other error
+func ErrorCategory(err error) error {
+	for _, category := range []error{ErrTimeout, ErrNotFound, ErrPermissionDenied, ErrInsufficientCapacity} {
+		if errors.Is(err, category) {
+			return category
+		}
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return awsErrorCategories[aerr.Code()]
+	}
+
+	return nil
+}
+
+// categorizedError keeps the message of an error while matching its category with errors.Is
+type categorizedError struct {
+	err      error
+	category error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() error {
+	return e.err
+}
+
+func (e *categorizedError) Is(target error) bool {
+	return target == e.category
+}
+
+// withCategory returns err marked with category, or err unchanged when category is nil
+func withCategory(err error, category error) error {
+	if category == nil {
+		return err
+	}
+
+	return &categorizedError{err: err, category: category}
+}
+
+// ExitWithError is used by lib functions that exit rather than return an error. The cmd package replaces
+// it so these failures exit with the same codes as errors returned to commands.
+var ExitWithError = func(err error) {
+	fmt.Println(err)
+	os.Exit(1)
+}
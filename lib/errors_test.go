@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Err      error
+		Expected error
+	}{
+		{
+			Name:     "wrapped not found",
+			Err:      fmt.Errorf("ASG %s %w", "test", ErrNotFound),
+			Expected: ErrNotFound,
+		},
+		{
+			Name:     "wrapped timeout",
+			Err:      fmt.Errorf("unable to replace instances: %w", fmt.Errorf("%w after 1m0s", ErrTimeout)),
+			Expected: ErrTimeout,
+		},
+		{
+			Name:     "ECS cluster not found",
+			Err:      handleEcsError(awserr.New(ecs.ErrCodeClusterNotFoundException, "Cluster not found.", nil)),
+			Expected: ErrNotFound,
+		},
+		{
+			Name:     "ECS access denied",
+			Err:      handleEcsError(awserr.New(ecs.ErrCodeAccessDeniedException, "not allowed", nil)),
+			Expected: ErrPermissionDenied,
+		},
+		{
+			Name:     "EC2 unauthorized",
+			Err:      fmt.Errorf("permission check failed: %w", awserr.New("UnauthorizedOperation", "not allowed", nil)),
+			Expected: ErrPermissionDenied,
+		},
+		{
+			Name:     "insufficient capacity",
+			Err:      withCategory(errors.New("task does not fit"), ErrInsufficientCapacity),
+			Expected: ErrInsufficientCapacity,
+		},
+		{
+			Name:     "uncategorized AWS error",
+			Err:      handleEcsError(awserr.New(ecs.ErrCodeServerException, "oops", nil)),
+			Expected: nil,
+		},
+		{
+			Name:     "plain error",
+			Err:      errors.New("something went wrong"),
+			Expected: nil,
+		},
+	}
+
+	for _, i := range tests {
+		if category := ErrorCategory(i.Err); category != i.Expected {
+			t.Errorf("%s: expected category %v, got %v", i.Name, i.Expected, category)
+		}
+	}
+}
+
+func TestHandleEcsErrorKeepsMessage(t *testing.T) {
+	err := handleEcsError(awserr.New(ecs.ErrCodeClusterNotFoundException, "Cluster not found.", nil))
+	if err.Error() != "cluster not found (ClusterNotFoundException): Cluster not found." {
+		t.Errorf("Unexpected message: %s", err)
+	}
+}
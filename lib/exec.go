@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// execCommandAgentName is the managed agent in a container that runs ECS Exec sessions
+const execCommandAgentName = "ExecuteCommandAgent"
+
+// ExecSession is an ECS Exec session started in a container of a task
+type ExecSession struct {
+	SessionID     string `json:"sessionId"`
+	StreamURL     string `json:"streamUrl"`
+	TokenValue    string `json:"-"`
+	TaskArn       string `json:"taskArn"`
+	ContainerName string `json:"containerName"`
+
+	// Target is the SSM target of the session, which the session manager plugin needs to connect to it
+	Target string `json:"target"`
+}
+
+// StartExecSession runs command in a container of the task with ECS Exec, leaving the session open for the
+// session manager plugin to connect to. container may be empty when the task only has one container.
+// An error explains how to enable ECS Exec when the task was started without it.
+func StartExecSession(ctx context.Context, awsSess *session.Session, cluster, task, container, command string) (*ExecSession, error) {
+	svc := newEcsClient(awsSess)
+
+	descResult, err := svc.DescribeTasksWithContext(ctx, &ecs.DescribeTasksInput{
+		Cluster: aws.String(cluster),
+		Tasks:   []*string{aws.String(task)},
+	})
+	if err != nil {
+		return nil, handleEcsError(err)
+	}
+	if len(descResult.Tasks) == 0 {
+		return nil, fmt.Errorf("task %s %w in cluster %q", task, ErrNotFound, cluster)
+	}
+	ecsTask := descResult.Tasks[0]
+
+	if !aws.BoolValue(ecsTask.EnableExecuteCommand) {
+		service := "<service>"
+		if group := aws.StringValue(ecsTask.Group); strings.HasPrefix(group, "service:") {
+			service = strings.TrimPrefix(group, "service:")
+		}
+		return nil, fmt.Errorf("task %s does not have ECS Exec enabled. Enable it on the service with "+
+			"'aws ecs update-service --cluster %s --service %s --enable-execute-command --force-new-deployment' "+
+			"or start the task with --enable-execute-command, and make sure the task role allows the ssmmessages actions",
+			task, cluster, service)
+	}
+
+	execContainer, err := findExecContainer(ecsTask, container)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := svc.ExecuteCommandWithContext(ctx, &ecs.ExecuteCommandInput{
+		Cluster:     aws.String(cluster),
+		Task:        ecsTask.TaskArn,
+		Container:   execContainer.Name,
+		Command:     aws.String(command),
+		Interactive: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, handleEcsError(err)
+	}
+
+	taskArn := aws.StringValue(ecsTask.TaskArn)
+	clusterArn := aws.StringValue(ecsTask.ClusterArn)
+	execSession := &ExecSession{
+		TaskArn:       taskArn,
+		ContainerName: aws.StringValue(execContainer.Name),
+		Target: fmt.Sprintf("ecs:%s_%s_%s", clusterArn[strings.LastIndex(clusterArn, "/")+1:],
+			taskArn[strings.LastIndex(taskArn, "/")+1:], aws.StringValue(execContainer.RuntimeId)),
+	}
+	if result.Session != nil {
+		execSession.SessionID = aws.StringValue(result.Session.SessionId)
+		execSession.StreamURL = aws.StringValue(result.Session.StreamUrl)
+		execSession.TokenValue = aws.StringValue(result.Session.TokenValue)
+	}
+
+	return execSession, nil
+}
+
+// findExecContainer returns the named container of the task, or its only container when name is empty,
+// after checking its ECS Exec agent is running
+func findExecContainer(task *ecs.Task, name string) (*ecs.Container, error) {
+	var found *ecs.Container
+	var names []string
+	for _, container := range task.Containers {
+		names = append(names, aws.StringValue(container.Name))
+		if aws.StringValue(container.Name) == name {
+			found = container
+		}
+	}
+
+	if name == "" {
+		if len(task.Containers) != 1 {
+			return nil, fmt.Errorf("task %s has %v containers, a container must be chosen from %v",
+				aws.StringValue(task.TaskArn), len(task.Containers), names)
+		}
+		found = task.Containers[0]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("container %s %w in task %s, it has %v", name, ErrNotFound, aws.StringValue(task.TaskArn), names)
+	}
+
+	for _, agent := range found.ManagedAgents {
+		if aws.StringValue(agent.Name) == execCommandAgentName && aws.StringValue(agent.LastStatus) != "RUNNING" {
+			return nil, fmt.Errorf("the ECS Exec agent in container %s is %s, it must be RUNNING to start a session",
+				aws.StringValue(found.Name), aws.StringValue(agent.LastStatus))
+		}
+	}
+
+	return found, nil
+}
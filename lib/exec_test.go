@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func makeExecTask(enabled bool, containers ...string) *ecs.Task {
+	task := &ecs.Task{
+		TaskArn:              aws.String("arn:aws:ecs:us-east-1:123456789012:task/test/0123abcd"),
+		ClusterArn:           aws.String("arn:aws:ecs:us-east-1:123456789012:cluster/test"),
+		Group:                aws.String("service:web"),
+		EnableExecuteCommand: aws.Bool(enabled),
+	}
+	for _, name := range containers {
+		task.Containers = append(task.Containers, &ecs.Container{
+			Name:      aws.String(name),
+			RuntimeId: aws.String("0123abcd-" + name),
+			ManagedAgents: []*ecs.ManagedAgent{
+				{Name: aws.String("ExecuteCommandAgent"), LastStatus: aws.String("RUNNING")},
+			},
+		})
+	}
+
+	return task
+}
+
+func TestStartExecSession(t *testing.T) {
+	mock := &mockEcsClient{tasks: map[string]*ecs.Task{"0123abcd": makeExecTask(true, "app")}}
+	setMockEcsClient(t, mock)
+
+	execSession, err := StartExecSession(context.Background(), nil, "test", "0123abcd", "", "/bin/sh")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if execSession.SessionID != "ecs-execute-command-0123456789" || execSession.ContainerName != "app" {
+		t.Errorf("Expected a session in the app container, got %+v", execSession)
+	}
+	if execSession.Target != "ecs:test_0123abcd_0123abcd-app" {
+		t.Errorf("Expected target ecs:test_0123abcd_0123abcd-app, got %s", execSession.Target)
+	}
+	if len(mock.executeCommandInputs) != 1 || *mock.executeCommandInputs[0].Command != "/bin/sh" {
+		t.Errorf("Expected one ExecuteCommand call running /bin/sh, got %v", mock.executeCommandInputs)
+	}
+}
+
+func TestStartExecSessionErrors(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Task      *ecs.Task
+		Container string
+		Expected  string
+		NotFound  bool
+	}{
+		{Name: "exec not enabled", Task: makeExecTask(false, "app"), Expected: "--service web --enable-execute-command"},
+		{Name: "container not chosen", Task: makeExecTask(true, "app", "proxy"), Expected: "has 2 containers"},
+		{Name: "container missing", Task: makeExecTask(true, "app"), Container: "proxy", Expected: "container proxy not found", NotFound: true},
+		{Name: "task missing", Expected: "task 0123abcd not found", NotFound: true},
+	}
+
+	for _, i := range tests {
+		mock := &mockEcsClient{tasks: map[string]*ecs.Task{}}
+		if i.Task != nil {
+			mock.tasks["0123abcd"] = i.Task
+		}
+		setMockEcsClient(t, mock)
+
+		_, err := StartExecSession(context.Background(), nil, "test", "0123abcd", i.Container, "/bin/sh")
+		if err == nil || !strings.Contains(err.Error(), i.Expected) {
+			t.Errorf("%s: expected an error containing %q, got %v", i.Name, i.Expected, err)
+		}
+		if errors.Is(err, ErrNotFound) != i.NotFound {
+			t.Errorf("%s: expected ErrNotFound %v, got %v", i.Name, i.NotFound, err)
+		}
+		if len(mock.executeCommandInputs) != 0 {
+			t.Errorf("%s: expected no ExecuteCommand calls", i.Name)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// Names of the checks CheckClusterHealth runs
+const (
+	HealthCheckServiceCounts   = "services running desired count"
+	HealthCheckDeployments     = "no failed deployments"
+	HealthCheckAgentsConnected = "container instance agents connected"
+	HealthCheckInstanceMemory  = "container instance memory below threshold"
+)
+
+// HealthCheck is the result of one check of a cluster. Failures has a line for each service or instance
+// that failed it.
+type HealthCheck struct {
+	Name     string   `json:"name"`
+	Passed   bool     `json:"passed"`
+	Failures []string `json:"failures"`
+}
+
+// CheckClusterHealth runs read-only checks that the cluster is operationally sound: every active service
+// has as many tasks running as it wants, no deployment has failed, the ECS agent on every container instance
+// is connected and no instance has more than maxMemoryPercent of its registered memory reserved. When the
+// services or container instances can't be described, the checks that need them fail with the error, the
+// rest still run, and the first error is returned along with the checks.
+func CheckClusterHealth(ctx context.Context, awsSess *session.Session, cluster string, maxMemoryPercent float64) ([]HealthCheck, error) {
+	counts := HealthCheck{Name: HealthCheckServiceCounts, Failures: []string{}}
+	deployments := HealthCheck{Name: HealthCheckDeployments, Failures: []string{}}
+	agents := HealthCheck{Name: HealthCheckAgentsConnected, Failures: []string{}}
+	memory := HealthCheck{Name: HealthCheckInstanceMemory, Failures: []string{}}
+
+	services, servicesErr := describeServicesForEcsCluster(ctx, awsSess, cluster)
+	if servicesErr != nil {
+		failure := fmt.Sprintf("unable to describe services: %s", servicesErr)
+		counts.Failures = append(counts.Failures, failure)
+		deployments.Failures = append(deployments.Failures, failure)
+	}
+
+	instances, instancesErr := describeInstancesForEcsCluster(ctx, awsSess, cluster)
+	if instancesErr != nil {
+		failure := fmt.Sprintf("unable to describe container instances: %s", instancesErr)
+		agents.Failures = append(agents.Failures, failure)
+		memory.Failures = append(memory.Failures, failure)
+	}
+
+	for _, service := range services {
+		name := aws.StringValue(service.ServiceName)
+		if aws.StringValue(service.Status) != "ACTIVE" {
+			continue
+		}
+
+		running, desired := aws.Int64Value(service.RunningCount), aws.Int64Value(service.DesiredCount)
+		if running != desired {
+			counts.Failures = append(counts.Failures, fmt.Sprintf("%s: %v of %v tasks running", name, running, desired))
+		}
+
+		if deployment := FailedDeployment(service); deployment != nil {
+			deployments.Failures = append(deployments.Failures, fmt.Sprintf("%s: deployment %s of %s failed: %s", name,
+				aws.StringValue(deployment.Id), aws.StringValue(deployment.TaskDefinition), aws.StringValue(deployment.RolloutStateReason)))
+		}
+	}
+
+	for _, instance := range instances {
+		instanceID := aws.StringValue(instance.Ec2InstanceId)
+		if !aws.BoolValue(instance.AgentConnected) {
+			agents.Failures = append(agents.Failures, fmt.Sprintf("%s: agent disconnected, instance is %s", instanceID,
+				aws.StringValue(instance.Status)))
+		}
+
+		registered := getContainerInstanceResource(instance.RegisteredResources, "MEMORY")
+		if registered == 0 {
+			continue
+		}
+		reserved := registered - getContainerInstanceResource(instance.RemainingResources, "MEMORY")
+		if percent := float64(reserved) / float64(registered) * 100; percent > maxMemoryPercent {
+			memory.Failures = append(memory.Failures, fmt.Sprintf("%s: %.1f%% of memory reserved, above %v%%", instanceID,
+				percent, maxMemoryPercent))
+		}
+	}
+
+	checks := []HealthCheck{counts, deployments, agents, memory}
+	for i := range checks {
+		checks[i].Passed = len(checks[i].Failures) == 0
+	}
+
+	if servicesErr != nil {
+		return checks, servicesErr
+	}
+	return checks, instancesErr
+}
@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestCheckClusterHealth(t *testing.T) {
+	mock := setMockCluster(t, []*ec2.Instance{{InstanceId: aws.String("i-1")}, {InstanceId: aws.String("i-2")}})
+	remaining := map[string]int64{"i-1": 1000, "i-2": 100}
+	for _, instance := range mock.containerInstances {
+		instance.RegisteredResources = []*ecs.Resource{{Name: aws.String("MEMORY"), IntegerValue: aws.Int64(2000)}}
+		instance.RemainingResources = []*ecs.Resource{
+			{Name: aws.String("MEMORY"), IntegerValue: aws.Int64(remaining[*instance.Ec2InstanceId])},
+		}
+		if *instance.Ec2InstanceId == "i-2" {
+			instance.AgentConnected = aws.Bool(false)
+		}
+	}
+
+	arns := makeArns("service", 3)
+	mock.serviceArnPages = [][]*string{arns}
+	mock.services = map[string]*ecs.Service{
+		*arns[0]: {ServiceName: aws.String("web"), Status: aws.String("ACTIVE"), DesiredCount: aws.Int64(2), RunningCount: aws.Int64(2)},
+		*arns[1]: {ServiceName: aws.String("worker"), Status: aws.String("ACTIVE"), DesiredCount: aws.Int64(3), RunningCount: aws.Int64(1),
+			Deployments: []*ecs.Deployment{{Id: aws.String("ecs-svc/1"), RolloutState: aws.String(ecs.DeploymentRolloutStateFailed)}}},
+		*arns[2]: {ServiceName: aws.String("old"), Status: aws.String("DRAINING"), DesiredCount: aws.Int64(1), RunningCount: aws.Int64(0)},
+	}
+
+	checks, err := CheckClusterHealth(context.Background(), nil, "test", 90)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(checks) != 4 {
+		t.Fatalf("Expected 4 checks, got %v", len(checks))
+	}
+	for _, check := range checks {
+		if check.Passed || len(check.Failures) != 1 {
+			t.Errorf("Expected %s to fail for one service or instance, got %+v", check.Name, check)
+		}
+	}
+
+	// Fixing the cluster passes every check
+	mock.services[*arns[1]] = &ecs.Service{ServiceName: aws.String("worker"), Status: aws.String("ACTIVE"),
+		DesiredCount: aws.Int64(3), RunningCount: aws.Int64(3)}
+	for _, instance := range mock.containerInstances {
+		instance.AgentConnected = aws.Bool(true)
+	}
+	checks, err = CheckClusterHealth(context.Background(), nil, "test", 96)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, check := range checks {
+		if !check.Passed {
+			t.Errorf("Expected %s to pass, got %+v", check.Name, check)
+		}
+	}
+
+	// The service checks still run when the container instances can't be described
+	original := ExitWithError
+	ExitWithError = func(err error) {
+		t.Fatalf("Expected an error to be returned, not exited with: %s", err)
+	}
+	t.Cleanup(func() {
+		ExitWithError = original
+	})
+	mock.listContainerInstancesErrors = map[string]error{
+		"test": awserr.New("AccessDeniedException", "not authorized", nil),
+	}
+
+	checks, err = CheckClusterHealth(context.Background(), nil, "test", 96)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected a permission error, got: %v", err)
+	}
+	for _, check := range checks {
+		instanceCheck := check.Name == HealthCheckAgentsConnected || check.Name == HealthCheckInstanceMemory
+		if check.Passed == instanceCheck {
+			t.Errorf("Expected only the container instance checks to fail, got %+v", check)
+		}
+	}
+}
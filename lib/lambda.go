@@ -1,13 +1,14 @@
 package lib
 
 import (
+	"context"
 	"encoding/base64"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/lambda"
 )
 
-func LambdaInvoke(awsSess *session.Session, functionName, payload string) (*lambda.InvokeOutput, error) {
+func LambdaInvoke(ctx context.Context, awsSess *session.Session, functionName, payload string) (*lambda.InvokeOutput, error) {
 	svc := lambda.New(awsSess)
 
 	encodedPayload := base64.StdEncoding.EncodeToString([]byte(payload))
@@ -19,5 +20,5 @@ func LambdaInvoke(awsSess *session.Session, functionName, payload string) (*lamb
 		Payload:        []byte(encodedPayload),
 	}
 
-	return svc.Invoke(input)
+	return svc.InvokeWithContext(ctx, input)
 }
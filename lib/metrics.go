@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ReplacementDurationBuckets are the upper bounds in seconds of the replacement_duration_seconds histogram
+var ReplacementDurationBuckets = []float64{30, 60, 120, 300, 600, 900, 1800, 3600}
+
+// ReplacementMetrics counts the progress of an instance replacement so it can be scraped by Prometheus.
+// It is safe to update while being written.
+type ReplacementMetrics struct {
+	mu                sync.Mutex
+	instancesReplaced int64
+	pendingTasks      int64
+	bucketCounts      []int64
+	durationSum       float64
+	durationCount     int64
+}
+
+// NewReplacementMetrics returns metrics with nothing replaced yet
+func NewReplacementMetrics() *ReplacementMetrics {
+	return &ReplacementMetrics{bucketCounts: make([]int64, len(ReplacementDurationBuckets))}
+}
+
+// InstanceReplaced counts a replaced instance and records how long replacing it took
+func (m *ReplacementMetrics) InstanceReplaced(duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.instancesReplaced++
+	seconds := duration.Seconds()
+	for i, bound := range ReplacementDurationBuckets {
+		if seconds <= bound {
+			m.bucketCounts[i]++
+		}
+	}
+	m.durationSum += seconds
+	m.durationCount++
+}
+
+// SetPendingTasks records the pending task count from the latest check
+func (m *ReplacementMetrics) SetPendingTasks(pending int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pendingTasks = pending
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format
+func (m *ReplacementMetrics) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP instances_replaced_total Instances terminated after their replacement was ready and tasks were rescheduled")
+	fmt.Fprintln(w, "# TYPE instances_replaced_total counter")
+	fmt.Fprintf(w, "instances_replaced_total %v\n", m.instancesReplaced)
+
+	fmt.Fprintln(w, "# HELP pending_tasks Pending ECS tasks in the cluster at the latest check")
+	fmt.Fprintln(w, "# TYPE pending_tasks gauge")
+	fmt.Fprintf(w, "pending_tasks %v\n", m.pendingTasks)
+
+	fmt.Fprintln(w, "# HELP replacement_duration_seconds Time taken to replace each instance")
+	fmt.Fprintln(w, "# TYPE replacement_duration_seconds histogram")
+	for i, bound := range ReplacementDurationBuckets {
+		fmt.Fprintf(w, "replacement_duration_seconds_bucket{le=\"%v\"} %v\n", bound, m.bucketCounts[i])
+	}
+	fmt.Fprintf(w, "replacement_duration_seconds_bucket{le=\"+Inf\"} %v\n", m.durationCount)
+	fmt.Fprintf(w, "replacement_duration_seconds_sum %v\n", m.durationSum)
+	fmt.Fprintf(w, "replacement_duration_seconds_count %v\n", m.durationCount)
+}
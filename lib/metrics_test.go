@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestReplacementMetricsWritePrometheus(t *testing.T) {
+	m := NewReplacementMetrics()
+
+	var empty bytes.Buffer
+	m.WritePrometheus(&empty)
+	expectedEmpty := `# HELP instances_replaced_total Instances terminated after their replacement was ready and tasks were rescheduled
+# TYPE instances_replaced_total counter
+instances_replaced_total 0
+# HELP pending_tasks Pending ECS tasks in the cluster at the latest check
+# TYPE pending_tasks gauge
+pending_tasks 0
+# HELP replacement_duration_seconds Time taken to replace each instance
+# TYPE replacement_duration_seconds histogram
+replacement_duration_seconds_bucket{le="30"} 0
+replacement_duration_seconds_bucket{le="60"} 0
+replacement_duration_seconds_bucket{le="120"} 0
+replacement_duration_seconds_bucket{le="300"} 0
+replacement_duration_seconds_bucket{le="600"} 0
+replacement_duration_seconds_bucket{le="900"} 0
+replacement_duration_seconds_bucket{le="1800"} 0
+replacement_duration_seconds_bucket{le="3600"} 0
+replacement_duration_seconds_bucket{le="+Inf"} 0
+replacement_duration_seconds_sum 0
+replacement_duration_seconds_count 0
+`
+	if empty.String() != expectedEmpty {
+		t.Errorf("Did not get expected exposition before any updates, got:\n%s", empty.String())
+	}
+
+	// Buckets are cumulative, a replacement counts in every bucket at or above its duration, and ones
+	// longer than the largest bound only count in +Inf
+	m.InstanceReplaced(45 * time.Second)
+	m.InstanceReplaced(5 * time.Minute)
+	m.InstanceReplaced(2 * time.Hour)
+	m.SetPendingTasks(4)
+	m.SetPendingTasks(3)
+
+	var out bytes.Buffer
+	m.WritePrometheus(&out)
+	expected := `# HELP instances_replaced_total Instances terminated after their replacement was ready and tasks were rescheduled
+# TYPE instances_replaced_total counter
+instances_replaced_total 3
+# HELP pending_tasks Pending ECS tasks in the cluster at the latest check
+# TYPE pending_tasks gauge
+pending_tasks 3
+# HELP replacement_duration_seconds Time taken to replace each instance
+# TYPE replacement_duration_seconds histogram
+replacement_duration_seconds_bucket{le="30"} 0
+replacement_duration_seconds_bucket{le="60"} 1
+replacement_duration_seconds_bucket{le="120"} 1
+replacement_duration_seconds_bucket{le="300"} 2
+replacement_duration_seconds_bucket{le="600"} 2
+replacement_duration_seconds_bucket{le="900"} 2
+replacement_duration_seconds_bucket{le="1800"} 2
+replacement_duration_seconds_bucket{le="3600"} 2
+replacement_duration_seconds_bucket{le="+Inf"} 3
+replacement_duration_seconds_sum 7545
+replacement_duration_seconds_count 3
+`
+	if out.String() != expected {
+		t.Errorf("Did not get expected exposition, got:\n%s", out.String())
+	}
+}
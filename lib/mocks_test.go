@@ -0,0 +1,916 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	"github.com/aws/aws-sdk-go/service/servicediscovery/servicediscoveryiface"
+)
+
+// mockEcsClient implements the subset of the ECS API used by lib from in-memory data.
+// Calling any other method panics via the nil embedded interface.
+type mockEcsClient struct {
+	ecsiface.ECSAPI
+
+	serviceArnPages           [][]*string
+	services                  map[string]*ecs.Service
+	taskDefinitions           map[string]*ecs.TaskDefinition
+	containerInstanceArnPages [][]*string
+	containerInstances        map[string]*ecs.ContainerInstance
+	clusters                  map[string]*ecs.Cluster
+	capacityProviders         map[string]*ecs.CapacityProvider
+	taskDefinitionArns        []*string
+	// tasks is keyed by task ARN
+	tasks map[string]*ecs.Task
+	// clusterServiceArns overrides serviceArnPages with a single page of services per cluster
+	clusterServiceArns map[string][]*string
+	// listServicesErrors is returned when listing the services of a cluster
+	listServicesErrors map[string]error
+	// listContainerInstancesErrors is returned when listing the container instances of a cluster
+	listContainerInstancesErrors map[string]error
+	// describeTaskDefinitionError is returned when describing any task definition
+	describeTaskDefinitionError error
+
+	mu                              sync.Mutex
+	describeServicesCalls           int
+	describeContainerInstancesCalls int
+	waitUntilServicesStableCalls    int
+	updateServiceInputs             []*ecs.UpdateServiceInput
+	createServiceInputs             []*ecs.CreateServiceInput
+	executeCommandInputs            []*ecs.ExecuteCommandInput
+}
+
+// setMockEcsClient makes lib use m for ECS calls until the test finishes
+func setMockEcsClient(t *testing.T, m ecsiface.ECSAPI) {
+	original := newEcsClient
+	newEcsClient = func(awsSess *session.Session) ecsiface.ECSAPI {
+		return m
+	}
+	t.Cleanup(func() {
+		newEcsClient = original
+	})
+}
+
+func (m *mockEcsClient) ListServicesPagesWithContext(ctx aws.Context, input *ecs.ListServicesInput,
+	fn func(*ecs.ListServicesOutput, bool) bool, opts ...request.Option) error {
+	if err, ok := m.listServicesErrors[aws.StringValue(input.Cluster)]; ok {
+		return err
+	}
+
+	if m.clusterServiceArns != nil {
+		fn(&ecs.ListServicesOutput{ServiceArns: m.clusterServiceArns[*input.Cluster]}, true)
+		return nil
+	}
+
+	for i, page := range m.serviceArnPages {
+		if !fn(&ecs.ListServicesOutput{ServiceArns: page}, i == len(m.serviceArnPages)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (m *mockEcsClient) DescribeServicesWithContext(ctx aws.Context, input *ecs.DescribeServicesInput,
+	opts ...request.Option) (*ecs.DescribeServicesOutput, error) {
+	m.mu.Lock()
+	m.describeServicesCalls++
+	m.mu.Unlock()
+	if len(input.Services) > 10 {
+		return nil, awserr.New(ecs.ErrCodeInvalidParameterException, "too many services", nil)
+	}
+
+	includeTags := false
+	for _, field := range input.Include {
+		includeTags = includeTags || aws.StringValue(field) == ecs.ServiceFieldTags
+	}
+
+	out := &ecs.DescribeServicesOutput{}
+	for _, arn := range input.Services {
+		if service, ok := m.services[*arn]; ok {
+			// Like ECS, tags are only returned when asked for
+			if !includeTags && service.Tags != nil {
+				untagged := *service
+				untagged.Tags = nil
+				service = &untagged
+			}
+			out.Services = append(out.Services, service)
+		} else {
+			out.Services = append(out.Services, &ecs.Service{ServiceArn: arn})
+		}
+	}
+
+	return out, nil
+}
+
+func (m *mockEcsClient) UpdateServiceWithContext(ctx aws.Context, input *ecs.UpdateServiceInput,
+	opts ...request.Option) (*ecs.UpdateServiceOutput, error) {
+	m.updateServiceInputs = append(m.updateServiceInputs, input)
+
+	return &ecs.UpdateServiceOutput{Service: m.services[*input.Service]}, nil
+}
+
+func (m *mockEcsClient) CreateServiceWithContext(ctx aws.Context, input *ecs.CreateServiceInput,
+	opts ...request.Option) (*ecs.CreateServiceOutput, error) {
+	m.createServiceInputs = append(m.createServiceInputs, input)
+
+	return &ecs.CreateServiceOutput{Service: &ecs.Service{
+		ServiceName:    input.ServiceName,
+		ServiceArn:     aws.String("arn:aws:ecs:us-east-1:123456789012:service/" + *input.Cluster + "/" + *input.ServiceName),
+		Status:         aws.String("ACTIVE"),
+		TaskDefinition: input.TaskDefinition,
+		DesiredCount:   input.DesiredCount,
+	}}, nil
+}
+
+func (m *mockEcsClient) WaitUntilServicesStableWithContext(ctx aws.Context, input *ecs.DescribeServicesInput,
+	opts ...request.WaiterOption) error {
+	m.waitUntilServicesStableCalls++
+	if len(input.Services) > 10 {
+		return awserr.New(ecs.ErrCodeInvalidParameterException, "too many services", nil)
+	}
+
+	for _, arn := range input.Services {
+		if service, ok := m.services[*arn]; ok && FailedDeployment(service) != nil {
+			return awserr.New(request.WaiterResourceNotReadyErrorCode, "failed waiting for successful resource state", nil)
+		}
+	}
+
+	for _, arn := range input.Services {
+		if service, ok := m.services[*arn]; ok && !IsServiceStable(service) {
+			return awserr.New(request.WaiterResourceNotReadyErrorCode, "exceeded wait attempts", nil)
+		}
+	}
+
+	return nil
+}
+
+func (m *mockEcsClient) ListClustersPagesWithContext(ctx aws.Context, input *ecs.ListClustersInput,
+	fn func(*ecs.ListClustersOutput, bool) bool, opts ...request.Option) error {
+	var arns []string
+	for arn := range m.clusters {
+		arns = append(arns, arn)
+	}
+	sort.Strings(arns)
+
+	pages := chunkStrings(aws.StringSlice(arns), 100)
+	for i, page := range pages {
+		if !fn(&ecs.ListClustersOutput{ClusterArns: page}, i == len(pages)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (m *mockEcsClient) DescribeClustersWithContext(ctx aws.Context, input *ecs.DescribeClustersInput,
+	opts ...request.Option) (*ecs.DescribeClustersOutput, error) {
+	if len(input.Clusters) > 100 {
+		return nil, awserr.New(ecs.ErrCodeInvalidParameterException, "too many clusters", nil)
+	}
+
+	out := &ecs.DescribeClustersOutput{}
+	for _, name := range input.Clusters {
+		if cluster, ok := m.clusters[*name]; ok {
+			out.Clusters = append(out.Clusters, cluster)
+		} else {
+			out.Failures = append(out.Failures, &ecs.Failure{Arn: name, Reason: aws.String("MISSING")})
+		}
+	}
+
+	return out, nil
+}
+
+func (m *mockEcsClient) DescribeCapacityProvidersWithContext(ctx aws.Context, input *ecs.DescribeCapacityProvidersInput,
+	opts ...request.Option) (*ecs.DescribeCapacityProvidersOutput, error) {
+	out := &ecs.DescribeCapacityProvidersOutput{}
+	for _, name := range input.CapacityProviders {
+		if provider, ok := m.capacityProviders[*name]; ok {
+			out.CapacityProviders = append(out.CapacityProviders, provider)
+		}
+	}
+
+	return out, nil
+}
+
+func (m *mockEcsClient) DescribeTaskDefinitionWithContext(ctx aws.Context, input *ecs.DescribeTaskDefinitionInput,
+	opts ...request.Option) (*ecs.DescribeTaskDefinitionOutput, error) {
+	if m.describeTaskDefinitionError != nil {
+		return nil, m.describeTaskDefinitionError
+	}
+
+	taskDef, ok := m.taskDefinitions[*input.TaskDefinition]
+	if !ok {
+		return nil, awserr.New(ecs.ErrCodeClientException, "Unable to describe task definition.", nil)
+	}
+
+	return &ecs.DescribeTaskDefinitionOutput{TaskDefinition: taskDef}, nil
+}
+
+func (m *mockEcsClient) ListTaskDefinitionsPagesWithContext(ctx aws.Context, input *ecs.ListTaskDefinitionsInput,
+	fn func(*ecs.ListTaskDefinitionsOutput, bool) bool, opts ...request.Option) error {
+	pages := chunkStrings(m.taskDefinitionArns, 100)
+	for i, page := range pages {
+		if !fn(&ecs.ListTaskDefinitionsOutput{TaskDefinitionArns: page}, i == len(pages)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (m *mockEcsClient) ListTasksPagesWithContext(ctx aws.Context, input *ecs.ListTasksInput,
+	fn func(*ecs.ListTasksOutput, bool) bool, opts ...request.Option) error {
+	var arns []*string
+	for arn, task := range m.tasks {
+		if input.ContainerInstance != nil && aws.StringValue(task.ContainerInstanceArn) != *input.ContainerInstance {
+			continue
+		}
+		if input.DesiredStatus != nil && aws.StringValue(task.DesiredStatus) != *input.DesiredStatus {
+			continue
+		}
+		arns = append(arns, aws.String(arn))
+	}
+
+	pages := chunkStrings(arns, 100)
+	for i, page := range pages {
+		if !fn(&ecs.ListTasksOutput{TaskArns: page}, i == len(pages)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (m *mockEcsClient) DescribeTasksWithContext(ctx aws.Context, input *ecs.DescribeTasksInput,
+	opts ...request.Option) (*ecs.DescribeTasksOutput, error) {
+	if len(input.Tasks) > 100 {
+		return nil, awserr.New(ecs.ErrCodeInvalidParameterException, "too many tasks", nil)
+	}
+
+	out := &ecs.DescribeTasksOutput{}
+	for _, arn := range input.Tasks {
+		if task, ok := m.tasks[*arn]; ok {
+			out.Tasks = append(out.Tasks, task)
+		}
+	}
+
+	return out, nil
+}
+
+func (m *mockEcsClient) ExecuteCommandWithContext(ctx aws.Context, input *ecs.ExecuteCommandInput,
+	opts ...request.Option) (*ecs.ExecuteCommandOutput, error) {
+	m.executeCommandInputs = append(m.executeCommandInputs, input)
+
+	return &ecs.ExecuteCommandOutput{
+		ClusterArn:    input.Cluster,
+		ContainerName: input.Container,
+		Interactive:   input.Interactive,
+		TaskArn:       input.Task,
+		Session: &ecs.Session{
+			SessionId:  aws.String("ecs-execute-command-0123456789"),
+			StreamUrl:  aws.String("wss://ssmmessages.us-east-1.amazonaws.com/v1/data-channel/ecs-execute-command-0123456789"),
+			TokenValue: aws.String("token"),
+		},
+	}, nil
+}
+
+func (m *mockEcsClient) ListContainerInstancesPagesWithContext(ctx aws.Context, input *ecs.ListContainerInstancesInput,
+	fn func(*ecs.ListContainerInstancesOutput, bool) bool, opts ...request.Option) error {
+	if err, ok := m.listContainerInstancesErrors[aws.StringValue(input.Cluster)]; ok {
+		return err
+	}
+
+	for i, page := range m.containerInstanceArnPages {
+		if !fn(&ecs.ListContainerInstancesOutput{ContainerInstanceArns: page}, i == len(m.containerInstanceArnPages)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// ListContainerInstancesWithContext supports the ec2InstanceId == filter used to find a single instance
+func (m *mockEcsClient) ListContainerInstancesWithContext(ctx aws.Context, input *ecs.ListContainerInstancesInput,
+	opts ...request.Option) (*ecs.ListContainerInstancesOutput, error) {
+	instanceID := strings.TrimPrefix(aws.StringValue(input.Filter), "ec2InstanceId == ")
+
+	out := &ecs.ListContainerInstancesOutput{}
+	for arn, instance := range m.containerInstances {
+		if aws.StringValue(instance.Ec2InstanceId) == instanceID {
+			out.ContainerInstanceArns = append(out.ContainerInstanceArns, aws.String(arn))
+		}
+	}
+
+	return out, nil
+}
+
+func (m *mockEcsClient) UpdateContainerInstancesStateWithContext(ctx aws.Context, input *ecs.UpdateContainerInstancesStateInput,
+	opts ...request.Option) (*ecs.UpdateContainerInstancesStateOutput, error) {
+	for _, arn := range input.ContainerInstances {
+		if instance, ok := m.containerInstances[*arn]; ok {
+			instance.Status = input.Status
+		}
+	}
+
+	return &ecs.UpdateContainerInstancesStateOutput{}, nil
+}
+
+func (m *mockEcsClient) DescribeContainerInstancesWithContext(ctx aws.Context, input *ecs.DescribeContainerInstancesInput,
+	opts ...request.Option) (*ecs.DescribeContainerInstancesOutput, error) {
+	m.describeContainerInstancesCalls++
+	if len(input.ContainerInstances) > 100 {
+		return nil, awserr.New(ecs.ErrCodeInvalidParameterException, "too many container instances", nil)
+	}
+
+	out := &ecs.DescribeContainerInstancesOutput{}
+	for _, arn := range input.ContainerInstances {
+		if instance, ok := m.containerInstances[*arn]; ok {
+			out.ContainerInstances = append(out.ContainerInstances, instance)
+		} else {
+			out.ContainerInstances = append(out.ContainerInstances, &ecs.ContainerInstance{ContainerInstanceArn: arn})
+		}
+	}
+
+	return out, nil
+}
+
+// mockEc2Client implements the subset of the EC2 API used by lib from in-memory data
+type mockEc2Client struct {
+	ec2iface.EC2API
+
+	instances     []*ec2.Instance
+	instanceTypes map[string]*ec2.InstanceTypeInfo
+
+	instanceStatuses map[string]*ec2.InstanceStatus
+
+	// launchTemplateVersions is keyed by launch template ID
+	launchTemplateVersions map[string][]*ec2.LaunchTemplateVersion
+
+	describeInstanceTypesCalls int
+	terminatedInstanceIDs      []string
+	// terminateErrors are returned by successive TerminateInstances calls before they succeed
+	terminateErrors    []error
+	terminateCallCount int
+
+	createTagsInputs []*ec2.CreateTagsInput
+
+	// mu guards the DescribeInstances call records, which lib may make concurrently
+	mu                     sync.Mutex
+	describeInstancesCalls int
+	maxDescribeInstanceIDs int
+}
+
+// setMockEc2Client makes lib use m for EC2 calls until the test finishes
+func setMockEc2Client(t *testing.T, m ec2iface.EC2API) {
+	original := newEc2Client
+	newEc2Client = func(awsSess *session.Session) ec2iface.EC2API {
+		return m
+	}
+	t.Cleanup(func() {
+		newEc2Client = original
+	})
+}
+
+func (m *mockEc2Client) DescribeInstancesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput,
+	opts ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+	m.mu.Lock()
+	m.describeInstancesCalls++
+	if len(input.InstanceIds) > m.maxDescribeInstanceIDs {
+		m.maxDescribeInstanceIDs = len(input.InstanceIds)
+	}
+	m.mu.Unlock()
+
+	if len(input.InstanceIds) > 1000 {
+		return nil, awserr.New("InvalidParameterValue", "too many instance IDs", nil)
+	}
+
+	requested := map[string]bool{}
+	for _, id := range input.InstanceIds {
+		requested[*id] = true
+	}
+
+	reservation := &ec2.Reservation{}
+	for _, instance := range m.instances {
+		if len(requested) == 0 || requested[*instance.InstanceId] {
+			reservation.Instances = append(reservation.Instances, instance)
+		}
+	}
+
+	// EC2 leaves out reservations with no matching instances rather than returning them empty
+	if len(reservation.Instances) == 0 {
+		return &ec2.DescribeInstancesOutput{}, nil
+	}
+
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{reservation}}, nil
+}
+
+func (m *mockEc2Client) CreateTagsWithContext(ctx aws.Context, input *ec2.CreateTagsInput,
+	opts ...request.Option) (*ec2.CreateTagsOutput, error) {
+	m.createTagsInputs = append(m.createTagsInputs, input)
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (m *mockEc2Client) DescribeInstanceTypesWithContext(ctx aws.Context, input *ec2.DescribeInstanceTypesInput,
+	opts ...request.Option) (*ec2.DescribeInstanceTypesOutput, error) {
+	m.describeInstanceTypesCalls++
+
+	out := &ec2.DescribeInstanceTypesOutput{}
+	for _, instanceType := range input.InstanceTypes {
+		info, ok := m.instanceTypes[*instanceType]
+		if !ok {
+			return nil, awserr.New("InvalidInstanceType", "invalid instance type", nil)
+		}
+		out.InstanceTypes = append(out.InstanceTypes, info)
+	}
+
+	return out, nil
+}
+
+func (m *mockEc2Client) DescribeInstanceStatusWithContext(ctx aws.Context, input *ec2.DescribeInstanceStatusInput,
+	opts ...request.Option) (*ec2.DescribeInstanceStatusOutput, error) {
+	out := &ec2.DescribeInstanceStatusOutput{}
+	for _, id := range input.InstanceIds {
+		if status, ok := m.instanceStatuses[*id]; ok {
+			out.InstanceStatuses = append(out.InstanceStatuses, status)
+		}
+	}
+
+	return out, nil
+}
+
+func (m *mockEc2Client) DescribeLaunchTemplateVersionsWithContext(ctx aws.Context, input *ec2.DescribeLaunchTemplateVersionsInput,
+	opts ...request.Option) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
+	if input.LaunchTemplateId != nil && input.LaunchTemplateName != nil {
+		return nil, awserr.New("InvalidParameterCombination", "specify either the ID or the name", nil)
+	}
+
+	var versions []*ec2.LaunchTemplateVersion
+	for id, templateVersions := range m.launchTemplateVersions {
+		for _, version := range templateVersions {
+			if aws.StringValue(input.LaunchTemplateId) == id ||
+				aws.StringValue(input.LaunchTemplateName) == aws.StringValue(version.LaunchTemplateName) {
+				versions = append(versions, version)
+			}
+		}
+	}
+	if len(versions) == 0 {
+		return nil, awserr.New("InvalidLaunchTemplateId.NotFound", "launch template not found", nil)
+	}
+
+	out := &ec2.DescribeLaunchTemplateVersionsOutput{}
+	for _, requested := range input.Versions {
+		var match *ec2.LaunchTemplateVersion
+		for _, version := range versions {
+			switch *requested {
+			case "$Default":
+				if aws.BoolValue(version.DefaultVersion) {
+					match = version
+				}
+			case "$Latest":
+				if match == nil || *version.VersionNumber > *match.VersionNumber {
+					match = version
+				}
+			default:
+				if fmt.Sprint(*version.VersionNumber) == *requested {
+					match = version
+				}
+			}
+		}
+		if match != nil {
+			out.LaunchTemplateVersions = append(out.LaunchTemplateVersions, match)
+		}
+	}
+
+	return out, nil
+}
+
+func (m *mockEc2Client) TerminateInstancesWithContext(ctx aws.Context, input *ec2.TerminateInstancesInput,
+	opts ...request.Option) (*ec2.TerminateInstancesOutput, error) {
+	m.terminateCallCount++
+	if len(m.terminateErrors) > 0 {
+		err := m.terminateErrors[0]
+		m.terminateErrors = m.terminateErrors[1:]
+		return nil, err
+	}
+
+	for _, id := range input.InstanceIds {
+		m.terminatedInstanceIDs = append(m.terminatedInstanceIDs, *id)
+	}
+
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
+// mockAutoscalingClient implements the subset of the Auto Scaling API used by lib from in-memory data
+type mockAutoscalingClient struct {
+	autoscalingiface.AutoScalingAPI
+
+	groups map[string]*autoscaling.Group
+	// duplicateGroups makes DescribeAutoScalingGroups return each matching group twice
+	duplicateGroups      bool
+	activities           []*autoscaling.Activity
+	launchConfigurations map[string]*autoscaling.LaunchConfiguration
+	// describeAutoScalingGroupsError is returned when describing any ASG
+	describeAutoScalingGroupsError error
+
+	attachInstancesInputs        []*autoscaling.AttachInstancesInput
+	detachInstancesInputs        []*autoscaling.DetachInstancesInput
+	updateAutoScalingGroupInputs []*autoscaling.UpdateAutoScalingGroupInput
+	startInstanceRefreshInputs   []*autoscaling.StartInstanceRefreshInput
+
+	// instanceRefreshes holds successive DescribeInstanceRefreshes responses, the last one
+	// is repeated once the others are used up
+	instanceRefreshes []*autoscaling.InstanceRefresh
+
+	scalingPolicies                      []*autoscaling.ScalingPolicy
+	lifecycleHooks                       []*autoscaling.LifecycleHook
+	completeLifecycleActionInputs        []*autoscaling.CompleteLifecycleActionInput
+	recordLifecycleActionHeartbeatInputs []*autoscaling.RecordLifecycleActionHeartbeatInput
+}
+
+// setMockAutoscalingClient makes lib use m for Auto Scaling calls until the test finishes
+func setMockAutoscalingClient(t *testing.T, m autoscalingiface.AutoScalingAPI) {
+	original := newAutoscalingClient
+	newAutoscalingClient = func(awsSess *session.Session) autoscalingiface.AutoScalingAPI {
+		return m
+	}
+	t.Cleanup(func() {
+		newAutoscalingClient = original
+	})
+}
+
+func (m *mockAutoscalingClient) AttachInstancesWithContext(ctx aws.Context, input *autoscaling.AttachInstancesInput,
+	opts ...request.Option) (*autoscaling.AttachInstancesOutput, error) {
+	m.attachInstancesInputs = append(m.attachInstancesInputs, input)
+	if len(input.InstanceIds) > 20 {
+		return nil, awserr.New("ValidationError", "too many instances", nil)
+	}
+
+	if group, ok := m.groups[*input.AutoScalingGroupName]; ok {
+		for _, id := range input.InstanceIds {
+			group.Instances = append(group.Instances, &autoscaling.Instance{
+				InstanceId:     id,
+				LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+			})
+		}
+	}
+
+	return &autoscaling.AttachInstancesOutput{}, nil
+}
+
+func (m *mockAutoscalingClient) DetachInstancesWithContext(ctx aws.Context, input *autoscaling.DetachInstancesInput,
+	opts ...request.Option) (*autoscaling.DetachInstancesOutput, error) {
+	m.detachInstancesInputs = append(m.detachInstancesInputs, input)
+	if len(input.InstanceIds) > 20 {
+		return nil, awserr.New("ValidationError", "too many instances", nil)
+	}
+
+	group, ok := m.groups[*input.AutoScalingGroupName]
+	if !ok {
+		return nil, awserr.New("ValidationError", "group not found", nil)
+	}
+
+	detach := map[string]bool{}
+	for _, id := range input.InstanceIds {
+		detach[*id] = true
+	}
+
+	var remaining []*autoscaling.Instance
+	for _, instance := range group.Instances {
+		if !detach[*instance.InstanceId] {
+			remaining = append(remaining, instance)
+		}
+	}
+	group.Instances = remaining
+
+	return &autoscaling.DetachInstancesOutput{}, nil
+}
+
+// UpdateAutoScalingGroupWithContext applies the new sizes and immediately launches InService
+// instances named i-launched-N up to the desired capacity
+func (m *mockAutoscalingClient) UpdateAutoScalingGroupWithContext(ctx aws.Context, input *autoscaling.UpdateAutoScalingGroupInput,
+	opts ...request.Option) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	m.updateAutoScalingGroupInputs = append(m.updateAutoScalingGroupInputs, input)
+
+	group, ok := m.groups[*input.AutoScalingGroupName]
+	if !ok {
+		return nil, awserr.New("ValidationError", "group not found", nil)
+	}
+
+	group.MinSize, group.MaxSize, group.DesiredCapacity = input.MinSize, input.MaxSize, input.DesiredCapacity
+	for n := 1; int64(len(group.Instances)) < aws.Int64Value(group.DesiredCapacity); n++ {
+		group.Instances = append(group.Instances, &autoscaling.Instance{
+			InstanceId:     aws.String(fmt.Sprintf("i-launched-%v", n)),
+			LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+		})
+	}
+
+	return &autoscaling.UpdateAutoScalingGroupOutput{}, nil
+}
+
+func (m *mockAutoscalingClient) StartInstanceRefreshWithContext(ctx aws.Context, input *autoscaling.StartInstanceRefreshInput,
+	opts ...request.Option) (*autoscaling.StartInstanceRefreshOutput, error) {
+	m.startInstanceRefreshInputs = append(m.startInstanceRefreshInputs, input)
+
+	return &autoscaling.StartInstanceRefreshOutput{InstanceRefreshId: aws.String("refresh-1")}, nil
+}
+
+func (m *mockAutoscalingClient) DescribeInstanceRefreshesWithContext(ctx aws.Context, input *autoscaling.DescribeInstanceRefreshesInput,
+	opts ...request.Option) (*autoscaling.DescribeInstanceRefreshesOutput, error) {
+	if len(m.instanceRefreshes) == 0 {
+		return &autoscaling.DescribeInstanceRefreshesOutput{}, nil
+	}
+
+	refresh := m.instanceRefreshes[0]
+	if len(m.instanceRefreshes) > 1 {
+		m.instanceRefreshes = m.instanceRefreshes[1:]
+	}
+
+	return &autoscaling.DescribeInstanceRefreshesOutput{InstanceRefreshes: []*autoscaling.InstanceRefresh{refresh}}, nil
+}
+
+func (m *mockAutoscalingClient) DescribeScalingActivitiesWithContext(ctx aws.Context, input *autoscaling.DescribeScalingActivitiesInput,
+	opts ...request.Option) (*autoscaling.DescribeScalingActivitiesOutput, error) {
+	activities := m.activities
+	if input.MaxRecords != nil && int64(len(activities)) > *input.MaxRecords {
+		activities = activities[:*input.MaxRecords]
+	}
+
+	return &autoscaling.DescribeScalingActivitiesOutput{Activities: activities}, nil
+}
+
+func (m *mockAutoscalingClient) DescribeLaunchConfigurationsWithContext(ctx aws.Context,
+	input *autoscaling.DescribeLaunchConfigurationsInput, opts ...request.Option) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
+	out := &autoscaling.DescribeLaunchConfigurationsOutput{}
+	for _, name := range input.LaunchConfigurationNames {
+		if lc, ok := m.launchConfigurations[*name]; ok {
+			out.LaunchConfigurations = append(out.LaunchConfigurations, lc)
+		}
+	}
+
+	return out, nil
+}
+
+func (m *mockAutoscalingClient) DescribeAutoScalingGroupsWithContext(ctx aws.Context, input *autoscaling.DescribeAutoScalingGroupsInput,
+	opts ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	if m.describeAutoScalingGroupsError != nil {
+		return nil, m.describeAutoScalingGroupsError
+	}
+
+	out := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	for _, name := range input.AutoScalingGroupNames {
+		if group, ok := m.groups[*name]; ok {
+			out.AutoScalingGroups = append(out.AutoScalingGroups, group)
+			if m.duplicateGroups {
+				out.AutoScalingGroups = append(out.AutoScalingGroups, group)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (m *mockAutoscalingClient) DescribePoliciesPagesWithContext(ctx aws.Context, input *autoscaling.DescribePoliciesInput,
+	fn func(*autoscaling.DescribePoliciesOutput, bool) bool, opts ...request.Option) error {
+	fn(&autoscaling.DescribePoliciesOutput{ScalingPolicies: m.scalingPolicies}, true)
+	return nil
+}
+
+func (m *mockAutoscalingClient) DescribeLifecycleHooksWithContext(ctx aws.Context, input *autoscaling.DescribeLifecycleHooksInput,
+	opts ...request.Option) (*autoscaling.DescribeLifecycleHooksOutput, error) {
+	return &autoscaling.DescribeLifecycleHooksOutput{LifecycleHooks: m.lifecycleHooks}, nil
+}
+
+func (m *mockAutoscalingClient) CompleteLifecycleActionWithContext(ctx aws.Context, input *autoscaling.CompleteLifecycleActionInput,
+	opts ...request.Option) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	m.completeLifecycleActionInputs = append(m.completeLifecycleActionInputs, input)
+	return &autoscaling.CompleteLifecycleActionOutput{}, nil
+}
+
+func (m *mockAutoscalingClient) RecordLifecycleActionHeartbeatWithContext(ctx aws.Context, input *autoscaling.RecordLifecycleActionHeartbeatInput,
+	opts ...request.Option) (*autoscaling.RecordLifecycleActionHeartbeatOutput, error) {
+	m.recordLifecycleActionHeartbeatInputs = append(m.recordLifecycleActionHeartbeatInputs, input)
+	return &autoscaling.RecordLifecycleActionHeartbeatOutput{}, nil
+}
+
+// mockCloudwatchClient implements the subset of the CloudWatch API used by lib from in-memory data
+type mockCloudwatchClient struct {
+	cloudwatchiface.CloudWatchAPI
+
+	// alarmStates is keyed by alarm name
+	alarmStates map[string]string
+
+	describeAlarmsInputs []*cloudwatch.DescribeAlarmsInput
+}
+
+// setMockCloudwatchClient makes lib use m for CloudWatch calls until the test finishes
+func setMockCloudwatchClient(t *testing.T, m cloudwatchiface.CloudWatchAPI) {
+	original := newCloudwatchClient
+	newCloudwatchClient = func(awsSess *session.Session) cloudwatchiface.CloudWatchAPI {
+		return m
+	}
+	t.Cleanup(func() {
+		newCloudwatchClient = original
+	})
+}
+
+func (m *mockCloudwatchClient) DescribeAlarmsPagesWithContext(ctx aws.Context, input *cloudwatch.DescribeAlarmsInput,
+	fn func(*cloudwatch.DescribeAlarmsOutput, bool) bool, opts ...request.Option) error {
+	m.describeAlarmsInputs = append(m.describeAlarmsInputs, input)
+
+	out := &cloudwatch.DescribeAlarmsOutput{}
+	for _, name := range input.AlarmNames {
+		state, ok := m.alarmStates[*name]
+		if ok && (input.StateValue == nil || *input.StateValue == state) {
+			out.MetricAlarms = append(out.MetricAlarms, &cloudwatch.MetricAlarm{AlarmName: name, StateValue: aws.String(state)})
+		}
+	}
+	fn(out, true)
+
+	return nil
+}
+
+// mockElbv2Client implements the subset of the ELBv2 API used by lib from in-memory data
+type mockElbv2Client struct {
+	elbv2iface.ELBV2API
+
+	// targetHealth holds successive DescribeTargetHealth responses for each target group,
+	// the last one is repeated once the others are used up
+	targetHealth map[string][][]*elbv2.TargetHealthDescription
+
+	describeTargetHealthCalls int
+}
+
+// setMockElbv2Client makes lib use m for ELBv2 calls until the test finishes
+func setMockElbv2Client(t *testing.T, m elbv2iface.ELBV2API) {
+	original := newElbv2Client
+	newElbv2Client = func(awsSess *session.Session) elbv2iface.ELBV2API {
+		return m
+	}
+	t.Cleanup(func() {
+		newElbv2Client = original
+	})
+}
+
+func (m *mockElbv2Client) DescribeTargetHealthWithContext(ctx aws.Context, input *elbv2.DescribeTargetHealthInput,
+	opts ...request.Option) (*elbv2.DescribeTargetHealthOutput, error) {
+	m.describeTargetHealthCalls++
+
+	responses, ok := m.targetHealth[*input.TargetGroupArn]
+	if !ok {
+		return nil, awserr.New(elbv2.ErrCodeTargetGroupNotFoundException, "target group not found", nil)
+	}
+
+	if len(responses) > 1 {
+		m.targetHealth[*input.TargetGroupArn] = responses[1:]
+	}
+
+	return &elbv2.DescribeTargetHealthOutput{TargetHealthDescriptions: responses[0]}, nil
+}
+
+// mockServiceDiscoveryClient implements the subset of the Cloud Map API used by lib from in-memory data
+type mockServiceDiscoveryClient struct {
+	servicediscoveryiface.ServiceDiscoveryAPI
+
+	// services and namespaces are keyed by ID
+	services   map[string]*servicediscovery.Service
+	namespaces map[string]*servicediscovery.Namespace
+
+	// instances holds successive DiscoverInstances responses for each service.namespace name,
+	// the last one is repeated once the others are used up
+	instances map[string][][]*servicediscovery.HttpInstanceSummary
+}
+
+// setMockServiceDiscoveryClient makes lib use m for Cloud Map calls until the test finishes
+func setMockServiceDiscoveryClient(t *testing.T, m servicediscoveryiface.ServiceDiscoveryAPI) {
+	original := newServiceDiscoveryClient
+	newServiceDiscoveryClient = func(awsSess *session.Session) servicediscoveryiface.ServiceDiscoveryAPI {
+		return m
+	}
+	t.Cleanup(func() {
+		newServiceDiscoveryClient = original
+	})
+}
+
+func (m *mockServiceDiscoveryClient) GetServiceWithContext(ctx aws.Context, input *servicediscovery.GetServiceInput,
+	opts ...request.Option) (*servicediscovery.GetServiceOutput, error) {
+	service, ok := m.services[*input.Id]
+	if !ok {
+		return nil, awserr.New(servicediscovery.ErrCodeServiceNotFound, "service not found", nil)
+	}
+
+	return &servicediscovery.GetServiceOutput{Service: service}, nil
+}
+
+func (m *mockServiceDiscoveryClient) GetNamespaceWithContext(ctx aws.Context, input *servicediscovery.GetNamespaceInput,
+	opts ...request.Option) (*servicediscovery.GetNamespaceOutput, error) {
+	namespace, ok := m.namespaces[*input.Id]
+	if !ok {
+		return nil, awserr.New(servicediscovery.ErrCodeNamespaceNotFound, "namespace not found", nil)
+	}
+
+	return &servicediscovery.GetNamespaceOutput{Namespace: namespace}, nil
+}
+
+func (m *mockServiceDiscoveryClient) DiscoverInstancesWithContext(ctx aws.Context, input *servicediscovery.DiscoverInstancesInput,
+	opts ...request.Option) (*servicediscovery.DiscoverInstancesOutput, error) {
+	key := *input.ServiceName + "." + *input.NamespaceName
+	responses, ok := m.instances[key]
+	if !ok {
+		return &servicediscovery.DiscoverInstancesOutput{}, nil
+	}
+
+	if len(responses) > 1 {
+		m.instances[key] = responses[1:]
+	}
+
+	return &servicediscovery.DiscoverInstancesOutput{Instances: responses[0]}, nil
+}
+
+// setMockInstanceTypes makes lib see t2 instance types from a mock EC2 API until the test finishes
+func setMockInstanceTypes(t *testing.T) *mockEc2Client {
+	instanceType := func(vcpus, memory int64) *ec2.InstanceTypeInfo {
+		return &ec2.InstanceTypeInfo{
+			VCpuInfo:   &ec2.VCpuInfo{DefaultVCpus: aws.Int64(vcpus)},
+			MemoryInfo: &ec2.MemoryInfo{SizeInMiB: aws.Int64(memory)},
+		}
+	}
+
+	mock := &mockEc2Client{
+		instanceTypes: map[string]*ec2.InstanceTypeInfo{
+			"t2.nano":    instanceType(1, 512),
+			"t2.micro":   instanceType(1, 1024),
+			"t2.small":   instanceType(1, 2048),
+			"t2.medium":  instanceType(2, 4096),
+			"t2.large":   instanceType(2, 8192),
+			"t2.xlarge":  instanceType(4, 16384),
+			"t2.2xlarge": instanceType(8, 32768),
+		},
+	}
+	setMockEc2Client(t, mock)
+	resetInstanceCapacities(t)
+
+	return mock
+}
+
+// resetInstanceCapacities clears loaded and cached instance capacities until the test finishes
+func resetInstanceCapacities(t *testing.T) {
+	overrides, cache := instanceCapacityOverrides, instanceCapacityCache
+	instanceCapacityOverrides = map[string]InstanceCapacity{}
+	instanceCapacityCache = map[string]InstanceCapacity{}
+	t.Cleanup(func() {
+		instanceCapacityOverrides, instanceCapacityCache = overrides, cache
+	})
+}
+
+// setMockCluster makes lib see a cluster whose container instances run on the given EC2 instances
+func setMockCluster(t *testing.T, instances []*ec2.Instance) *mockEcsClient {
+	var arns []*string
+	containerInstances := map[string]*ecs.ContainerInstance{}
+	for n, instance := range instances {
+		arn := fmt.Sprintf("arn:aws:ecs:us-east-1:123456789012:container-instance/test/%v", n)
+		arns = append(arns, aws.String(arn))
+		containerInstances[arn] = &ecs.ContainerInstance{
+			ContainerInstanceArn: aws.String(arn),
+			Ec2InstanceId:        instance.InstanceId,
+			Status:               aws.String(ecs.ContainerInstanceStatusActive),
+			AgentConnected:       aws.Bool(true),
+		}
+	}
+
+	mock := &mockEcsClient{
+		containerInstanceArnPages: [][]*string{arns},
+		containerInstances:        containerInstances,
+	}
+	setMockEcsClient(t, mock)
+	setMockEc2Client(t, &mockEc2Client{instances: instances})
+
+	return mock
+}
+
+func makeArns(resource string, count int) []*string {
+	var arns []*string
+	for i := 0; i < count; i++ {
+		arns = append(arns, aws.String(fmt.Sprintf("arn:aws:ecs:us-east-1:123456789012:%s/%v", resource, i)))
+	}
+
+	return arns
+}
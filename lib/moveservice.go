@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// GetCreateServiceInputForCopy returns the CreateService input for a service named name in cluster that runs
+// the same task definition with the same desired count, load balancers, placement, networking and deployment
+// settings as service. Tags reserved by AWS are left out, and so is a capacity provider strategy using
+// providers other than Fargate, since capacity providers belong to a cluster, so the target cluster's default
+// strategy applies instead.
+func GetCreateServiceInputForCopy(service *ecs.Service, cluster, name string) *ecs.CreateServiceInput {
+	input := &ecs.CreateServiceInput{
+		Cluster:                       aws.String(cluster),
+		ServiceName:                   aws.String(name),
+		TaskDefinition:                service.TaskDefinition,
+		LoadBalancers:                 service.LoadBalancers,
+		ServiceRegistries:             service.ServiceRegistries,
+		PlacementConstraints:          service.PlacementConstraints,
+		PlacementStrategy:             service.PlacementStrategy,
+		NetworkConfiguration:          service.NetworkConfiguration,
+		DeploymentConfiguration:       service.DeploymentConfiguration,
+		DeploymentController:          service.DeploymentController,
+		HealthCheckGracePeriodSeconds: service.HealthCheckGracePeriodSeconds,
+		PlatformVersion:               service.PlatformVersion,
+		SchedulingStrategy:            service.SchedulingStrategy,
+		EnableECSManagedTags:          service.EnableECSManagedTags,
+		EnableExecuteCommand:          service.EnableExecuteCommand,
+	}
+
+	// Daemon services run a task on every instance and don't accept a desired count
+	if aws.StringValue(service.SchedulingStrategy) != ecs.SchedulingStrategyDaemon {
+		input.DesiredCount = service.DesiredCount
+	}
+
+	if propagate := aws.StringValue(service.PropagateTags); propagate != "" && propagate != "NONE" {
+		input.PropagateTags = service.PropagateTags
+	}
+
+	if len(service.CapacityProviderStrategy) > 0 {
+		fargateOnly := true
+		for _, item := range service.CapacityProviderStrategy {
+			fargateOnly = fargateOnly && strings.HasPrefix(aws.StringValue(item.CapacityProvider), "FARGATE")
+		}
+		if fargateOnly {
+			input.CapacityProviderStrategy = service.CapacityProviderStrategy
+		}
+	} else {
+		input.LaunchType = service.LaunchType
+	}
+
+	// Services created with the ECS service-linked role report it, but it can't be passed as the role
+	roleArn := aws.StringValue(service.RoleArn)
+	if roleArn != "" && len(service.LoadBalancers) > 0 && !strings.Contains(roleArn, "/aws-service-role/") {
+		input.Role = service.RoleArn
+	}
+
+	for _, tag := range service.Tags {
+		if !strings.HasPrefix(aws.StringValue(tag.Key), "aws:") {
+			input.Tags = append(input.Tags, tag)
+		}
+	}
+
+	return input
+}
+
+// CheckServiceNameAvailable returns an error if cluster already has an ACTIVE or DRAINING service named name.
+// Only INACTIVE services, which ECS keeps reporting for a while after they are deleted, can be replaced.
+func CheckServiceNameAvailable(ctx context.Context, awsSess *session.Session, cluster, name string) error {
+	svc := newEcsClient(awsSess)
+
+	descResult, err := svc.DescribeServicesWithContext(ctx, &ecs.DescribeServicesInput{
+		Cluster:  aws.String(cluster),
+		Services: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return handleEcsError(err)
+	}
+
+	for _, existing := range descResult.Services {
+		switch aws.StringValue(existing.Status) {
+		case "ACTIVE":
+			return fmt.Errorf("service %s already exists in cluster %s, choose another name for the new service", name, cluster)
+		case "DRAINING":
+			return fmt.Errorf("service %s in cluster %s is still being deleted, wait for it to be INACTIVE or choose another name", name, cluster)
+		}
+	}
+
+	return nil
+}
+
+// CreateEcsService creates the service described by input after checking its name is not already used in the cluster
+func CreateEcsService(ctx context.Context, awsSess *session.Session, input *ecs.CreateServiceInput) (*ecs.Service, error) {
+	if err := CheckServiceNameAvailable(ctx, awsSess, aws.StringValue(input.Cluster), aws.StringValue(input.ServiceName)); err != nil {
+		return nil, err
+	}
+
+	svc := newEcsClient(awsSess)
+
+	result, err := svc.CreateServiceWithContext(ctx, input)
+	if err != nil {
+		return nil, handleEcsError(err)
+	}
+
+	return result.Service, nil
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestGetCreateServiceInputForCopy(t *testing.T) {
+	service := &ecs.Service{
+		ServiceName:    aws.String("web"),
+		TaskDefinition: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/web:7"),
+		DesiredCount:   aws.Int64(3),
+		LaunchType:     aws.String(ecs.LaunchTypeEc2),
+		LoadBalancers: []*ecs.LoadBalancer{
+			{TargetGroupArn: aws.String("tg-web"), ContainerName: aws.String("web"), ContainerPort: aws.Int64(80)},
+		},
+		PlacementConstraints: []*ecs.PlacementConstraint{{Type: aws.String("distinctInstance")}},
+		PlacementStrategy:    []*ecs.PlacementStrategy{{Type: aws.String("spread"), Field: aws.String("instanceId")}},
+		RoleArn:              aws.String("arn:aws:iam::123456789012:role/aws-service-role/ecs.amazonaws.com/AWSServiceRoleForECS"),
+		PropagateTags:        aws.String("NONE"),
+		Tags: []*ecs.Tag{
+			{Key: aws.String("team"), Value: aws.String("web")},
+			{Key: aws.String("aws:cloudformation:stack-name"), Value: aws.String("web")},
+		},
+	}
+
+	input := GetCreateServiceInputForCopy(service, "new-cluster", "web")
+	if aws.StringValue(input.Cluster) != "new-cluster" || aws.StringValue(input.ServiceName) != "web" ||
+		aws.StringValue(input.TaskDefinition) != aws.StringValue(service.TaskDefinition) || aws.Int64Value(input.DesiredCount) != 3 {
+		t.Errorf("Expected cluster, name, task definition and desired count to be set, got %s", input)
+	}
+	if len(input.LoadBalancers) != 1 || len(input.PlacementConstraints) != 1 || len(input.PlacementStrategy) != 1 {
+		t.Errorf("Expected load balancers and placement to be copied, got %s", input)
+	}
+	if aws.StringValue(input.LaunchType) != ecs.LaunchTypeEc2 || input.CapacityProviderStrategy != nil {
+		t.Errorf("Expected the launch type to be copied, got %s", input)
+	}
+	if input.Role != nil || input.PropagateTags != nil {
+		t.Errorf("Expected the service-linked role and NONE tag propagation to be left out, got %s", input)
+	}
+	if len(input.Tags) != 1 || aws.StringValue(input.Tags[0].Key) != "team" {
+		t.Errorf("Expected only tags not reserved by AWS to be copied, got %s", input)
+	}
+
+	service.LaunchType = nil
+	service.CapacityProviderStrategy = []*ecs.CapacityProviderStrategyItem{{CapacityProvider: aws.String("asg-provider")}}
+	service.SchedulingStrategy = aws.String(ecs.SchedulingStrategyDaemon)
+	input = GetCreateServiceInputForCopy(service, "new-cluster", "web")
+	if input.CapacityProviderStrategy != nil || input.LaunchType != nil {
+		t.Errorf("Expected a cluster's own capacity provider strategy to be left out, got %s", input)
+	}
+	if input.DesiredCount != nil {
+		t.Errorf("Expected no desired count for a daemon service, got %s", input)
+	}
+
+	service.CapacityProviderStrategy = []*ecs.CapacityProviderStrategyItem{{CapacityProvider: aws.String("FARGATE_SPOT")}}
+	input = GetCreateServiceInputForCopy(service, "new-cluster", "web")
+	if len(input.CapacityProviderStrategy) != 1 {
+		t.Errorf("Expected a Fargate capacity provider strategy to be copied, got %s", input)
+	}
+}
+
+func TestCreateEcsService(t *testing.T) {
+	mock := &mockEcsClient{services: map[string]*ecs.Service{
+		"web":    {ServiceName: aws.String("web"), Status: aws.String("ACTIVE")},
+		"worker": {ServiceName: aws.String("worker"), Status: aws.String("DRAINING")},
+		"old":    {ServiceName: aws.String("old"), Status: aws.String("INACTIVE")},
+	}}
+	setMockEcsClient(t, mock)
+
+	for _, name := range []string{"web", "worker"} {
+		_, err := CreateEcsService(context.Background(), nil, &ecs.CreateServiceInput{Cluster: aws.String("test"), ServiceName: aws.String(name)})
+		if err == nil {
+			t.Errorf("Expected an error creating %s over an existing service", name)
+		}
+	}
+	if len(mock.createServiceInputs) != 0 {
+		t.Errorf("Expected no services to be created over existing ones, got %v", len(mock.createServiceInputs))
+	}
+
+	for _, name := range []string{"old", "api"} {
+		created, err := CreateEcsService(context.Background(), nil, &ecs.CreateServiceInput{Cluster: aws.String("test"), ServiceName: aws.String(name)})
+		if err != nil || aws.StringValue(created.ServiceName) != name {
+			t.Errorf("Expected %s to be created, got %v (%v)", name, created, err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"math"
+	"path"
+	"strings"
+)
+
+// ServicePlacement is the part of a service's placement constraints and strategy that affects sizing
+type ServicePlacement struct {
+	// DistinctInstance is true when the service has a distinctInstance constraint, so each task needs its own instance
+	DistinctInstance bool
+
+	// MemberOf is the expression of each memberOf constraint, all of which an instance must match to run a task
+	MemberOf []string
+
+	// SpreadAcrossInstances is true when the strategy spreads tasks by instanceId. Strategies are preferences
+	// ECS relaxes when instances are full, so this does not change the servers needed.
+	SpreadAcrossInstances bool
+}
+
+// GetServicePlacement reads the placement constraints and strategy of a service
+func GetServicePlacement(service *ecs.Service) ServicePlacement {
+	var placement ServicePlacement
+	for _, constraint := range service.PlacementConstraints {
+		switch aws.StringValue(constraint.Type) {
+		case ecs.PlacementConstraintTypeDistinctInstance:
+			placement.DistinctInstance = true
+		case ecs.PlacementConstraintTypeMemberOf:
+			placement.MemberOf = append(placement.MemberOf, aws.StringValue(constraint.Expression))
+		}
+	}
+
+	for _, strategy := range service.PlacementStrategy {
+		if aws.StringValue(strategy.Type) == ecs.PlacementStrategyTypeSpread && aws.StringValue(strategy.Field) == "instanceId" {
+			placement.SpreadAcrossInstances = true
+		}
+	}
+
+	return placement
+}
+
+// InstanceMatchesExpression evaluates a memberOf cluster query expression against a container instance.
+// Only attribute comparisons joined with "and" are understood: attribute:name ==, !=, =~ (with * wildcards),
+// in [...], not_in [...], exists and !exists. ok is false for any other expression.
+func InstanceMatchesExpression(instance *ecs.ContainerInstance, expression string) (matches bool, ok bool) {
+	// Every term is checked, even after one doesn't match, so ok doesn't depend on the instance
+	matches = true
+	for _, term := range strings.Split(expression, " and ") {
+		fields := strings.Fields(term)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "attribute:") {
+			return false, false
+		}
+
+		value, present := containerInstanceAttributeValue(instance, strings.TrimPrefix(fields[0], "attribute:"))
+		operand := strings.Trim(strings.Join(fields[2:], " "), " '\"")
+
+		// Only in and not_in lists may contain spaces
+		operator := fields[1]
+		if (operator == "exists" || operator == "!exists") && len(fields) != 2 ||
+			(operator == "==" || operator == "!=" || operator == "=~") && len(fields) != 3 {
+			return false, false
+		}
+
+		var termMatches bool
+		switch operator {
+		case "exists":
+			termMatches = present
+		case "!exists":
+			termMatches = !present
+		case "==":
+			termMatches = present && value == operand
+		case "!=":
+			termMatches = !present || value != operand
+		case "=~":
+			matched, err := path.Match(operand, value)
+			if err != nil {
+				return false, false
+			}
+			termMatches = present && matched
+		case "in", "not_in":
+			if !strings.HasPrefix(operand, "[") || !strings.HasSuffix(operand, "]") {
+				return false, false
+			}
+			inList := false
+			for _, item := range strings.Split(strings.Trim(operand, "[]"), ",") {
+				if strings.Trim(item, " '\"") == value {
+					inList = present
+				}
+			}
+			termMatches = inList == (operator == "in")
+		default:
+			return false, false
+		}
+
+		matches = matches && termMatches
+	}
+
+	return matches, true
+}
+
+// containerInstanceAttributeValue returns the value of the named attribute and whether it is set at all
+func containerInstanceAttributeValue(instance *ecs.ContainerInstance, name string) (string, bool) {
+	for _, attribute := range instance.Attributes {
+		if aws.StringValue(attribute.Name) == name {
+			return aws.StringValue(attribute.Value), true
+		}
+	}
+
+	return "", false
+}
+
+// GetPlacementMinimumServers returns the fewest servers the placement constraints of the services need, along
+// with a note for each constrained service explaining how it was sized. This is an approximation:
+//   - A distinctInstance service needs at least as many servers as its desired count.
+//   - Other tasks of a memberOf service are bin packed onto servers with the given capacity on their own.
+//   - A memberOf service can only use the share of servers matching its constraints today, so its servers are
+//     scaled up by that share, assuming new instances match in the same proportion (e.g. an AZ constraint).
+//   - Services with expressions InstanceMatchesExpression does not understand are treated as able to use every
+//     server, and services no current instance matches are left out as new instances are not likely to either.
+func GetPlacementMinimumServers(ctx context.Context, awsSess *session.Session, ecsServices []*ecs.Service,
+	instances []*ecs.ContainerInstance, capacity TaskResources) (int64, []string, error) {
+	var minimum int64
+	var notes []string
+
+	for _, service := range ecsServices {
+		placement := GetServicePlacement(service)
+		name := aws.StringValue(service.ServiceName)
+		if aws.Int64Value(service.DesiredCount) == 0 || (!placement.DistinctInstance && len(placement.MemberOf) == 0) {
+			continue
+		}
+
+		var expressions []string
+		for _, expression := range placement.MemberOf {
+			if _, ok := InstanceMatchesExpression(&ecs.ContainerInstance{}, expression); !ok {
+				notes = append(notes, fmt.Sprintf("%s: memberOf expression %q is not understood, assuming every server can run its tasks",
+					name, expression))
+				continue
+			}
+			expressions = append(expressions, expression)
+		}
+
+		eligible := 0
+		for _, instance := range instances {
+			matchesAll := true
+			for _, expression := range expressions {
+				matches, _ := InstanceMatchesExpression(instance, expression)
+				matchesAll = matchesAll && matches
+			}
+			if matchesAll {
+				eligible++
+			}
+		}
+
+		if eligible == 0 {
+			notes = append(notes, fmt.Sprintf("%s: no container instances match its memberOf constraints, leaving it out of placement sizing", name))
+			continue
+		}
+
+		servers := aws.Int64Value(service.DesiredCount)
+		if !placement.DistinctInstance {
+			var err error
+			tasks, err := tasksNeededForEcsServices(ctx, awsSess, []*ecs.Service{service})
+			if err != nil {
+				return 0, nil, err
+			}
+			servers, err = HowManyServersNeededForTasks(capacity, tasks)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+
+		needed := int64(math.Ceil(float64(servers) * float64(len(instances)) / float64(eligible)))
+		note := fmt.Sprintf("%s: needs %v servers", name, needed)
+		if placement.DistinctInstance {
+			note += fmt.Sprintf(", one for each of its %v tasks with distinctInstance", servers)
+		}
+		if eligible < len(instances) {
+			note += fmt.Sprintf(", %v of %v instances match its memberOf constraints", eligible, len(instances))
+		}
+		if placement.SpreadAcrossInstances {
+			note += ", spread across instances is treated as a preference"
+		}
+		notes = append(notes, note)
+
+		if needed > minimum {
+			minimum = needed
+		}
+	}
+
+	return minimum, notes, nil
+}
@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func makeContainerInstance(attributes map[string]string) *ecs.ContainerInstance {
+	instance := &ecs.ContainerInstance{}
+	for name, value := range attributes {
+		instance.Attributes = append(instance.Attributes, &ecs.Attribute{Name: aws.String(name), Value: aws.String(value)})
+	}
+
+	return instance
+}
+
+func TestInstanceMatchesExpression(t *testing.T) {
+	instance := makeContainerInstance(map[string]string{
+		"ecs.availability-zone": "us-east-1a",
+		"ecs.instance-type":     "t3.large",
+		"stack":                 "prod",
+	})
+
+	tests := []struct {
+		Expression string
+		Matches    bool
+		OK         bool
+	}{
+		{Expression: "attribute:ecs.availability-zone == us-east-1a", Matches: true, OK: true},
+		{Expression: "attribute:ecs.availability-zone != us-east-1a", Matches: false, OK: true},
+		{Expression: "attribute:ecs.instance-type =~ t3.*", Matches: true, OK: true},
+		{Expression: "attribute:ecs.instance-type =~ m5.*", Matches: false, OK: true},
+		{Expression: "attribute:ecs.availability-zone in [us-east-1a, us-east-1b]", Matches: true, OK: true},
+		{Expression: "attribute:ecs.availability-zone not_in [us-east-1a, us-east-1b]", Matches: false, OK: true},
+		{Expression: "attribute:stack exists", Matches: true, OK: true},
+		{Expression: "attribute:gpu !exists", Matches: true, OK: true},
+		{Expression: "attribute:gpu == true", Matches: false, OK: true},
+		{Expression: "attribute:stack == prod and attribute:ecs.instance-type == t3.large", Matches: true, OK: true},
+		{Expression: "attribute:stack == prod and attribute:ecs.instance-type == m5.large", Matches: false, OK: true},
+		{Expression: "attribute:stack == prod or attribute:stack == dev", Matches: false, OK: false},
+		{Expression: "registeredAt < 2026-10-01", Matches: false, OK: false},
+	}
+
+	for _, i := range tests {
+		matches, ok := InstanceMatchesExpression(instance, i.Expression)
+		if matches != i.Matches || ok != i.OK {
+			t.Errorf("%s: expected matches %v and ok %v, got %v and %v", i.Expression, i.Matches, i.OK, matches, ok)
+		}
+	}
+}
+
+func TestGetPlacementMinimumServers(t *testing.T) {
+	setMockEcsClient(t, &mockEcsClient{taskDefinitions: map[string]*ecs.TaskDefinition{
+		"small": {ContainerDefinitions: []*ecs.ContainerDefinition{{Memory: aws.Int64(256), Cpu: aws.Int64(128)}}},
+		"large": {ContainerDefinitions: []*ecs.ContainerDefinition{{Memory: aws.Int64(1024), Cpu: aws.Int64(512)}}},
+	}})
+
+	var instances []*ecs.ContainerInstance
+	for _, az := range []string{"us-east-1a", "us-east-1b", "us-east-1c", "us-east-1a", "us-east-1b", "us-east-1c"} {
+		instances = append(instances, makeContainerInstance(map[string]string{"ecs.availability-zone": az}))
+	}
+	capacity := TaskResources{MemoryMb: 2048, CPUUnits: 2048}
+	noSurge := &ecs.DeploymentConfiguration{MaximumPercent: aws.Int64(100)}
+
+	tests := []struct {
+		Name     string
+		Services []*ecs.Service
+		Expected int64
+	}{
+		{
+			Name: "unconstrained services don't need a minimum",
+			Services: []*ecs.Service{
+				{ServiceName: aws.String("web"), DesiredCount: aws.Int64(8), TaskDefinition: aws.String("small")},
+			},
+			Expected: 0,
+		},
+		{
+			Name: "distinct instance needs a server for each task",
+			Services: []*ecs.Service{
+				{
+					ServiceName:          aws.String("web"),
+					DesiredCount:         aws.Int64(8),
+					TaskDefinition:       aws.String("small"),
+					PlacementConstraints: []*ecs.PlacementConstraint{{Type: aws.String("distinctInstance")}},
+				},
+			},
+			Expected: 8,
+		},
+		{
+			Name: "member of one AZ can only use a third of the servers",
+			Services: []*ecs.Service{
+				{
+					ServiceName:             aws.String("worker"),
+					DesiredCount:            aws.Int64(3),
+					TaskDefinition:          aws.String("large"),
+					DeploymentConfiguration: noSurge,
+					PlacementConstraints: []*ecs.PlacementConstraint{
+						{Type: aws.String("memberOf"), Expression: aws.String("attribute:ecs.availability-zone == us-east-1a")},
+					},
+				},
+			},
+			// 4 large tasks with the rolling update extra fit on 2 servers, which must be the third in us-east-1a
+			Expected: 6,
+		},
+		{
+			Name: "services no instance matches are left out",
+			Services: []*ecs.Service{
+				{
+					ServiceName:    aws.String("gpu"),
+					DesiredCount:   aws.Int64(3),
+					TaskDefinition: aws.String("large"),
+					PlacementConstraints: []*ecs.PlacementConstraint{
+						{Type: aws.String("memberOf"), Expression: aws.String("attribute:gpu exists")},
+					},
+				},
+			},
+			Expected: 0,
+		},
+		{
+			Name: "services with desired count of zero are skipped",
+			Services: []*ecs.Service{
+				{
+					ServiceName:          aws.String("web"),
+					DesiredCount:         aws.Int64(0),
+					TaskDefinition:       aws.String("small"),
+					PlacementConstraints: []*ecs.PlacementConstraint{{Type: aws.String("distinctInstance")}},
+				},
+			},
+			Expected: 0,
+		},
+	}
+
+	for _, i := range tests {
+		minimum, notes, err := GetPlacementMinimumServers(context.Background(), nil, i.Services, instances, capacity)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", i.Name, err)
+			continue
+		}
+		if minimum != i.Expected {
+			t.Errorf("%s: expected a minimum of %v servers, got %v (%v)", i.Name, i.Expected, minimum, notes)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+)
+
+// registrationsPollInterval is how often Cloud Map is checked while waiting for tasks to be registered
+var registrationsPollInterval = 10 * time.Second
+
+// ServiceRegistration is a Cloud Map service an ECS service registers its tasks in
+type ServiceRegistration struct {
+	EcsService    string
+	RegistryArn   string
+	NamespaceName string
+	ServiceName   string
+
+	// Expected is how many instances should be registered, the ECS service desired count
+	Expected int64
+}
+
+// GetServiceRegistrations returns the Cloud Map service of each service registry of the services. Services
+// without service registries, or with a desired count of zero, don't contribute any.
+func GetServiceRegistrations(ctx context.Context, awsSess *session.Session, services []*ecs.Service) ([]ServiceRegistration, error) {
+	svc := newServiceDiscoveryClient(awsSess)
+
+	// Services often share a namespace, so each is only looked up once
+	namespaceNames := map[string]string{}
+
+	var registrations []ServiceRegistration
+	for _, service := range services {
+		if aws.Int64Value(service.DesiredCount) == 0 {
+			continue
+		}
+
+		for _, registry := range service.ServiceRegistries {
+			registryArn := aws.StringValue(registry.RegistryArn)
+			cloudMapService, err := svc.GetServiceWithContext(ctx, &servicediscovery.GetServiceInput{
+				Id: aws.String(registryArn[strings.LastIndex(registryArn, "/")+1:]),
+			})
+			if err != nil {
+				return nil, withCategory(fmt.Errorf("unable to get Cloud Map service %s of %s: %s",
+					registryArn, aws.StringValue(service.ServiceName), err), ErrorCategory(err))
+			}
+
+			namespaceID := aws.StringValue(cloudMapService.Service.NamespaceId)
+			if _, ok := namespaceNames[namespaceID]; !ok {
+				namespace, err := svc.GetNamespaceWithContext(ctx, &servicediscovery.GetNamespaceInput{Id: aws.String(namespaceID)})
+				if err != nil {
+					return nil, withCategory(fmt.Errorf("unable to get Cloud Map namespace %s: %s", namespaceID, err), ErrorCategory(err))
+				}
+				namespaceNames[namespaceID] = aws.StringValue(namespace.Namespace.Name)
+			}
+
+			registrations = append(registrations, ServiceRegistration{
+				EcsService:    aws.StringValue(service.ServiceName),
+				RegistryArn:   registryArn,
+				NamespaceName: namespaceNames[namespaceID],
+				ServiceName:   aws.StringValue(cloudMapService.Service.Name),
+				Expected:      aws.Int64Value(service.DesiredCount),
+			})
+		}
+	}
+
+	return registrations, nil
+}
+
+// CountRegisteredInstances returns how many healthy instances DiscoverInstances finds for the registration.
+// Instances of services without health checks are always healthy.
+func CountRegisteredInstances(ctx context.Context, awsSess *session.Session, registration ServiceRegistration) (int64, error) {
+	svc := newServiceDiscoveryClient(awsSess)
+
+	result, err := svc.DiscoverInstancesWithContext(ctx, &servicediscovery.DiscoverInstancesInput{
+		NamespaceName: aws.String(registration.NamespaceName),
+		ServiceName:   aws.String(registration.ServiceName),
+		HealthStatus:  aws.String(servicediscovery.HealthStatusFilterHealthy),
+		MaxResults:    aws.Int64(1000),
+	})
+	if err != nil {
+		return 0, withCategory(fmt.Errorf("unable to discover instances of %s.%s: %s",
+			registration.ServiceName, registration.NamespaceName, err), ErrorCategory(err))
+	}
+
+	return int64(len(result.Instances)), nil
+}
+
+// WaitForServiceRegistrations waits up to timeout for each service with service registries to have at
+// least its desired count of instances registered in each of its Cloud Map services
+func WaitForServiceRegistrations(ctx context.Context, awsSess *session.Session, services []*ecs.Service, timeout time.Duration) error {
+	registrations, err := GetServiceRegistrations(ctx, awsSess, services)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		var missing []string
+		for _, registration := range registrations {
+			registered, err := CountRegisteredInstances(ctx, awsSess, registration)
+			if err != nil {
+				return err
+			}
+			if registered < registration.Expected {
+				missing = append(missing, fmt.Sprintf("%s (%v of %v registered in %s.%s)", registration.EcsService,
+					registered, registration.Expected, registration.ServiceName, registration.NamespaceName))
+			}
+		}
+
+		if len(missing) == 0 {
+			return nil
+		}
+
+		if err := aws.SleepWithContext(ctx, registrationsPollInterval); err != nil {
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("interrupted while waiting for tasks to be registered in Cloud Map: %w", ctx.Err())
+			}
+			return withCategory(fmt.Errorf("tasks were not registered in Cloud Map within %s, still waiting on: %s",
+				timeout, strings.Join(missing, ", ")), ErrTimeout)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+)
+
+func makeRegisteredInstances(n int) []*servicediscovery.HttpInstanceSummary {
+	var instances []*servicediscovery.HttpInstanceSummary
+	for i := 0; i < n; i++ {
+		instances = append(instances, &servicediscovery.HttpInstanceSummary{InstanceId: aws.String("task")})
+	}
+
+	return instances
+}
+
+func newMockCloudMap() *mockServiceDiscoveryClient {
+	return &mockServiceDiscoveryClient{
+		services: map[string]*servicediscovery.Service{
+			"srv-web": {Name: aws.String("web"), NamespaceId: aws.String("ns-internal")},
+			"srv-api": {Name: aws.String("api"), NamespaceId: aws.String("ns-internal")},
+		},
+		namespaces: map[string]*servicediscovery.Namespace{
+			"ns-internal": {Name: aws.String("internal.local")},
+		},
+	}
+}
+
+func TestGetServiceRegistrations(t *testing.T) {
+	setMockServiceDiscoveryClient(t, newMockCloudMap())
+
+	services := []*ecs.Service{
+		{
+			ServiceName:       aws.String("web"),
+			DesiredCount:      aws.Int64(3),
+			ServiceRegistries: []*ecs.ServiceRegistry{{RegistryArn: aws.String("arn:aws:servicediscovery:us-east-1:123456789012:service/srv-web")}},
+		},
+		{ServiceName: aws.String("worker"), DesiredCount: aws.Int64(2)},
+		{
+			ServiceName:       aws.String("api"),
+			DesiredCount:      aws.Int64(0),
+			ServiceRegistries: []*ecs.ServiceRegistry{{RegistryArn: aws.String("arn:aws:servicediscovery:us-east-1:123456789012:service/srv-api")}},
+		},
+	}
+
+	registrations, err := GetServiceRegistrations(context.Background(), nil, services)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(registrations) != 1 {
+		t.Fatalf("Expected only the web service to have a registration, got %+v", registrations)
+	}
+	if r := registrations[0]; r.EcsService != "web" || r.ServiceName != "web" || r.NamespaceName != "internal.local" || r.Expected != 3 {
+		t.Errorf("Unexpected registration: %+v", r)
+	}
+
+	services[0].ServiceRegistries[0].RegistryArn = aws.String("arn:aws:servicediscovery:us-east-1:123456789012:service/srv-missing")
+	if _, err := GetServiceRegistrations(context.Background(), nil, services); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing Cloud Map service, got: %v", err)
+	}
+}
+
+func TestWaitForServiceRegistrations(t *testing.T) {
+	original := registrationsPollInterval
+	registrationsPollInterval = time.Millisecond
+	t.Cleanup(func() {
+		registrationsPollInterval = original
+	})
+
+	mock := newMockCloudMap()
+	mock.instances = map[string][][]*servicediscovery.HttpInstanceSummary{
+		"web.internal.local": {makeRegisteredInstances(1), makeRegisteredInstances(2)},
+		"api.internal.local": {makeRegisteredInstances(1)},
+	}
+	setMockServiceDiscoveryClient(t, mock)
+
+	registry := func(id string) []*ecs.ServiceRegistry {
+		return []*ecs.ServiceRegistry{{RegistryArn: aws.String("arn:aws:servicediscovery:us-east-1:123456789012:service/" + id)}}
+	}
+
+	web := []*ecs.Service{{ServiceName: aws.String("web"), DesiredCount: aws.Int64(2), ServiceRegistries: registry("srv-web")}}
+	if err := WaitForServiceRegistrations(context.Background(), nil, web, time.Second); err != nil {
+		t.Errorf("Expected the wait to finish once both web tasks are registered, got: %s", err)
+	}
+
+	api := []*ecs.Service{{ServiceName: aws.String("api"), DesiredCount: aws.Int64(3), ServiceRegistries: registry("srv-api")}}
+	err := WaitForServiceRegistrations(context.Background(), nil, api, 20*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) || !strings.Contains(err.Error(), "api (1 of 3 registered in api.internal.local)") {
+		t.Errorf("Expected a timeout naming the api service, got: %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = WaitForServiceRegistrations(cancelled, nil, api, time.Second)
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected an interrupted wait not to be reported as a timeout, got: %v", err)
+	}
+
+	unregistered := []*ecs.Service{{ServiceName: aws.String("worker"), DesiredCount: aws.Int64(2)}}
+	if err := WaitForServiceRegistrations(context.Background(), nil, unregistered, time.Second); err != nil {
+		t.Errorf("Expected services without service registries to be skipped, got: %s", err)
+	}
+}
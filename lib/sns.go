@@ -0,0 +1,20 @@
+package lib
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+func PublishNotification(ctx context.Context, awsSess *session.Session, topicArn, subject, body string) error {
+	svc := newSnsClient(awsSess)
+
+	_, err := svc.PublishWithContext(ctx, &sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String(subject),
+		Message:  aws.String(body),
+	})
+
+	return err
+}
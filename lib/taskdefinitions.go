@@ -0,0 +1,313 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TaskDefinitionFamily is a task definition family and its revisions, oldest first
+type TaskDefinitionFamily struct {
+	Family    string                   `json:"family"`
+	Revisions []TaskDefinitionRevision `json:"revisions"`
+}
+
+type TaskDefinitionRevision struct {
+	Revision int64  `json:"revision"`
+	Arn      string `json:"arn"`
+}
+
+// ParseTaskDefinitionArn returns the family and revision from a task definition ARN
+// such as arn:aws:ecs:us-east-1:123456789012:task-definition/family:12
+func ParseTaskDefinitionArn(arn string) (string, int64, error) {
+	slash := strings.LastIndex(arn, "/")
+	colon := strings.LastIndex(arn, ":")
+	if slash == -1 || colon < slash {
+		return "", 0, fmt.Errorf("invalid task definition ARN %s", arn)
+	}
+
+	revision, err := strconv.ParseInt(arn[colon+1:], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid revision in task definition ARN %s", arn)
+	}
+
+	return arn[slash+1 : colon], revision, nil
+}
+
+// ListTaskDefinitionFamilies returns the task definitions with the given status, grouped by family and
+// sorted by family name. An empty familyPrefix lists every family. The ListTaskDefinitions API only
+// filters on a complete family name, so the prefix is matched here instead.
+func ListTaskDefinitionFamilies(ctx context.Context, awsSess *session.Session, familyPrefix, status string) ([]TaskDefinitionFamily, error) {
+	svc := newEcsClient(awsSess)
+
+	byFamily := map[string][]TaskDefinitionRevision{}
+	var parseErr error
+	err := svc.ListTaskDefinitionsPagesWithContext(ctx, &ecs.ListTaskDefinitionsInput{
+		Status: aws.String(status),
+	}, func(page *ecs.ListTaskDefinitionsOutput, lastPage bool) bool {
+		for _, arn := range page.TaskDefinitionArns {
+			family, revision, err := ParseTaskDefinitionArn(aws.StringValue(arn))
+			if err != nil {
+				parseErr = err
+				return false
+			}
+
+			if strings.HasPrefix(family, familyPrefix) {
+				byFamily[family] = append(byFamily[family], TaskDefinitionRevision{Revision: revision, Arn: *arn})
+			}
+		}
+
+		return !lastPage
+	})
+	if err != nil {
+		return nil, handleEcsError(err)
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	families := []TaskDefinitionFamily{}
+	for family, revisions := range byFamily {
+		sort.Slice(revisions, func(i, j int) bool {
+			return revisions[i].Revision < revisions[j].Revision
+		})
+		families = append(families, TaskDefinitionFamily{Family: family, Revisions: revisions})
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].Family < families[j].Family
+	})
+
+	return families, nil
+}
+
+// GetTaskDefinitionsInUse returns the task definition ARNs referenced by any service, including those of
+// deployments still rolling out or back, in the given clusters, mapped to the name of a service using each.
+// It returns an error if any cluster's services can't be described, since then it can't tell what is in use.
+func GetTaskDefinitionsInUse(ctx context.Context, awsSess *session.Session, clusters []*string) (map[string]string, error) {
+	inUse := map[string]string{}
+	for _, cluster := range clusters {
+		services, err := describeServicesForEcsCluster(ctx, awsSess, aws.StringValue(cluster))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, service := range services {
+			name := aws.StringValue(service.ServiceName)
+			if service.TaskDefinition != nil {
+				inUse[*service.TaskDefinition] = name
+			}
+			for _, deployment := range service.Deployments {
+				if deployment.TaskDefinition != nil {
+					inUse[*deployment.TaskDefinition] = name
+				}
+			}
+		}
+	}
+
+	return inUse, nil
+}
+
+// GetOldTaskDefinitionRevisions returns all but the newest keep revisions in each family, skipping any
+// revision in inUse so a task definition a service still references is never returned
+func GetOldTaskDefinitionRevisions(families []TaskDefinitionFamily, keep int, inUse map[string]string) []TaskDefinitionRevision {
+	old := []TaskDefinitionRevision{}
+	for _, family := range families {
+		if len(family.Revisions) <= keep {
+			continue
+		}
+
+		// Revisions are sorted oldest first
+		for _, revision := range family.Revisions[:len(family.Revisions)-keep] {
+			if _, ok := inUse[revision.Arn]; ok {
+				continue
+			}
+			old = append(old, revision)
+		}
+	}
+
+	return old
+}
+
+// DeregisterTaskDefinition marks the task definition revision INACTIVE
+func DeregisterTaskDefinition(ctx context.Context, awsSess *session.Session, taskDefinitionArn string) error {
+	svc := newEcsClient(awsSess)
+
+	_, err := svc.DeregisterTaskDefinitionWithContext(ctx, &ecs.DeregisterTaskDefinitionInput{
+		TaskDefinition: aws.String(taskDefinitionArn),
+	})
+	if err != nil {
+		return handleEcsError(err)
+	}
+
+	return nil
+}
+
+// TaskDefinitionDiff is what changed from task definition revision A to revision B
+type TaskDefinitionDiff struct {
+	A       string                 `json:"a"`
+	B       string                 `json:"b"`
+	Changes []TaskDefinitionChange `json:"changes"`
+}
+
+// TaskDefinitionChange is a single field that differs between two task definition revisions. Container
+// is empty for task level fields. Old or New is empty when the field is not set in that revision.
+type TaskDefinitionChange struct {
+	Container string `json:"container,omitempty"`
+	// Field is one of cpu, memory, container, image, memoryReservation, environment or portMappings
+	Field string `json:"field"`
+	// Key is the variable name of environment changes
+	Key string `json:"key,omitempty"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// GetTaskDefinitionDiff describes both task definitions, each given as a family for its latest ACTIVE
+// revision, family:revision or ARN, and returns the changes to image, CPU, memory, environment and port
+// mappings from a to b. Containers are matched by name, so a renamed container is removed and added.
+func GetTaskDefinitionDiff(ctx context.Context, awsSess *session.Session, a, b string) (TaskDefinitionDiff, error) {
+	taskDefA, err := describeTaskDefinition(ctx, awsSess, a)
+	if err != nil {
+		return TaskDefinitionDiff{}, err
+	}
+	taskDefB, err := describeTaskDefinition(ctx, awsSess, b)
+	if err != nil {
+		return TaskDefinitionDiff{}, err
+	}
+
+	diff := TaskDefinitionDiff{
+		A:       aws.StringValue(taskDefA.TaskDefinitionArn),
+		B:       aws.StringValue(taskDefB.TaskDefinitionArn),
+		Changes: []TaskDefinitionChange{},
+	}
+	addChange := func(container, field, key, old, new string) {
+		if old != new {
+			diff.Changes = append(diff.Changes, TaskDefinitionChange{Container: container, Field: field, Key: key, Old: old, New: new})
+		}
+	}
+
+	addChange("", "cpu", "", aws.StringValue(taskDefA.Cpu), aws.StringValue(taskDefB.Cpu))
+	addChange("", "memory", "", aws.StringValue(taskDefA.Memory), aws.StringValue(taskDefB.Memory))
+
+	containersB := map[string]*ecs.ContainerDefinition{}
+	for _, container := range taskDefB.ContainerDefinitions {
+		containersB[aws.StringValue(container.Name)] = container
+	}
+
+	for _, containerA := range taskDefA.ContainerDefinitions {
+		name := aws.StringValue(containerA.Name)
+		containerB, ok := containersB[name]
+		if !ok {
+			addChange(name, "container", "", aws.StringValue(containerA.Image), "")
+			continue
+		}
+		delete(containersB, name)
+
+		addChange(name, "image", "", aws.StringValue(containerA.Image), aws.StringValue(containerB.Image))
+		addChange(name, "cpu", "", formatOptionalInt(containerA.Cpu), formatOptionalInt(containerB.Cpu))
+		addChange(name, "memory", "", formatOptionalInt(containerA.Memory), formatOptionalInt(containerB.Memory))
+		addChange(name, "memoryReservation", "", formatOptionalInt(containerA.MemoryReservation),
+			formatOptionalInt(containerB.MemoryReservation))
+
+		envA, envB := environmentMap(containerA), environmentMap(containerB)
+		var keys []string
+		for key := range envA {
+			keys = append(keys, key)
+		}
+		for key := range envB {
+			if _, ok := envA[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			addChange(name, "environment", key, envA[key], envB[key])
+		}
+
+		addChange(name, "portMappings", "", formatPortMappings(containerA), formatPortMappings(containerB))
+	}
+
+	// Containers only in b were left in the map, sorted so the output is stable
+	var added []string
+	for name := range containersB {
+		added = append(added, name)
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		addChange(name, "container", "", "", aws.StringValue(containersB[name].Image))
+	}
+
+	return diff, nil
+}
+
+// missingTaskDefinitionMessage starts the message of the ClientException ECS returns for a task definition
+// that does not exist
+const missingTaskDefinitionMessage = "Unable to describe task definition"
+
+// describeTaskDefinition returns the task definition, wrapping ErrNotFound when it does not exist. ECS
+// reports a missing task definition as a ClientException, which is otherwise a permission or parameter
+// error, so only the ClientException with missingTaskDefinitionMessage is treated as not found.
+func describeTaskDefinition(ctx context.Context, awsSess *session.Session, taskDefinition string) (*ecs.TaskDefinition, error) {
+	svc := newEcsClient(awsSess)
+
+	result, err := svc.DescribeTaskDefinitionWithContext(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(taskDefinition),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == ecs.ErrCodeClientException &&
+			strings.HasPrefix(aerr.Message(), missingTaskDefinitionMessage) {
+			return nil, fmt.Errorf("task definition %s %w: %s", taskDefinition, ErrNotFound, aerr.Message())
+		}
+		return nil, handleEcsError(err)
+	}
+
+	return result.TaskDefinition, nil
+}
+
+func formatOptionalInt(value *int64) string {
+	if value == nil {
+		return ""
+	}
+
+	return strconv.FormatInt(*value, 10)
+}
+
+func environmentMap(container *ecs.ContainerDefinition) map[string]string {
+	environment := map[string]string{}
+	for _, variable := range container.Environment {
+		environment[aws.StringValue(variable.Name)] = aws.StringValue(variable.Value)
+	}
+
+	return environment
+}
+
+// formatPortMappings returns the port mappings of the container as a sorted list such as 80:8080/tcp, 443/tcp,
+// leaving out the host port when it is dynamic
+func formatPortMappings(container *ecs.ContainerDefinition) string {
+	var mappings []string
+	for _, mapping := range container.PortMappings {
+		containerPort := aws.Int64Value(mapping.ContainerPort)
+		hostPort := aws.Int64Value(mapping.HostPort)
+		protocol := aws.StringValue(mapping.Protocol)
+		if protocol == "" {
+			protocol = ecs.TransportProtocolTcp
+		}
+
+		if hostPort == 0 {
+			mappings = append(mappings, fmt.Sprintf("%v/%s", containerPort, protocol))
+		} else {
+			mappings = append(mappings, fmt.Sprintf("%v:%v/%s", hostPort, containerPort, protocol))
+		}
+	}
+	sort.Strings(mappings)
+
+	return strings.Join(mappings, ", ")
+}
@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+func TestParseTaskDefinitionArn(t *testing.T) {
+	family, revision, err := ParseTaskDefinitionArn("arn:aws:ecs:us-east-1:123456789012:task-definition/web-app:12")
+	if err != nil || family != "web-app" || revision != 12 {
+		t.Errorf("Expected web-app revision 12, got %s %v (%v)", family, revision, err)
+	}
+
+	for _, arn := range []string{"web-app:12", "arn:aws:ecs:us-east-1:123456789012:task-definition/web-app", ""} {
+		if _, _, err := ParseTaskDefinitionArn(arn); err == nil {
+			t.Errorf("Expected an error for invalid ARN %q", arn)
+		}
+	}
+}
+
+func TestListTaskDefinitionFamilies(t *testing.T) {
+	var arns []*string
+	for revision := 1; revision <= 150; revision++ {
+		arns = append(arns, aws.String(fmt.Sprintf("arn:aws:ecs:us-east-1:123456789012:task-definition/web-app:%v", revision)))
+	}
+	arns = append(arns,
+		aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/web-worker:3"),
+		aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/web-worker:1"),
+		aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/api:7"),
+	)
+	setMockEcsClient(t, &mockEcsClient{taskDefinitionArns: arns})
+
+	families, err := ListTaskDefinitionFamilies(context.Background(), nil, "web-", ecs.TaskDefinitionStatusActive)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(families) != 2 || families[0].Family != "web-app" || families[1].Family != "web-worker" {
+		t.Fatalf("Expected the web-app and web-worker families, got %v", families)
+	}
+	if len(families[0].Revisions) != 150 {
+		t.Errorf("Expected revisions from every page, got %v", len(families[0].Revisions))
+	}
+	if families[1].Revisions[0].Revision != 1 || families[1].Revisions[1].Revision != 3 {
+		t.Errorf("Expected revisions sorted oldest first, got %v", families[1].Revisions)
+	}
+
+	all, err := ListTaskDefinitionFamilies(context.Background(), nil, "", ecs.TaskDefinitionStatusActive)
+	if err != nil || len(all) != 3 {
+		t.Errorf("Expected every family without a prefix, got %v (%v)", all, err)
+	}
+}
+
+func TestGetOldTaskDefinitionRevisions(t *testing.T) {
+	arn := func(family string, revision int64) string {
+		return fmt.Sprintf("arn:aws:ecs:us-east-1:123456789012:task-definition/%s:%v", family, revision)
+	}
+	family := func(name string, count int64) TaskDefinitionFamily {
+		f := TaskDefinitionFamily{Family: name}
+		for revision := int64(1); revision <= count; revision++ {
+			f.Revisions = append(f.Revisions, TaskDefinitionRevision{Revision: revision, Arn: arn(name, revision)})
+		}
+		return f
+	}
+
+	families := []TaskDefinitionFamily{family("api", 5), family("web", 2)}
+	inUse := map[string]string{arn("api", 2): "api-service"}
+
+	old := GetOldTaskDefinitionRevisions(families, 2, inUse)
+
+	var revisions []string
+	for _, r := range old {
+		revisions = append(revisions, r.Arn)
+	}
+	expected := []string{arn("api", 1), arn("api", 3)}
+	if fmt.Sprint(revisions) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v to be deregistered, got %v", expected, revisions)
+	}
+}
+
+func TestGetTaskDefinitionsInUse(t *testing.T) {
+	arns := makeArns("service", 1)
+	setMockEcsClient(t, &mockEcsClient{
+		serviceArnPages: [][]*string{arns},
+		services: map[string]*ecs.Service{
+			*arns[0]: {
+				ServiceArn:     arns[0],
+				ServiceName:    aws.String("web"),
+				TaskDefinition: aws.String("td-web:3"),
+				Deployments: []*ecs.Deployment{
+					{TaskDefinition: aws.String("td-web:3")},
+					{TaskDefinition: aws.String("td-web:2")},
+				},
+			},
+		},
+	})
+
+	inUse, err := GetTaskDefinitionsInUse(context.Background(), nil, []*string{aws.String("test")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(inUse) != 2 || inUse["td-web:3"] != "web" || inUse["td-web:2"] != "web" {
+		t.Errorf("Expected the service and deployment task definitions to be in use, got %v", inUse)
+	}
+}
+
+func TestGetTaskDefinitionDiff(t *testing.T) {
+	setMockEcsClient(t, &mockEcsClient{taskDefinitions: map[string]*ecs.TaskDefinition{
+		"web:1": {
+			TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/web:1"),
+			ContainerDefinitions: []*ecs.ContainerDefinition{
+				{
+					Name:   aws.String("app"),
+					Image:  aws.String("example/app:1.0"),
+					Memory: aws.Int64(512),
+					Environment: []*ecs.KeyValuePair{
+						{Name: aws.String("LOG_LEVEL"), Value: aws.String("info")},
+						{Name: aws.String("OLD_FLAG"), Value: aws.String("true")},
+					},
+					PortMappings: []*ecs.PortMapping{{ContainerPort: aws.Int64(8080)}},
+				},
+				{Name: aws.String("cron"), Image: aws.String("example/cron:1.0")},
+			},
+		},
+		"web:2": {
+			TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:123456789012:task-definition/web:2"),
+			ContainerDefinitions: []*ecs.ContainerDefinition{
+				{
+					Name:   aws.String("app"),
+					Image:  aws.String("example/app:1.1"),
+					Memory: aws.Int64(512),
+					Environment: []*ecs.KeyValuePair{
+						{Name: aws.String("LOG_LEVEL"), Value: aws.String("debug")},
+					},
+					PortMappings: []*ecs.PortMapping{
+						{ContainerPort: aws.Int64(8080)},
+						{ContainerPort: aws.Int64(9090), HostPort: aws.Int64(9090), Protocol: aws.String("udp")},
+					},
+				},
+				{Name: aws.String("proxy"), Image: aws.String("nginx:1.25")},
+			},
+		},
+	}})
+
+	diff, err := GetTaskDefinitionDiff(context.Background(), nil, "web:1", "web:2")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := []TaskDefinitionChange{
+		{Container: "app", Field: "image", Old: "example/app:1.0", New: "example/app:1.1"},
+		{Container: "app", Field: "environment", Key: "LOG_LEVEL", Old: "info", New: "debug"},
+		{Container: "app", Field: "environment", Key: "OLD_FLAG", Old: "true", New: ""},
+		{Container: "app", Field: "portMappings", Old: "8080/tcp", New: "8080/tcp, 9090:9090/udp"},
+		{Container: "cron", Field: "container", Old: "example/cron:1.0", New: ""},
+		{Container: "proxy", Field: "container", Old: "", New: "nginx:1.25"},
+	}
+	if len(diff.Changes) != len(expected) {
+		t.Fatalf("Expected %v changes, got %+v", len(expected), diff.Changes)
+	}
+	for i, change := range diff.Changes {
+		if change != expected[i] {
+			t.Errorf("Expected change %v to be %+v, got %+v", i, expected[i], change)
+		}
+	}
+
+	same, err := GetTaskDefinitionDiff(context.Background(), nil, "web:2", "web:2")
+	if err != nil || len(same.Changes) != 0 {
+		t.Errorf("Expected no changes comparing a revision to itself, got %+v (%v)", same.Changes, err)
+	}
+
+	if _, err := GetTaskDefinitionDiff(context.Background(), nil, "web:1", "web:3"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a revision that doesn't exist, got %v", err)
+	}
+}
+
+func TestDescribeTaskDefinitionClientException(t *testing.T) {
+	// Other client exceptions, such as missing permissions, are not reported as not found
+	setMockEcsClient(t, &mockEcsClient{describeTaskDefinitionError: awserr.New(ecs.ErrCodeClientException,
+		"User is not authorized to perform ecs:DescribeTaskDefinition", nil)})
+
+	_, err := describeTaskDefinition(context.Background(), nil, "web:1")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an error other than ErrNotFound, got %v", err)
+	}
+
+	setMockEcsClient(t, &mockEcsClient{})
+	if _, err := describeTaskDefinition(context.Background(), nil, "web:1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a task definition that doesn't exist, got %v", err)
+	}
+}